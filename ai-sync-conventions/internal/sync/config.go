@@ -0,0 +1,103 @@
+package sync
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFiles are the names a config file can have in the sync root, in the
+// order they are looked for
+var ConfigFiles = []string{".ai-sync.yaml", ".ai-sync.yml", ".ai-sync.toml"}
+
+// Config sets which files are synced. Files are relative to the sync root
+// and may be glob patterns, such as .github/instructions/*.md.
+type Config struct {
+	Files []string `yaml:"files" toml:"files"`
+}
+
+// LoadConfig reads the config file in dir, returning its path along with
+// it. Both are empty when dir has no config file.
+func LoadConfig(dir string) (*Config, string, error) {
+	for _, name := range ConfigFiles {
+		path := filepath.Join(dir, name)
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+
+		var cfg Config
+		if strings.HasSuffix(name, ".toml") {
+			dec := toml.NewDecoder(bytes.NewReader(data))
+			dec.DisallowUnknownFields()
+			err = dec.Decode(&cfg)
+		} else {
+			dec := yaml.NewDecoder(bytes.NewReader(data))
+			dec.KnownFields(true)
+			err = dec.Decode(&cfg)
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		if len(cfg.Files) == 0 {
+			return nil, "", fmt.Errorf("%s lists no files", path)
+		}
+		return &cfg, path, nil
+	}
+	return nil, "", nil
+}
+
+// NewSyncManagerForRoot creates a SyncManager for the files listed in the
+// config file in rootPath, or for the default files when there is none
+func NewSyncManagerForRoot(rootPath string) (*SyncManager, error) {
+	cfg, _, err := LoadConfig(rootPath)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return NewSyncManager(), nil
+	}
+	return &SyncManager{Files: cfg.Files}, nil
+}
+
+// Paths expands the files to sync under rootPath. Plain names are kept
+// whether or not they exist, so missing files get created; glob patterns
+// expand to the files that match.
+func (sm *SyncManager) Paths(rootPath string) ([]string, error) {
+	var paths []string
+	for _, file := range sm.Files {
+		fullPath := filepath.Join(rootPath, file)
+		if !strings.ContainsAny(file, "*?[") {
+			paths = append(paths, fullPath)
+			continue
+		}
+		matches, err := filepath.Glob(fullPath)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %w", file, err)
+		}
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.Mode().IsRegular() {
+				paths = append(paths, match)
+			}
+		}
+	}
+
+	// A file named twice, or matched by two patterns, is synced once
+	var unique []string
+	for _, path := range paths {
+		if !slices.Contains(unique, path) {
+			unique = append(unique, path)
+		}
+	}
+	return unique, nil
+}
@@ -0,0 +1,97 @@
+package sync
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestConfigFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tmpDir, ".github", "instructions"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	config := "files:\n  - CONVENTIONS.md\n  - AGENTS.md\n  - .github/instructions/*.md\n"
+	if err := os.WriteFile(filepath.Join(tmpDir, ".ai-sync.yaml"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// AGENTS.md is the newest; .cursorrules is not configured
+	oldTime := time.Now().Add(-1 * time.Hour)
+	for _, file := range []string{"CONVENTIONS.md", ".github/instructions/go.md", ".cursorrules"} {
+		path := filepath.Join(tmpDir, file)
+		if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, oldTime, oldTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	agents := filepath.Join(tmpDir, "AGENTS.md")
+	if err := os.WriteFile(agents, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	subDir := filepath.Join(tmpDir, "sub")
+	if err := os.Mkdir(subDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	root, err := FindSyncRoot(subDir)
+	if err != nil || root != tmpDir {
+		t.Fatalf("FindSyncRoot() = %v, %v; want %v", root, err, tmpDir)
+	}
+
+	sm, err := NewSyncManagerForRoot(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := sm.CreatePlan(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.SourcePath != agents {
+		t.Errorf("source = %v, want %v", plan.SourcePath, agents)
+	}
+	want := []string{
+		filepath.Join(tmpDir, "CONVENTIONS.md"),
+		filepath.Join(tmpDir, ".github", "instructions", "go.md"),
+	}
+	if !slices.Equal(plan.TargetPaths, want) {
+		t.Errorf("targets = %v, want %v", plan.TargetPaths, want)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    []string
+		wantErr bool
+	}{
+		{"yaml", ".ai-sync.yaml", "files: [AGENTS.md]\n", []string{"AGENTS.md"}, false},
+		{"toml", ".ai-sync.toml", "files = [\"AGENTS.md\", \".aider.conf.yml\"]\n", []string{"AGENTS.md", ".aider.conf.yml"}, false},
+		{"no config", "", "", NewSyncManager().Files, false},
+		{"empty list", ".ai-sync.yaml", "files: []\n", nil, true},
+		{"unknown field", ".ai-sync.toml", "file = [\"AGENTS.md\"]\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			if tt.file != "" {
+				if err := os.WriteFile(filepath.Join(tmpDir, tt.file), []byte(tt.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			sm, err := NewSyncManagerForRoot(tmpDir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewSyncManagerForRoot() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(sm.Files, tt.want) {
+				t.Errorf("Files = %v, want %v", sm.Files, tt.want)
+			}
+		})
+	}
+}
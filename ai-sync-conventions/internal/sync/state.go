@@ -0,0 +1,80 @@
+package sync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stateDirName is the directory under the user's state directory holding
+// one file per sync root, so nothing is added to the synced project itself
+const stateDirName = "ai-sync-conventions"
+
+type syncState struct {
+	Root     string    `json:"root"`
+	SyncedAt time.Time `json:"synced_at"`
+}
+
+// statePath returns the file recording when rootPath was last synced. It is
+// under $XDG_STATE_HOME, or the user's config directory when that is unset,
+// and named after a hash of the root's absolute path.
+func statePath(rootPath string) (string, error) {
+	root, err := filepath.Abs(rootPath)
+	if err != nil {
+		return "", err
+	}
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		if dir, err = os.UserConfigDir(); err != nil {
+			return "", fmt.Errorf("failed to find a directory for sync state: %w", err)
+		}
+	}
+	sum := sha256.Sum256([]byte(root))
+	return filepath.Join(dir, stateDirName, hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+// readLastSync returns when files under rootPath were last synced, or the
+// zero time if they never were
+func readLastSync(rootPath string) (time.Time, error) {
+	path, err := statePath(rootPath)
+	if err != nil {
+		return time.Time{}, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	var state syncState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return state.SyncedAt, nil
+}
+
+// writeLastSync records a sync of the files under rootPath
+func writeLastSync(rootPath string, syncedAt time.Time) error {
+	path, err := statePath(rootPath)
+	if err != nil {
+		return err
+	}
+	root, _ := filepath.Abs(rootPath)
+	data, err := json.Marshal(syncState{Root: root, SyncedAt: syncedAt})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
@@ -6,10 +6,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 )
 
-// NewSyncManager creates a new SyncManager with the specified files to sync
+// NewSyncManager creates a new SyncManager with the default files to sync
 func NewSyncManager() *SyncManager {
 	return &SyncManager{
 		Files: []string{
@@ -22,18 +24,54 @@ func NewSyncManager() *SyncManager {
 	}
 }
 
+// Strategies for choosing the source file among those that exist. Any
+// other strategy names the source file itself.
+const (
+	StrategyNewest  = "newest"
+	StrategyLargest = "largest"
+)
+
 // SyncManager handles file synchronization operations
 type SyncManager struct {
 	Files []string
+
+	// Strategy chooses the source file, defaulting to StrategyNewest
+	Strategy string
+	// Source, if set, is the source file, overriding Strategy. Like a
+	// strategy naming a file, it is relative to the sync root unless
+	// absolute.
+	Source string
+	// Force plans a sync even when files conflict
+	Force bool
+	// FollowSymlinks syncs the files symlinks point to, which are
+	// otherwise left alone
+	FollowSymlinks bool
 }
 
 // Plan represents a synchronization plan
 type Plan struct {
+	RootPath    string
 	SourcePath  string
 	TargetPaths []string
 }
 
-// FindSyncRoot locates the root directory by searching for any of the sync files
+// ConflictError reports files that were edited independently since the
+// last sync, so syncing would keep one edit and lose the others
+type ConflictError struct {
+	LastSync time.Time
+	Files    []*FileInfo
+}
+
+func (e *ConflictError) Error() string {
+	paths := make([]string, len(e.Files))
+	for i, file := range e.Files {
+		paths[i] = file.Path
+	}
+	return fmt.Sprintf("files edited independently since the last sync: %s", strings.Join(paths, ", "))
+}
+
+// FindSyncRoot locates the root directory by searching for a config file or
+// any of the files it lists, or of the default files where there is none
 func FindSyncRoot(startPath string) (string, error) {
 	if startPath == "" {
 		var err error
@@ -43,12 +81,24 @@ func FindSyncRoot(startPath string) (string, error) {
 		}
 	}
 
-	sm := NewSyncManager()
 	current := startPath
 	for {
+		// A config file marks the root even before the files it lists exist
+		cfg, _, err := LoadConfig(current)
+		if err != nil {
+			return "", err
+		}
+		if cfg != nil {
+			return current, nil
+		}
+
 		// Check if any of the sync files exist in the current directory
-		for _, file := range sm.Files {
-			if _, err := os.Stat(filepath.Join(current, file)); err == nil {
+		paths, err := NewSyncManager().Paths(current)
+		if err != nil {
+			return "", err
+		}
+		for _, path := range paths {
+			if _, err := os.Stat(path); err == nil {
 				return current, nil
 			}
 		}
@@ -108,45 +158,46 @@ func (sm *SyncManager) GetFileInfo(path string) (*FileInfo, error) {
 
 // CreatePlan returns a Plan for synchronization
 func (sm *SyncManager) CreatePlan(rootPath string) (*Plan, error) {
-	var latest *FileInfo
-	var latestPath string
-
 	stats := make(map[string]*FileInfo)
 
-	// Find the most recently modified file
-	for _, file := range sm.Files {
-		fullPath := filepath.Join(rootPath, file)
+	paths, err := sm.syncPaths(rootPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the files that exist
+	for _, fullPath := range paths {
 		info, err := sm.GetFileInfo(fullPath)
 		if err != nil {
 			if !os.IsNotExist(err) {
-				return nil, fmt.Errorf("error checking file %s: %w", file, err)
+				return nil, fmt.Errorf("error checking file %s: %w", fullPath, err)
 			}
 			continue
 		}
 
 		stats[fullPath] = info
+	}
 
-		if latest == nil || info.ModTime.After(latest.ModTime) {
-			latest = info
-			latestPath = fullPath
+	if !sm.Force {
+		lastSync, err := readLastSync(rootPath)
+		if err != nil {
+			return nil, err
+		}
+		if conflicts := findConflicts(lastSync, paths, stats); conflicts != nil {
+			return nil, &ConflictError{LastSync: lastSync, Files: conflicts}
 		}
 	}
 
-	if latest == nil {
-		return nil, errors.New("no valid files found to sync")
+	source, err := sm.chooseSource(rootPath, paths, stats)
+	if err != nil {
+		return nil, err
 	}
+	sourcePath := source.Path
 
-	// Collect target files that do not have the same hash as the latest file
-	targets := make([]string, 0, len(sm.Files)-1)
-	for _, file := range sm.Files {
-		fullPath := filepath.Join(rootPath, file)
-		if fullPath == latestPath {
-			continue
-		}
-		_, exists := stats[fullPath]
-
-		// Skip symlinks
-		if exists && stats[fullPath].Mode&os.ModeSymlink == os.ModeSymlink {
+	// Collect target files that do not have the same hash as the source file
+	targets := make([]string, 0, len(paths))
+	for _, fullPath := range paths {
+		if fullPath == sourcePath {
 			continue
 		}
 
@@ -160,17 +211,141 @@ func (sm *SyncManager) CreatePlan(rootPath string) (*Plan, error) {
 		}
 
 		// Add target file to plan if hashes do not match
-		if targetHash != latest.Hash {
+		if targetHash != source.Hash {
 			targets = append(targets, fullPath)
 		}
 	}
 
 	return &Plan{
-		SourcePath:  latestPath,
+		RootPath:    rootPath,
+		SourcePath:  sourcePath,
 		TargetPaths: targets,
 	}, nil
 }
 
+// syncPaths returns the files to sync under rootPath. Symlinks are left
+// out, or with FollowSymlinks replaced by the files they point to.
+func (sm *SyncManager) syncPaths(rootPath string) ([]string, error) {
+	paths, err := sm.Paths(rootPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, path := range paths {
+		if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			if !sm.FollowSymlinks {
+				continue
+			}
+			if path, err = resolveSymlink(path); err != nil {
+				return nil, err
+			}
+		}
+		// A symlink to another of the files is the same file
+		if !slices.Contains(result, path) {
+			result = append(result, path)
+		}
+	}
+	return result, nil
+}
+
+// resolveSymlink follows a chain of symlinks to the path at its end, which
+// may not exist yet, and fails if the chain loops
+func resolveSymlink(path string) (string, error) {
+	seen := make(map[string]bool)
+	for {
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return path, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return path, nil
+		}
+		if seen[path] {
+			return "", fmt.Errorf("symlink cycle at %s", path)
+		}
+		seen[path] = true
+
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = filepath.Clean(target)
+	}
+}
+
+// findConflicts returns the files modified since the last sync when they
+// differ from each other. Without a record of a sync, edits can't be told
+// apart from the state before it, so nothing conflicts.
+func findConflicts(lastSync time.Time, paths []string, stats map[string]*FileInfo) []*FileInfo {
+	if lastSync.IsZero() {
+		return nil
+	}
+	var edited []*FileInfo
+	hashes := make(map[string]bool)
+	for _, path := range paths {
+		if info, ok := stats[path]; ok && info.ModTime.After(lastSync) {
+			edited = append(edited, info)
+			hashes[info.Hash] = true
+		}
+	}
+	if len(hashes) < 2 {
+		return nil
+	}
+	return edited
+}
+
+// chooseSource picks the source file by Source or Strategy from the files
+// found, which stats holds by path
+func (sm *SyncManager) chooseSource(rootPath string, paths []string, stats map[string]*FileInfo) (*FileInfo, error) {
+	strategy := sm.Strategy
+	if sm.Source != "" {
+		strategy = sm.Source
+	}
+
+	var source *FileInfo
+	switch strategy {
+	case "", StrategyNewest:
+		for _, path := range paths {
+			if info, ok := stats[path]; ok && (source == nil || info.ModTime.After(source.ModTime)) {
+				source = info
+			}
+		}
+	case StrategyLargest:
+		// Ties go to the newer file
+		for _, path := range paths {
+			info, ok := stats[path]
+			if ok && (source == nil || info.Size > source.Size || (info.Size == source.Size && info.ModTime.After(source.ModTime))) {
+				source = info
+			}
+		}
+	default:
+		path := filepath.Clean(strategy)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(rootPath, path)
+		}
+		info, err := sm.GetFileInfo(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("source file %s does not exist (strategy must be %s, %s or a file name)", path, StrategyNewest, StrategyLargest)
+			}
+			return nil, fmt.Errorf("error checking source file %s: %w", path, err)
+		}
+		return info, nil
+	}
+
+	if source == nil {
+		return nil, errors.New("no valid files found to sync")
+	}
+	return source, nil
+}
+
 // Sync synchronizes all target files based on the source file in the plan
 func (p *Plan) Sync() error {
 	// If there are no target files to update, do nothing
@@ -178,11 +353,15 @@ func (p *Plan) Sync() error {
 		return nil
 	}
 
-	// Read the content of the source file
+	// Read the content and permissions of the source file
 	content, err := os.ReadFile(p.SourcePath)
 	if err != nil {
 		return fmt.Errorf("failed to read source file: %w", err)
 	}
+	stat, err := os.Stat(p.SourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to read source file: %w", err)
+	}
 
 	// Update all target files
 	for _, targetPath := range p.TargetPaths {
@@ -191,14 +370,51 @@ func (p *Plan) Sync() error {
 			return fmt.Errorf("failed to create directory %s: %w", dir, err)
 		}
 
-		if err := os.WriteFile(targetPath, content, 0644); err != nil {
+		if err := writeFileAtomic(targetPath, content, stat.Mode().Perm()); err != nil {
 			return fmt.Errorf("failed to write file %s: %w", targetPath, err)
 		}
 	}
 
+	if p.RootPath != "" {
+		return writeLastSync(p.RootPath, time.Now())
+	}
 	return nil
 }
 
+// writeContent writes a file's new content. Tests replace it to simulate
+// an interrupted write.
+var writeContent = func(f *os.File, content []byte) error {
+	_, err := f.Write(content)
+	return err
+}
+
+// writeFileAtomic replaces path by writing a temporary file beside it and
+// renaming that into place, so a failed write never leaves a partial file
+func writeFileAtomic(path string, content []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	// Once renamed, there is nothing left to remove
+	defer os.Remove(tmp.Name())
+
+	if err := writeContent(tmp, content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
 // Sync creates a plan and synchronizes files based on the plan
 func (sm *SyncManager) Sync(rootPath string) error {
 	plan, err := sm.CreatePlan(rootPath)
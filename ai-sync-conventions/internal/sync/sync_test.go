@@ -1,12 +1,28 @@
 package sync
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 )
 
+// TestMain keeps the sync state the tests record out of the user's own
+// state directory
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "ai-sync-state")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("XDG_STATE_HOME", dir)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 func TestFindSyncRoot(t *testing.T) {
 	// Create a temporary directory structure
 	tmpDir := t.TempDir()
@@ -85,20 +101,18 @@ func TestSyncManager_PlanSync(t *testing.T) {
 	}
 
 	sm := NewSyncManager()
-	_, err := sm.CreatePlan(tmpDir) // Removed unused variable 'plan'
+	plan, err := sm.CreatePlan(tmpDir)
 	if err != nil {
 		t.Fatalf("PlanSync() error = %v", err)
 	}
 
-	source := newerFile // Define 'source'
-	if source != newerFile {
-		t.Errorf("PlanSync() source = %v, want %v", source, newerFile)
+	if plan.SourcePath != newerFile {
+		t.Errorf("PlanSync() source = %v, want %v", plan.SourcePath, newerFile)
 	}
 
-	targets := []string{}    // Define 'targets'
 	expectedTargetCount := 4 // all files except the source
-	if len(targets) != expectedTargetCount {
-		t.Errorf("PlanSync() returned %d targets, want %d", len(targets), expectedTargetCount)
+	if len(plan.TargetPaths) != expectedTargetCount {
+		t.Errorf("PlanSync() returned %d targets, want %d", len(plan.TargetPaths), expectedTargetCount)
 	}
 }
 
@@ -173,3 +187,296 @@ func TestGetFileInfo(t *testing.T) {
 		t.Errorf("GetFileInfo().Hash = %v, want %v", info.Hash, expectedHash)
 	}
 }
+
+func TestSyncManager_Strategies(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// CONVENTIONS.md is the largest, .clinerules the newest
+	now := time.Now()
+	fixtures := []struct {
+		file    string
+		content string
+		age     time.Duration
+	}{
+		{"CONVENTIONS.md", "the longest rules of them all", 3 * time.Hour},
+		{".cursorrules", "medium rules", 2 * time.Hour},
+		{".clinerules", "short", 1 * time.Hour},
+	}
+	for _, f := range fixtures {
+		path := filepath.Join(tmpDir, f.file)
+		if err := os.WriteFile(path, []byte(f.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now.Add(-f.age), now.Add(-f.age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		strategy string
+		source   string
+		want     string
+		wantErr  bool
+	}{
+		{name: "default", want: ".clinerules"},
+		{name: "newest", strategy: StrategyNewest, want: ".clinerules"},
+		{name: "largest", strategy: StrategyLargest, want: "CONVENTIONS.md"},
+		{name: "named file", strategy: ".cursorrules", want: ".cursorrules"},
+		{name: "source overrides strategy", strategy: StrategyLargest, source: ".cursorrules", want: ".cursorrules"},
+		{name: "absolute source", source: filepath.Join(tmpDir, "CONVENTIONS.md"), want: "CONVENTIONS.md"},
+		{name: "missing source", source: ".windsurfrules", wantErr: true},
+		{name: "unknown strategy", strategy: "oldest", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := NewSyncManager()
+			sm.Strategy, sm.Source = tt.strategy, tt.source
+			plan, err := sm.CreatePlan(tmpDir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CreatePlan() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if want := filepath.Join(tmpDir, tt.want); plan.SourcePath != want {
+				t.Errorf("CreatePlan() source = %v, want %v", plan.SourcePath, want)
+			}
+			// Every other file differs from the source, or is missing
+			if len(plan.TargetPaths) != len(sm.Files)-1 {
+				t.Errorf("CreatePlan() returned %d targets, want %d", len(plan.TargetPaths), len(sm.Files)-1)
+			}
+		})
+	}
+}
+
+func TestSyncManager_Conflicts(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "CONVENTIONS.md"), []byte("agreed rules"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Syncing records when it happened
+	sm := NewSyncManager()
+	if err := sm.Sync(tmpDir); err != nil {
+		t.Fatalf("first Sync() error = %v", err)
+	}
+	path, err := statePath(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("Sync() did not record the sync: %v", err)
+	}
+
+	edit := func(file, content string, age time.Duration) string {
+		path := filepath.Join(tmpDir, file)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(time.Minute - age)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	// One edit since the sync is simply propagated
+	edit(".clinerules", "cline edit", 0)
+	if _, err := sm.CreatePlan(tmpDir); err != nil {
+		t.Fatalf("CreatePlan() with one edit error = %v", err)
+	}
+
+	// A second, different edit conflicts with it
+	cursor := edit(".cursorrules", "cursor edit", time.Second)
+	_, err = sm.CreatePlan(tmpDir)
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("CreatePlan() error = %v, want a ConflictError", err)
+	}
+	var got []string
+	for _, file := range conflict.Files {
+		got = append(got, file.Path)
+	}
+	want := []string{filepath.Join(tmpDir, ".clinerules"), cursor}
+	if !slices.Equal(got, want) {
+		t.Errorf("conflicting files = %v, want %v", got, want)
+	}
+	if conflict.LastSync.IsZero() {
+		t.Error("ConflictError.LastSync is zero")
+	}
+
+	// Making the edits agree resolves the conflict
+	edit(".cursorrules", "cline edit", time.Second)
+	if _, err := sm.CreatePlan(tmpDir); err != nil {
+		t.Errorf("CreatePlan() with agreeing edits error = %v", err)
+	}
+
+	// Force syncs from the newest regardless
+	edit(".cursorrules", "cursor edit", time.Second)
+	sm.Force = true
+	plan, err := sm.CreatePlan(tmpDir)
+	if err != nil {
+		t.Fatalf("CreatePlan() with Force error = %v", err)
+	}
+	if want := filepath.Join(tmpDir, ".clinerules"); plan.SourcePath != want {
+		t.Errorf("CreatePlan() with Force source = %v, want %v", plan.SourcePath, want)
+	}
+}
+
+func TestPlan_SyncAtomic(t *testing.T) {
+	tmpDir := t.TempDir()
+	source := filepath.Join(tmpDir, "CONVENTIONS.md")
+	if err := os.WriteFile(source, []byte("new rules"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(source, 0750); err != nil {
+		t.Fatal(err)
+	}
+	existing := filepath.Join(tmpDir, ".clinerules")
+	if err := os.WriteFile(existing, []byte("old rules"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(tmpDir, ".github", "copilot-instructions.md")
+	plan := &Plan{SourcePath: source, TargetPaths: []string{existing, missing}}
+
+	// A write that fails halfway leaves the old file whole and no temp file
+	write := writeContent
+	writeContent = func(f *os.File, content []byte) error {
+		f.Write(content[:len(content)/2])
+		return errors.New("disk full")
+	}
+	err := plan.Sync()
+	writeContent = write
+	if err == nil {
+		t.Fatal("Sync() with an interrupted write succeeded")
+	}
+	if content, _ := os.ReadFile(existing); string(content) != "old rules" {
+		t.Errorf("interrupted write left %q", content)
+	}
+	entries, _ := os.ReadDir(tmpDir)
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".tmp") {
+			t.Errorf("interrupted write left temp file %s", entry.Name())
+		}
+	}
+
+	// Targets, created or replaced, get the source's content and mode
+	if err := plan.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	for _, target := range plan.TargetPaths {
+		stat, err := os.Stat(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat.Mode().Perm() != 0750 {
+			t.Errorf("%s mode = %v, want %v", target, stat.Mode().Perm(), os.FileMode(0750))
+		}
+		if content, _ := os.ReadFile(target); string(content) != "new rules" {
+			t.Errorf("%s content = %q", target, content)
+		}
+	}
+}
+
+func TestSyncManager_FollowSymlinks(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tmpDir, "shared"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	shared := filepath.Join(tmpDir, "shared", "rules.md")
+	if err := os.WriteFile(shared, []byte("old rules"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	oldTime := time.Now().Add(-1 * time.Hour)
+	if err := os.Chtimes(shared, oldTime, oldTime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "CONVENTIONS.md"), []byte("new rules"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(tmpDir, ".cursorrules")
+	if err := os.Symlink(filepath.Join("shared", "rules.md"), link); err != nil {
+		t.Fatal(err)
+	}
+
+	// By default the symlink is left alone
+	sm := NewSyncManager()
+	plan, err := sm.CreatePlan(tmpDir)
+	if err != nil {
+		t.Fatalf("CreatePlan() error = %v", err)
+	}
+	if slices.Contains(plan.TargetPaths, link) || slices.Contains(plan.TargetPaths, shared) {
+		t.Errorf("CreatePlan() targets %v include the symlink", plan.TargetPaths)
+	}
+
+	// Following it syncs the file it points to, and keeps the link
+	sm.FollowSymlinks = true
+	if err := sm.Sync(tmpDir); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if content, _ := os.ReadFile(shared); string(content) != "new rules" {
+		t.Errorf("symlinked file content = %q, want %q", content, "new rules")
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("%s is no longer a symlink", link)
+	}
+
+	// A cycle is refused rather than followed forever
+	for _, file := range []string{".clinerules", ".windsurfrules"} {
+		if err := os.Remove(filepath.Join(tmpDir, file)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(".windsurfrules", filepath.Join(tmpDir, ".clinerules")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(".clinerules", filepath.Join(tmpDir, ".windsurfrules")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sm.CreatePlan(tmpDir); err == nil || !strings.Contains(err.Error(), "symlink cycle") {
+		t.Errorf("CreatePlan() with a cycle error = %v, want a symlink cycle", err)
+	}
+	sm.FollowSymlinks = false
+	if _, err := sm.CreatePlan(tmpDir); err != nil {
+		t.Errorf("CreatePlan() ignoring symlinks error = %v", err)
+	}
+}
+
+func TestSyncManager_SyncLeavesRootClean(t *testing.T) {
+	stateHome := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", stateHome)
+
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "CONVENTIONS.md"), []byte("agreed rules"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sm := NewSyncManager()
+	if err := sm.Sync(tmpDir); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	// Only the synced files themselves appear in the project
+	var got []string
+	err := filepath.WalkDir(tmpDir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(tmpDir, path)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := slices.Clone(sm.Files)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("sync root contains %v, want only %v", got, want)
+	}
+
+	// The state went to the state directory instead
+	entries, err := os.ReadDir(filepath.Join(stateHome, stateDirName))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("expected one state file in %s, got %v (%v)", stateHome, entries, err)
+	}
+}
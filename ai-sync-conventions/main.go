@@ -2,16 +2,22 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/presbrey/cmd/ai-sync-conventions/internal/sync"
 )
 
 func main() {
 	startPath := flag.String("path", "", "Starting path to search for sync files (defaults to current directory)")
+	source := flag.String("source", "", "Source file to copy to the others, relative to the sync root (overrides -strategy)")
+	strategy := flag.String("strategy", sync.StrategyNewest, "How to choose the source file: newest, largest, or a file name")
+	force := flag.Bool("force", false, "Sync even when files were edited independently since the last sync")
+	followSymlinks := flag.Bool("follow-symlinks", false, "Sync the files that symlinks point to instead of skipping symlinks")
 	flag.Parse()
 
 	root, err := sync.FindSyncRoot(*startPath)
@@ -20,8 +26,25 @@ func main() {
 		os.Exit(1)
 	}
 
-	syncManager := sync.NewSyncManager()
+	syncManager, err := sync.NewSyncManagerForRoot(root)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	syncManager.Source = *source
+	syncManager.Strategy = *strategy
+	syncManager.Force = *force
+	syncManager.FollowSymlinks = *followSymlinks
 	plan, err := syncManager.CreatePlan(root)
+	var conflict *sync.ConflictError
+	if errors.As(err, &conflict) {
+		fmt.Fprintf(os.Stderr, "Files were edited independently since the last sync at %s:\n", conflict.LastSync.Format(time.DateTime))
+		for _, file := range conflict.Files {
+			fmt.Fprintf(os.Stderr, "  %s (modified %s, MD5 %s)\n", file.Path, file.ModTime.Format(time.DateTime), file.Hash)
+		}
+		fmt.Fprintln(os.Stderr, "Merge the edits by hand, or pass -force to keep only the source file's")
+		os.Exit(1)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error creating sync plan: %v\n", err)
 		os.Exit(1)
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// PRPrompts are the prompts found on one pull request in batch mode
+type PRPrompts struct {
+	Repo    string   `json:"repo"`
+	Number  int      `json:"number"`
+	Title   string   `json:"title"`
+	Branch  string   `json:"branch"`
+	Prompts []Prompt `json:"prompts"`
+}
+
+// repoScan is what batch mode found in one repository: how many open pull
+// requests it read, and those that had prompts, by number
+type repoScan struct {
+	Owner   string
+	Repo    string
+	Scanned int
+	PRs     []PRPrompts
+}
+
+// parseRepoSpec splits a CARROTS_REPOS entry into its host, owner and name.
+// owner/repo is on github.com, or on gitlab.com when CARROTS_PROVIDER is
+// gitlab; any other host is given as a repository URL.
+func parseRepoSpec(config *Config, spec string) (host, owner, repo string, err error) {
+	if strings.Contains(spec, "://") || strings.Contains(spec, "@") {
+		return parseRemoteURL(spec)
+	}
+	i := strings.LastIndex(spec, "/")
+	if i <= 0 || i == len(spec)-1 {
+		return "", "", "", fmt.Errorf("invalid repository %q: expected owner/repo", spec)
+	}
+	host = "github.com"
+	if strings.EqualFold(config.Provider, "gitlab") {
+		host = "gitlab.com"
+	}
+	return host, spec[:i], spec[i+1:], nil
+}
+
+// scanRepos reads the prompts from every open pull request in each of
+// config.Repos, or only from the PR for config.Branch when it is set.
+// Repositories keep the order they were given in, and their pull requests
+// are ordered by number.
+func scanRepos(ctx context.Context, config *Config) ([]repoScan, error) {
+	var scans []repoScan
+	for _, spec := range config.Repos {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		repoConfig := *config
+		host, owner, repo, err := parseRepoSpec(config, spec)
+		if err != nil {
+			return nil, err
+		}
+		repoConfig.Host, repoConfig.Owner, repoConfig.Repo = host, owner, repo
+		name := owner + "/" + repo
+
+		provider, err := newProvider(&repoConfig)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if _, ok := provider.(gitlabProvider); ok && config.SinceReview != 0 {
+			return nil, fmt.Errorf("%s: CARROTS_SINCE can only name a review on GitHub; use a timestamp for GitLab", name)
+		}
+
+		var prs []PullRequest
+		if config.Branch != "" {
+			pr, err := provider.FindPRForBranch(ctx, &repoConfig)
+			if err != nil {
+				return nil, fmt.Errorf("%s: finding PR: %w", name, err)
+			}
+			if pr != nil {
+				prs = append(prs, *pr)
+			}
+		} else if prs, err = provider.ListOpenPRs(ctx, &repoConfig); err != nil {
+			return nil, fmt.Errorf("%s: listing open PRs: %w", name, err)
+		}
+		slices.SortFunc(prs, func(a, b PullRequest) int { return a.Number - b.Number })
+
+		scan := repoScan{Owner: owner, Repo: repo, Scanned: len(prs)}
+		for i := range prs {
+			pr := &prs[i]
+			prompts, err := extractAIPrompts(&repoConfig, provider.IterComments(ctx, &repoConfig, pr))
+			if err != nil {
+				return nil, fmt.Errorf("%s#%d: extracting prompts: %w", name, pr.Number, err)
+			}
+			if len(prompts) == 0 {
+				continue
+			}
+			scan.PRs = append(scan.PRs, PRPrompts{
+				Repo:    name,
+				Number:  pr.Number,
+				Title:   pr.Title,
+				Branch:  pr.Head.Ref,
+				Prompts: prompts,
+			})
+		}
+		scans = append(scans, scan)
+	}
+	if len(scans) == 0 {
+		return nil, errors.New("CARROTS_REPOS lists no repositories")
+	}
+	return scans, nil
+}
+
+// writeBatch writes batch mode's results in the configured format, grouped
+// by repository and pull request. JSON output is one flat array of
+// PRPrompts, and pull requests without prompts are left out of every
+// format.
+func writeBatch(w io.Writer, format string, scans []repoScan) error {
+	switch format {
+	case "json":
+		results := []PRPrompts{}
+		for _, scan := range scans {
+			results = append(results, scan.PRs...)
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		return encoder.Encode(results)
+
+	case "agent":
+		// One instruction block per pull request, since each is its own
+		// checkout
+		first := true
+		for _, scan := range scans {
+			for _, pr := range scan.PRs {
+				if !first {
+					fmt.Fprint(w, "---\n\n")
+				}
+				first = false
+				config := &Config{Owner: scan.Owner, Repo: scan.Repo, Branch: pr.Branch}
+				writeAgentPrompt(w, config, &PullRequest{Number: pr.Number, Title: pr.Title}, pr.Prompts)
+			}
+		}
+		if first {
+			fmt.Fprintln(w, "No CodeRabbitAI prompts found in these repositories")
+		}
+		return nil
+	}
+
+	for _, scan := range scans {
+		fmt.Fprintf(w, "Repository: %s/%s\n", scan.Owner, scan.Repo)
+		fmt.Fprintf(w, "Scanned %d open PR(s)\n\n", scan.Scanned)
+		if len(scan.PRs) == 0 {
+			fmt.Fprint(w, "No CodeRabbitAI prompts found in this repository\n\n")
+			continue
+		}
+		for _, pr := range scan.PRs {
+			fmt.Fprintf(w, "PR #%d: %s (%s)\n", pr.Number, pr.Title, pr.Branch)
+			fmt.Fprintf(w, "Found %d AI prompt(s):\n\n", len(pr.Prompts))
+			for i, prompt := range pr.Prompts {
+				fmt.Fprintf(w, "=== Prompt %d ===\n%s\n\n", i+1, prompt.Body)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScanRepos(t *testing.T) {
+	prompt := func(text string) string {
+		return fmt.Sprintf("<summary>🤖 Prompt for AI Agents</summary>\n\n```\n%s\n```\n", text)
+	}
+	issueComments := map[string]string{
+		"/repos/octo/widgets/issues/3/comments": prompt("Fix the widget."),
+		"/repos/octo/widgets/issues/5/comments": prompt("Test the gadget."),
+		"/repos/acme/tools/issues/1/comments":   "LGTM",
+	}
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		switch {
+		case path == "/graphql":
+			fmt.Fprint(w, `{"data": {"repository": {"pullRequest": {"reviewThreads": {"pageInfo": {"hasNextPage": false}, "nodes": []}}}}}`)
+		case path == "/repos/octo/widgets/pulls" && r.URL.Query().Get("page") == "":
+			if r.URL.Query().Get("state") != "open" {
+				t.Errorf("unexpected PR list query %q", r.URL.RawQuery)
+			}
+			w.Header().Set("Link", "<"+srv.URL+path+"?state=open&page=2>; rel=\"next\"")
+			fmt.Fprint(w, `[{"number": 5, "title": "Add gadgets", "head": {"ref": "gadgets"}}]`)
+		case path == "/repos/octo/widgets/pulls":
+			fmt.Fprint(w, `[{"number": 3, "title": "Fix widgets", "head": {"ref": "fix"}}]`)
+		case path == "/repos/acme/tools/pulls":
+			fmt.Fprint(w, `[{"number": 1, "title": "Tidy", "head": {"ref": "tidy"}}]`)
+		case strings.HasSuffix(path, "/comments") && strings.Contains(path, "/issues/"):
+			body, ok := issueComments[path]
+			if !ok {
+				t.Errorf("unexpected request %s", r.URL)
+			}
+			json.NewEncoder(w).Encode([]map[string]any{{"id": 1, "body": body, "user": map[string]string{"login": "coderabbitai"}}})
+		case strings.HasSuffix(path, "/comments"):
+			fmt.Fprint(w, `[]`)
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	defer func(api, graphql string) { githubAPIBase, githubGraphQLURL = api, graphql }(githubAPIBase, githubGraphQLURL)
+	githubAPIBase, githubGraphQLURL = srv.URL, srv.URL+"/graphql"
+
+	config := &Config{Token: "test", Concurrency: 3, Repos: []string{"octo/widgets", " acme/tools "}}
+	scans, err := scanRepos(context.Background(), config)
+	if err != nil {
+		t.Fatalf("scanRepos failed: %v", err)
+	}
+
+	type summary struct {
+		repo    string
+		scanned int
+		prs     []int
+	}
+	var got []summary
+	for _, scan := range scans {
+		s := summary{repo: scan.Owner + "/" + scan.Repo, scanned: scan.Scanned}
+		for _, pr := range scan.PRs {
+			s.prs = append(s.prs, pr.Number)
+		}
+		got = append(got, s)
+	}
+	want := []summary{{"octo/widgets", 2, []int{3, 5}}, {"acme/tools", 1, nil}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("scanRepos = %+v, want %+v", got, want)
+	}
+
+	var list bytes.Buffer
+	if err := writeBatch(&list, "list", scans); err != nil {
+		t.Fatal(err)
+	}
+	wantList := "Repository: octo/widgets\nScanned 2 open PR(s)\n\n" +
+		"PR #3: Fix widgets (fix)\nFound 1 AI prompt(s):\n\n=== Prompt 1 ===\nFix the widget.\n\n" +
+		"PR #5: Add gadgets (gadgets)\nFound 1 AI prompt(s):\n\n=== Prompt 1 ===\nTest the gadget.\n\n" +
+		"Repository: acme/tools\nScanned 1 open PR(s)\n\nNo CodeRabbitAI prompts found in this repository\n\n"
+	if list.String() != wantList {
+		t.Errorf("list output:\n%s\nwant:\n%s", list.String(), wantList)
+	}
+
+	var out bytes.Buffer
+	if err := writeBatch(&out, "json", scans); err != nil {
+		t.Fatal(err)
+	}
+	var results []PRPrompts
+	if err := json.Unmarshal(out.Bytes(), &results); err != nil {
+		t.Fatalf("JSON output does not parse: %v\n%s", err, out.String())
+	}
+	if len(results) != 2 || results[0].Repo != "octo/widgets" || results[0].Number != 3 || results[1].Number != 5 ||
+		results[1].Branch != "gadgets" || results[1].Prompts[0].Body != "Test the gadget." {
+		t.Errorf("unexpected JSON output:\n%s", out.String())
+	}
+}
+
+func TestParseRepoSpec(t *testing.T) {
+	tests := []struct {
+		spec, provider    string
+		host, owner, repo string
+	}{
+		{"octo/widgets", "", "github.com", "octo", "widgets"},
+		{"group/sub/project", "gitlab", "gitlab.com", "group/sub", "project"},
+		{"https://gitlab.example.com/group/project.git", "", "gitlab.example.com", "group", "project"},
+		{"git@github.com:octo/widgets.git", "", "github.com", "octo", "widgets"},
+	}
+	for _, tt := range tests {
+		host, owner, repo, err := parseRepoSpec(&Config{Provider: tt.provider}, tt.spec)
+		if err != nil || host != tt.host || owner != tt.owner || repo != tt.repo {
+			t.Errorf("parseRepoSpec(%q) = %q, %q, %q, %v; want %q, %q, %q", tt.spec, host, owner, repo, err, tt.host, tt.owner, tt.repo)
+		}
+	}
+	for _, bad := range []string{"widgets", "octo/", "/widgets"} {
+		if _, _, _, err := parseRepoSpec(&Config{}, bad); err == nil {
+			t.Errorf("parseRepoSpec(%q) should fail", bad)
+		}
+	}
+}
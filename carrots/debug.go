@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// debugLog receives request/response traces. It discards everything until
+// setupDebugLog enables it.
+var debugLog = slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+// setupDebugLog enables debug tracing as JSON lines. Traces go to
+// CARROTS_DEBUG_FILE when set, which also implies CARROTS_DEBUG, and to
+// stderr otherwise. The returned file, if any, must be closed by the caller.
+func setupDebugLog(config *Config) (*os.File, error) {
+	if !config.Debug && config.DebugFile == "" {
+		return nil, nil
+	}
+
+	var w io.Writer = os.Stderr
+	var file *os.File
+	if config.DebugFile != "" {
+		var err error
+		file, err = os.OpenFile(config.DebugFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+		w = file
+	}
+
+	debugLog = newDebugLogger(w)
+	return file, nil
+}
+
+// newDebugLogger returns a logger writing debug-level JSON lines to w
+func newDebugLogger(w io.Writer) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// debugHeaders flattens headers for logging, redacting credentials
+func debugHeaders(header http.Header) map[string]string {
+	headers := make(map[string]string, len(header))
+	for k, v := range header {
+		if k == "Authorization" {
+			headers[k] = "Bearer [REDACTED]"
+			continue
+		}
+		headers[k] = strings.Join(v, ", ")
+	}
+	return headers
+}
+
+// debugBody embeds a JSON body as-is so traces stay machine-readable, and
+// falls back to a string for anything else
+func debugBody(body []byte) slog.Attr {
+	if json.Valid(body) {
+		return slog.Any("body", json.RawMessage(body))
+	}
+	return slog.String("body", string(body))
+}
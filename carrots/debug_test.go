@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDebugLogJSONLines(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newDebugLogger(&buf)
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer secret-token")
+	header.Set("Accept", "application/json")
+	logger.Debug("api request", "url", "https://api.github.com/x", "headers", debugHeaders(header))
+	logger.Debug("api response", "status", 200, debugBody([]byte(`{"ok": true}`)))
+	logger.Debug("api response", "status", 502, debugBody([]byte("Bad Gateway")))
+
+	if strings.Contains(buf.String(), "secret-token") {
+		t.Fatalf("Token leaked into debug log:\n%s", buf.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 JSON lines, got %d:\n%s", len(lines), buf.String())
+	}
+
+	var entries []map[string]interface{}
+	for _, line := range lines {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Invalid JSON line %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	headers, _ := entries[0]["headers"].(map[string]interface{})
+	if headers["Authorization"] != "Bearer [REDACTED]" || headers["Accept"] != "application/json" {
+		t.Errorf("Unexpected headers: %v", entries[0]["headers"])
+	}
+	if body, ok := entries[1]["body"].(map[string]interface{}); !ok || body["ok"] != true {
+		t.Errorf("Expected JSON body to be embedded as an object, got %v", entries[1]["body"])
+	}
+	if entries[2]["body"] != "Bad Gateway" {
+		t.Errorf("Expected non-JSON body as a string, got %v", entries[2]["body"])
+	}
+}
+
+func TestSetupDebugLogFile(t *testing.T) {
+	saved := debugLog
+	defer func() { debugLog = saved }()
+
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+	file, err := setupDebugLog(&Config{DebugFile: path})
+	if err != nil {
+		t.Fatalf("setupDebugLog failed: %v", err)
+	}
+	debugLog.Debug("graphql request", "query", "{ viewer { login } }")
+	file.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"msg":"graphql request"`) {
+		t.Errorf("Debug file missing trace, got:\n%s", data)
+	}
+
+	// Without CARROTS_DEBUG or CARROTS_DEBUG_FILE nothing is enabled
+	if file, err := setupDebugLog(&Config{}); file != nil || err != nil {
+		t.Errorf("Expected debug logging to stay disabled, got %v, %v", file, err)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"net/url"
+	"time"
+)
+
+// gitlabProvider reads merge requests and their notes from the GitLab REST
+// API, on gitlab.com or a self-managed instance
+type gitlabProvider struct {
+	// apiBase is the root of the API, e.g. https://gitlab.com/api/v4
+	apiBase string
+}
+
+type gitlabMergeRequest struct {
+	IID          int    `json:"iid"`
+	Title        string `json:"title"`
+	SourceBranch string `json:"source_branch"`
+	SHA          string `json:"sha"`
+}
+
+// pullRequest converts the merge request to the form the providers share
+func (mr gitlabMergeRequest) pullRequest() *PullRequest {
+	pr := &PullRequest{Number: mr.IID, Title: mr.Title}
+	pr.Head.Ref = mr.SourceBranch
+	pr.Head.SHA = mr.SHA
+	return pr
+}
+
+// gitlabNote is a merge request note. Notes on code carry a position.
+type gitlabNote struct {
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Body      string    `json:"body"`
+	Author    struct {
+		Username string `json:"username"`
+	} `json:"author"`
+	System   bool `json:"system"`
+	Resolved bool `json:"resolved"`
+	Position *struct {
+		HeadSHA string `json:"head_sha"`
+		NewPath string `json:"new_path"`
+		OldPath string `json:"old_path"`
+		NewLine *int   `json:"new_line"`
+		OldLine *int   `json:"old_line"`
+	} `json:"position"`
+}
+
+// projectURL returns the API URL of the project, which GitLab accepts as
+// its URL-encoded full path in place of a numeric ID
+func (p gitlabProvider) projectURL(config *Config) string {
+	return p.apiBase + "/projects/" + url.PathEscape(config.Owner+"/"+config.Repo)
+}
+
+func (p gitlabProvider) FindPRForBranch(ctx context.Context, config *Config) (*PullRequest, error) {
+	mrURL := fmt.Sprintf("%s/merge_requests?source_branch=%s&state=opened",
+		p.projectURL(config), url.QueryEscape(config.Branch))
+
+	body, _, err := makeAPIRequest(ctx, "GitLab", mrURL, config.Token, "application/json")
+	if err != nil {
+		return nil, err
+	}
+
+	var mrs []gitlabMergeRequest
+	if err := json.Unmarshal(body, &mrs); err != nil {
+		return nil, fmt.Errorf("failed to parse merge request list: %w", err)
+	}
+
+	if len(mrs) == 0 {
+		return nil, nil
+	}
+
+	return mrs[0].pullRequest(), nil
+}
+
+func (p gitlabProvider) GetPR(ctx context.Context, config *Config, number int) (*PullRequest, error) {
+	mrURL := fmt.Sprintf("%s/merge_requests/%d", p.projectURL(config), number)
+
+	body, _, err := makeAPIRequest(ctx, "GitLab", mrURL, config.Token, "application/json")
+	if isNotFound(err) {
+		return nil, fmt.Errorf("merge request !%d not found in %s/%s", number, config.Owner, config.Repo)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var mr gitlabMergeRequest
+	if err := json.Unmarshal(body, &mr); err != nil {
+		return nil, fmt.Errorf("failed to parse merge request: %w", err)
+	}
+	return mr.pullRequest(), nil
+}
+
+func (p gitlabProvider) ListOpenPRs(ctx context.Context, config *Config) ([]PullRequest, error) {
+	mrURL := p.projectURL(config) + "/merge_requests?state=opened"
+
+	var prs []PullRequest
+	for body, err := range iterPages(ctx, "GitLab", mrURL, config.Token, "application/json") {
+		if err != nil {
+			return nil, err
+		}
+		var mrs []gitlabMergeRequest
+		if err := json.Unmarshal(body, &mrs); err != nil {
+			return nil, fmt.Errorf("failed to parse merge request list: %w", err)
+		}
+		for _, mr := range mrs {
+			prs = append(prs, *mr.pullRequest())
+		}
+	}
+	return prs, nil
+}
+
+func (p gitlabProvider) IterComments(ctx context.Context, config *Config, pr *PullRequest) iter.Seq2[ReviewComment, error] {
+	return func(yield func(ReviewComment, error) bool) {
+		// Notes are listed newest first unless asked otherwise
+		notesURL := fmt.Sprintf("%s/merge_requests/%d/notes?sort=asc&order_by=created_at",
+			p.projectURL(config), pr.Number)
+
+		for body, err := range iterPages(ctx, "GitLab", notesURL, config.Token, "application/json") {
+			if err != nil {
+				yield(ReviewComment{}, err)
+				return
+			}
+
+			comments, err := parseGitLabNotes(body)
+			if err != nil {
+				yield(ReviewComment{}, err)
+				return
+			}
+			for _, comment := range comments {
+				if !yield(comment, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// parseGitLabNotes converts a page of merge request notes, dropping system
+// notes such as "added 1 commit". GitLab doesn't mark notes as outdated, so
+// CARROTS_COMMIT is the way to skip notes on earlier pushes.
+func parseGitLabNotes(body []byte) ([]ReviewComment, error) {
+	var notes []gitlabNote
+	if err := json.Unmarshal(body, &notes); err != nil {
+		return nil, fmt.Errorf("failed to parse notes: %w", err)
+	}
+
+	var comments []ReviewComment
+	for _, note := range notes {
+		if note.System {
+			continue
+		}
+		comment := ReviewComment{
+			ID:        note.ID,
+			Body:      note.Body,
+			Author:    note.Author.Username,
+			CreatedAt: note.CreatedAt,
+			Resolved:  note.Resolved,
+		}
+
+		// Notes on removed lines only have an old path and line
+		if pos := note.Position; pos != nil {
+			comment.Path = pos.NewPath
+			if comment.Path == "" {
+				comment.Path = pos.OldPath
+			}
+			if pos.NewLine != nil {
+				comment.Line = *pos.NewLine
+			} else if pos.OldLine != nil {
+				comment.Line = *pos.OldLine
+			}
+			comment.CommitIDs = []string{pos.HeadSHA}
+		}
+		comments = append(comments, comment)
+	}
+	return comments, nil
+}
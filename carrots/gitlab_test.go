@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGitLabProvider(t *testing.T) {
+	const project = "/api/v4/projects/group%2Fsub%2Fproject"
+	prompt := "<summary>🤖 Prompt for AI Agents</summary>\n\n```\n%s\n```\n"
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer glpat-test" {
+			t.Errorf("Authorization = %q", got)
+		}
+		switch r.URL.EscapedPath() {
+		case project + "/merge_requests":
+			if q := r.URL.Query(); q.Get("source_branch") == "" && q.Get("state") == "opened" {
+				fmt.Fprint(w, `[{"iid": 9, "title": "Fix gadgets", "source_branch": "fix"}, {"iid": 7, "title": "Add widgets", "source_branch": "feature/x", "sha": "abc123"}]`)
+				return
+			}
+			if q := r.URL.Query(); q.Get("source_branch") != "feature/x" || q.Get("state") != "opened" {
+				t.Errorf("unexpected merge request query %q", r.URL.RawQuery)
+			}
+			fmt.Fprint(w, `[{"iid": 7, "title": "Add widgets", "source_branch": "feature/x", "sha": "abc123"}]`)
+		case project + "/merge_requests/7":
+			fmt.Fprint(w, `{"iid": 7, "title": "Add widgets", "source_branch": "feature/x", "sha": "abc123"}`)
+		case project + "/merge_requests/8":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message": "404 Not found"}`)
+		case project + "/merge_requests/7/notes":
+			if r.URL.Query().Get("page") == "" {
+				w.Header().Set("Link", fmt.Sprintf(`<%s%s/merge_requests/7/notes?page=2&per_page=100>; rel="next"`, srv.URL, project))
+				fmt.Fprintf(w, `[
+					{"body": "added 1 commit", "author": {"username": "octo"}, "system": true},
+					{"id": 301, "created_at": "2024-05-14T12:00:00.000Z", "body": %q, "author": {"username": "coderabbitai"}}
+				]`, fmt.Sprintf(prompt, "Describe the change."))
+				return
+			}
+			fmt.Fprintf(w, `[
+				{"id": 302, "created_at": "2024-05-14T12:01:00.000Z", "body": %q, "author": {"username": "coderabbitai"}, "resolvable": true, "resolved": false,
+				 "position": {"head_sha": "abc123", "new_path": "main.go", "old_path": "main.go", "new_line": 12, "old_line": null}},
+				{"id": 303, "created_at": "2024-05-14T12:02:00.000Z", "body": %q, "author": {"username": "coderabbitai"}, "resolvable": true, "resolved": false,
+				 "position": {"head_sha": "abc123", "new_path": "", "old_path": "old.go", "new_line": null, "old_line": 4}},
+				{"body": %q, "author": {"username": "coderabbitai"}, "resolvable": true, "resolved": true,
+				 "position": {"head_sha": "abc123", "new_path": "main.go", "new_line": 20}}
+			]`, fmt.Sprintf(prompt, "Handle the error."), fmt.Sprintf(prompt, "Restore the check."), fmt.Sprintf(prompt, "Already fixed."))
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	config := &Config{Token: "glpat-test", Owner: "group/sub", Repo: "project", Branch: "feature/x"}
+	provider := gitlabProvider{apiBase: srv.URL + "/api/v4"}
+
+	pr, err := provider.FindPRForBranch(context.Background(), config)
+	if err != nil {
+		t.Fatalf("FindPRForBranch failed: %v", err)
+	}
+	if pr == nil || pr.Number != 7 || pr.Title != "Add widgets" || pr.Head.SHA != "abc123" {
+		t.Fatalf("unexpected merge request: %+v", pr)
+	}
+
+	if open, err := provider.ListOpenPRs(context.Background(), config); err != nil || len(open) != 2 || open[0].Number != 9 || open[1] != *pr {
+		t.Errorf("ListOpenPRs = %+v, %v", open, err)
+	}
+
+	if byNumber, err := provider.GetPR(context.Background(), config, 7); err != nil || *byNumber != *pr {
+		t.Errorf("GetPR(7) = %+v, %v; want %+v", byNumber, err, pr)
+	}
+	if _, err := provider.GetPR(context.Background(), config, 8); err == nil || err.Error() != "merge request !8 not found in group/sub/project" {
+		t.Errorf("GetPR(8): got %v", err)
+	}
+
+	prompts, err := extractAIPrompts(config, provider.IterComments(context.Background(), config, pr))
+	if err != nil {
+		t.Fatalf("extractAIPrompts failed: %v", err)
+	}
+	at := func(minute int) time.Time { return time.Date(2024, 5, 14, 12, minute, 0, 0, time.UTC) }
+	want := []Prompt{
+		{Body: "Describe the change.", CommentID: 301, Author: "coderabbitai", CreatedAt: at(0)},
+		{Body: "Handle the error.", Path: "main.go", Line: 12, CommentID: 302, Author: "coderabbitai", CreatedAt: at(1)},
+		{Body: "Restore the check.", Path: "old.go", Line: 4, CommentID: 303, Author: "coderabbitai", CreatedAt: at(2)},
+	}
+	if len(prompts) != len(want) {
+		t.Fatalf("got %d prompts, want %d: %+v", len(prompts), len(want), prompts)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(prompts[i], want[i]) {
+			t.Errorf("prompt %d = %+v, want %+v", i, prompts[i], want[i])
+		}
+	}
+
+	// Notes on other pushes are left out when asked for one commit
+	config.Commit = "def456"
+	if prompts, err := extractAIPrompts(config, provider.IterComments(context.Background(), config, pr)); err != nil || len(prompts) != 1 {
+		t.Errorf("with CARROTS_COMMIT: got %+v, %v; want only the general note", prompts, err)
+	}
+}
+
+func TestGitLabProviderNoMergeRequest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[]`)
+	}))
+	defer srv.Close()
+
+	provider := gitlabProvider{apiBase: srv.URL + "/api/v4"}
+	pr, err := provider.FindPRForBranch(context.Background(), &Config{Owner: "group", Repo: "project", Branch: "main"})
+	if err != nil || pr != nil {
+		t.Errorf("got %+v, %v; want no merge request", pr, err)
+	}
+}
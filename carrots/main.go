@@ -4,13 +4,18 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"iter"
 	"net/http"
 	"os"
 	"os/exec"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/caarlos0/env/v11"
@@ -22,27 +27,89 @@ func init() {
 }
 
 const (
+	githubAccept = "application/vnd.github.v3+json"
+	userAgent    = "carrots/1.0"
+)
+
+// The GitHub endpoints are variables so tests can point them at a fake server
+var (
 	githubAPIBase    = "https://api.github.com"
 	githubGraphQLURL = "https://api.github.com/graphql"
-	userAgent        = "carrots/1.0"
 )
 
-var debugMode bool
-
 // Config holds environment-based configuration
 type Config struct {
-	Debug  bool   `env:"DEBUG"                       envDefault:"false"`
-	Dir    string `env:"DIR"                         envDefault:"."`
-	Token  string `env:"TOKEN,required"              envDefault:""`
-	Output string `env:"OUTPUT"                      envDefault:"CARROTS.md"`
+	Debug     bool   `env:"DEBUG"                       envDefault:"false"`
+	DebugFile string `env:"DEBUG_FILE"                  envDefault:""`
+	Dir       string `env:"DIR"                         envDefault:"."`
+	Token     string `env:"TOKEN,required"              envDefault:""`
+	Output    string `env:"OUTPUT"                      envDefault:"CARROTS.md"`
+	Format    string `env:"FORMAT"                      envDefault:"list"`
 
 	IncludeResolved bool `env:"INCLUDE_RESOLVED"            envDefault:"false"`
 	IncludeOutdated bool `env:"INCLUDE_OUTDATED"            envDefault:"false"`
 
+	// Dedup drops prompts whose text repeats an earlier one, as when a
+	// summary comment repeats the review comments' prompts
+	Dedup bool `env:"DEDUP"                       envDefault:"true"`
+
+	// Commit limits review comments to those left on this commit SHA or a
+	// prefix of it
+	Commit string `env:"COMMIT"                      envDefault:""`
+
+	// Since limits prompts to the latest review round: an RFC3339
+	// timestamp keeps comments created at or after it, and a number keeps
+	// only the comments of that GitHub review
+	Since string `env:"SINCE"                       envDefault:""`
+
+	// PRNumber selects the pull request directly instead of looking it up
+	// from the current branch, e.g. in CI. The -pr flag overrides it.
+	PRNumber int `env:"PR_NUMBER"                   envDefault:"0"`
+
+	// MaxRetries is how many times a request that failed with a network
+	// error, server error or rate limit is retried
+	MaxRetries int `env:"MAX_RETRIES"                 envDefault:"3"`
+
+	// Timeout bounds the whole run, including retries and rate-limit
+	// waits, rather than each request
+	Timeout time.Duration `env:"TIMEOUT"                     envDefault:"2m"`
+
+	// PromptPattern replaces the regular expression that finds prompts in
+	// comments. Its first group, or the whole match if it has none, is the
+	// prompt.
+	PromptPattern string `env:"PROMPT_PATTERN"              envDefault:""`
+	// BotLogins are the accounts whose comments are searched for prompts,
+	// in addition to any account GitHub marks as a bot
+	BotLogins []string `env:"BOT_LOGINS"                  envDefault:"coderabbitai"`
+
+	// Concurrency is how many GitHub endpoints, such as issue comments and
+	// review comments, are fetched at the same time
+	Concurrency int `env:"CONCURRENCY"                 envDefault:"3"`
+
+	// Provider is github or gitlab, overriding detection from the remote
+	// URL for self-managed hosts
+	Provider string `env:"PROVIDER"                    envDefault:""`
+
+	// Repos switches to batch mode: instead of the repository in Dir,
+	// every open PR in each of these repositories is scanned. Entries are
+	// owner/repo, or a repository URL for other hosts.
+	Repos []string `env:"REPOS"                       envDefault:""`
+
+	// Branch is the branch to find a PR for. It is read from git unless
+	// set, and in batch mode limits each repository to that branch's PR.
+	Branch string `env:"BRANCH"                      envDefault:""`
+
+	// PromptRegex is PromptPattern compiled, or nil for the default
+	PromptRegex *regexp.Regexp `env:"-"`
+
+	// SinceTime and SinceReview are Since parsed, whichever it names
+	SinceTime   time.Time `env:"-"`
+	SinceReview int       `env:"-"`
+
 	// These are populated from git, not environment
-	Owner  string `env:"-"`
-	Repo   string `env:"-"`
-	Branch string `env:"-"`
+	Host  string `env:"-"`
+	Owner string `env:"-"`
+	Repo  string `env:"-"`
 }
 
 var cfg *Config
@@ -52,10 +119,12 @@ type PullRequest struct {
 	Title  string `json:"title"`
 	Head   struct {
 		Ref string `json:"ref"`
+		SHA string `json:"sha"`
 	} `json:"head"`
 }
 
 type Comment struct {
+	ID                  int       `json:"id"`
 	Body                string    `json:"body"`
 	User                User      `json:"user"`
 	CreatedAt           time.Time `json:"created_at"`
@@ -115,6 +184,31 @@ type GraphQLComment struct {
 	} `json:"author"`
 }
 
+// Prompt is a single AI agent prompt extracted from a CodeRabbitAI comment,
+// along with the comment it came from. Path and Line are only set for
+// review comments attached to code.
+type Prompt struct {
+	Body      string    `json:"body"`
+	Path      string    `json:"path,omitempty"`
+	Line      int       `json:"line,omitempty"`
+	CommentID int       `json:"comment_id"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+	Resolved  bool      `json:"resolved"`
+	Outdated  bool      `json:"outdated"`
+}
+
+// Location describes where in the code a prompt applies, or "" if unknown
+func (p Prompt) Location() string {
+	switch {
+	case p.Path != "" && p.Line > 0:
+		return fmt.Sprintf("%s:%d", p.Path, p.Line)
+	case p.Path != "":
+		return p.Path
+	}
+	return ""
+}
+
 // ThreadStatus holds the status of a review thread
 type ThreadStatus struct {
 	IsResolved bool
@@ -122,70 +216,263 @@ type ThreadStatus struct {
 }
 
 func main() {
+	prNumber := flag.Int("pr", 0, "Pull request number to read, instead of finding it from the current branch (overrides CARROTS_PR_NUMBER)")
+	flag.Parse()
+
 	cfg = &Config{}
 
 	// Parse environment variables with CARROTS_ prefix
-	// Also check GITHUB_TOKEN as fallback for TOKEN
-	if os.Getenv("CARROTS_TOKEN") == "" && os.Getenv("GITHUB_TOKEN") != "" {
-		os.Setenv("CARROTS_TOKEN", os.Getenv("GITHUB_TOKEN"))
+	// Also check GITHUB_TOKEN, then GITLAB_TOKEN, as fallback for TOKEN
+	for _, name := range []string{"GITHUB_TOKEN", "GITLAB_TOKEN"} {
+		if os.Getenv("CARROTS_TOKEN") == "" && os.Getenv(name) != "" {
+			os.Setenv("CARROTS_TOKEN", os.Getenv(name))
+		}
 	}
 
 	if err := env.ParseWithOptions(cfg, env.Options{Prefix: "CARROTS_"}); err != nil {
 		fmt.Fprintf(os.Stderr, "Error parsing config: %v\n", err)
-		fmt.Fprintln(os.Stderr, "Required: CARROTS_TOKEN or GITHUB_TOKEN")
+		fmt.Fprintln(os.Stderr, "Required: CARROTS_TOKEN, GITHUB_TOKEN or GITLAB_TOKEN")
+		os.Exit(1)
+	}
+
+	// An empty CARROTS_OUTPUT would otherwise fall back to the default file
+	if output, ok := os.LookupEnv("CARROTS_OUTPUT"); ok && output == "" {
+		cfg.Output = "-"
+	}
+
+	debugFile, err := setupDebugLog(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening debug file: %v\n", err)
 		os.Exit(1)
 	}
+	if debugFile != nil {
+		defer debugFile.Close()
+	}
 
-	debugMode = cfg.Debug
+	if cfg.Format != "list" && cfg.Format != "agent" && cfg.Format != "json" {
+		fmt.Fprintf(os.Stderr, "Error: unknown CARROTS_FORMAT %q (expected list, agent or json)\n", cfg.Format)
+		os.Exit(1)
+	}
+
+	if *prNumber != 0 {
+		cfg.PRNumber = *prNumber
+	}
+	if cfg.PRNumber < 0 {
+		fmt.Fprintf(os.Stderr, "Error: invalid pull request number %d\n", cfg.PRNumber)
+		os.Exit(1)
+	}
+
+	if cfg.MaxRetries < 0 {
+		fmt.Fprintf(os.Stderr, "Error: CARROTS_MAX_RETRIES must not be negative, got %d\n", cfg.MaxRetries)
+		os.Exit(1)
+	}
+	maxRetries = cfg.MaxRetries
+
+	if cfg.Concurrency < 1 {
+		fmt.Fprintf(os.Stderr, "Error: CARROTS_CONCURRENCY must be at least 1, got %d\n", cfg.Concurrency)
+		os.Exit(1)
+	}
+
+	if cfg.Timeout <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: CARROTS_TIMEOUT must be positive, got %s\n", cfg.Timeout)
+		os.Exit(1)
+	}
+
+	if cfg.PromptPattern != "" {
+		cfg.PromptRegex, err = regexp.Compile(cfg.PromptPattern)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid CARROTS_PROMPT_PATTERN: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if cfg.Since != "" {
+		cfg.SinceTime, cfg.SinceReview, err = parseSince(cfg.Since)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid CARROTS_SINCE: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
 	// Set up output writer
-	file, err := os.Create(cfg.Output)
+	outputWriter, closeOutput, err := openOutput(cfg.Output)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error creating output file: %v\n", err)
 		os.Exit(1)
 	}
-	defer file.Close()
-	outputWriter := file
+	defer closeOutput()
 
-	if err := populateRepoConfig(cfg.Dir); err != nil {
+	// Every request shares one deadline, so pagination and retries stop
+	// together once it passes
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+
+	if len(cfg.Repos) > 0 {
+		if cfg.PRNumber != 0 {
+			fmt.Fprintln(os.Stderr, "Error: -pr cannot be combined with CARROTS_REPOS")
+			os.Exit(1)
+		}
+		scans, err := scanRepos(ctx, cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", timeoutError(ctx, cfg.Timeout, err))
+			os.Exit(1)
+		}
+		if err := writeBatch(outputWriter, cfg.Format, scans); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// A pull request given by number names its own branch
+	if err := populateRepoConfig(cfg.Dir, cfg.PRNumber == 0 && cfg.Branch == ""); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
-	fmt.Fprintf(outputWriter, "Repository: %s/%s\n", cfg.Owner, cfg.Repo)
-	fmt.Fprintf(outputWriter, "Branch: %s\n\n", cfg.Branch)
+	provider, err := newProvider(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if _, ok := provider.(gitlabProvider); ok && cfg.SinceReview != 0 {
+		fmt.Fprintln(os.Stderr, "Error: CARROTS_SINCE can only name a review on GitHub; use a timestamp for GitLab")
+		os.Exit(1)
+	}
 
-	pr, err := findPRForBranch(cfg)
+	var pr *PullRequest
+	if cfg.PRNumber > 0 {
+		pr, err = provider.GetPR(ctx, cfg, cfg.PRNumber)
+		if pr != nil {
+			cfg.Branch = pr.Head.Ref
+		}
+	} else {
+		pr, err = provider.FindPRForBranch(ctx, cfg)
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error finding PR: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error finding PR: %v\n", timeoutError(ctx, cfg.Timeout, err))
 		os.Exit(1)
 	}
 
+	if cfg.Format == "list" {
+		fmt.Fprintf(outputWriter, "Repository: %s/%s\n", cfg.Owner, cfg.Repo)
+		fmt.Fprintf(outputWriter, "Branch: %s\n\n", cfg.Branch)
+	}
+
 	if pr == nil {
+		if cfg.Format == "json" {
+			// Keep the output parseable and report on stderr instead
+			fmt.Fprintln(os.Stderr, "No open PR found for this branch")
+			writeJSONPrompts(outputWriter, nil)
+			os.Exit(0)
+		}
 		fmt.Fprintln(outputWriter, "No open PR found for this branch")
 		os.Exit(0)
 	}
 
-	fmt.Fprintf(outputWriter, "Found PR #%d: %s\n\n", pr.Number, pr.Title)
+	if cfg.Format == "list" {
+		fmt.Fprintf(outputWriter, "Found PR #%d: %s\n\n", pr.Number, pr.Title)
+	}
 
-	prompts, err := extractAIPrompts(cfg, pr.Number, cfg.IncludeResolved, cfg.IncludeOutdated)
+	// Prompts refer to the code at the PR head, which may not be what's
+	// checked out locally
+	if warning := headSyncWarning(cfg.Dir, pr.Head.SHA); warning != "" {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+
+	prompts, err := extractAIPrompts(cfg, provider.IterComments(ctx, cfg, pr))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error extracting prompts: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error extracting prompts: %v\n", timeoutError(ctx, cfg.Timeout, err))
 		os.Exit(1)
 	}
 
+	if cfg.Format == "json" {
+		if err := writeJSONPrompts(outputWriter, prompts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if len(prompts) == 0 {
 		fmt.Fprintln(outputWriter, "No CodeRabbitAI prompts found in this PR")
 		os.Exit(0)
 	}
 
+	if cfg.Format == "agent" {
+		writeAgentPrompt(outputWriter, cfg, pr, prompts)
+		return
+	}
+
 	fmt.Fprintf(outputWriter, "Found %d AI prompt(s):\n\n", len(prompts))
 	for i, prompt := range prompts {
-		fmt.Fprintf(outputWriter, "=== Prompt %d ===\n%s\n\n", i+1, prompt)
+		fmt.Fprintf(outputWriter, "=== Prompt %d ===\n%s\n\n", i+1, prompt.Body)
+	}
+}
+
+// openOutput creates the output file, or returns stdout when path is "-" so
+// the results can be piped. Warnings and debug traces go to stderr either
+// way. The returned function closes the file.
+func openOutput(path string) (io.Writer, func() error, error) {
+	if path == "-" {
+		return os.Stdout, func() error { return nil }, nil
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, file.Close, nil
+}
+
+// writeAgentPrompt packages all prompts into a single instruction block that
+// can be pasted directly into an AI coding agent
+func writeAgentPrompt(w io.Writer, config *Config, pr *PullRequest, prompts []Prompt) {
+	fmt.Fprintf(w, "You are working in the %s/%s repository on branch %s.\n", config.Owner, config.Repo, config.Branch)
+	fmt.Fprintf(w, "CodeRabbitAI reviewed pull request #%d (%s) and left %d suggestion(s).\n", pr.Number, pr.Title, len(prompts))
+	fmt.Fprintln(w, "Apply the following review suggestions. For each one, verify the issue still exists in the current code before changing it, and keep unrelated code untouched.")
+	fmt.Fprintln(w)
+
+	for i, prompt := range prompts {
+		if loc := prompt.Location(); loc != "" {
+			fmt.Fprintf(w, "## %d. %s\n\n", i+1, loc)
+		} else {
+			fmt.Fprintf(w, "## %d. General\n\n", i+1)
+		}
+		fmt.Fprintf(w, "%s\n\n", prompt.Body)
+	}
+}
+
+// populateRepoConfig reads the repository's host, owner and name from the
+// origin remote, and with detectBranch the branch to look up a PR for
+func populateRepoConfig(dir string, detectBranch bool) error {
+	if detectBranch {
+		if err := populateBranch(dir); err != nil {
+			return err
+		}
+	}
+
+	// Get remote URL
+	cmd := exec.Command("git", "-C", dir, "config", "--get", "remote.origin.url")
+	remoteOutput, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("failed to get remote URL: %w", err)
+	}
+	remoteURL := strings.TrimSpace(string(remoteOutput))
+
+	// Parse host, owner and repo from URL
+	host, owner, repo, err := parseRemoteURL(remoteURL)
+	if err != nil {
+		return err
 	}
+
+	cfg.Host = host
+	cfg.Owner = owner
+	cfg.Repo = repo
+	return nil
 }
 
-func populateRepoConfig(dir string) error {
+// populateBranch sets the branch to look up a PR for
+func populateBranch(dir string) error {
 	// Get the tracking branch (upstream) for PR lookup
 	// Format: refs/remotes/origin/branch-name -> extract branch-name
 	cmd := exec.Command("git", "-C", dir, "rev-parse", "--abbrev-ref", "--symbolic-full-name", "@{upstream}")
@@ -213,42 +500,70 @@ func populateRepoConfig(dir string) error {
 			}
 		}
 	}
+	return nil
+}
 
-	// Get remote URL
-	cmd = exec.Command("git", "-C", dir, "config", "--get", "remote.origin.url")
-	remoteOutput, err := cmd.Output()
-	if err != nil {
-		return fmt.Errorf("failed to get remote URL: %w", err)
+// writeJSONPrompts writes the prompts with their comment metadata as a JSON
+// array, which is empty rather than null when there are none
+func writeJSONPrompts(w io.Writer, prompts []Prompt) error {
+	if prompts == nil {
+		prompts = []Prompt{}
 	}
-	remoteURL := strings.TrimSpace(string(remoteOutput))
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(prompts)
+}
 
-	// Parse owner and repo from URL
-	owner, repo, err := parseGitHubURL(remoteURL)
+// headSyncWarning compares the local HEAD with the PR's head commit and
+// describes how they differ, or returns "" when they match or can't be compared
+func headSyncWarning(dir, headSHA string) string {
+	if headSHA == "" {
+		return ""
+	}
+	output, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
 	if err != nil {
-		return err
+		return ""
+	}
+	if strings.TrimSpace(string(output)) == headSHA {
+		return ""
 	}
 
-	cfg.Owner = owner
-	cfg.Repo = repo
-	return nil
-}
+	short := headSHA
+	if len(short) > 7 {
+		short = short[:7]
+	}
 
-func parseGitHubURL(url string) (owner, repo string, err error) {
-	// Handle HTTPS URLs: https://github.com/owner/repo.git
-	httpsRegex := regexp.MustCompile(`github\.com[:/]([^/]+)/([^/]+?)(\.git)?$`)
-	matches := httpsRegex.FindStringSubmatch(url)
-	if len(matches) >= 3 {
-		return matches[1], matches[2], nil
+	if err := exec.Command("git", "-C", dir, "cat-file", "-e", headSHA+"^{commit}").Run(); err != nil {
+		return fmt.Sprintf("local branch differs from the PR head %s, which is not available locally; fetch to compare", short)
 	}
 
-	return "", "", fmt.Errorf("unable to parse GitHub URL: %s", url)
+	output, err = exec.Command("git", "-C", dir, "rev-list", "--left-right", "--count", "HEAD..."+headSHA).Output()
+	if err != nil {
+		return fmt.Sprintf("local branch differs from the PR head %s", short)
+	}
+	var ahead, behind int
+	fmt.Sscanf(string(output), "%d\t%d", &ahead, &behind)
+
+	switch {
+	case ahead > 0 && behind > 0:
+		return fmt.Sprintf("local branch has diverged from the PR head %s (%d commit(s) ahead, %d behind); prompts may not match your code", short, ahead, behind)
+	case ahead > 0:
+		return fmt.Sprintf("local branch is %d commit(s) ahead of the PR head %s; prompts may refer to code you have already changed", ahead, short)
+	default:
+		return fmt.Sprintf("local branch is %d commit(s) behind the PR head %s; pull before acting on prompts", behind, short)
+	}
 }
 
-func findPRForBranch(config *Config) (*PullRequest, error) {
+// githubProvider reads pull requests and their comments from the GitHub REST
+// API, and review thread status from the GraphQL API
+type githubProvider struct{}
+
+func (githubProvider) FindPRForBranch(ctx context.Context, config *Config) (*PullRequest, error) {
 	url := fmt.Sprintf("%s/repos/%s/%s/pulls?head=%s:%s&state=open",
 		githubAPIBase, config.Owner, config.Repo, config.Owner, config.Branch)
 
-	body, err := makeGitHubRequest(url, config.Token)
+	body, err := makeGitHubRequest(ctx, url, config.Token)
 	if err != nil {
 		return nil, err
 	}
@@ -265,9 +580,230 @@ func findPRForBranch(config *Config) (*PullRequest, error) {
 	return &prs[0], nil
 }
 
+func (githubProvider) GetPR(ctx context.Context, config *Config, number int) (*PullRequest, error) {
+	url := fmt.Sprintf("%s/repos/%s/%s/pulls/%d", githubAPIBase, config.Owner, config.Repo, number)
+
+	body, err := makeGitHubRequest(ctx, url, config.Token)
+	if isNotFound(err) {
+		return nil, fmt.Errorf("pull request #%d not found in %s/%s", number, config.Owner, config.Repo)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var pr PullRequest
+	if err := json.Unmarshal(body, &pr); err != nil {
+		return nil, fmt.Errorf("failed to parse PR: %w", err)
+	}
+	return &pr, nil
+}
+
+func (githubProvider) ListOpenPRs(ctx context.Context, config *Config) ([]PullRequest, error) {
+	url := fmt.Sprintf("%s/repos/%s/%s/pulls?state=open", githubAPIBase, config.Owner, config.Repo)
+
+	var prs []PullRequest
+	for body, err := range iterPages(ctx, "GitHub", url, config.Token, githubAccept) {
+		if err != nil {
+			return nil, err
+		}
+		var page []PullRequest
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("failed to parse PR list: %w", err)
+		}
+		prs = append(prs, page...)
+	}
+	return prs, nil
+}
+
+func (githubProvider) IterComments(ctx context.Context, config *Config, pr *PullRequest) iter.Seq2[ReviewComment, error] {
+	return func(yield func(ReviewComment, error) bool) {
+		comments, err := fetchGitHubComments(ctx, config, pr)
+		if err != nil {
+			yield(ReviewComment{}, err)
+			return
+		}
+		for _, comment := range comments {
+			if !yield(comment, nil) {
+				return
+			}
+		}
+	}
+}
+
+// fetchGitHubComments fetches a pull request's issue comments, its review
+// comments and its review thread status at the same time, with at most
+// config.Concurrency endpoints in flight. Pages of one endpoint depend on
+// each other's Link headers, so each endpoint is still paged in order. The
+// comments are returned as GitHub lists them: issue comments first, then
+// review comments. The first error stops the other fetches.
+func fetchGitHubComments(ctx context.Context, config *Config, pr *PullRequest) ([]ReviewComment, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	limit := config.Concurrency
+	if limit < 1 {
+		limit = 1
+	}
+	slots := make(chan struct{}, limit)
+
+	var (
+		wg             sync.WaitGroup
+		mu             sync.Mutex
+		firstErr       error
+		issueComments  []ReviewComment
+		reviewComments []ReviewComment
+		threadStatus   map[int]ThreadStatus
+	)
+	fetch := func(f func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err error
+			select {
+			case slots <- struct{}{}:
+				err = f()
+				<-slots
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// Get thread status via GraphQL (only if we need to filter or report
+	// it). The REST API doesn't report it, so when GraphQL is unavailable,
+	// e.g. to a token without access to it, every thread is kept.
+	if !config.IncludeResolved || !config.IncludeOutdated || config.Format == "json" {
+		fetch(func() error {
+			status, err := getReviewThreadStatusGraphQL(ctx, config, pr.Number)
+			if err != nil && ctx.Err() != nil {
+				// Out of time, not a token without GraphQL access
+				return err
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: cannot get review thread status via GraphQL, so resolved and outdated threads are included: %v\n", err)
+				return nil
+			}
+			mu.Lock()
+			threadStatus = status
+			mu.Unlock()
+			return nil
+		})
+	}
+
+	// Get PR comments (issue comments - not part of code review threads) with pagination
+	fetch(func() error {
+		issueCommentsURL := fmt.Sprintf("%s/repos/%s/%s/issues/%d/comments",
+			githubAPIBase, config.Owner, config.Repo, pr.Number)
+
+		for body, err := range iterPages(ctx, "GitHub", issueCommentsURL, config.Token, githubAccept) {
+			if err != nil {
+				return err
+			}
+
+			var comments []Comment
+			if err := json.Unmarshal(body, &comments); err != nil {
+				return fmt.Errorf("failed to parse comments: %w", err)
+			}
+
+			// Issue comments are never part of resolved threads
+			mu.Lock()
+			for _, comment := range comments {
+				issueComments = append(issueComments, ReviewComment{
+					ID:        comment.ID,
+					Body:      comment.Body,
+					Author:    comment.User.Login,
+					Bot:       comment.User.Type == "Bot",
+					CreatedAt: comment.CreatedAt,
+				})
+			}
+			mu.Unlock()
+		}
+		return nil
+	})
+
+	// Get review comments with pagination
+	fetch(func() error {
+		reviewURL := fmt.Sprintf("%s/repos/%s/%s/pulls/%d/comments",
+			githubAPIBase, config.Owner, config.Repo, pr.Number)
+
+		for body, err := range iterPages(ctx, "GitHub", reviewURL, config.Token, githubAccept) {
+			if err != nil {
+				return err
+			}
+
+			var comments []struct {
+				ID                  int       `json:"id"`
+				Body                string    `json:"body"`
+				User                User      `json:"user"`
+				CreatedAt           time.Time `json:"created_at"`
+				PullRequestReviewID *int      `json:"pull_request_review_id"`
+				InReplyToID         *int      `json:"in_reply_to_id"`
+				Path                string    `json:"path"`
+				Line                *int      `json:"line"`
+				OriginalLine        *int      `json:"original_line"`
+				CommitID            string    `json:"commit_id"`
+				OriginalCommitID    string    `json:"original_commit_id"`
+			}
+			if err := json.Unmarshal(body, &comments); err != nil {
+				return fmt.Errorf("failed to parse review comments: %w", err)
+			}
+
+			mu.Lock()
+			for _, comment := range comments {
+				// Outdated comments no longer have a current line, so fall
+				// back to the line they were originally left on
+				line := 0
+				if comment.Line != nil {
+					line = *comment.Line
+				} else if comment.OriginalLine != nil {
+					line = *comment.OriginalLine
+				}
+
+				reviewComment := ReviewComment{
+					ID:        comment.ID,
+					Body:      comment.Body,
+					Author:    comment.User.Login,
+					Bot:       comment.User.Type == "Bot",
+					CreatedAt: comment.CreatedAt,
+					Path:      comment.Path,
+					Line:      line,
+					CommitIDs: []string{comment.CommitID, comment.OriginalCommitID},
+				}
+				if comment.PullRequestReviewID != nil {
+					reviewComment.ReviewID = *comment.PullRequestReviewID
+				}
+				reviewComments = append(reviewComments, reviewComment)
+			}
+			mu.Unlock()
+		}
+		return nil
+	})
+
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	// Thread status is only known when it was fetched
+	for i := range reviewComments {
+		status := threadStatus[reviewComments[i].ID]
+		reviewComments[i].Resolved = status.IsResolved
+		reviewComments[i].Outdated = status.IsOutdated
+	}
+	return append(issueComments, reviewComments...), nil
+}
+
 // getReviewThreadStatusGraphQL fetches review thread status using GitHub GraphQL API.
 // Returns a map of comment database IDs to their thread status (resolved/outdated).
-func getReviewThreadStatusGraphQL(config *Config, prNumber int) (map[int]ThreadStatus, error) {
+func getReviewThreadStatusGraphQL(ctx context.Context, config *Config, prNumber int) (map[int]ThreadStatus, error) {
 	query := `
 query($owner: String!, $repo: String!, $prNumber: Int!, $cursor: String) {
   repository(owner: $owner, name: $repo) {
@@ -308,7 +844,7 @@ query($owner: String!, $repo: String!, $prNumber: Int!, $cursor: String) {
 			"cursor":   cursor,
 		}
 
-		resp, err := makeGraphQLRequest(query, variables, config.Token)
+		resp, err := makeGraphQLRequest(ctx, query, variables, config.Token)
 		if err != nil {
 			return nil, fmt.Errorf("GraphQL request failed: %w", err)
 		}
@@ -339,7 +875,7 @@ query($owner: String!, $repo: String!, $prNumber: Int!, $cursor: String) {
 }
 
 // makeGraphQLRequest sends a GraphQL query to GitHub and returns the parsed response.
-func makeGraphQLRequest(query string, variables map[string]interface{}, token string) (*GraphQLResponse, error) {
+func makeGraphQLRequest(ctx context.Context, query string, variables map[string]interface{}, token string) (*GraphQLResponse, error) {
 	reqBody := GraphQLRequest{
 		Query:     query,
 		Variables: variables,
@@ -350,9 +886,6 @@ func makeGraphQLRequest(query string, variables map[string]interface{}, token st
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
 	req, err := http.NewRequestWithContext(ctx, "POST", githubGraphQLURL, bytes.NewReader(jsonBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
@@ -362,37 +895,21 @@ func makeGraphQLRequest(query string, variables map[string]interface{}, token st
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("User-Agent", userAgent)
 
-	if debugMode {
-		fmt.Fprintf(os.Stderr, "\n=== GraphQL REQUEST ===\n")
-		fmt.Fprintf(os.Stderr, "URL: %s\n", githubGraphQLURL)
-		fmt.Fprintf(os.Stderr, "Query: %s\n", query)
-		prettyVars, _ := json.MarshalIndent(variables, "", "  ")
-		fmt.Fprintf(os.Stderr, "Variables: %s\n\n", string(prettyVars))
-	}
+	debugLog.Debug("graphql request",
+		"url", githubGraphQLURL,
+		"query", query,
+		"variables", variables,
+	)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, body, err := doWithRetry(ctx, "GitHub", req)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, err
 	}
 
-	if debugMode {
-		fmt.Fprintf(os.Stderr, "=== GraphQL RESPONSE ===\n")
-		fmt.Fprintf(os.Stderr, "Status: %d %s\n", resp.StatusCode, resp.Status)
-		var prettyJSON interface{}
-		if err := json.Unmarshal(body, &prettyJSON); err == nil {
-			prettyBody, _ := json.MarshalIndent(prettyJSON, "", "  ")
-			fmt.Fprintf(os.Stderr, "Body:\n%s\n\n", string(prettyBody))
-		} else {
-			fmt.Fprintf(os.Stderr, "Body:\n%s\n\n", string(body))
-		}
-	}
+	debugLog.Debug("graphql response",
+		"status", resp.StatusCode,
+		debugBody(body),
+	)
 
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("GitHub GraphQL API error (status %d): %s", resp.StatusCode, string(body))
@@ -406,112 +923,155 @@ func makeGraphQLRequest(query string, variables map[string]interface{}, token st
 	return &graphQLResp, nil
 }
 
-func extractAIPrompts(config *Config, prNumber int, includeResolved, includeOutdated bool) ([]string, error) {
-	// Get thread status via GraphQL (only if we need to filter)
-	var threadStatus map[int]ThreadStatus
-	if !includeResolved || !includeOutdated {
-		var err error
-		threadStatus, err = getReviewThreadStatusGraphQL(config, prNumber)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get thread status via GraphQL: %w", err)
-		}
-	}
-
-	var prompts []string
-	promptRegex := regexp.MustCompile(`(?s)Prompt for AI Agents.*?\n\s*\x60\x60\x60[^\n]*\n(.*?)\n\s*\x60\x60\x60`)
+// promptRegex matches the code block that follows a "Prompt for AI Agents"
+// heading in a CodeRabbitAI comment. CARROTS_PROMPT_PATTERN replaces it.
+var promptRegex = regexp.MustCompile(`(?s)Prompt for AI Agents.*?\n\s*\x60\x60\x60[^\n]*\n(.*?)\n\s*\x60\x60\x60`)
 
-	// Get PR comments (issue comments - not part of code review threads) with pagination
-	issueCommentsURL := fmt.Sprintf("%s/repos/%s/%s/issues/%d/comments",
-		githubAPIBase, config.Owner, config.Repo, prNumber)
+// extractAIPrompts collects the prompts from CodeRabbitAI's comments,
+// filtering out resolved/outdated threads and other commits as configured
+func extractAIPrompts(config *Config, comments iter.Seq2[ReviewComment, error]) ([]Prompt, error) {
+	pattern := promptRegex
+	if config.PromptRegex != nil {
+		pattern = config.PromptRegex
+	}
 
-	for body, err := range iterGitHubPages(issueCommentsURL, config.Token, "application/vnd.github.v3+json") {
+	var prompts []Prompt
+	for comment, err := range comments {
 		if err != nil {
 			return nil, err
 		}
 
-		var comments []Comment
-		if err := json.Unmarshal(body, &comments); err != nil {
-			return nil, fmt.Errorf("failed to parse comments: %w", err)
+		// Check if comment is from a review bot
+		if !isBotComment(config, comment) {
+			continue
 		}
 
-		// Process issue comments (these are never part of resolved threads)
-		for _, comment := range comments {
-			// Check if comment is from coderabbitai bot
-			if comment.User.Login != "coderabbitai" && comment.User.Type != "Bot" {
-				continue
-			}
-
-			// Extract prompts from comment body
-			matches := promptRegex.FindAllStringSubmatch(comment.Body, -1)
-			for _, match := range matches {
-				if len(match) > 1 {
-					prompts = append(prompts, strings.TrimSpace(match[1]))
-				}
-			}
+		// Skip comments left on other commits, e.g. before a force push.
+		// General comments are not tied to a commit.
+		if config.Commit != "" && len(comment.CommitIDs) > 0 && !matchesCommit(config.Commit, comment.CommitIDs...) {
+			continue
 		}
-	}
 
-	// Get review comments with pagination
-	reviewURL := fmt.Sprintf("%s/repos/%s/%s/pulls/%d/comments",
-		githubAPIBase, config.Owner, config.Repo, prNumber)
-
-	for body, err := range iterGitHubPages(reviewURL, config.Token, "application/vnd.github.v3+json") {
-		if err != nil {
-			return nil, err
+		// Skip comments from earlier review rounds
+		if !config.SinceTime.IsZero() && comment.CreatedAt.Before(config.SinceTime) {
+			continue
+		}
+		if config.SinceReview != 0 && comment.ReviewID != config.SinceReview {
+			continue
 		}
 
-		var reviewComments []struct {
-			ID                  int       `json:"id"`
-			Body                string    `json:"body"`
-			User                User      `json:"user"`
-			CreatedAt           time.Time `json:"created_at"`
-			PullRequestReviewID *int      `json:"pull_request_review_id"`
-			InReplyToID         *int      `json:"in_reply_to_id"`
+		// Skip if this thread is resolved (unless including resolved)
+		if !config.IncludeResolved && comment.Resolved {
+			continue
 		}
-		if err := json.Unmarshal(body, &reviewComments); err != nil {
-			return nil, fmt.Errorf("failed to parse review comments: %w", err)
+		// Skip if this thread is outdated (unless including outdated)
+		if !config.IncludeOutdated && comment.Outdated {
+			continue
 		}
 
-		// Process review comments, filtering out resolved/outdated threads if requested
-		for _, comment := range reviewComments {
-			// Check if comment is from coderabbitai bot
-			if comment.User.Login != "coderabbitai" && comment.User.Type != "Bot" {
-				continue
+		// Extract prompts from comment body, using the first group if the
+		// pattern has one
+		matches := pattern.FindAllStringSubmatch(comment.Body, -1)
+		for _, match := range matches {
+			body := match[0]
+			if len(match) > 1 {
+				body = match[1]
 			}
+			prompts = append(prompts, Prompt{
+				Body:      strings.TrimSpace(body),
+				Path:      comment.Path,
+				Line:      comment.Line,
+				CommentID: comment.ID,
+				Author:    comment.Author,
+				CreatedAt: comment.CreatedAt,
+				Resolved:  comment.Resolved,
+				Outdated:  comment.Outdated,
+			})
+		}
+	}
 
-			// Check thread status using GraphQL data
-			if status, ok := threadStatus[comment.ID]; ok {
-				// Skip if this thread is resolved (unless including resolved)
-				if !includeResolved && status.IsResolved {
-					continue
-				}
-				// Skip if this thread is outdated (unless including outdated)
-				if !includeOutdated && status.IsOutdated {
-					continue
-				}
-			}
+	if config.Dedup {
+		prompts = dedupePrompts(prompts)
+	}
+	return prompts, nil
+}
 
-			// Extract prompts from comment body
-			matches := promptRegex.FindAllStringSubmatch(comment.Body, -1)
-			for _, match := range matches {
-				if len(match) > 1 {
-					prompts = append(prompts, strings.TrimSpace(match[1]))
-				}
+// dedupePrompts keeps the first prompt with each text, in order. A summary
+// comment usually comes first, so a later copy's location is kept when the
+// first copy has none.
+func dedupePrompts(prompts []Prompt) []Prompt {
+	index := make(map[string]int)
+	var unique []Prompt
+	for _, prompt := range prompts {
+		if i, ok := index[prompt.Body]; ok {
+			if first := &unique[i]; first.Path == "" && prompt.Path != "" {
+				first.Path, first.Line = prompt.Path, prompt.Line
 			}
+			continue
 		}
+		index[prompt.Body] = len(unique)
+		unique = append(unique, prompt)
 	}
+	return unique
+}
 
-	return prompts, nil
+// isBotComment reports whether a comment was left by one of the configured
+// bot logins, or by any account the provider marks as a bot. Without
+// CARROTS_BOT_LOGINS only coderabbitai is listed.
+func isBotComment(config *Config, comment ReviewComment) bool {
+	if comment.Bot {
+		return true
+	}
+	logins := config.BotLogins
+	if logins == nil {
+		logins = []string{"coderabbitai"}
+	}
+	for _, login := range logins {
+		if strings.EqualFold(strings.TrimSpace(login), comment.Author) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSince parses CARROTS_SINCE, which is either an RFC3339 timestamp or
+// the numeric ID of a review
+func parseSince(s string) (time.Time, int, error) {
+	s = strings.TrimSpace(s)
+	if id, err := strconv.Atoi(s); err == nil {
+		if id <= 0 {
+			return time.Time{}, 0, fmt.Errorf("review ID must be positive, got %d", id)
+		}
+		return time.Time{}, id, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("%q is neither an RFC3339 timestamp nor a review ID", s)
+	}
+	return t, 0, nil
+}
+
+// matchesCommit reports whether a review comment's current or original
+// commit is the wanted one, which may be abbreviated
+func matchesCommit(want string, commitIDs ...string) bool {
+	want = strings.ToLower(strings.TrimSpace(want))
+	for _, id := range commitIDs {
+		if id != "" && strings.HasPrefix(strings.ToLower(id), want) {
+			return true
+		}
+	}
+	return false
 }
 
-func makeGitHubRequest(url, token string) ([]byte, error) {
-	body, _, err := makeGitHubRequestWithAccept(url, token, "application/vnd.github.v3+json")
+func makeGitHubRequest(ctx context.Context, url, token string) ([]byte, error) {
+	body, _, err := makeAPIRequest(ctx, "GitHub", url, token, githubAccept)
 	return body, err
 }
 
-// iterGitHubPages returns an iterator that yields each page of results from a paginated GitHub API endpoint.
-// It automatically adds per_page=100 and follows Link headers.
-func iterGitHubPages(baseURL, token, acceptHeader string) func(yield func([]byte, error) bool) {
+// iterPages returns an iterator that yields each page of results from a paginated API endpoint.
+// It automatically adds per_page=100 and follows Link headers, which GitHub and GitLab both send.
+// Once ctx is done it yields ctx's error instead of requesting another page.
+func iterPages(ctx context.Context, service, baseURL, token, acceptHeader string) func(yield func([]byte, error) bool) {
 	return func(yield func([]byte, error) bool) {
 		// Add per_page=100 to the URL
 		url := baseURL
@@ -522,7 +1082,11 @@ func iterGitHubPages(baseURL, token, acceptHeader string) func(yield func([]byte
 		}
 
 		for url != "" {
-			body, nextURL, err := makeGitHubRequestWithAccept(url, token, acceptHeader)
+			if err := ctx.Err(); err != nil {
+				yield(nil, err)
+				return
+			}
+			body, nextURL, err := makeAPIRequest(ctx, service, url, token, acceptHeader)
 			if !yield(body, err) {
 				return
 			}
@@ -534,7 +1098,7 @@ func iterGitHubPages(baseURL, token, acceptHeader string) func(yield func([]byte
 	}
 }
 
-// parseNextLink extracts the "next" URL from a GitHub Link header.
+// parseNextLink extracts the "next" URL from a Link header.
 // Example: <https://api.github.com/...?page=2>; rel="next", <https://...>; rel="last"
 func parseNextLink(linkHeader string) string {
 	if linkHeader == "" {
@@ -550,10 +1114,27 @@ func parseNextLink(linkHeader string) string {
 	return ""
 }
 
-func makeGitHubRequestWithAccept(url, token, acceptHeader string) ([]byte, string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+// apiError is a response from a REST API with an unexpected status
+type apiError struct {
+	Service string
+	Status  int
+	Body    string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s API error (status %d): %s", e.Service, e.Status, e.Body)
+}
 
+// isNotFound reports whether err is a 404 from a REST API
+func isNotFound(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound
+}
+
+// makeAPIRequest fetches url from the service's REST API and returns the body
+// along with the next page's URL, if any. GitLab accepts personal access
+// tokens as bearer tokens too.
+func makeAPIRequest(ctx context.Context, service, url, token, acceptHeader string) ([]byte, string, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to create request: %w", err)
@@ -563,66 +1144,29 @@ func makeGitHubRequestWithAccept(url, token, acceptHeader string) ([]byte, strin
 	req.Header.Set("Accept", acceptHeader)
 	req.Header.Set("User-Agent", userAgent)
 
-	if debugMode {
-		fmt.Fprintf(os.Stderr, "\n=== API REQUEST ===\n")
-		fmt.Fprintf(os.Stderr, "Method: %s\n", req.Method)
-		fmt.Fprintf(os.Stderr, "URL: %s\n", url)
-		fmt.Fprintf(os.Stderr, "Headers:\n")
-		for k, v := range req.Header {
-			// Redact the token for security
-			if k == "Authorization" {
-				fmt.Fprintf(os.Stderr, "  %s: Bearer [REDACTED]\n", k)
-			} else {
-				fmt.Fprintf(os.Stderr, "  %s: %s\n", k, strings.Join(v, ", "))
-			}
-		}
-		fmt.Fprintf(os.Stderr, "\n")
-	}
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, "", fmt.Errorf("request failed: %w", err)
-	}
-	defer resp.Body.Close()
+	debugLog.Debug("api request",
+		"method", req.Method,
+		"url", url,
+		"headers", debugHeaders(req.Header),
+	)
 
-	body, err := io.ReadAll(resp.Body)
+	resp, body, err := doWithRetry(ctx, service, req)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to read response: %w", err)
+		return nil, "", err
 	}
 
 	// Extract next page URL from Link header
 	nextURL := parseNextLink(resp.Header.Get("Link"))
 
-	if debugMode {
-		fmt.Fprintf(os.Stderr, "=== API RESPONSE ===\n")
-		fmt.Fprintf(os.Stderr, "Status: %d %s\n", resp.StatusCode, resp.Status)
-		fmt.Fprintf(os.Stderr, "Headers:\n")
-		for k, v := range resp.Header {
-			fmt.Fprintf(os.Stderr, "  %s: %s\n", k, strings.Join(v, ", "))
-		}
-		if nextURL != "" {
-			fmt.Fprintf(os.Stderr, "Next Page: %s\n", nextURL)
-		}
-		fmt.Fprintf(os.Stderr, "\nBody:\n")
-
-		// Try to pretty print JSON
-		var prettyJSON interface{}
-		if err := json.Unmarshal(body, &prettyJSON); err == nil {
-			prettyBody, err := json.MarshalIndent(prettyJSON, "", "  ")
-			if err == nil {
-				fmt.Fprintf(os.Stderr, "%s\n", string(prettyBody))
-			} else {
-				fmt.Fprintf(os.Stderr, "%s\n", string(body))
-			}
-		} else {
-			fmt.Fprintf(os.Stderr, "%s\n", string(body))
-		}
-		fmt.Fprintf(os.Stderr, "\n")
-	}
+	debugLog.Debug("api response",
+		"status", resp.StatusCode,
+		"headers", debugHeaders(resp.Header),
+		"next", nextURL,
+		debugBody(body),
+	)
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf("GitHub API error (status %d): %s", resp.StatusCode, string(body))
+		return nil, "", &apiError{Service: service, Status: resp.StatusCode, Body: string(body)}
 	}
 
 	return body, nextURL, nil
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWriteAgentPrompt(t *testing.T) {
+	config := &Config{Owner: "octo", Repo: "widgets", Branch: "feature"}
+	pr := &PullRequest{Number: 42, Title: "Add widgets"}
+	prompts := []Prompt{
+		{Body: "In main.go around line 10, handle the error.", Path: "main.go", Line: 10},
+		{Body: "Consider adding tests.", Path: "README.md"},
+		{Body: "Summarize the change."},
+	}
+
+	var buf bytes.Buffer
+	writeAgentPrompt(&buf, config, pr, prompts)
+	got := buf.String()
+
+	for _, want := range []string{
+		"octo/widgets repository on branch feature",
+		"pull request #42 (Add widgets) and left 3 suggestion(s)",
+		"Apply the following review suggestions.",
+		"## 1. main.go:10\n\nIn main.go around line 10, handle the error.\n",
+		"## 2. README.md\n\nConsider adding tests.\n",
+		"## 3. General\n\nSummarize the change.\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("agent prompt missing %q\ngot:\n%s", want, got)
+		}
+	}
+
+	if strings.Index(got, "## 1.") > strings.Index(got, "## 2.") {
+		t.Errorf("prompts out of order:\n%s", got)
+	}
+}
+
+func TestHeadSyncWarning(t *testing.T) {
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	git("init", "-q", "-b", "main")
+	git("commit", "-q", "--allow-empty", "-m", "base")
+	base := git("rev-parse", "HEAD")
+	git("commit", "-q", "--allow-empty", "-m", "pr")
+	prHead := git("rev-parse", "HEAD")
+
+	if got := headSyncWarning(dir, prHead); got != "" {
+		t.Errorf("in sync: got %q, want no warning", got)
+	}
+
+	git("commit", "-q", "--allow-empty", "-m", "local")
+	if got := headSyncWarning(dir, prHead); !strings.Contains(got, "1 commit(s) ahead of the PR head "+prHead[:7]) {
+		t.Errorf("ahead: got %q", got)
+	}
+
+	git("reset", "-q", "--hard", base)
+	if got := headSyncWarning(dir, prHead); !strings.Contains(got, "1 commit(s) behind the PR head") {
+		t.Errorf("behind: got %q", got)
+	}
+
+	git("commit", "-q", "--allow-empty", "-m", "other")
+	git("commit", "-q", "--allow-empty", "-m", "another")
+	if got := headSyncWarning(dir, prHead); !strings.Contains(got, "diverged from the PR head "+prHead[:7]+" (2 commit(s) ahead, 1 behind)") {
+		t.Errorf("diverged: got %q", got)
+	}
+
+	missing := strings.Repeat("ab", 20)
+	if got := headSyncWarning(dir, missing); !strings.Contains(got, "not available locally") {
+		t.Errorf("missing: got %q", got)
+	}
+}
+
+func TestMatchesCommit(t *testing.T) {
+	const current = "9fceb02d0ae598e95dc970b74767f19372d61af8"
+	const original = "0d1d7fc32e5a947fbd92ee598033d85bfc445a50"
+
+	tests := []struct {
+		want string
+		ids  []string
+		ok   bool
+	}{
+		{want: current, ids: []string{current, original}, ok: true},
+		{want: original, ids: []string{current, original}, ok: true},
+		{want: "9fceb02", ids: []string{current, original}, ok: true},
+		{want: "9FCEB02", ids: []string{current}, ok: true},
+		{want: "deadbeef", ids: []string{current, original}, ok: false},
+		{want: "9fceb02", ids: []string{"", ""}, ok: false},
+	}
+
+	for _, tt := range tests {
+		if got := matchesCommit(tt.want, tt.ids...); got != tt.ok {
+			t.Errorf("matchesCommit(%q, %v) = %v, want %v", tt.want, tt.ids, got, tt.ok)
+		}
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	since, review, err := parseSince("2025-03-01T12:00:00Z")
+	if err != nil || !since.Equal(time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)) || review != 0 {
+		t.Errorf("parseSince(timestamp) = %v, %d, %v", since, review, err)
+	}
+	since, review, err = parseSince(" 2001 ")
+	if err != nil || !since.IsZero() || review != 2001 {
+		t.Errorf("parseSince(review ID) = %v, %d, %v", since, review, err)
+	}
+	for _, bad := range []string{"yesterday", "2025-03-01", "0", "-5"} {
+		if _, _, err := parseSince(bad); err == nil {
+			t.Errorf("parseSince(%q) should fail", bad)
+		}
+	}
+}
+
+// reviewThreadPages are GraphQL responses recorded from a pull request with
+// three review threads, trimmed to the fields carrots queries
+var reviewThreadPages = []string{`{
+  "data": {
+    "repository": {
+      "pullRequest": {
+        "reviewThreads": {
+          "pageInfo": {"hasNextPage": true, "endCursor": "Y3Vyc29yOnYyOpK0MjAyNC0wNS0xNFQxMjowMDowMFo"},
+          "nodes": [
+            {
+              "id": "PRRT_kwDOLx8Bzs5AbCdE",
+              "isResolved": true,
+              "isOutdated": false,
+              "comments": {"nodes": [
+                {"databaseId": 1601234567, "body": "_⚠️ Potential issue_", "author": {"login": "coderabbitai"}},
+                {"databaseId": 1601239999, "body": "Fixed in abc123", "author": {"login": "octocat"}}
+              ]}
+            },
+            {
+              "id": "PRRT_kwDOLx8Bzs5AbCdF",
+              "isResolved": false,
+              "isOutdated": true,
+              "comments": {"nodes": [
+                {"databaseId": 1601234568, "body": "_🛠️ Refactor suggestion_", "author": {"login": "coderabbitai"}}
+              ]}
+            }
+          ]
+        }
+      }
+    }
+  }
+}`, `{
+  "data": {
+    "repository": {
+      "pullRequest": {
+        "reviewThreads": {
+          "pageInfo": {"hasNextPage": false, "endCursor": "Y3Vyc29yOnYyOpK0MjAyNC0wNS0xNVQwOTozMDowMFo"},
+          "nodes": [
+            {
+              "id": "PRRT_kwDOLx8Bzs5AbCdG",
+              "isResolved": false,
+              "isOutdated": false,
+              "comments": {"nodes": [
+                {"databaseId": 1601234569, "body": "_💡 Verification agent_", "author": {"login": "coderabbitai"}}
+              ]}
+            }
+          ]
+        }
+      }
+    }
+  }
+}`}
+
+func TestReviewThreadStatusGraphQL(t *testing.T) {
+	var cursors []interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req GraphQLRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid GraphQL request: %v", err)
+			return
+		}
+		if req.Variables["owner"] != "octo" || req.Variables["repo"] != "widgets" || req.Variables["prNumber"] != float64(42) {
+			t.Errorf("unexpected variables: %v", req.Variables)
+		}
+		cursors = append(cursors, req.Variables["cursor"])
+		if len(cursors) > len(reviewThreadPages) {
+			t.Errorf("too many requests")
+			return
+		}
+		w.Write([]byte(reviewThreadPages[len(cursors)-1]))
+	}))
+	defer srv.Close()
+
+	defer func(url string) { githubGraphQLURL = url }(githubGraphQLURL)
+	githubGraphQLURL = srv.URL
+
+	status, err := getReviewThreadStatusGraphQL(context.Background(), &Config{Owner: "octo", Repo: "widgets", Token: "test"}, 42)
+	if err != nil {
+		t.Fatalf("getReviewThreadStatusGraphQL failed: %v", err)
+	}
+
+	want := map[int]ThreadStatus{
+		1601234567: {IsResolved: true},
+		1601239999: {IsResolved: true},
+		1601234568: {IsOutdated: true},
+		1601234569: {},
+	}
+	if len(status) != len(want) {
+		t.Errorf("got %d comments, want %d: %v", len(status), len(want), status)
+	}
+	for id, w := range want {
+		if got, ok := status[id]; !ok || got != w {
+			t.Errorf("comment %d: got %+v (found %v), want %+v", id, got, ok, w)
+		}
+	}
+
+	// The second page is requested with the first page's end cursor
+	if len(cursors) != 2 || cursors[0] != nil || cursors[1] != "Y3Vyc29yOnYyOpK0MjAyNC0wNS0xNFQxMjowMDowMFo" {
+		t.Errorf("unexpected cursors: %v", cursors)
+	}
+}
+
+func TestReviewThreadStatusGraphQLError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data": null, "errors": [{"type": "FORBIDDEN", "message": "Resource not accessible by personal access token"}]}`))
+	}))
+	defer srv.Close()
+
+	defer func(url string) { githubGraphQLURL = url }(githubGraphQLURL)
+	githubGraphQLURL = srv.URL
+
+	_, err := getReviewThreadStatusGraphQL(context.Background(), &Config{Owner: "octo", Repo: "widgets"}, 42)
+	if err == nil || !strings.Contains(err.Error(), "Resource not accessible") {
+		t.Errorf("expected the GraphQL error, got %v", err)
+	}
+}
+
+func TestGitHubGetPR(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/octo/widgets/pulls/42":
+			w.Write([]byte(`{"number": 42, "title": "Add widgets", "state": "open", "head": {"ref": "feature", "sha": "9fceb02"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "Not Found"}`))
+		}
+	}))
+	defer srv.Close()
+
+	defer func(url string) { githubAPIBase = url }(githubAPIBase)
+	githubAPIBase = srv.URL
+
+	config := &Config{Owner: "octo", Repo: "widgets", Token: "test"}
+	pr, err := githubProvider{}.GetPR(context.Background(), config, 42)
+	if err != nil {
+		t.Fatalf("GetPR failed: %v", err)
+	}
+	if pr.Number != 42 || pr.Title != "Add widgets" || pr.Head.Ref != "feature" || pr.Head.SHA != "9fceb02" {
+		t.Errorf("unexpected PR: %+v", pr)
+	}
+
+	if _, err := (githubProvider{}).GetPR(context.Background(), config, 7); err == nil || err.Error() != "pull request #7 not found in octo/widgets" {
+		t.Errorf("missing PR: got %v", err)
+	}
+}
+
+func TestWriteJSONPrompts(t *testing.T) {
+	comments := func(yield func(ReviewComment, error) bool) {
+		yield(ReviewComment{
+			ID:        1601234567,
+			Body:      "<summary>🤖 Prompt for AI Agents</summary>\n\n```\nIn main.go around line 10, handle the error.\n```\n",
+			Author:    "coderabbitai[bot]",
+			Bot:       true,
+			CreatedAt: time.Date(2024, 5, 14, 12, 0, 0, 0, time.UTC),
+			Path:      "main.go",
+			Line:      10,
+			CommitIDs: []string{"9fceb02"},
+			Resolved:  true,
+		}, nil)
+	}
+	prompts, err := extractAIPrompts(&Config{IncludeResolved: true}, comments)
+	if err != nil {
+		t.Fatalf("extractAIPrompts failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeJSONPrompts(&buf, prompts); err != nil {
+		t.Fatalf("writeJSONPrompts failed: %v", err)
+	}
+
+	var got []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+	want := map[string]interface{}{
+		"body":       "In main.go around line 10, handle the error.",
+		"path":       "main.go",
+		"line":       float64(10),
+		"comment_id": float64(1601234567),
+		"author":     "coderabbitai[bot]",
+		"created_at": "2024-05-14T12:00:00Z",
+		"resolved":   true,
+		"outdated":   false,
+	}
+	if len(got) != 1 || !reflect.DeepEqual(got[0], want) {
+		t.Errorf("got %v, want [%v]", got, want)
+	}
+
+	buf.Reset()
+	writeJSONPrompts(&buf, nil)
+	if strings.TrimSpace(buf.String()) != "[]" {
+		t.Errorf("no prompts: got %s, want []", buf.String())
+	}
+}
+
+func TestOpenOutputStdout(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out, closeOutput, err := openOutput("-")
+	if err != nil {
+		t.Fatalf("openOutput(-) error = %v", err)
+	}
+	if err := writeJSONPrompts(out, []Prompt{{Body: "Handle the error.", CommentID: 7}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := closeOutput(); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	os.Stdout = stdout
+
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prompts []Prompt
+	if err := json.Unmarshal(got, &prompts); err != nil || len(prompts) != 1 || prompts[0].Body != "Handle the error." {
+		t.Errorf("stdout = %q, want the prompts as JSON", got)
+	}
+	if _, err := os.Stat("-"); !os.IsNotExist(err) {
+		t.Errorf("openOutput(-) created a file named -")
+	}
+}
+
+func TestFetchGitHubCommentsConcurrently(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			prev := maxInFlight.Load()
+			if n <= prev || maxInFlight.CompareAndSwap(prev, n) {
+				break
+			}
+		}
+		// Slow enough for the endpoints to overlap when fetched together
+		time.Sleep(50 * time.Millisecond)
+
+		switch {
+		case r.URL.Path == "/graphql":
+			w.Write([]byte(reviewThreadPages[1]))
+		case r.URL.Path == "/repos/octo/widgets/issues/42/comments" && r.URL.Query().Get("page") == "":
+			w.Header().Set("Link", "<"+srv.URL+r.URL.Path+"?page=2>; rel=\"next\"")
+			w.Write([]byte(`[{"id": 1, "body": "first"}]`))
+		case r.URL.Path == "/repos/octo/widgets/issues/42/comments":
+			w.Write([]byte(`[{"id": 2, "body": "second"}]`))
+		case r.URL.Path == "/repos/octo/widgets/pulls/42/comments":
+			w.Write([]byte(`[{"id": 1601234569, "body": "review", "path": "main.go", "line": 3, "pull_request_review_id": 2001}]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	defer func(api, graphql string) { githubAPIBase, githubGraphQLURL = api, graphql }(githubAPIBase, githubGraphQLURL)
+	githubAPIBase, githubGraphQLURL = srv.URL, srv.URL+"/graphql"
+
+	for _, tt := range []struct {
+		concurrency int
+		wantMax     int32
+	}{
+		{concurrency: 1, wantMax: 1},
+		{concurrency: 3, wantMax: 3},
+	} {
+		maxInFlight.Store(0)
+		config := &Config{Owner: "octo", Repo: "widgets", Token: "test", Concurrency: tt.concurrency}
+		comments, err := fetchGitHubComments(context.Background(), config, &PullRequest{Number: 42})
+		if err != nil {
+			t.Fatalf("concurrency %d: fetchGitHubComments failed: %v", tt.concurrency, err)
+		}
+		if got := maxInFlight.Load(); got != tt.wantMax {
+			t.Errorf("concurrency %d: %d requests in flight at once, want %d", tt.concurrency, got, tt.wantMax)
+		}
+
+		var ids []int
+		for _, comment := range comments {
+			ids = append(ids, comment.ID)
+		}
+		if !reflect.DeepEqual(ids, []int{1, 2, 1601234569}) {
+			t.Errorf("concurrency %d: got comments %v, want issue comments then review comments", tt.concurrency, ids)
+		}
+		if last := comments[len(comments)-1]; last.Path != "main.go" || last.Line != 3 || last.ReviewID != 2001 || last.Resolved || last.Outdated {
+			t.Errorf("concurrency %d: unexpected review comment %+v", tt.concurrency, last)
+		}
+	}
+}
+
+func TestFetchGitHubCommentsError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/repos/octo/widgets/pulls/42/comments" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "Not Found"}`))
+			return
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+
+	defer func(url string) { githubAPIBase = url }(githubAPIBase)
+	githubAPIBase = srv.URL
+
+	config := &Config{Owner: "octo", Repo: "widgets", Token: "test", Concurrency: 3, IncludeResolved: true, IncludeOutdated: true}
+	if _, err := fetchGitHubComments(context.Background(), config, &PullRequest{Number: 42}); !isNotFound(err) {
+		t.Errorf("expected the review comments error, got %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Provider is a code host carrots can read review prompts from. GitLab
+// merge requests are represented as PullRequests, numbered by their IID.
+type Provider interface {
+	// FindPRForBranch returns the open pull request for the configured
+	// branch, or nil if there is none
+	FindPRForBranch(ctx context.Context, config *Config) (*PullRequest, error)
+	// GetPR returns the pull request with the given number, whatever its
+	// state, or an error if it doesn't exist
+	GetPR(ctx context.Context, config *Config, number int) (*PullRequest, error)
+	// ListOpenPRs returns every open pull request in the repository
+	ListOpenPRs(ctx context.Context, config *Config) ([]PullRequest, error)
+	// IterComments yields every comment on a pull request, general
+	// comments and comments on code alike
+	IterComments(ctx context.Context, config *Config, pr *PullRequest) iter.Seq2[ReviewComment, error]
+}
+
+// ReviewComment is a pull request comment in a form common to all providers
+type ReviewComment struct {
+	ID        int
+	Body      string
+	Author    string
+	Bot       bool
+	CreatedAt time.Time
+
+	// ReviewID is the GitHub review a comment on code was submitted with,
+	// or 0
+	ReviewID int
+
+	// Path and Line locate comments left on code. CommitIDs lists the
+	// commits such a comment was left on; it is empty for general comments.
+	Path      string
+	Line      int
+	CommitIDs []string
+
+	// Resolved and Outdated describe the comment's review thread
+	Resolved bool
+	Outdated bool
+}
+
+// newProvider chooses the provider for the repository's host, unless
+// CARROTS_PROVIDER names one
+func newProvider(config *Config) (Provider, error) {
+	name := strings.ToLower(config.Provider)
+	if name == "" {
+		switch {
+		case config.Host == "github.com":
+			name = "github"
+		case strings.Contains(config.Host, "gitlab"):
+			name = "gitlab"
+		default:
+			return nil, fmt.Errorf("cannot tell whether %s is GitHub or GitLab; set CARROTS_PROVIDER", config.Host)
+		}
+	}
+
+	switch name {
+	case "github":
+		return githubProvider{}, nil
+	case "gitlab":
+		return gitlabProvider{apiBase: "https://" + config.Host + "/api/v4"}, nil
+	}
+	return nil, fmt.Errorf("unknown CARROTS_PROVIDER %q (expected github or gitlab)", config.Provider)
+}
+
+// remoteURLRegex matches HTTPS, ssh:// and scp-style remote URLs, capturing
+// the host and the repository path
+var remoteURLRegex = regexp.MustCompile(`^(?:[a-z][a-z0-9+.-]*://)?(?:[^@/]+@)?([^/:]+)(?::\d+)?[:/](.+?)(?:\.git)?/?$`)
+
+// parseRemoteURL splits a git remote URL into its host, owner and repository
+// name. On GitLab the owner is the full namespace, which may include
+// subgroups: git@gitlab.com:group/sub/repo.git has owner group/sub.
+func parseRemoteURL(url string) (host, owner, repo string, err error) {
+	matches := remoteURLRegex.FindStringSubmatch(url)
+	if matches == nil {
+		return "", "", "", fmt.Errorf("unable to parse remote URL: %s", url)
+	}
+	host, path := strings.ToLower(matches[1]), matches[2]
+
+	i := strings.LastIndex(path, "/")
+	if i <= 0 || i == len(path)-1 {
+		return "", "", "", fmt.Errorf("unable to parse remote URL: %s", url)
+	}
+	return host, path[:i], path[i+1:], nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// maxRetries is how many times a failed request is retried. main sets it
+// from CARROTS_MAX_RETRIES.
+var maxRetries = 3
+
+// retryBaseDelay is the wait before the first retry; it doubles with each
+// retry after that
+var retryBaseDelay = time.Second
+
+// sleep waits between attempts, returning early with ctx's error once ctx
+// is done. It is a variable so tests don't have to wait.
+var sleep = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// doWithRetry sends a request, retrying network errors, server errors and
+// rate limits up to maxRetries times. Each attempt gets its own timeout
+// within the request's context, and nothing is retried once that context
+// is done. The response body has already been read and closed.
+func doWithRetry(ctx context.Context, service string, req *http.Request) (*http.Response, []byte, error) {
+	for attempt := 0; ; attempt++ {
+		resp, body, err := send(req)
+		if attempt >= maxRetries || ctx.Err() != nil {
+			return resp, body, err
+		}
+
+		delay, reason, retry := retryDelay(resp, body, err, attempt, time.Now())
+		if !retry {
+			return resp, body, err
+		}
+
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		debugLog.Debug("api retry",
+			"url", req.URL.String(),
+			"attempt", attempt+1,
+			"reason", reason,
+			"status", status,
+			"error", fmt.Sprint(err),
+			"delay", delay.String(),
+		)
+		if reason == "rate limited" {
+			fmt.Fprintf(os.Stderr, "Warning: %s rate limit reached; waiting %s before retrying\n", service, delay.Round(time.Second))
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return nil, nil, fmt.Errorf("request failed: %w", err)
+		}
+	}
+}
+
+// send makes a single attempt at a request
+func send(req *http.Request) (*http.Response, []byte, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), 30*time.Second)
+	defer cancel()
+
+	attempt := req.Clone(ctx)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		attempt.Body = body
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(attempt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return resp, body, nil
+}
+
+// retryDelay decides whether an attempt is worth retrying and how long to
+// wait first. Rate limits wait as long as the server asks, via Retry-After
+// or until X-RateLimit-Reset once X-RateLimit-Remaining reaches zero.
+// Anything else transient backs off exponentially.
+func retryDelay(resp *http.Response, body []byte, err error, attempt int, now time.Time) (time.Duration, string, bool) {
+	backoff := retryBaseDelay << attempt
+	if err != nil {
+		return backoff, "request failed", true
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusForbidden && rateLimited(resp.Header, body):
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			return time.Duration(seconds) * time.Second, "rate limited", true
+		}
+		if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+			if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+				return max(time.Unix(reset, 0).Sub(now), 0), "rate limited", true
+			}
+		}
+		return backoff, "rate limited", true
+	case resp.StatusCode >= 500:
+		return backoff, "server error", true
+	}
+	return 0, "", false
+}
+
+// rateLimited tells a 403 caused by a primary or secondary rate limit from
+// one caused by missing permissions
+func rateLimited(header http.Header, body []byte) bool {
+	return header.Get("Retry-After") != "" ||
+		header.Get("X-RateLimit-Remaining") == "0" ||
+		bytes.Contains(bytes.ToLower(body), []byte("rate limit"))
+}
+
+// timeoutError explains an error caused by ctx's deadline passing, which on
+// its own only names the request that was cut off
+func timeoutError(ctx context.Context, timeout time.Duration, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s; set CARROTS_TIMEOUT to allow longer", timeout)
+	}
+	return err
+}
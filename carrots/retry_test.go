@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withFakeSleep records the delays doWithRetry would have waited
+func withFakeSleep(t *testing.T) *[]time.Duration {
+	t.Helper()
+	var delays []time.Duration
+	oldSleep, oldRetries := sleep, maxRetries
+	sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+	maxRetries = 3
+	t.Cleanup(func() { sleep, maxRetries = oldSleep, oldRetries })
+	return &delays
+}
+
+func TestMakeAPIRequestRateLimitRetry(t *testing.T) {
+	delays := withFakeSleep(t)
+	var buf bytes.Buffer
+	oldLog := debugLog
+	debugLog = newDebugLogger(&buf)
+	t.Cleanup(func() { debugLog = oldLog })
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(30*time.Second).Unix(), 10))
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"message": "API rate limit exceeded"}`)
+			return
+		}
+		fmt.Fprint(w, `[{"number": 1}]`)
+	}))
+	defer srv.Close()
+
+	body, _, err := makeAPIRequest(context.Background(), "GitHub", srv.URL, "token", githubAccept)
+	if err != nil {
+		t.Fatalf("makeAPIRequest failed: %v", err)
+	}
+	if string(body) != `[{"number": 1}]` {
+		t.Errorf("unexpected body %s", body)
+	}
+	if requests != 2 {
+		t.Errorf("got %d requests, want 2", requests)
+	}
+	// The wait runs until the reset time, give or take the clock
+	if len(*delays) != 1 || (*delays)[0] < 28*time.Second || (*delays)[0] > 30*time.Second {
+		t.Errorf("unexpected delays %v, want one of about 30s", *delays)
+	}
+	if !strings.Contains(buf.String(), `"msg":"api retry"`) || !strings.Contains(buf.String(), `"reason":"rate limited"`) {
+		t.Errorf("retry not logged:\n%s", buf.String())
+	}
+}
+
+func TestMakeAPIRequestRetryAfter(t *testing.T) {
+	delays := withFakeSleep(t)
+
+	statuses := []int{http.StatusBadGateway, http.StatusTooManyRequests, http.StatusOK}
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := statuses[requests]
+		requests++
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "7")
+		}
+		w.WriteHeader(status)
+		fmt.Fprint(w, "[]")
+	}))
+	defer srv.Close()
+
+	if _, _, err := makeAPIRequest(context.Background(), "GitHub", srv.URL, "token", githubAccept); err != nil {
+		t.Fatalf("makeAPIRequest failed: %v", err)
+	}
+	want := []time.Duration{retryBaseDelay, 7 * time.Second}
+	if fmt.Sprint(*delays) != fmt.Sprint(want) {
+		t.Errorf("got delays %v, want %v", *delays, want)
+	}
+}
+
+func TestMakeAPIRequestNoRetry(t *testing.T) {
+	delays := withFakeSleep(t)
+
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		requests int
+	}{
+		// A 403 without rate-limit headers is a permission problem
+		{name: "forbidden", status: http.StatusForbidden, body: `{"message": "Resource not accessible"}`, requests: 1},
+		{name: "not found", status: http.StatusNotFound, body: `{"message": "Not Found"}`, requests: 1},
+		// Server errors give up after maxRetries retries
+		{name: "persistent 503", status: http.StatusServiceUnavailable, body: "unavailable", requests: 4},
+	}
+	for _, tt := range tests {
+		*delays = nil
+		requests := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.WriteHeader(tt.status)
+			fmt.Fprint(w, tt.body)
+		}))
+
+		_, _, err := makeAPIRequest(context.Background(), "GitHub", srv.URL, "token", githubAccept)
+		srv.Close()
+		if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("status %d", tt.status)) {
+			t.Errorf("%s: expected a status %d error, got %v", tt.name, tt.status, err)
+		}
+		if requests != tt.requests {
+			t.Errorf("%s: got %d requests, want %d", tt.name, requests, tt.requests)
+		}
+		if len(*delays) != tt.requests-1 {
+			t.Errorf("%s: got delays %v", tt.name, *delays)
+		}
+	}
+}
+
+func TestGraphQLRequestRetry(t *testing.T) {
+	withFakeSleep(t)
+
+	// The request body must be sent again on the retry
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		buf.ReadFrom(r.Body)
+		bodies = append(bodies, buf.String())
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, `{"data": {}}`)
+	}))
+	defer srv.Close()
+
+	defer func(url string) { githubGraphQLURL = url }(githubGraphQLURL)
+	githubGraphQLURL = srv.URL
+
+	if _, err := makeGraphQLRequest(context.Background(), "query { viewer { login } }", nil, "token"); err != nil {
+		t.Fatalf("makeGraphQLRequest failed: %v", err)
+	}
+	if len(bodies) != 2 || bodies[0] == "" || bodies[0] != bodies[1] {
+		t.Errorf("unexpected request bodies %q", bodies)
+	}
+}
+
+func TestIterPagesCancel(t *testing.T) {
+	requests := 0
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		// Every page links to another, so only cancellation ends the loop
+		w.Header().Set("Link", fmt.Sprintf(`<%s/?page=%d>; rel="next"`, srv.URL, requests+1))
+		fmt.Fprint(w, "[]")
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pages := 0
+	var lastErr error
+	for _, err := range iterPages(ctx, "GitHub", srv.URL, "token", githubAccept) {
+		if err != nil {
+			lastErr = err
+			break
+		}
+		pages++
+		if pages == 2 {
+			cancel()
+		}
+	}
+	if !errors.Is(lastErr, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", lastErr)
+	}
+	if pages != 2 || requests != 2 {
+		t.Errorf("got %d pages from %d requests, want 2 of each", pages, requests)
+	}
+}
+
+func TestRetryStopsAtDeadline(t *testing.T) {
+	// The real sleep must give up waiting at the deadline
+	oldDelay := retryBaseDelay
+	retryBaseDelay = time.Minute
+	t.Cleanup(func() { retryBaseDelay = oldDelay })
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, _, err := makeAPIRequest(ctx, "GitHub", srv.URL, "token", githubAccept)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("gave up after %s, want about 50ms", elapsed)
+	}
+
+	want := "timed out after 50ms; set CARROTS_TIMEOUT to allow longer"
+	if got := timeoutError(ctx, 50*time.Millisecond, err); got.Error() != want {
+		t.Errorf("timeoutError = %q, want %q", got, want)
+	}
+	if got := timeoutError(context.Background(), time.Minute, err); got != err {
+		t.Errorf("timeoutError without a deadline = %v, want %v", got, err)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/presbrey/argon2aes/pkg/base92"
+)
+
+// The envelope around the ciphertext, which records how it was made:
+//
+//	-----BEGIN ENV-CRYPT-----
+//	Version: 1
+//	KDF: argon2id t=3 m=65536 p=4
+//	Cipher: aes-256-gcm
+//
+//	<base92 ciphertext, wrapped>
+//	-----END ENV-CRYPT-----
+const (
+	armorBegin = "-----BEGIN ENV-CRYPT-----"
+	armorEnd   = "-----END ENV-CRYPT-----"
+
+	armorVersion = "1"
+	// the parameters argon2aes derives keys with
+	armorKDF    = "argon2id t=3 m=65536 p=4"
+	armorCipher = "aes-256-gcm"
+)
+
+// lineWriter passes what is written to it on to w, breaking it into lines
+// of width bytes as it goes. A width of 0 or less writes one line.
+type lineWriter struct {
+	w     io.Writer
+	width int
+	col   int
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	if lw.width <= 0 {
+		return lw.w.Write(p)
+	}
+	written := 0
+	for len(p) > 0 {
+		// Break the line only once there is more to write, so a full
+		// last line isn't followed by an empty one
+		if lw.col == lw.width {
+			if _, err := io.WriteString(lw.w, "\n"); err != nil {
+				return written, err
+			}
+			lw.col = 0
+		}
+		n, err := lw.w.Write(p[:min(len(p), lw.width-lw.col)])
+		written += n
+		lw.col += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// encodeWrapped writes data to w as base92, in lines of at most width
+// characters (one line when width is 0), ending with a newline. base92
+// encodes its input as a single number, so the encoding happens in one
+// step, but the lines are written as they are cut rather than building a
+// wrapped copy of the text.
+func encodeWrapped(w io.Writer, data []byte, width int) error {
+	lw := &lineWriter{w: w, width: width}
+	if _, err := io.WriteString(lw, base92.DefaultEncoding.EncodeToString(data)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// armor writes ciphertext to w as base92 in the envelope
+func armor(w io.Writer, ciphertext []byte, width int) error {
+	_, err := fmt.Fprintf(w, "%s\nVersion: %s\nKDF: %s\nCipher: %s\n\n",
+		armorBegin, armorVersion, armorKDF, armorCipher)
+	if err != nil {
+		return err
+	}
+	if err := encodeWrapped(w, ciphertext, width); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, armorEnd+"\n")
+	return err
+}
+
+// dearmor returns the base92 ciphertext inside an envelope, after checking
+// that its headers describe a format this build can decrypt. Input without
+// an envelope, as written by -raw, is returned as is.
+func dearmor(input string) (string, error) {
+	input = strings.ReplaceAll(input, "\r\n", "\n")
+	_, rest, found := strings.Cut(input, armorBegin+"\n")
+	if !found {
+		if strings.Contains(input, armorBegin) {
+			return "", errors.New("malformed envelope: nothing after the BEGIN line")
+		}
+		return input, nil
+	}
+	body, _, found := strings.Cut(rest, armorEnd)
+	if !found {
+		return "", errors.New("malformed envelope: missing END line")
+	}
+	headerText, text, found := strings.Cut(body, "\n\n")
+	if !found {
+		return "", errors.New("malformed envelope: missing blank line after the headers")
+	}
+
+	headers := make(map[string]string)
+	for _, line := range strings.Split(headerText, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return "", fmt.Errorf("malformed envelope header %q", line)
+		}
+		headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	want := []struct{ key, value string }{
+		{"Version", armorVersion},
+		{"KDF", armorKDF},
+		{"Cipher", armorCipher},
+	}
+	for _, h := range want {
+		got, ok := headers[h.key]
+		switch {
+		case !ok:
+			return "", fmt.Errorf("envelope has no %s header", h.key)
+		case h.key == "Version" && got != h.value:
+			return "", fmt.Errorf("unsupported envelope version %s (this build reads version %s)", got, h.value)
+		case got != h.value:
+			return "", fmt.Errorf("unsupported %s %q (this build uses %q)", h.key, got, h.value)
+		}
+	}
+	return text, nil
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -9,41 +11,66 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"unicode"
 
 	"github.com/joho/godotenv"
 	"github.com/presbrey/argon2aes"
 	"github.com/presbrey/argon2aes/pkg/base92"
+	"golang.org/x/term"
 )
 
 var (
-	flagIn = flag.String("in", "", "Read environment variables from this file instead of os.Environ ('-' means stdin)")
+	flagIn   = flag.String("in", "", "Read environment variables from this file instead of os.Environ ('-' means stdin)")
+	flagFile = flag.String("file", "", "Encrypt only the variables in this .env file, ignoring the environment")
 
-	flagGlobal   = flag.Bool("global", false, "Walk up the directory tree to find .env files (env: $ENV_GLOBAL)")
-	flagPassword = flag.String("password", "", "Password to encrypt the environment variables (env: $ENV_PASSWORD)")
-	flagWrap     = flag.Int("wrap", 80, "Wrap the output at this many characters")
+	flagDecrypt = flag.Bool("decrypt", false, "Decrypt base92 ciphertext from stdin and print the environment variables")
+	flagOutput  = flag.String("output", "", "With -decrypt, write the variables to this file in .env format instead of printing them")
 
-	skipPrefixes = []string{
+	flagGlobal       = flag.Bool("global", false, "Walk up the directory tree to find .env files (env: $ENV_GLOBAL)")
+	flagPassword     = flag.String("password", "", "Password to encrypt the environment variables (env: $ENV_PASSWORD)")
+	flagPasswordFile = flag.String("password-file", "", "Read the password from the first line of this file")
+	flagWrap         = flag.Int("wrap", 80, "Wrap the output at this many characters")
+	flagRaw          = flag.Bool("raw", false, "Print the bare base92 ciphertext without the BEGIN/END envelope")
+
+	flagSkipPrefix listFlag
+	flagSkipExact  listFlag
+	flagInclude    listFlag
+	flagNoDefaults = flag.Bool("no-defaults", false, "Don't skip the built-in list of shell and tool variables")
+
+	// variables skipped by default, being shell or tool state rather than
+	// configuration
+	defaultSkipPrefixes = []string{
 		"#", "_",
 
 		"AIDER_", "COLOR", "ENV_", "HOMEBREW_", "ITERM_", "LANG", "LC_", "LESS", "LOGNAME",
 		"LS_COLORS", "MAKE", "NVM_", "PKG_", "PYENV_", "SSH_", "TERM_", "TERMINFO_", "XPC_",
 	}
-	skipExact = []string{
+	defaultSkipExact = []string{
 		"CPPFLAGS", "COMMAND_MODE", "HOME", "INFOPATH", "LaunchInstanceID", "LANG", "LDFLAGS", "MANPATH",
 		"OLDPWD", "PATH", "PWD", "SECURITYSESSIONID", "SHELL", "SHLVL", "TERM", "TMPDIR", "USER",
 	}
 )
 
 func init() {
+	flag.Var(&flagSkipPrefix, "skip-prefix", "Skip variables starting with this prefix (repeatable or comma-separated)")
+	flag.Var(&flagSkipExact, "skip-exact", "Skip this variable (repeatable or comma-separated)")
+	flag.Var(&flagInclude, "include", "Keep this variable even if a skip rule matches it (repeatable or comma-separated)")
+
 	godotenv.Load()
-	flag.Parse()
 
 	// log lines will include file name and line number
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
 	// prefer cgo resolver
 	net.DefaultResolver.PreferGo = false
+}
+
+// parseFlags parses the command line, falling back to the environment.
+// It runs from main rather than init so tests can define their own flags.
+func parseFlags() {
+	flag.Parse()
 
 	switch strings.ToLower(os.Getenv("ENV_GLOBAL")) {
 	case "false", "0", "no", "off":
@@ -51,9 +78,53 @@ func init() {
 	case "true", "1", "yes", "on":
 		*flagGlobal = true
 	}
-	if *flagPassword == "" {
-		*flagPassword = os.Getenv("ENV_PASSWORD")
+}
+
+// resolvePassword picks the password from the first source given, in
+// order: -password, -password-file, $ENV_PASSWORD, and finally a prompt
+// when stdin is a terminal
+func resolvePassword() (string, error) {
+	if *flagPassword != "" {
+		return *flagPassword, nil
+	}
+	if *flagPasswordFile != "" {
+		return readPasswordFile(*flagPasswordFile)
+	}
+	if password := os.Getenv("ENV_PASSWORD"); password != "" {
+		return password, nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", errors.New("no password given: use -password-file, $ENV_PASSWORD or -password, or run from a terminal to be prompted")
+	}
+	return promptPassword()
+}
+
+// readPasswordFile reads the password from the first line of a file
+func readPasswordFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading password file: %w", err)
+	}
+	password, _, _ := strings.Cut(string(data), "\n")
+	password = strings.TrimSuffix(password, "\r")
+	if password == "" {
+		return "", fmt.Errorf("password file %s is empty", path)
 	}
+	return password, nil
+}
+
+// promptPassword reads the password from the terminal without echoing it
+func promptPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("reading password: %w", err)
+	}
+	if len(password) == 0 {
+		return "", errors.New("no password entered")
+	}
+	return string(password), nil
 }
 
 func getEnvFilePaths() ([]string, error) {
@@ -88,51 +159,194 @@ func getEnvFilePaths() ([]string, error) {
 	return envFiles, nil
 }
 
-func buildEnvMap() map[string]string {
-	envMap := make(map[string]string)
-	for _, envVar := range os.Environ() {
-		pair := strings.SplitN(envVar, "=", 2)
-		if len(pair) != 2 {
-			continue
-		}
-		for _, exact := range skipExact {
-			if pair[0] == exact {
-				goto skip
-			}
+// listFlag is a flag that can be repeated, each value holding one or more
+// comma-separated items
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
 		}
-		for _, prefix := range skipPrefixes {
-			if strings.HasPrefix(pair[0], prefix) {
-				goto skip
-			}
+	}
+	return nil
+}
+
+// envFilter decides which environment variables are encrypted
+type envFilter struct {
+	skipPrefixes []string
+	skipExact    []string
+	include      []string
+}
+
+// newEnvFilter merges the skip flags with the defaults, unless -no-defaults
+// is given
+func newEnvFilter() envFilter {
+	f := envFilter{
+		skipPrefixes: flagSkipPrefix,
+		skipExact:    flagSkipExact,
+		include:      flagInclude,
+	}
+	if !*flagNoDefaults {
+		f.skipPrefixes = append(slices.Clone(defaultSkipPrefixes), f.skipPrefixes...)
+		f.skipExact = append(slices.Clone(defaultSkipExact), f.skipExact...)
+	}
+	return f
+}
+
+// keep reports whether a variable is encrypted. -include wins over any
+// skip rule.
+func (f envFilter) keep(name string) bool {
+	if slices.Contains(f.include, name) {
+		return true
+	}
+	if slices.Contains(f.skipExact, name) {
+		return false
+	}
+	for _, prefix := range f.skipPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return false
 		}
+	}
+	return true
+}
 
-		envMap[pair[0]] = pair[1]
-	skip:
+// buildEnvMap collects the variables of environ, in os.Environ form, that
+// the filter keeps
+func buildEnvMap(environ []string, filter envFilter) map[string]string {
+	envMap := make(map[string]string)
+	for _, envVar := range environ {
+		name, value, ok := strings.Cut(envVar, "=")
+		if !ok || !filter.keep(name) {
+			continue
+		}
+		envMap[name] = value
 	}
 	return envMap
 }
 
+// loadEnvFiles overlays the .env files found by getEnvFilePaths onto the
+// environment
+func loadEnvFiles() {
+	envFiles, err := getEnvFilePaths()
+	if err != nil {
+		panic(err)
+	}
+	if len(envFiles) > 0 {
+		err = godotenv.Overload(envFiles...)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
 func jsonEnvMap() ([]byte, error) {
+	if *flagFile != "" {
+		envMap, err := godotenv.Read(*flagFile)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(envMap)
+	}
 	if *flagIn == "-" {
 		return io.ReadAll(os.Stdin)
 	}
 	if *flagIn != "" {
 		return os.ReadFile(*flagIn)
 	}
-	envMap := buildEnvMap()
+	envMap := buildEnvMap(os.Environ(), newEnvFilter())
 	return json.Marshal(envMap)
 }
 
+// encrypt encrypts the JSON env map with the password. The ciphertext is
+// printed as base92 by armor or, with -raw, encodeWrapped.
+func encrypt(envJSON []byte, password string) ([]byte, error) {
+	return argon2aes.Encrypt(envJSON, []byte(password))
+}
+
+// decrypt reverses encrypt, taking the ciphertext in its envelope or bare.
+// Whitespace, such as the line breaks added by -wrap, is ignored.
+func decrypt(text string, password string) (map[string]string, error) {
+	text, err := dearmor(text)
+	if err != nil {
+		return nil, err
+	}
+	text = strings.Join(strings.Fields(text), "")
+	if text == "" {
+		return nil, errors.New("no ciphertext to decrypt")
+	}
+	// base92 only handles ASCII and panics on wider runes
+	if strings.IndexFunc(text, func(r rune) bool { return r > unicode.MaxASCII }) >= 0 {
+		return nil, errors.New("invalid base92 character")
+	}
+	ciphertext, err := base92.DefaultEncoding.DecodeString(text)
+	if err != nil {
+		return nil, err
+	}
+	envJSON, err := argon2aes.Decrypt(ciphertext, []byte(password))
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed (wrong password or corrupted input): %w", err)
+	}
+	var envMap map[string]string
+	if err := json.Unmarshal(envJSON, &envMap); err != nil {
+		return nil, fmt.Errorf("decrypted data is not an env map: %w", err)
+	}
+	return envMap, nil
+}
+
+// writeEnv prints the variables as KEY=value lines sorted by key, or
+// writes them to a .env file when -output is set
+func writeEnv(envMap map[string]string) error {
+	if *flagOutput != "" {
+		content, err := godotenv.Marshal(envMap)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(*flagOutput, []byte(content+"\n"), 0600)
+	}
+	keys := make([]string, 0, len(envMap))
+	for key := range envMap {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		fmt.Printf("%s=%s\n", key, envMap[key])
+	}
+	return nil
+}
+
 func main() {
-	envFiles, err := getEnvFilePaths()
+	parseFlags()
+	password, err := resolvePassword()
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
 	}
-	if len(envFiles) > 0 {
-		err = godotenv.Overload(envFiles...)
+
+	if *flagDecrypt {
+		text, err := io.ReadAll(os.Stdin)
 		if err != nil {
-			panic(err)
+			log.Fatal(err)
 		}
+		envMap, err := decrypt(string(text), password)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := writeEnv(envMap); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *flagFile != "" && *flagIn != "" {
+		log.Fatal("-file and -in cannot be used together")
+	}
+	// .env files only matter when encrypting the environment
+	if *flagFile == "" && *flagIn == "" {
+		loadEnvFiles()
 	}
 
 	envJSON, err := jsonEnvMap()
@@ -140,24 +354,20 @@ func main() {
 		log.Fatal(err)
 	}
 
-	ciphertext, err := argon2aes.Encrypt(envJSON, []byte(*flagPassword))
+	ciphertext, err := encrypt(envJSON, password)
 	if err != nil {
 		log.Fatal(err)
 	}
-	base92text := base92.DefaultEncoding.EncodeToString(ciphertext)
-	if *flagWrap == 0 {
-		fmt.Println(base92text)
-		return
+	out := bufio.NewWriter(os.Stdout)
+	if *flagRaw {
+		err = encodeWrapped(out, ciphertext, *flagWrap)
+	} else {
+		err = armor(out, ciphertext, *flagWrap)
 	}
-
-	// print the text at 80 characters per line
-	for len(base92text) > 0 {
-		if len(base92text) > *flagWrap {
-			fmt.Println(base92text[:*flagWrap])
-			base92text = base92text[*flagWrap:]
-		} else {
-			fmt.Println(base92text)
-			break
-		}
+	if err == nil {
+		err = out.Flush()
+	}
+	if err != nil {
+		log.Fatal(err)
 	}
 }
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/joho/godotenv"
+	"github.com/presbrey/argon2aes/pkg/base92"
+)
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	envMap := map[string]string{
+		"API_KEY":  "s3cr3t",
+		"DB_URL":   "postgres://user:p@ss@localhost/db?sslmode=disable",
+		"GREETING": "hello \"world\"\nsecond line",
+		"EMPTY":    "",
+	}
+	envJSON, err := json.Marshal(envMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := encrypt(envJSON, "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Bare ciphertext, as printed by -raw
+	var buf bytes.Buffer
+	if err := encodeWrapped(&buf, ciphertext, 40); err != nil {
+		t.Fatal(err)
+	}
+	wrapped := buf.String()
+	got, err := decrypt(wrapped, "hunter2")
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	if !maps.Equal(got, envMap) {
+		t.Errorf("decrypt = %v, want %v", got, envMap)
+	}
+
+	if _, err := decrypt(wrapped, "wrong"); err == nil || !strings.Contains(err.Error(), "decryption failed") {
+		t.Errorf("decrypt with the wrong password: err = %v", err)
+	}
+	for _, bad := range []string{"", "abc", "not base92 é"} {
+		if _, err := decrypt(bad, "hunter2"); err == nil {
+			t.Errorf("decrypt(%q) succeeded", bad)
+		}
+	}
+
+	// -output writes a .env file that loads back to the same map
+	*flagOutput = filepath.Join(t.TempDir(), ".env")
+	defer func() { *flagOutput = "" }()
+	if err := writeEnv(got); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := godotenv.Read(*flagOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(loaded, envMap) {
+		t.Errorf(".env file loads as %v, want %v", loaded, envMap)
+	}
+}
+
+func TestBuildEnvMapFilter(t *testing.T) {
+	environ := []string{
+		"API_KEY=s3cr3t",
+		"HOME=/home/me",
+		"PATH=/usr/bin",
+		"SSH_AUTH_SOCK=/tmp/agent",
+		"AWS_PROFILE=dev",
+		"DEBUG=1",
+		"MALFORMED",
+	}
+	defer func() {
+		flagSkipPrefix, flagSkipExact, flagInclude = nil, nil, nil
+		*flagNoDefaults = false
+	}()
+
+	tests := []struct {
+		name       string
+		args       []string
+		noDefaults bool
+		want       []string
+	}{
+		{"defaults", nil, false, []string{"API_KEY", "AWS_PROFILE", "DEBUG"}},
+		{"skip flags add to the defaults", []string{"-skip-prefix", "AWS_", "-skip-exact", "DEBUG"}, false, []string{"API_KEY"}},
+		{"comma-separated", []string{"-skip-exact", "DEBUG,API_KEY"}, false, []string{"AWS_PROFILE"}},
+		{"include beats a default rule", []string{"-include", "HOME,SSH_AUTH_SOCK"}, false, []string{"API_KEY", "AWS_PROFILE", "DEBUG", "HOME", "SSH_AUTH_SOCK"}},
+		{"include beats a flag rule", []string{"-skip-prefix", "AWS_", "-include", "AWS_PROFILE"}, false, []string{"API_KEY", "AWS_PROFILE", "DEBUG"}},
+		{"no defaults", []string{"-skip-exact", "PATH"}, true, []string{"API_KEY", "AWS_PROFILE", "DEBUG", "HOME", "SSH_AUTH_SOCK"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flagSkipPrefix, flagSkipExact, flagInclude = nil, nil, nil
+			*flagNoDefaults = tt.noDefaults
+			fs := flag.NewFlagSet("env-crypt", flag.ContinueOnError)
+			fs.Var(&flagSkipPrefix, "skip-prefix", "")
+			fs.Var(&flagSkipExact, "skip-exact", "")
+			fs.Var(&flagInclude, "include", "")
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			got := slices.Sorted(maps.Keys(buildEnvMap(environ, newEnvFilter())))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("kept %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolvePasswordFromFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "password")
+	if err := os.WriteFile(path, []byte("from-file\r\nignored\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { *flagPassword, *flagPasswordFile = "", "" }()
+	t.Setenv("ENV_PASSWORD", "from-env")
+
+	// The file wins over the environment, and the flag over the file
+	*flagPasswordFile = path
+	if got, err := resolvePassword(); err != nil || got != "from-file" {
+		t.Errorf("with -password-file: got %q, %v", got, err)
+	}
+	*flagPassword = "from-flag"
+	if got, err := resolvePassword(); err != nil || got != "from-flag" {
+		t.Errorf("with -password: got %q, %v", got, err)
+	}
+	*flagPassword = ""
+
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{empty, filepath.Join(dir, "missing")} {
+		*flagPasswordFile = bad
+		if _, err := resolvePassword(); err == nil {
+			t.Errorf("password file %s: no error", bad)
+		}
+	}
+}
+
+// armorString returns ciphertext in its envelope, as main prints it
+func armorString(t *testing.T, ciphertext []byte, width int) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := armor(&buf, ciphertext, width); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestEncodeWrapped(t *testing.T) {
+	data := []byte("\x00\x01 some ciphertext bytes, long enough to wrap a few times \xff")
+	text := base92.DefaultEncoding.EncodeToString(data)
+
+	for _, width := range []int{0, -1, 1, 7, 16, len(text) - 1, len(text), len(text) + 1, 1000} {
+		var buf bytes.Buffer
+		if err := encodeWrapped(&buf, data, width); err != nil {
+			t.Fatalf("width %d: %v", width, err)
+		}
+		out := buf.String()
+		if !strings.HasSuffix(out, "\n") {
+			t.Errorf("width %d: output doesn't end with a newline: %q", width, out)
+		}
+		lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+		if got := strings.Join(lines, ""); got != text {
+			t.Errorf("width %d: joined lines = %q, want %q", width, got, text)
+		}
+
+		wantLines := 1
+		if width > 0 {
+			wantLines = (len(text) + width - 1) / width
+		}
+		if len(lines) != wantLines {
+			t.Errorf("width %d: %d lines, want %d", width, len(lines), wantLines)
+		}
+		for i, line := range lines {
+			if width > 0 && (len(line) > width || i < len(lines)-1 && len(line) != width) {
+				t.Errorf("width %d: line %d has %d characters", width, i, len(line))
+			}
+		}
+	}
+
+	// Empty input is an empty line, as an empty string always was
+	var buf bytes.Buffer
+	if err := encodeWrapped(&buf, nil, 10); err != nil || buf.String() != "\n" {
+		t.Errorf("encodeWrapped(nil) = %q, %v", buf.String(), err)
+	}
+}
+
+func TestArmor(t *testing.T) {
+	envMap := map[string]string{"API_KEY": "s3cr3t"}
+	envJSON, _ := json.Marshal(envMap)
+	ciphertext, err := encrypt(envJSON, "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	armored := armorString(t, ciphertext, 64)
+	lines := strings.Split(strings.TrimSuffix(armored, "\n"), "\n")
+	if lines[0] != armorBegin || lines[len(lines)-1] != armorEnd || lines[1] != "Version: 1" {
+		t.Fatalf("unexpected envelope:\n%s", armored)
+	}
+	got, err := decrypt(armored, "hunter2")
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	if !maps.Equal(got, envMap) {
+		t.Errorf("decrypt = %v, want %v", got, envMap)
+	}
+
+	// Text around the envelope, as when pasted from an email, is ignored
+	if _, err := decrypt("Here it is:\n\n"+strings.ReplaceAll(armored, "\n", "\r\n")+"Thanks\n", "hunter2"); err != nil {
+		t.Errorf("decrypt with surrounding text: %v", err)
+	}
+
+	tests := []struct {
+		name, input, want string
+	}{
+		{"version mismatch", strings.Replace(armored, "Version: 1", "Version: 2", 1), "unsupported envelope version 2"},
+		{"kdf mismatch", strings.Replace(armored, "t=3", "t=4", 1), "unsupported KDF"},
+		{"missing version", strings.Replace(armored, "Version: 1\n", "", 1), "no Version header"},
+		{"missing end", strings.TrimSuffix(armored, armorEnd+"\n"), "missing END line"},
+	}
+	for _, tt := range tests {
+		if _, err := decrypt(tt.input, "hunter2"); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestEncryptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	fixture := "# database\nDB_URL=postgres://localhost/app\nexport API_KEY='s3cr3t'\nGREETING=\"hello world\"\n"
+	if err := os.WriteFile(path, []byte(fixture), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Neither the environment nor the skip rules apply to -file
+	t.Setenv("AMBIENT_SECRET", "leaked")
+	flagSkipExact = listFlag{"DB_URL"}
+	*flagFile = path
+	defer func() { *flagFile, flagSkipExact = "", nil }()
+
+	envJSON, err := jsonEnvMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := encrypt(envJSON, "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decrypt(armorString(t, ciphertext, 80), "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"DB_URL":   "postgres://localhost/app",
+		"API_KEY":  "s3cr3t",
+		"GREETING": "hello world",
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("decrypted %v, want %v", got, want)
+	}
+}
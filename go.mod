@@ -9,6 +9,8 @@ require (
 	github.com/pelletier/go-toml/v2 v2.2.4
 	github.com/presbrey/argon2aes v1.1.1
 	github.com/presbrey/pkg v0.0.0-20251104183518-bc63a83c1259
+	golang.org/x/term v0.38.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
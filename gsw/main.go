@@ -2,29 +2,99 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 type BranchStatus struct {
-	Name    string
-	IsDirty bool
-	Ahead   int
-	Behind  int
-	Status  string
-	Current bool
+	Name     string `json:"name"`
+	Current  bool   `json:"current"`
+	IsDirty  bool   `json:"dirty"`
+	Ahead    int    `json:"ahead"`
+	Behind   int    `json:"behind"`
+	Unpushed bool   `json:"unpushed"` // no remote branch exists for this branch
+	Remote   bool   `json:"remote"`   // remote-tracking branch with no local counterpart
+	Status   string `json:"status"`
+
+	// HasUpstream is false when the branch has no upstream to count Ahead
+	// and Behind against, so that 0/0 doesn't pass for in sync
+	HasUpstream bool `json:"has_upstream"`
+
+	// LastCommit is the committer date of the branch's tip, and AgeDays the
+	// whole days since then at the time of the analysis
+	LastCommit time.Time `json:"last_commit"`
+	AgeDays    int       `json:"age_days"`
+	Stale      bool      `json:"stale,omitempty"` // listed by -stale
 }
 
 type RepoStatus struct {
-	Path          string
-	Branches      []BranchStatus
-	CurrentBranch string
-	Error         string
+	Path          string         `json:"path"`
+	CurrentBranch string         `json:"current_branch"`
+	Error         string         `json:"error,omitempty"`
+	Warning       string         `json:"warning,omitempty"` // e.g. a failed -fetch; the analysis still ran
+	Branches      []BranchStatus `json:"branches"`
+	Exec          *ExecResult    `json:"exec,omitempty"`
+
+	// anyAhead and anyBehind record whether any analyzed branch is ahead of
+	// or behind its upstream, including clean branches left out of Branches
+	anyAhead, anyBehind bool
+}
+
+// ExecResult is the outcome of the -exec command in one repository
+type ExecResult struct {
+	Command  string `json:"command"`
+	Output   string `json:"output"`
+	ExitCode int    `json:"exit_code"` // -1 when the command did not run to completion
+	Error    string `json:"error,omitempty"`
+}
+
+// Totals counts repositories by condition. A repository counts once toward
+// each condition any of its branches meets, and a repository that failed to
+// analyze counts only toward Errors.
+type Totals struct {
+	Repos  int `json:"repos"`
+	Dirty  int `json:"dirty"`
+	Ahead  int `json:"ahead"`
+	Behind int `json:"behind"`
+	Errors int `json:"errors"`
+}
+
+// analyzeOptions controls how each repository is analyzed
+type analyzeOptions struct {
+	IncludeClean  bool
+	IncludeRemote bool
+	Verbose       bool
+	Fetch         bool
+	FetchTimeout  time.Duration
+	Exec          string
+	ExecTimeout   time.Duration
+}
+
+// logMu serializes verbose logging so messages from parallel workers stay intact
+var logMu sync.Mutex
+
+// logOutput is where verbose messages are written
+var logOutput io.Writer = os.Stderr
+
+// logRepo writes a verbose message attributed to a repository
+func logRepo(repoPath, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+
+	logMu.Lock()
+	defer logMu.Unlock()
+	fmt.Fprintf(logOutput, "[%s] %s\n", repoPath, msg)
 }
 
 func main() {
@@ -34,25 +104,93 @@ func main() {
 	verbose := flag.Bool("verbose", false, "Verbose output")
 	maxDepth := flag.Int("max-depth", 10, "Maximum directory depth to search")
 	parallel := flag.Bool("parallel", false, "Process repositories in parallel (faster)")
+	jobs := flag.Int("jobs", runtime.NumCPU(), "Maximum number of repositories to analyze at once with -parallel")
 	jsonOutput := flag.Bool("json", false, "Output in JSON format")
+	fetch := flag.Bool("fetch", false, "Fetch from remotes before computing ahead/behind")
+	fetchTimeout := flag.Duration("fetch-timeout", 30*time.Second, "Maximum time to spend fetching each repository")
+	filter := flag.String("filter", filterAll, "Only show repositories matching: dirty, ahead, behind, diverged or all")
+	includeRemote := flag.Bool("include-remote-branches", false, "Also list remote-tracking branches that have no local branch")
+	sortBy := flag.String("sort", sortPath, "Order repositories by: path, name or dirty-first")
+	execCmd := flag.String("exec", "", "Shell command to run in each repository; {} is replaced by the repository path")
+	execTimeout := flag.Duration("exec-timeout", 30*time.Second, "Maximum time to let the -exec command run in each repository")
+	byReason := flag.Bool("by-reason", false, "Group branches needing attention by reason (modified, ahead, behind, ...) instead of by repository")
+	reposFile := flag.String("repos-file", "", "File listing repository paths, one per line, to analyze instead of scanning -dir")
+	staleDays := flag.Int("stale", 0, "Only list branches whose last commit is at least this many days old")
+	var include, exclude patternList
+	flag.Var(&include, "include", "Only analyze repositories whose path matches this glob pattern (repeatable)")
+	flag.Var(&exclude, "exclude", "Skip repositories whose path matches this glob pattern, even if included (repeatable)")
 
 	flag.Parse()
 
-	// Resolve absolute path
-	absDir, err := filepath.Abs(*dir)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error resolving path: %v\n", err)
+	if !validSort(*sortBy) {
+		fmt.Fprintf(os.Stderr, "Error: unknown sort %q (expected path, name or dirty-first)\n", *sortBy)
+		os.Exit(1)
+	}
+	if *jobs < 1 {
+		fmt.Fprintf(os.Stderr, "Error: -jobs must be at least 1, got %d\n", *jobs)
 		os.Exit(1)
 	}
+	if *staleDays < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -stale must not be negative, got %d\n", *staleDays)
+		os.Exit(1)
+	}
+	if !validFilter(*filter) {
+		fmt.Fprintf(os.Stderr, "Error: unknown filter %q (expected dirty, ahead, behind, diverged or all)\n", *filter)
+		os.Exit(1)
+	}
+	for _, pattern := range append(include, exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid pattern %q: %v\n", pattern, err)
+			os.Exit(1)
+		}
+	}
 
-	if *verbose && !*jsonOutput {
-		fmt.Printf("Scanning directory: %s\n", absDir)
-		fmt.Printf("Show clean branches: %v\n", *showClean)
-		fmt.Printf("Parallel processing: %v\n", *parallel)
-		fmt.Println()
+	// Ahead/behind filters look at branches that are otherwise hidden as
+	// clean, so keep and show them to make the divergence visible. Stale
+	// branches are usually clean too.
+	includeClean := *showClean || *filter == filterAhead || *filter == filterBehind || *filter == filterDiverged || *staleDays > 0
+
+	var repos []string
+	if *reposFile != "" {
+		// An explicit list replaces the directory walk entirely
+		var err error
+		repos, err = readReposFile(*reposFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading repos file: %v\n", err)
+			os.Exit(1)
+		}
+
+		if *verbose && !*jsonOutput {
+			fmt.Printf("Repositories from: %s\n", *reposFile)
+			fmt.Printf("Show clean branches: %v\n", *showClean)
+			fmt.Printf("Parallel processing: %v\n", *parallel)
+			if *parallel {
+				fmt.Printf("Jobs: %d\n", *jobs)
+			}
+			fmt.Println()
+		}
+	} else {
+		// Resolve absolute path
+		absDir, err := filepath.Abs(*dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving path: %v\n", err)
+			os.Exit(1)
+		}
+
+		if *verbose && !*jsonOutput {
+			fmt.Printf("Scanning directory: %s\n", absDir)
+			fmt.Printf("Show clean branches: %v\n", *showClean)
+			fmt.Printf("Parallel processing: %v\n", *parallel)
+			if *parallel {
+				fmt.Printf("Jobs: %d\n", *jobs)
+			}
+			fmt.Println()
+		}
+
+		repos = findGitRepos(absDir, *maxDepth, *verbose && !*jsonOutput)
 	}
 
-	repos := findGitRepos(absDir, *maxDepth, *verbose && !*jsonOutput)
+	repos = filterPaths(repos, include, exclude)
 
 	if len(repos) == 0 {
 		if !*jsonOutput {
@@ -61,22 +199,234 @@ func main() {
 		return
 	}
 
+	// Grouping by reason needs every branch, since clean ones may be ahead
+	// or behind
+	opts := analyzeOptions{
+		IncludeClean:  includeClean || *byReason,
+		IncludeRemote: *includeRemote,
+		Verbose:       *verbose && !*jsonOutput,
+		Fetch:         *fetch,
+		FetchTimeout:  *fetchTimeout,
+		Exec:          *execCmd,
+		ExecTimeout:   *execTimeout,
+	}
+
 	var statuses []RepoStatus
 
 	if *parallel {
-		statuses = analyzeReposParallel(repos, *showClean, *verbose && !*jsonOutput)
+		statuses = analyzeReposParallel(repos, *jobs, func(path string) RepoStatus {
+			return analyzeRepo(path, opts)
+		})
 	} else {
-		statuses = analyzeReposSequential(repos, *showClean, *verbose && !*jsonOutput)
+		statuses = analyzeReposSequential(repos, opts)
 	}
 
+	statuses = filterRepos(statuses, *filter)
+	if *staleDays > 0 {
+		statuses = filterStale(statuses, *staleDays)
+	}
+	sortRepos(statuses, *sortBy)
+
 	if *jsonOutput {
-		displayJSONOutput(statuses)
+		if err := displayJSONOutput(os.Stdout, statuses); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing JSON: %v\n", err)
+			os.Exit(1)
+		}
 	} else {
-		fmt.Printf("Found %d git repositor%s:\n\n", len(repos), pluralize(len(repos), "y", "ies"))
-		for _, status := range statuses {
-			displayRepoStatus(status, *showClean)
+		if *staleDays > 0 {
+			fmt.Printf("Found %d git repositor%s, %d with branches untouched for %d day%s:\n\n", len(repos), pluralize(len(repos), "y", "ies"), len(statuses), *staleDays, pluralize(*staleDays, "", "s"))
+		} else if *filter != filterAll {
+			fmt.Printf("Found %d git repositor%s, %d matching filter %s:\n\n", len(repos), pluralize(len(repos), "y", "ies"), len(statuses), *filter)
+		} else {
+			fmt.Printf("Found %d git repositor%s:\n\n", len(repos), pluralize(len(repos), "y", "ies"))
+		}
+		if *byReason {
+			displayByReason(os.Stdout, statuses)
+		} else {
+			for _, status := range statuses {
+				displayRepoStatus(status, includeClean)
+			}
+		}
+		fmt.Println(computeTotals(statuses))
+	}
+}
+
+// Values accepted by -filter
+const (
+	filterAll      = "all"
+	filterDirty    = "dirty"
+	filterAhead    = "ahead"
+	filterBehind   = "behind"
+	filterDiverged = "diverged"
+)
+
+func validFilter(filter string) bool {
+	switch filter {
+	case filterAll, filterDirty, filterAhead, filterBehind, filterDiverged:
+		return true
+	}
+	return false
+}
+
+// Values accepted by -sort
+const (
+	sortPath       = "path"
+	sortName       = "name"
+	sortDirtyFirst = "dirty-first"
+)
+
+func validSort(by string) bool {
+	switch by {
+	case sortPath, sortName, sortDirtyFirst:
+		return true
+	}
+	return false
+}
+
+// sortRepos orders repositories so output is the same from run to run, even
+// when they were analyzed in parallel. Ties are broken by path.
+func sortRepos(statuses []RepoStatus, by string) {
+	sort.SliceStable(statuses, func(i, j int) bool {
+		a, b := statuses[i], statuses[j]
+		switch by {
+		case sortName:
+			if an, bn := filepath.Base(a.Path), filepath.Base(b.Path); an != bn {
+				return an < bn
+			}
+		case sortDirtyFirst:
+			if ad, bd := hasDirtyBranch(a), hasDirtyBranch(b); ad != bd {
+				return ad
+			}
+		}
+		return a.Path < b.Path
+	})
+}
+
+func hasDirtyBranch(status RepoStatus) bool {
+	for _, branch := range status.Branches {
+		if branch.IsDirty {
+			return true
+		}
+	}
+	return false
+}
+
+// filterRepos keeps the repositories with at least one branch matching the
+// filter. Repositories that failed to analyze are always kept, since their
+// state is unknown and needs attention anyway.
+func filterRepos(statuses []RepoStatus, filter string) []RepoStatus {
+	if filter == filterAll {
+		return statuses
+	}
+
+	var filtered []RepoStatus
+	for _, status := range statuses {
+		if status.Error != "" || repoMatches(status, filter) {
+			filtered = append(filtered, status)
+		}
+	}
+	return filtered
+}
+
+// filterStale keeps only the branches whose last commit is at least days
+// old, marking them stale, and drops repositories left without any.
+// Repositories that failed to analyze are kept, as with filterRepos.
+func filterStale(statuses []RepoStatus, days int) []RepoStatus {
+	var filtered []RepoStatus
+	for _, status := range statuses {
+		if status.Error != "" {
+			filtered = append(filtered, status)
+			continue
+		}
+		var stale []BranchStatus
+		for _, branch := range status.Branches {
+			if !branch.LastCommit.IsZero() && branch.AgeDays >= days {
+				branch.Stale = true
+				stale = append(stale, branch)
+			}
+		}
+		if len(stale) > 0 {
+			status.Branches = stale
+			filtered = append(filtered, status)
+		}
+	}
+	return filtered
+}
+
+// repoMatches reports whether any branch of the repository matches the filter
+func repoMatches(status RepoStatus, filter string) bool {
+	for _, branch := range status.Branches {
+		switch filter {
+		case filterDirty:
+			if branch.IsDirty {
+				return true
+			}
+		case filterAhead:
+			if branch.Ahead > 0 {
+				return true
+			}
+		case filterBehind:
+			if branch.Behind > 0 {
+				return true
+			}
+		case filterDiverged:
+			if branch.Ahead > 0 && branch.Behind > 0 {
+				return true
+			}
 		}
 	}
+	return false
+}
+
+// patternList is a flag that can be repeated to give several glob patterns
+type patternList []string
+
+func (p *patternList) String() string {
+	return strings.Join(*p, ", ")
+}
+
+func (p *patternList) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+// filterPaths keeps the repository paths that match one of the include
+// patterns, or all of them when there are none, and no exclude pattern.
+// Exclude takes precedence.
+func filterPaths(repos []string, include, exclude []string) []string {
+	if len(include) == 0 && len(exclude) == 0 {
+		return repos
+	}
+
+	var filtered []string
+	for _, repo := range repos {
+		if len(include) > 0 && !matchAny(include, repo) {
+			continue
+		}
+		if matchAny(exclude, repo) {
+			continue
+		}
+		filtered = append(filtered, repo)
+	}
+	return filtered
+}
+
+// matchAny reports whether a path matches any of the patterns. A pattern
+// matches the end of the path, whole directories at a time, so "api-*"
+// matches a repository's directory name, "work/*" any repository directly
+// inside a directory named work, and an absolute pattern the full path.
+func matchAny(patterns []string, path string) bool {
+	elems := strings.Split(filepath.Clean(path), string(filepath.Separator))
+	for _, pattern := range patterns {
+		pattern = filepath.Clean(pattern)
+		for i := range elems {
+			suffix := strings.Join(elems[i:], string(filepath.Separator))
+			if ok, _ := filepath.Match(pattern, suffix); ok {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func findGitRepos(root string, maxDepth int, verbose bool) []string {
@@ -101,16 +451,9 @@ func findGitRepos(root string, maxDepth int, verbose bool) []string {
 			return nil
 		}
 
-		// Skip common directories that shouldn't be searched
-		if info.IsDir() {
-			basename := filepath.Base(path)
-			if basename == "node_modules" || basename == "vendor" || basename == ".git" {
-				return filepath.SkipDir
-			}
-		}
-
-		// Check if this is a .git directory
-		if info.IsDir() && info.Name() == ".git" {
+		// A .git directory marks a repository. In worktrees and submodules
+		// .git is instead a file holding a gitdir: pointer.
+		if info.Name() == ".git" {
 			repoPath := filepath.Dir(path)
 
 			// Avoid duplicates
@@ -121,7 +464,18 @@ func findGitRepos(root string, maxDepth int, verbose bool) []string {
 					fmt.Printf("Found repository: %s\n", repoPath)
 				}
 			}
-			return filepath.SkipDir
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Skip common directories that shouldn't be searched
+		if info.IsDir() {
+			basename := filepath.Base(path)
+			if basename == "node_modules" || basename == "vendor" {
+				return filepath.SkipDir
+			}
 		}
 
 		return nil
@@ -130,28 +484,74 @@ func findGitRepos(root string, maxDepth int, verbose bool) []string {
 	return repos
 }
 
-func analyzeReposSequential(repos []string, includeClean bool, verbose bool) []RepoStatus {
+// readReposFile reads repository paths from a file, one per line. Blank lines
+// and lines starting with # are ignored, and relative paths are resolved
+// against the file's own directory.
+func readReposFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	base, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	var repos []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(base, line)
+		}
+		repos = append(repos, filepath.Clean(line))
+	}
+	return repos, scanner.Err()
+}
+
+func analyzeReposSequential(repos []string, opts analyzeOptions) []RepoStatus {
 	var statuses []RepoStatus
 	for _, repoPath := range repos {
-		status := analyzeRepo(repoPath, includeClean, verbose)
+		status := analyzeRepo(repoPath, opts)
 		statuses = append(statuses, status)
 	}
 	return statuses
 }
 
-func analyzeReposParallel(repos []string, includeClean bool, verbose bool) []RepoStatus {
+// analyzeReposParallel analyzes repositories with a pool of jobs workers.
+// Each analysis runs several git processes, so the pool keeps large scans
+// from exhausting processes or file descriptors.
+func analyzeReposParallel(repos []string, jobs int, analyze func(path string) RepoStatus) []RepoStatus {
+	if jobs < 1 {
+		jobs = 1
+	}
+
 	var wg sync.WaitGroup
+	pathChan := make(chan string)
 	statusChan := make(chan RepoStatus, len(repos))
 
-	for _, repoPath := range repos {
+	for i := 0; i < jobs && i < len(repos); i++ {
 		wg.Add(1)
-		go func(path string) {
+		go func() {
 			defer wg.Done()
-			status := analyzeRepo(path, includeClean, verbose)
-			statusChan <- status
-		}(repoPath)
+			for path := range pathChan {
+				statusChan <- analyze(path)
+			}
+		}()
 	}
 
+	go func() {
+		for _, repoPath := range repos {
+			pathChan <- repoPath
+		}
+		close(pathChan)
+	}()
+
 	go func() {
 		wg.Wait()
 		close(statusChan)
@@ -165,14 +565,46 @@ func analyzeReposParallel(repos []string, includeClean bool, verbose bool) []Rep
 	return statuses
 }
 
-func analyzeRepo(repoPath string, includeClean bool, verbose bool) RepoStatus {
+func analyzeRepo(repoPath string, opts analyzeOptions) RepoStatus {
 	status := RepoStatus{
 		Path:     repoPath,
 		Branches: []BranchStatus{},
 	}
+	verbose := opts.Verbose
+
+	// Paths may come from a hand-written list, so check them before running git
+	if info, err := os.Stat(repoPath); err != nil {
+		status.Error = fmt.Sprintf("Error accessing repository: %v", err)
+		return status
+	} else if !info.IsDir() {
+		status.Error = "Error accessing repository: not a directory"
+		return status
+	}
+	cmd := exec.Command("git", "rev-parse", "--git-dir")
+	cmd.Dir = repoPath
+	if err := cmd.Run(); err != nil {
+		status.Error = "Not a git repository"
+		return status
+	}
+
+	// Update remote-tracking refs first so ahead/behind reflects the remote.
+	// A failed fetch is reported as a warning and the local analysis still
+	// runs, against the remote-tracking refs from the last successful fetch.
+	if opts.Fetch {
+		if err := fetchRepo(repoPath, opts.FetchTimeout); err != nil {
+			if verbose {
+				logRepo(repoPath, "Warning: %v", err)
+			}
+			status.Warning = err.Error()
+		}
+	}
+
+	if opts.Exec != "" {
+		status.Exec = runExec(repoPath, opts.Exec, opts.ExecTimeout)
+	}
 
-	// Save the current branch
-	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
+	// Remember the current branch; it is the only one with a working tree
+	cmd = exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
 	cmd.Dir = repoPath
 	output, err := cmd.Output()
 	if err != nil {
@@ -188,7 +620,7 @@ func analyzeRepo(repoPath string, includeClean bool, verbose bool) RepoStatus {
 	output, err = cmd.Output()
 	if err != nil {
 		if verbose {
-			fmt.Fprintf(os.Stderr, "Error getting branches for %s: %v\n", repoPath, err)
+			logRepo(repoPath, "Error getting branches: %v", err)
 		}
 		status.Error = fmt.Sprintf("Error getting branches: %v", err)
 		return status
@@ -196,84 +628,246 @@ func analyzeRepo(repoPath string, includeClean bool, verbose bool) RepoStatus {
 
 	branches := strings.Split(strings.TrimSpace(string(output)), "\n")
 
+	// Without any remote there is nothing to push to, so don't flag branches
+	cmd = exec.Command("git", "remote")
+	cmd.Dir = repoPath
+	output, err = cmd.Output()
+	hasRemote := err == nil && len(strings.TrimSpace(string(output))) > 0
+
 	for _, branch := range branches {
 		if branch == "" {
 			continue
 		}
 
-		branchStatus := analyzeBranch(repoPath, branch, currentBranch, verbose)
+		branchStatus := analyzeBranch(repoPath, branch, currentBranch, hasRemote, verbose)
+		status.anyAhead = status.anyAhead || branchStatus.Ahead > 0
+		status.anyBehind = status.anyBehind || branchStatus.Behind > 0
 
-		// Only include if dirty or if we're showing clean branches
-		if branchStatus.IsDirty || includeClean {
+		// Only include if dirty, unpushed or if we're showing clean branches
+		if branchStatus.IsDirty || branchStatus.Unpushed || opts.IncludeClean {
 			status.Branches = append(status.Branches, branchStatus)
 		}
 	}
 
-	// Return to original branch
-	if currentBranch != "" {
-		cmd = exec.Command("git", "checkout", "-q", currentBranch)
-		cmd.Dir = repoPath
-		if err := cmd.Run(); err != nil {
-			if verbose {
-				fmt.Fprintf(os.Stderr, "Warning: cannot return to branch %s in %s: %v\n", currentBranch, repoPath, err)
-			}
-		}
+	if opts.IncludeRemote && hasRemote {
+		status.Branches = append(status.Branches, remoteOnlyBranches(repoPath, branches, verbose)...)
 	}
 
 	return status
 }
 
-func analyzeBranch(repoPath, branch, currentBranch string, verbose bool) BranchStatus {
+// fetchRepo fetches all remotes of a repository, giving up after timeout
+func fetchRepo(repoPath string, timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, "git", "fetch", "--all", "--quiet")
+	cmd.Dir = repoPath
+	// Never block on a credential prompt, and don't wait long for helpers
+	// like ssh that outlive a killed git process
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	cmd.WaitDelay = time.Second
+
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("Error fetching: timed out after %s", timeout)
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			return fmt.Errorf("Error fetching: %s", msg)
+		}
+		return fmt.Errorf("Error fetching: %v", err)
+	}
+	return nil
+}
+
+// runExec runs a shell command in a repository, giving up after timeout.
+// Each {} in the command is replaced by the quoted repository path.
+func runExec(repoPath, command string, timeout time.Duration) *ExecResult {
+	result := &ExecResult{Command: strings.ReplaceAll(command, "{}", shellQuote(repoPath))}
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var stdout, stderr strings.Builder
+	cmd := exec.CommandContext(ctx, "sh", "-c", result.Command)
+	cmd.Dir = repoPath
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	result.Output = stdout.String()
+	result.ExitCode = cmd.ProcessState.ExitCode()
+	if ctx.Err() == context.DeadlineExceeded {
+		result.ExitCode = -1
+		result.Error = fmt.Sprintf("timed out after %s", timeout)
+	} else if err != nil {
+		result.Error = strings.TrimSpace(stderr.String())
+		if result.Error == "" {
+			result.Error = err.Error()
+		}
+	}
+	return result
+}
+
+// shellQuote quotes s as a single sh word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func analyzeBranch(repoPath, branch, currentBranch string, hasRemote, verbose bool) BranchStatus {
 	status := BranchStatus{
 		Name:    branch,
 		IsDirty: false,
 		Current: branch == currentBranch,
 	}
 
-	// Only checkout if not already on this branch
-	if branch != currentBranch {
-		cmd := exec.Command("git", "checkout", "-q", branch)
+	// Only the checked-out branch has a working tree to inspect. Other
+	// branches are never checked out, so they report commit divergence only.
+	if status.Current {
+		cmd := exec.Command("git", "status", "--porcelain")
 		cmd.Dir = repoPath
-		if err := cmd.Run(); err != nil {
+		output, err := cmd.Output()
+		if err != nil {
 			if verbose {
-				fmt.Fprintf(os.Stderr, "Warning: cannot checkout %s in %s: %v\n", branch, repoPath, err)
+				logRepo(repoPath, "Warning: cannot get status for %s: %v", branch, err)
 			}
-			status.Status = "Error checking out branch"
+			status.Status = "Error getting status"
 			return status
 		}
+
+		if len(output) > 0 {
+			status.IsDirty = true
+			status.Status = parseGitStatus(string(output))
+		} else {
+			status.Status = "Clean"
+		}
+	} else {
+		status.Status = "Not checked out"
 	}
 
-	// Check for uncommitted changes
-	cmd := exec.Command("git", "status", "--porcelain")
+	// Check ahead/behind relative to the branch's own upstream
+	cmd := exec.Command("git", "rev-list", "--left-right", "--count", fmt.Sprintf("%s...%s@{u}", branch, branch))
+	cmd.Dir = repoPath
+	output, err := cmd.Output()
+	if err == nil {
+		var ahead, behind int
+		fmt.Sscanf(string(output), "%d\t%d", &ahead, &behind)
+		status.Ahead = ahead
+		status.Behind = behind
+		status.HasUpstream = true
+	} else if verbose {
+		logRepo(repoPath, "%s has no upstream", branch)
+	}
+
+	// 0 ahead/0 behind looks the same for a synced branch and one that was
+	// never pushed, so check whether the remote has the branch at all
+	if hasRemote && !remoteBranchExists(repoPath, branch) {
+		status.Unpushed = true
+	}
+
+	setLastCommit(repoPath, &status, verbose)
+
+	return status
+}
+
+// setLastCommit records when the tip of a branch was committed and how many
+// days ago that was
+func setLastCommit(repoPath string, branch *BranchStatus, verbose bool) {
+	cmd := exec.Command("git", "log", "-1", "--format=%ct", branch.Name, "--")
 	cmd.Dir = repoPath
 	output, err := cmd.Output()
 	if err != nil {
 		if verbose {
-			fmt.Fprintf(os.Stderr, "Warning: cannot get status for %s in %s: %v\n", branch, repoPath, err)
+			logRepo(repoPath, "Warning: cannot get last commit of %s: %v", branch.Name, err)
 		}
-		status.Status = "Error getting status"
-		return status
+		return
 	}
+	seconds, err := strconv.ParseInt(strings.TrimSpace(string(output)), 10, 64)
+	if err != nil {
+		return
+	}
+	branch.LastCommit = time.Unix(seconds, 0).UTC()
+	branch.AgeDays = int(time.Since(branch.LastCommit).Hours() / 24)
+}
 
-	if len(output) > 0 {
-		status.IsDirty = true
-		status.Status = parseGitStatus(string(output))
-	} else {
-		status.Status = "Clean"
+// remoteOnlyBranches lists remote-tracking branches that no local branch
+// tracks or shares a name with. They have no working tree, so only their
+// names are reported.
+func remoteOnlyBranches(repoPath string, localBranches []string, verbose bool) []BranchStatus {
+	local := make(map[string]bool)
+	for _, branch := range localBranches {
+		local[branch] = true
+	}
+
+	cmd := exec.Command("git", "for-each-ref", "--format=%(upstream)", "refs/heads")
+	cmd.Dir = repoPath
+	output, err := cmd.Output()
+	if err != nil {
+		if verbose {
+			logRepo(repoPath, "Warning: cannot list upstream branches: %v", err)
+		}
+		return nil
+	}
+	tracked := make(map[string]bool)
+	for _, ref := range strings.Split(string(output), "\n") {
+		tracked[strings.TrimSpace(ref)] = true
 	}
 
-	// Check ahead/behind relative to upstream
-	cmd = exec.Command("git", "rev-list", "--left-right", "--count", fmt.Sprintf("%s...@{u}", branch))
+	// Full ref names avoid the ambiguous short form of refs/remotes/origin/HEAD
+	cmd = exec.Command("git", "for-each-ref", "--format=%(refname)", "refs/remotes")
 	cmd.Dir = repoPath
 	output, err = cmd.Output()
-	if err == nil {
-		var ahead, behind int
-		fmt.Sscanf(string(output), "%d\t%d", &ahead, &behind)
-		status.Ahead = ahead
-		status.Behind = behind
+	if err != nil {
+		if verbose {
+			logRepo(repoPath, "Warning: cannot list remote branches: %v", err)
+		}
+		return nil
 	}
 
-	return status
+	var branches []BranchStatus
+	for _, ref := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		name := strings.TrimPrefix(ref, "refs/remotes/")
+		if name == "" || strings.HasSuffix(name, "/HEAD") || tracked[ref] {
+			continue
+		}
+		// origin/feature has a local counterpart in feature
+		if _, branch, ok := strings.Cut(name, "/"); ok && local[branch] {
+			continue
+		}
+		branch := BranchStatus{
+			Name:   name,
+			Remote: true,
+			Status: "Remote only",
+		}
+		setLastCommit(repoPath, &branch, verbose)
+		branches = append(branches, branch)
+	}
+	return branches
+}
+
+// remoteBranchExists reports whether a branch has an upstream or a branch of
+// the same name exists on any remote
+func remoteBranchExists(repoPath, branch string) bool {
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", branch+"@{u}")
+	cmd.Dir = repoPath
+	if err := cmd.Run(); err == nil {
+		return true
+	}
+
+	cmd = exec.Command("git", "for-each-ref", "--format=%(refname)", "refs/remotes/*/"+branch)
+	cmd.Dir = repoPath
+	output, err := cmd.Output()
+	return err == nil && len(strings.TrimSpace(string(output))) > 0
 }
 
 func parseGitStatus(statusOutput string) string {
@@ -330,20 +924,33 @@ func displayRepoStatus(status RepoStatus, showClean bool) {
 		return
 	}
 
+	fmt.Printf("📁 %s\n", status.Path)
+	if status.Warning != "" {
+		// git's messages span several lines; keep them under the repository
+		lines := strings.Split(status.Warning, "\n")
+		fmt.Printf("   WARNING: %s\n", lines[0])
+		for _, line := range lines[1:] {
+			if line != "" {
+				fmt.Printf("     %s\n", line)
+			}
+		}
+	}
+
 	if len(status.Branches) == 0 {
-		fmt.Printf("📁 %s\n", status.Path)
 		if !showClean {
 			fmt.Println("   ✓ All branches clean")
+			displayExecResult(status.Exec)
+			fmt.Println()
+		} else if status.Exec != nil || status.Warning != "" {
+			displayExecResult(status.Exec)
 			fmt.Println()
 		}
 		return
 	}
 
-	fmt.Printf("📁 %s\n", status.Path)
-
 	hasDirty := false
 	for _, branch := range status.Branches {
-		if branch.IsDirty {
+		if branch.IsDirty || branch.Unpushed || branch.Remote {
 			hasDirty = true
 			break
 		}
@@ -351,6 +958,7 @@ func displayRepoStatus(status RepoStatus, showClean bool) {
 
 	if !hasDirty && !showClean {
 		fmt.Println("   ✓ All branches clean")
+		displayExecResult(status.Exec)
 		fmt.Println()
 		return
 	}
@@ -359,6 +967,8 @@ func displayRepoStatus(status RepoStatus, showClean bool) {
 		var icon string
 		if branch.IsDirty {
 			icon = "⚠️ "
+		} else if branch.Remote {
+			icon = "☁️ "
 		} else {
 			icon = "✓ "
 		}
@@ -368,62 +978,234 @@ func displayRepoStatus(status RepoStatus, showClean bool) {
 			branchName = fmt.Sprintf("%s *", branchName)
 		}
 
-		fmt.Printf("   %s %s", icon, branchName)
+		fmt.Printf("   %s %s%s", icon, branchName, aheadBehind(branch))
 
-		// Add ahead/behind indicators
-		if branch.Ahead > 0 || branch.Behind > 0 {
-			fmt.Printf(" [")
-			if branch.Ahead > 0 {
-				fmt.Printf("↑%d", branch.Ahead)
-			}
-			if branch.Behind > 0 {
-				if branch.Ahead > 0 {
-					fmt.Print(" ")
-				}
-				fmt.Printf("↓%d", branch.Behind)
-			}
-			fmt.Print("]")
+		if branch.Unpushed {
+			fmt.Print(" (unpushed)")
+		} else if !branch.HasUpstream && !branch.Remote {
+			// Ahead/behind is unknown rather than level
+			fmt.Print(" [no upstream]")
+		}
+		if branch.Stale {
+			fmt.Printf(" (%s)", staleAge(branch))
 		}
 
 		fmt.Printf(" - %s\n", branch.Status)
 	}
 
+	displayExecResult(status.Exec)
 	fmt.Println()
 }
 
-func displayJSONOutput(statuses []RepoStatus) {
-	// Simple JSON output for scripting
-	fmt.Println("[")
-	for i, status := range statuses {
-		fmt.Printf("  {\n")
-		fmt.Printf("    \"path\": %q,\n", status.Path)
-		fmt.Printf("    \"current_branch\": %q,\n", status.CurrentBranch)
+// aheadBehind formats a branch's ahead/behind indicators, e.g. " [↑2 ↓1]",
+// or returns "" when it is level with its upstream
+func aheadBehind(branch BranchStatus) string {
+	var parts []string
+	if branch.Ahead > 0 {
+		parts = append(parts, fmt.Sprintf("↑%d", branch.Ahead))
+	}
+	if branch.Behind > 0 {
+		parts = append(parts, fmt.Sprintf("↓%d", branch.Behind))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " [" + strings.Join(parts, " ") + "]"
+}
+
+// staleAge describes how long ago a stale branch was last committed to,
+// e.g. "last commit 120 days ago"
+func staleAge(branch BranchStatus) string {
+	return fmt.Sprintf("last commit %d day%s ago", branch.AgeDays, pluralize(branch.AgeDays, "", "s"))
+}
+
+// Reasons a branch needs attention, in the order -by-reason lists them.
+// A branch may have several, e.g. modified and ahead.
+const (
+	reasonModified   = "modified"
+	reasonUntracked  = "untracked-only"
+	reasonAhead      = "ahead"
+	reasonBehind     = "behind"
+	reasonDiverged   = "diverged"
+	reasonUnpushed   = "unpushed"
+	reasonRemoteOnly = "remote-only"
+	reasonStale      = "stale"
+	reasonError      = "error"
+)
+
+var reasonOrder = []string{
+	reasonModified, reasonUntracked, reasonAhead, reasonBehind,
+	reasonDiverged, reasonUnpushed, reasonRemoteOnly, reasonStale, reasonError,
+}
+
+// reasonEntry is a branch listed under a reason, or a repository that
+// failed to analyze, which has no branch
+type reasonEntry struct {
+	Repo   RepoStatus
+	Branch BranchStatus
+}
+
+// branchReasons lists why a branch needs attention. Ahead and behind only
+// count when the branch isn't both, so that "ahead" is exactly the set of
+// branches a push would bring in sync.
+func branchReasons(branch BranchStatus) []string {
+	var reasons []string
+	if branch.IsDirty {
+		if untrackedOnly(branch.Status) {
+			reasons = append(reasons, reasonUntracked)
+		} else {
+			reasons = append(reasons, reasonModified)
+		}
+	}
+	switch {
+	case branch.Ahead > 0 && branch.Behind > 0:
+		reasons = append(reasons, reasonDiverged)
+	case branch.Ahead > 0:
+		reasons = append(reasons, reasonAhead)
+	case branch.Behind > 0:
+		reasons = append(reasons, reasonBehind)
+	}
+	if branch.Unpushed {
+		reasons = append(reasons, reasonUnpushed)
+	}
+	if branch.Remote {
+		reasons = append(reasons, reasonRemoteOnly)
+	}
+	if branch.Stale {
+		reasons = append(reasons, reasonStale)
+	}
+	return reasons
+}
+
+// untrackedOnly reports whether a summary from parseGitStatus lists nothing
+// but untracked files
+func untrackedOnly(summary string) bool {
+	return strings.HasSuffix(summary, " untracked") && !strings.Contains(summary, ",")
+}
+
+// groupByReason reorganizes repository statuses by the reasons their
+// branches need attention, keeping repository order within each reason
+func groupByReason(statuses []RepoStatus) map[string][]reasonEntry {
+	groups := make(map[string][]reasonEntry)
+	for _, status := range statuses {
 		if status.Error != "" {
-			fmt.Printf("    \"error\": %q,\n", status.Error)
-		}
-		fmt.Printf("    \"branches\": [\n")
-		for j, branch := range status.Branches {
-			fmt.Printf("      {\n")
-			fmt.Printf("        \"name\": %q,\n", branch.Name)
-			fmt.Printf("        \"current\": %v,\n", branch.Current)
-			fmt.Printf("        \"dirty\": %v,\n", branch.IsDirty)
-			fmt.Printf("        \"ahead\": %d,\n", branch.Ahead)
-			fmt.Printf("        \"behind\": %d,\n", branch.Behind)
-			fmt.Printf("        \"status\": %q\n", branch.Status)
-			if j < len(status.Branches)-1 {
-				fmt.Printf("      },\n")
-			} else {
-				fmt.Printf("      }\n")
+			groups[reasonError] = append(groups[reasonError], reasonEntry{Repo: status})
+			continue
+		}
+		for _, branch := range status.Branches {
+			for _, reason := range branchReasons(branch) {
+				groups[reason] = append(groups[reason], reasonEntry{Repo: status, Branch: branch})
 			}
 		}
-		fmt.Printf("    ]\n")
-		if i < len(statuses)-1 {
-			fmt.Printf("  },\n")
-		} else {
-			fmt.Printf("  }\n")
+	}
+	return groups
+}
+
+// displayByReason shows a one-line count per reason, then the branches
+// under each reason
+func displayByReason(w io.Writer, statuses []RepoStatus) {
+	groups := groupByReason(statuses)
+
+	var counts []string
+	for _, reason := range reasonOrder {
+		if n := len(groups[reason]); n > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", n, reason))
+		}
+	}
+	if len(counts) == 0 {
+		fmt.Fprint(w, "✓ Nothing needs attention\n\n")
+		return
+	}
+	fmt.Fprintf(w, "%s\n\n", strings.Join(counts, ", "))
+
+	for _, reason := range reasonOrder {
+		entries := groups[reason]
+		if len(entries) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%s (%d)\n", reason, len(entries))
+		for _, entry := range entries {
+			if entry.Repo.Error != "" {
+				fmt.Fprintf(w, "   📁 %s - ERROR: %s\n", entry.Repo.Path, entry.Repo.Error)
+				continue
+			}
+			branch := entry.Branch
+			branchName := branch.Name
+			if branch.Current {
+				branchName += " *"
+			}
+			details := aheadBehind(branch)
+			if branch.Stale {
+				details += " (" + staleAge(branch) + ")"
+			}
+			fmt.Fprintf(w, "   📁 %s: %s%s - %s\n", entry.Repo.Path, branchName, details, branch.Status)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// displayExecResult shows the -exec command with its exit code and output
+func displayExecResult(result *ExecResult) {
+	if result == nil {
+		return
+	}
+
+	fmt.Printf("   $ %s (exit %d)\n", result.Command, result.ExitCode)
+	if output := strings.TrimRight(result.Output, "\n"); output != "" {
+		for _, line := range strings.Split(output, "\n") {
+			fmt.Printf("     %s\n", line)
 		}
 	}
-	fmt.Println("]")
+	if result.Error != "" {
+		fmt.Printf("     ERROR: %s\n", result.Error)
+	}
+}
+
+func displayJSONOutput(w io.Writer, statuses []RepoStatus) error {
+	if statuses == nil {
+		statuses = []RepoStatus{}
+	}
+	report := struct {
+		Repos []RepoStatus `json:"repos"`
+		Total Totals       `json:"total"`
+	}{statuses, computeTotals(statuses)}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(report)
+}
+
+func computeTotals(statuses []RepoStatus) Totals {
+	totals := Totals{Repos: len(statuses)}
+	for _, status := range statuses {
+		if status.Error != "" {
+			totals.Errors++
+			continue
+		}
+		dirty, ahead, behind := false, status.anyAhead, status.anyBehind
+		for _, branch := range status.Branches {
+			dirty = dirty || branch.IsDirty
+			ahead = ahead || branch.Ahead > 0
+			behind = behind || branch.Behind > 0
+		}
+		if dirty {
+			totals.Dirty++
+		}
+		if ahead {
+			totals.Ahead++
+		}
+		if behind {
+			totals.Behind++
+		}
+	}
+	return totals
+}
+
+// String formats the totals as a one-line summary
+func (t Totals) String() string {
+	return fmt.Sprintf("%d repo%s, %d dirty, %d ahead, %d behind, %d error%s",
+		t.Repos, pluralize(t.Repos, "", "s"), t.Dirty, t.Ahead, t.Behind, t.Errors, pluralize(t.Errors, "", "s"))
 }
 
 func pluralize(count int, singular, plural string) string {
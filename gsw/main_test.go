@@ -0,0 +1,905 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLogRepoParallel(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(old io.Writer) { logOutput = old }(logOutput)
+	logOutput = &buf
+
+	const workers = 20
+	const messages = 50
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			repo := fmt.Sprintf("/repos/repo-%d", w)
+			for m := 0; m < messages; m++ {
+				logRepo(repo, "Warning: message %d from worker %d", m, w)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != workers*messages {
+		t.Fatalf("Expected %d lines, got %d", workers*messages, len(lines))
+	}
+
+	// Every line must be intact and attributed to the worker that wrote it
+	lineRegex := regexp.MustCompile(`^\[/repos/repo-(\d+)\] Warning: message \d+ from worker (\d+)$`)
+	for _, line := range lines {
+		matches := lineRegex.FindStringSubmatch(line)
+		if matches == nil {
+			t.Fatalf("Garbled log line: %q", line)
+		}
+		if matches[1] != matches[2] {
+			t.Errorf("Log line attributed to the wrong repo: %q", line)
+		}
+	}
+}
+
+// git runs a git command in dir, failing the test on error
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	return gitEnv(t, dir, nil, args...)
+}
+
+// gitEnv is git with extra environment variables, e.g. to backdate commits
+func gitEnv(t *testing.T, dir string, env []string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=gsw", "GIT_AUTHOR_EMAIL=gsw@example.com",
+		"GIT_COMMITTER_NAME=gsw", "GIT_COMMITTER_EMAIL=gsw@example.com",
+		"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1",
+	)
+	cmd.Env = append(cmd.Env, env...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// newClonedRepo creates a bare remote with one commit and returns it along
+// with a clone tracking its main branch
+func newClonedRepo(t *testing.T) (remote, clone string) {
+	t.Helper()
+	root := t.TempDir()
+	remote = filepath.Join(root, "remote.git")
+	clone = filepath.Join(root, "clone")
+
+	git(t, root, "init", "--bare", "-b", "main", remote)
+	git(t, root, "clone", "-q", remote, clone)
+	git(t, clone, "commit", "-q", "--allow-empty", "-m", "initial")
+	git(t, clone, "push", "-q", "-u", "origin", "main")
+	return remote, clone
+}
+
+func TestAnalyzeRepoFetch(t *testing.T) {
+	remote, clone := newClonedRepo(t)
+
+	// Someone else pushes a commit the clone hasn't seen yet
+	other := filepath.Join(t.TempDir(), "other")
+	git(t, filepath.Dir(other), "clone", "-q", remote, other)
+	git(t, other, "commit", "-q", "--allow-empty", "-m", "upstream change")
+	git(t, other, "push", "-q", "origin", "main")
+
+	opts := analyzeOptions{IncludeClean: true}
+	status := analyzeRepo(clone, opts)
+	if len(status.Branches) != 1 || status.Branches[0].Behind != 0 {
+		t.Fatalf("Without fetch, expected stale behind count 0, got %+v", status.Branches)
+	}
+
+	opts.Fetch = true
+	opts.FetchTimeout = 30 * time.Second
+	status = analyzeRepo(clone, opts)
+	if status.Error != "" {
+		t.Fatalf("Unexpected error: %s", status.Error)
+	}
+	if len(status.Branches) != 1 || status.Branches[0].Behind != 1 {
+		t.Errorf("With fetch, expected behind count 1, got %+v", status.Branches)
+	}
+}
+
+func TestAnalyzeRepoFetchError(t *testing.T) {
+	_, clone := newClonedRepo(t)
+	git(t, clone, "remote", "set-url", "origin", filepath.Join(t.TempDir(), "missing.git"))
+
+	opts := analyzeOptions{
+		IncludeClean: true,
+		Fetch:        true,
+		FetchTimeout: 30 * time.Second,
+	}
+	statuses := analyzeReposParallel([]string{clone}, 1, func(path string) RepoStatus {
+		return analyzeRepo(path, opts)
+	})
+	if len(statuses) != 1 {
+		t.Fatalf("Expected 1 status, got %d", len(statuses))
+	}
+	if !strings.HasPrefix(statuses[0].Warning, "Error fetching:") {
+		t.Errorf("Expected fetch error to be recorded as a warning, got %q", statuses[0].Warning)
+	}
+	// It is not an analysis failure, so the repository isn't counted as one
+	if statuses[0].Error != "" {
+		t.Errorf("Expected no repository error, got %q", statuses[0].Error)
+	}
+	if totals := computeTotals(statuses); totals.Errors != 0 {
+		t.Errorf("Expected no errors in totals, got %+v", totals)
+	}
+	// The local analysis still ran
+	if len(statuses[0].Branches) != 1 {
+		t.Errorf("Expected local branches despite fetch error, got %+v", statuses[0].Branches)
+	}
+}
+
+// findBranch returns the named branch from a repo status
+func findBranch(t *testing.T, status RepoStatus, name string) BranchStatus {
+	t.Helper()
+	for _, branch := range status.Branches {
+		if branch.Name == name {
+			return branch
+		}
+	}
+	t.Fatalf("Branch %s not found in %+v", name, status.Branches)
+	return BranchStatus{}
+}
+
+func TestAnalyzeRepoUnpushed(t *testing.T) {
+	_, clone := newClonedRepo(t)
+	git(t, clone, "branch", "local-only")
+	git(t, clone, "branch", "pushed")
+	git(t, clone, "push", "-q", "origin", "pushed")
+
+	status := analyzeRepo(clone, analyzeOptions{IncludeClean: true})
+	if status.Error != "" {
+		t.Fatalf("Unexpected error: %s", status.Error)
+	}
+
+	if findBranch(t, status, "main").Unpushed {
+		t.Error("main tracks origin/main and should not be unpushed")
+	}
+	// pushed has no upstream configured, but origin has a branch of that name
+	if findBranch(t, status, "pushed").Unpushed {
+		t.Error("pushed exists on origin and should not be unpushed")
+	}
+	if local := findBranch(t, status, "local-only"); !local.Unpushed || local.Ahead != 0 {
+		t.Errorf("local-only should be unpushed with 0 ahead, got %+v", local)
+	}
+
+	// Unpushed branches are reported even when clean branches are hidden
+	status = analyzeRepo(clone, analyzeOptions{})
+	if len(status.Branches) != 1 || status.Branches[0].Name != "local-only" {
+		t.Errorf("Expected only local-only to be reported, got %+v", status.Branches)
+	}
+}
+
+func TestAnalyzeRepoNoUpstream(t *testing.T) {
+	_, clone := newClonedRepo(t)
+	git(t, clone, "branch", "local-only")
+	git(t, clone, "branch", "pushed")
+	git(t, clone, "push", "-q", "origin", "pushed")
+
+	status := analyzeRepo(clone, analyzeOptions{IncludeClean: true})
+	if status.Error != "" {
+		t.Fatalf("Unexpected error: %s", status.Error)
+	}
+
+	if main := findBranch(t, status, "main"); !main.HasUpstream {
+		t.Errorf("main tracks origin/main and should have an upstream, got %+v", main)
+	}
+	if local := findBranch(t, status, "local-only"); local.HasUpstream || local.Ahead != 0 || local.Behind != 0 {
+		t.Errorf("local-only should have no upstream, got %+v", local)
+	}
+	// A branch of the same name on origin is not an upstream until it is set
+	if pushed := findBranch(t, status, "pushed"); pushed.HasUpstream || pushed.Unpushed {
+		t.Errorf("pushed should have no upstream but not be unpushed, got %+v", pushed)
+	}
+
+	git(t, clone, "branch", "-q", "--set-upstream-to=origin/pushed", "pushed")
+	status = analyzeRepo(clone, analyzeOptions{IncludeClean: true})
+	if pushed := findBranch(t, status, "pushed"); !pushed.HasUpstream {
+		t.Errorf("pushed should have an upstream once set, got %+v", pushed)
+	}
+}
+
+func TestAnalyzeRepoNoRemote(t *testing.T) {
+	repo := t.TempDir()
+	git(t, repo, "init", "-q", "-b", "main")
+	git(t, repo, "commit", "-q", "--allow-empty", "-m", "initial")
+
+	status := analyzeRepo(repo, analyzeOptions{IncludeClean: true})
+	if findBranch(t, status, "main").Unpushed {
+		t.Error("Branches of a repo without remotes should not be flagged unpushed")
+	}
+}
+
+func TestAnalyzeRepoLeavesWorkingTree(t *testing.T) {
+	_, clone := newClonedRepo(t)
+	git(t, clone, "checkout", "-q", "-b", "feature")
+	git(t, clone, "commit", "-q", "--allow-empty", "-m", "pushed")
+	git(t, clone, "push", "-q", "-u", "origin", "feature")
+	git(t, clone, "commit", "-q", "--allow-empty", "-m", "local")
+	git(t, clone, "checkout", "-q", "main")
+
+	// Staged and untracked work in progress on the current branch
+	if err := os.WriteFile(filepath.Join(clone, "staged.txt"), []byte("staged\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, clone, "add", "staged.txt")
+	if err := os.WriteFile(filepath.Join(clone, "scratch.txt"), []byte("scratch\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	before := git(t, clone, "status", "--porcelain")
+
+	status := analyzeRepo(clone, analyzeOptions{IncludeClean: true})
+	if status.Error != "" {
+		t.Fatalf("Unexpected error: %s", status.Error)
+	}
+
+	if head := git(t, clone, "rev-parse", "--abbrev-ref", "HEAD"); head != "main" {
+		t.Errorf("Expected main to stay checked out, got %s", head)
+	}
+	if after := git(t, clone, "status", "--porcelain"); after != before {
+		t.Errorf("Working tree changed:\nbefore:\n%s\nafter:\n%s", before, after)
+	}
+
+	if main := findBranch(t, status, "main"); !main.IsDirty || main.Status != "1 added, 1 untracked" {
+		t.Errorf("Expected main to be dirty, got %+v", main)
+	}
+	feature := findBranch(t, status, "feature")
+	if feature.IsDirty || feature.Status != "Not checked out" {
+		t.Errorf("Non-current branch should not report a working tree, got %+v", feature)
+	}
+	if feature.Ahead != 1 || feature.Behind != 0 {
+		t.Errorf("Expected feature to be 1 ahead of its own upstream, got %+v", feature)
+	}
+}
+
+// Branches are inspected without checkouts, so no dirty-tree guard is needed:
+// staged edits that would conflict with another branch must survive a scan
+func TestAnalyzeRepoStagedConflictingChanges(t *testing.T) {
+	_, clone := newClonedRepo(t)
+	file := filepath.Join(clone, "config.txt")
+	if err := os.WriteFile(file, []byte("base\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, clone, "add", "config.txt")
+	git(t, clone, "commit", "-q", "-m", "add config")
+	git(t, clone, "branch", "other")
+	git(t, clone, "checkout", "-q", "other")
+	if err := os.WriteFile(file, []byte("other\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, clone, "commit", "-q", "-am", "change config")
+	git(t, clone, "checkout", "-q", "main")
+
+	if err := os.WriteFile(file, []byte("in progress\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, clone, "add", "config.txt")
+
+	status := analyzeRepo(clone, analyzeOptions{IncludeClean: true})
+	if status.Error != "" {
+		t.Fatalf("Unexpected error: %s", status.Error)
+	}
+	if main := findBranch(t, status, "main"); !main.IsDirty {
+		t.Errorf("Expected main to be dirty, got %+v", main)
+	}
+	if other := findBranch(t, status, "other"); other.IsDirty {
+		t.Errorf("Expected other to report no working tree changes, got %+v", other)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "in progress\n" {
+		t.Errorf("Staged work was clobbered, file now contains %q", data)
+	}
+	if staged := git(t, clone, "diff", "--cached", "--name-only"); staged != "config.txt" {
+		t.Errorf("Expected config.txt to remain staged, got %q", staged)
+	}
+}
+
+func TestDisplayJSONOutput(t *testing.T) {
+	statuses := []RepoStatus{
+		{
+			Path:          "/src/weird \"quoted\" dir\\with\ttab\x01ctl/ünïcode",
+			CurrentBranch: "feature/a&b<c>",
+			Branches: []BranchStatus{
+				{Name: "feature/a&b<c>", Current: true, IsDirty: true, Ahead: 2, Status: "1 modified"},
+				{Name: "main", Behind: 3, Unpushed: true, Status: "Not checked out"},
+			},
+		},
+		{
+			Path:     "/src/broken",
+			Branches: []BranchStatus{},
+			Error:    "Error getting current branch: exit status 128",
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := displayJSONOutput(&buf, statuses); err != nil {
+		t.Fatalf("displayJSONOutput failed: %v", err)
+	}
+
+	var decoded struct {
+		Repos []RepoStatus
+		Total Totals
+	}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("Output is not valid JSON: %v\n%s", err, buf.String())
+	}
+	if !reflect.DeepEqual(decoded.Repos, statuses) {
+		t.Errorf("Round trip mismatch:\ngot  %+v\nwant %+v", decoded.Repos, statuses)
+	}
+	if want := (Totals{Repos: 2, Dirty: 1, Ahead: 1, Behind: 1, Errors: 1}); decoded.Total != want {
+		t.Errorf("Expected totals %+v, got %+v", want, decoded.Total)
+	}
+
+	var report struct {
+		Repos []map[string]interface{}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	raw := report.Repos
+	if _, ok := raw[0]["error"]; ok {
+		t.Error("Expected error to be omitted for a repo without one")
+	}
+	if raw[1]["error"] != statuses[1].Error {
+		t.Errorf("Expected error %q, got %v", statuses[1].Error, raw[1]["error"])
+	}
+	branch := raw[0]["branches"].([]interface{})[1].(map[string]interface{})
+	for _, key := range []string{"name", "current", "dirty", "ahead", "behind", "unpushed", "status"} {
+		if _, ok := branch[key]; !ok {
+			t.Errorf("Branch is missing %q: %v", key, branch)
+		}
+	}
+}
+
+func TestDisplayJSONOutputEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := displayJSONOutput(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	var report map[string]json.RawMessage
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(report["repos"]); got != "[]" {
+		t.Errorf("Expected an empty repos array, got %s", got)
+	}
+}
+
+func TestFilterRepos(t *testing.T) {
+	statuses := []RepoStatus{
+		{Path: "/clean", Branches: []BranchStatus{{Name: "main", Status: "Clean"}}},
+		{Path: "/dirty", Branches: []BranchStatus{{Name: "main", IsDirty: true}}},
+		{Path: "/ahead", Branches: []BranchStatus{{Name: "main"}, {Name: "feature", Ahead: 2}}},
+		{Path: "/behind", Branches: []BranchStatus{{Name: "main", Behind: 1}}},
+		// Ahead on one branch and behind on another is not diverged
+		{Path: "/split", Branches: []BranchStatus{{Name: "main", Ahead: 1}, {Name: "dev", Behind: 1}}},
+		{Path: "/diverged", Branches: []BranchStatus{{Name: "main", IsDirty: true, Ahead: 1, Behind: 3}}},
+		{Path: "/broken", Branches: []BranchStatus{}, Error: "Error getting branches: exit status 128"},
+	}
+
+	tests := []struct {
+		filter string
+		want   []string
+	}{
+		{filterAll, []string{"/clean", "/dirty", "/ahead", "/behind", "/split", "/diverged", "/broken"}},
+		{filterDirty, []string{"/dirty", "/diverged", "/broken"}},
+		{filterAhead, []string{"/ahead", "/split", "/diverged", "/broken"}},
+		{filterBehind, []string{"/behind", "/split", "/diverged", "/broken"}},
+		{filterDiverged, []string{"/diverged", "/broken"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			var got []string
+			for _, status := range filterRepos(statuses, tt.filter) {
+				got = append(got, status.Path)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filterRepos(%s) = %v, want %v", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterPaths(t *testing.T) {
+	repos := []string{
+		"/home/user/work/api-server",
+		"/home/user/work/api-client",
+		"/home/user/work/archive/api-old",
+		"/home/user/personal/dotfiles",
+		"/home/user/personal/blog",
+	}
+
+	tests := []struct {
+		name             string
+		include, exclude []string
+		want             []string
+	}{
+		{"no patterns", nil, nil, repos},
+		{"include by name", []string{"api-*"}, nil, []string{
+			"/home/user/work/api-server", "/home/user/work/api-client", "/home/user/work/archive/api-old",
+		}},
+		{"include by parent", []string{"personal/*"}, nil, []string{"/home/user/personal/dotfiles", "/home/user/personal/blog"}},
+		{"include several", []string{"blog", "api-server"}, nil, []string{"/home/user/work/api-server", "/home/user/personal/blog"}},
+		{"include absolute", []string{"/home/user/work/*"}, nil, []string{"/home/user/work/api-server", "/home/user/work/api-client"}},
+		{"exclude only", nil, []string{"archive/*", "dotfiles"}, []string{
+			"/home/user/work/api-server", "/home/user/work/api-client", "/home/user/personal/blog",
+		}},
+		{"exclude wins", []string{"api-*"}, []string{"archive/*", "api-client"}, []string{"/home/user/work/api-server"}},
+		{"no match", []string{"nothing"}, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := filterPaths(repos, tt.include, tt.exclude); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filterPaths(%v, %v) = %v, want %v", tt.include, tt.exclude, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidFilter(t *testing.T) {
+	for _, filter := range []string{"all", "dirty", "ahead", "behind", "diverged"} {
+		if !validFilter(filter) {
+			t.Errorf("Expected %q to be a valid filter", filter)
+		}
+	}
+	for _, filter := range []string{"", "clean", "Dirty"} {
+		if validFilter(filter) {
+			t.Errorf("Expected %q to be rejected", filter)
+		}
+	}
+}
+
+func TestReposFile(t *testing.T) {
+	_, clone := newClonedRepo(t)
+	notRepo := t.TempDir()
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	dir := t.TempDir()
+	list := filepath.Join(dir, "repos.txt")
+	content := fmt.Sprintf("# curated repos\n%s\n\n  %s  \n%s\n", clone, notRepo, missing)
+	if err := os.WriteFile(list, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	repos, err := readReposFile(list)
+	if err != nil {
+		t.Fatalf("readReposFile failed: %v", err)
+	}
+	if want := []string{clone, notRepo, missing}; !reflect.DeepEqual(repos, want) {
+		t.Fatalf("readReposFile = %v, want %v", repos, want)
+	}
+
+	statuses := analyzeReposSequential(repos, analyzeOptions{IncludeClean: true})
+	if len(statuses) != 3 {
+		t.Fatalf("Expected 3 statuses, got %d", len(statuses))
+	}
+	if statuses[0].Error != "" || len(statuses[0].Branches) != 1 {
+		t.Errorf("Expected the valid repo to be analyzed, got %+v", statuses[0])
+	}
+	if statuses[1].Error != "Not a git repository" {
+		t.Errorf("Expected a non-git error, got %q", statuses[1].Error)
+	}
+	if !strings.HasPrefix(statuses[2].Error, "Error accessing repository:") {
+		t.Errorf("Expected an access error, got %q", statuses[2].Error)
+	}
+}
+
+func TestReposFileRelativePaths(t *testing.T) {
+	dir := t.TempDir()
+	list := filepath.Join(dir, "repos.txt")
+	if err := os.WriteFile(list, []byte("projects/app\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	repos, err := readReposFile(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "projects", "app"); len(repos) != 1 || repos[0] != want {
+		t.Errorf("Expected %s relative to the file, got %v", want, repos)
+	}
+}
+
+func TestAnalyzeRepoRemoteBranches(t *testing.T) {
+	remote, clone := newClonedRepo(t)
+
+	// Someone else pushes two branches; the clone checks out only one of them
+	other := filepath.Join(t.TempDir(), "other")
+	git(t, filepath.Dir(other), "clone", "-q", remote, other)
+	git(t, other, "push", "-q", "origin", "main:feature", "main:release")
+	git(t, clone, "fetch", "-q", "origin")
+	git(t, clone, "branch", "-q", "--track", "release", "origin/release")
+
+	status := analyzeRepo(clone, analyzeOptions{IncludeClean: true})
+	for _, branch := range status.Branches {
+		if branch.Remote {
+			t.Errorf("Remote branches listed without IncludeRemote: %+v", branch)
+		}
+	}
+
+	status = analyzeRepo(clone, analyzeOptions{IncludeClean: true, IncludeRemote: true})
+	if status.Error != "" {
+		t.Fatalf("Unexpected error: %s", status.Error)
+	}
+	var remoteOnly []string
+	for _, branch := range status.Branches {
+		if branch.Remote {
+			remoteOnly = append(remoteOnly, branch.Name)
+		}
+	}
+	// origin/main and origin/release have local branches, origin/HEAD is an alias
+	if !reflect.DeepEqual(remoteOnly, []string{"origin/feature"}) {
+		t.Fatalf("Expected only origin/feature to be remote-only, got %v", remoteOnly)
+	}
+	if feature := findBranch(t, status, "origin/feature"); feature.IsDirty || feature.Current || feature.Status != "Remote only" {
+		t.Errorf("Unexpected remote branch status: %+v", feature)
+	}
+}
+
+func TestFindGitReposWorktree(t *testing.T) {
+	root := t.TempDir()
+	repo := filepath.Join(root, "repo")
+	worktree := filepath.Join(root, "checkouts", "wt")
+	git(t, root, "init", "-q", "-b", "main", repo)
+	git(t, repo, "commit", "-q", "--allow-empty", "-m", "initial")
+	git(t, repo, "worktree", "add", "-q", "-b", "wt-branch", worktree)
+
+	// Repositories inside skipped directories stay hidden
+	git(t, root, "init", "-q", filepath.Join(root, "node_modules", "dep"))
+
+	info, err := os.Lstat(filepath.Join(worktree, ".git"))
+	if err != nil || info.IsDir() {
+		t.Fatalf("Expected the worktree's .git to be a file, got %v, %v", info, err)
+	}
+
+	repos := findGitRepos(root, 10, false)
+	if want := []string{worktree, repo}; !reflect.DeepEqual(repos, want) {
+		t.Fatalf("findGitRepos = %v, want %v", repos, want)
+	}
+
+	status := analyzeRepo(worktree, analyzeOptions{IncludeClean: true})
+	if status.Error != "" {
+		t.Fatalf("Unexpected error: %s", status.Error)
+	}
+	if status.CurrentBranch != "wt-branch" || !findBranch(t, status, "wt-branch").Current {
+		t.Errorf("Expected wt-branch to be current in the worktree, got %+v", status)
+	}
+}
+
+func TestComputeTotals(t *testing.T) {
+	statuses := []RepoStatus{
+		{Path: "/clean", Branches: []BranchStatus{{Name: "main"}}},
+		// Several matching branches still count the repo once
+		{Path: "/busy", Branches: []BranchStatus{
+			{Name: "main", IsDirty: true, Ahead: 1},
+			{Name: "a", Ahead: 2},
+			{Name: "b", Behind: 1},
+		}},
+		{Path: "/behind", Branches: []BranchStatus{{Name: "main", Behind: 4}}},
+		// A failed repo counts only as an error, even with branch data
+		{Path: "/failed", Error: "Error getting branches: exit status 128", Branches: []BranchStatus{
+			{Name: "main", IsDirty: true, Ahead: 1, Behind: 1},
+		}},
+		// A failed fetch is only a warning, and the branches still count
+		{Path: "/fetch-failed", Warning: "Error fetching: timed out after 30s", Branches: []BranchStatus{
+			{Name: "main", Ahead: 1},
+		}},
+	}
+
+	got := computeTotals(statuses)
+	want := Totals{Repos: 5, Dirty: 1, Ahead: 2, Behind: 2, Errors: 1}
+	if got != want {
+		t.Errorf("computeTotals = %+v, want %+v", got, want)
+	}
+	if s := got.String(); s != "5 repos, 1 dirty, 2 ahead, 2 behind, 1 error" {
+		t.Errorf("Unexpected summary: %s", s)
+	}
+	if s := computeTotals(statuses[:1]).String(); s != "1 repo, 0 dirty, 0 ahead, 0 behind, 0 errors" {
+		t.Errorf("Unexpected summary: %s", s)
+	}
+}
+
+func TestComputeTotalsHiddenBranches(t *testing.T) {
+	_, clone := newClonedRepo(t)
+	git(t, clone, "commit", "-q", "--allow-empty", "-m", "pushed")
+	git(t, clone, "push", "-q", "origin", "main")
+	git(t, clone, "branch", "lagging", "HEAD~1")
+	git(t, clone, "branch", "-q", "--set-upstream-to=origin/main", "lagging")
+	git(t, clone, "commit", "-q", "--allow-empty", "-m", "local")
+
+	// Both branches are clean, so they are hidden without IncludeClean
+	status := analyzeRepo(clone, analyzeOptions{})
+	if status.Error != "" || len(status.Branches) != 0 {
+		t.Fatalf("Expected no listed branches, got %+v", status)
+	}
+	if got, want := computeTotals([]RepoStatus{status}), (Totals{Repos: 1, Ahead: 1, Behind: 1}); got != want {
+		t.Errorf("computeTotals = %+v, want %+v", got, want)
+	}
+}
+
+func TestSortRepos(t *testing.T) {
+	statuses := []RepoStatus{
+		{Path: "/work/zeta/app"},
+		{Path: "/home/me/beta", Branches: []BranchStatus{{Name: "main", IsDirty: true}}},
+		{Path: "/srv/app"},
+		{Path: "/home/me/alpha"},
+		{Path: "/opt/gamma", Branches: []BranchStatus{{Name: "dev", IsDirty: true}}},
+		{Path: "/opt/broken", Error: "Not a git repository"},
+	}
+
+	tests := []struct {
+		by   string
+		want []string
+	}{
+		{sortPath, []string{"/home/me/alpha", "/home/me/beta", "/opt/broken", "/opt/gamma", "/srv/app", "/work/zeta/app"}},
+		// Repos sharing a name are ordered by path
+		{sortName, []string{"/home/me/alpha", "/srv/app", "/work/zeta/app", "/home/me/beta", "/opt/broken", "/opt/gamma"}},
+		{sortDirtyFirst, []string{"/home/me/beta", "/opt/gamma", "/home/me/alpha", "/opt/broken", "/srv/app", "/work/zeta/app"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.by, func(t *testing.T) {
+			shuffled := append([]RepoStatus(nil), statuses...)
+			for i := range shuffled {
+				j := (i*7 + 3) % len(shuffled)
+				shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+			}
+
+			sortRepos(shuffled, tt.by)
+			var got []string
+			for _, status := range shuffled {
+				got = append(got, status.Path)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sortRepos(%s) = %v, want %v", tt.by, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnalyzeReposParallelBounded(t *testing.T) {
+	var repos []string
+	for i := 0; i < 20; i++ {
+		repos = append(repos, fmt.Sprintf("/repo/%02d", i))
+	}
+
+	const jobs = 3
+	var mu sync.Mutex
+	running, peak := 0, 0
+	analyze := func(path string) RepoStatus {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return RepoStatus{Path: path}
+	}
+
+	statuses := analyzeReposParallel(repos, jobs, analyze)
+
+	if peak > jobs {
+		t.Errorf("peak concurrency = %d, want at most %d", peak, jobs)
+	}
+	if len(statuses) != len(repos) {
+		t.Fatalf("got %d statuses, want %d", len(statuses), len(repos))
+	}
+	sortRepos(statuses, sortPath)
+	for i, status := range statuses {
+		if status.Path != repos[i] {
+			t.Errorf("statuses[%d].Path = %q, want %q", i, status.Path, repos[i])
+		}
+	}
+}
+
+func TestAnalyzeRepoExec(t *testing.T) {
+	_, clone := newClonedRepo(t)
+
+	tests := []struct {
+		name         string
+		command      string
+		timeout      time.Duration
+		wantOutput   string
+		wantExitCode int
+		wantError    string
+	}{
+		{name: "output", command: "echo {}; pwd", wantOutput: clone + "\n" + clone + "\n"},
+		{name: "git", command: "git rev-parse --abbrev-ref HEAD", wantOutput: "main\n"},
+		{name: "exit code", command: "echo partial; echo broken >&2; exit 3", wantOutput: "partial\n", wantExitCode: 3, wantError: "broken"},
+		{name: "timeout", command: "sleep 5", timeout: 100 * time.Millisecond, wantExitCode: -1, wantError: "timed out after 100ms"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := analyzeRepo(clone, analyzeOptions{Exec: tt.command, ExecTimeout: tt.timeout})
+			if status.Exec == nil {
+				t.Fatal("Expected an exec result")
+			}
+			if status.Exec.Output != tt.wantOutput {
+				t.Errorf("Output = %q, want %q", status.Exec.Output, tt.wantOutput)
+			}
+			if status.Exec.ExitCode != tt.wantExitCode {
+				t.Errorf("ExitCode = %d, want %d", status.Exec.ExitCode, tt.wantExitCode)
+			}
+			if status.Exec.Error != tt.wantError {
+				t.Errorf("Error = %q, want %q", status.Exec.Error, tt.wantError)
+			}
+			// The git analysis still runs alongside the command
+			if status.CurrentBranch != "main" {
+				t.Errorf("CurrentBranch = %q, want main", status.CurrentBranch)
+			}
+		})
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "it's a repo")
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	result := runExec(path, "printf '%s' {}", 10*time.Second)
+	if result.Output != path {
+		t.Errorf("Output = %q, want %q", result.Output, path)
+	}
+}
+
+func TestFilterStale(t *testing.T) {
+	// commitAged adds a commit to a new branch, committed days ago
+	commitAged := func(repo, branch string, days int) {
+		date := time.Now().Add(-time.Duration(days) * 24 * time.Hour).Format(time.RFC3339)
+		git(t, repo, "checkout", "-q", "-b", branch, "main")
+		gitEnv(t, repo, []string{"GIT_COMMITTER_DATE=" + date}, "commit", "-q", "--allow-empty", "-m", branch)
+		git(t, repo, "checkout", "-q", "main")
+	}
+
+	old := t.TempDir()
+	git(t, old, "init", "-q", "-b", "main")
+	git(t, old, "commit", "-q", "--allow-empty", "-m", "initial")
+	commitAged(old, "ancient", 400)
+	commitAged(old, "dormant", 120)
+	commitAged(old, "recent", 30)
+	commitAged(old, "edge", 89)
+
+	fresh := t.TempDir()
+	git(t, fresh, "init", "-q", "-b", "main")
+	git(t, fresh, "commit", "-q", "--allow-empty", "-m", "initial")
+	commitAged(fresh, "topic", 2)
+
+	opts := analyzeOptions{IncludeClean: true}
+	statuses := []RepoStatus{analyzeRepo(old, opts), analyzeRepo(fresh, opts), {Path: "/broken", Error: "Not a git repository"}}
+	if ancient := findBranch(t, statuses[0], "ancient"); ancient.AgeDays != 400 || ancient.LastCommit.IsZero() {
+		t.Errorf("ancient should be 400 days old, got %+v", ancient)
+	}
+
+	stale := filterStale(statuses, 90)
+	if len(stale) != 2 || stale[0].Path != old || stale[1].Path != "/broken" {
+		t.Fatalf("Expected the old and broken repositories, got %+v", stale)
+	}
+	var names []string
+	for _, branch := range stale[0].Branches {
+		if !branch.Stale {
+			t.Errorf("%s should be marked stale", branch.Name)
+		}
+		names = append(names, branch.Name)
+	}
+	if want := []string{"ancient", "dormant"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected stale branches %v, got %v", want, names)
+	}
+
+	var buf bytes.Buffer
+	if err := displayJSONOutput(&buf, stale); err != nil {
+		t.Fatal(err)
+	}
+	var report struct {
+		Repos []struct {
+			Branches []map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	branch := report.Repos[0].Branches[1]
+	if branch["name"] != "dormant" || branch["age_days"] != 120.0 || branch["stale"] != true || branch["last_commit"] == nil {
+		t.Errorf("Unexpected JSON for a stale branch: %v", branch)
+	}
+}
+
+func TestGroupByReason(t *testing.T) {
+	statuses := []RepoStatus{
+		{Path: "/clean", Branches: []BranchStatus{{Name: "main", Current: true, Status: "Clean"}}},
+		{Path: "/busy", Branches: []BranchStatus{
+			{Name: "main", Current: true, IsDirty: true, Ahead: 1, Status: "2 modified, 1 untracked"},
+			{Name: "feature", Ahead: 2, Status: "Not checked out"},
+			{Name: "old", Behind: 1, Status: "Not checked out"},
+			{Name: "wip", Unpushed: true, Status: "Not checked out"},
+		}},
+		{Path: "/scratch", Branches: []BranchStatus{
+			{Name: "main", Current: true, IsDirty: true, Ahead: 3, Behind: 1, Status: "4 untracked"},
+			{Name: "origin/topic", Remote: true, Status: "Remote only"},
+		}},
+		{Path: "/broken", Error: "Not a git repository"},
+	}
+
+	groups := groupByReason(statuses)
+	names := func(reason string) []string {
+		var got []string
+		for _, entry := range groups[reason] {
+			got = append(got, entry.Repo.Path+":"+entry.Branch.Name)
+		}
+		return got
+	}
+
+	want := map[string][]string{
+		reasonModified:   {"/busy:main"},
+		reasonUntracked:  {"/scratch:main"},
+		reasonAhead:      {"/busy:main", "/busy:feature"},
+		reasonBehind:     {"/busy:old"},
+		reasonDiverged:   {"/scratch:main"},
+		reasonUnpushed:   {"/busy:wip"},
+		reasonRemoteOnly: {"/scratch:origin/topic"},
+		reasonError:      {"/broken:"},
+	}
+	for _, reason := range reasonOrder {
+		if got := names(reason); !reflect.DeepEqual(got, want[reason]) {
+			t.Errorf("%s: got %v, want %v", reason, got, want[reason])
+		}
+	}
+
+	var buf bytes.Buffer
+	displayByReason(&buf, statuses)
+	out := buf.String()
+	for _, line := range []string{
+		"1 modified, 1 untracked-only, 2 ahead, 1 behind, 1 diverged, 1 unpushed, 1 remote-only, 1 error\n\n",
+		"ahead (2)\n   📁 /busy: main * [↑1] - 2 modified, 1 untracked\n   📁 /busy: feature [↑2] - Not checked out\n\n",
+		"diverged (1)\n   📁 /scratch: main * [↑3 ↓1] - 4 untracked\n",
+		"error (1)\n   📁 /broken - ERROR: Not a git repository\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("output missing %q:\n%s", line, out)
+		}
+	}
+	if strings.Index(out, "modified (1)") > strings.Index(out, "ahead (2)") {
+		t.Errorf("reasons out of order:\n%s", out)
+	}
+
+	buf.Reset()
+	displayByReason(&buf, statuses[:1])
+	if got := buf.String(); got != "✓ Nothing needs attention\n\n" {
+		t.Errorf("clean output = %q", got)
+	}
+}
@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultPrintedBodySize is how much of each body is printed, and so held in
+// memory, when MaxBodySize is 0. Bodies beyond it are printed truncated and
+// stream through like any other.
+const DefaultPrintedBodySize = 1 << 20
+
+// printLimit is the number of bytes of a body that get printed
+func (pp *PrettyPrinter) printLimit() int {
+	if pp.config.MaxBodySize > 0 {
+		return pp.config.MaxBodySize
+	}
+	return DefaultPrintedBodySize
+}
+
+// peekBody reads the part of a body that gets printed: up to printLimit
+// bytes, plus one more to tell whether it was truncated. It returns that
+// part along with a body that replays it before streaming the rest, so
+// large bodies are never held in memory.
+func (pp *PrettyPrinter) peekBody(body io.ReadCloser) ([]byte, io.ReadCloser, error) {
+	peeked, err := io.ReadAll(io.LimitReader(body, int64(pp.printLimit())+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	return peeked, replayBody{io.MultiReader(bytes.NewReader(peeked), body), body}, nil
+}
+
+// peekEncodedBody is peekBody for a body with a Content-Encoding. The part
+// that gets printed is decompressed, while the body returned replays the
+// original bytes. Any error is in decompressing; the body is usable anyway.
+func (pp *PrettyPrinter) peekEncodedBody(body io.ReadCloser, encoding string) ([]byte, io.ReadCloser, error) {
+	// Keep the compressed bytes the decompressor consumes, to replay them
+	var raw bytes.Buffer
+	replay := func() io.ReadCloser {
+		return replayBody{io.MultiReader(bytes.NewReader(raw.Bytes()), body), body}
+	}
+
+	r, err := newDecompressor(encoding, io.TeeReader(body, &raw))
+	if err == io.EOF && raw.Len() == 0 {
+		// An empty body, as for HEAD requests, has nothing to decompress
+		return nil, replay(), nil
+	}
+	if err != nil {
+		return nil, replay(), err
+	}
+	peeked, err := io.ReadAll(io.LimitReader(r, int64(pp.printLimit())+1))
+	return peeked, replay(), err
+}
+
+// newDecompressor decodes a Content-Encoding. Brotli would need a
+// dependency, so br is not supported.
+func newDecompressor(encoding string, r io.Reader) (io.Reader, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		// deflate should be zlib-wrapped, but some servers send it raw
+		br := bufio.NewReader(r)
+		header, err := br.Peek(2)
+		if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	}
+	return nil, fmt.Errorf("%s encoding is not supported", encoding)
+}
+
+// replayBody reads from a peeked body while closing the original
+type replayBody struct {
+	io.Reader
+	io.Closer
+}
+
+// clientBody remembers the first error reading the client's request body
+// while it is streamed upstream, so a broken upload isn't blamed on the
+// upstream
+type clientBody struct {
+	io.ReadCloser
+	err error
+}
+
+func (b *clientBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// cappedBuffer keeps the first max bytes written to it, or all of them when
+// max is 0, and counts the rest
+type cappedBuffer struct {
+	max  int
+	buf  bytes.Buffer
+	size int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	b.size += n
+	if b.max > 0 {
+		p = p[:min(n, max(b.max-b.buf.Len(), 0))]
+	}
+	b.buf.Write(p)
+	return n, nil
+}
+
+// String returns the bytes kept
+func (b *cappedBuffer) String() string {
+	return b.buf.String()
+}
+
+// Size returns the number of bytes written, kept or not
+func (b *cappedBuffer) Size() int {
+	return b.size
+}
+
+// Truncated reports whether any bytes were dropped
+func (b *cappedBuffer) Truncated() bool {
+	return b.size > b.buf.Len()
+}
+
+// saveBody starts saving a body to a new file under SaveBodiesDir, named
+// for the time, whether it is a request or response body, and a sequence
+// number. The file fills as the returned body streams, so it ends up with
+// the whole body however little is printed. It returns a note with the
+// file's path to print in place of the body.
+func (pp *PrettyPrinter) saveBody(kind string, body io.ReadCloser, contentType string) (string, io.ReadCloser) {
+	name := fmt.Sprintf("%s-%s-%d%s", time.Now().Format("20060102-150405.000000"), kind, pp.saved.Add(1), bodyExtension(contentType))
+	path := filepath.Join(pp.config.SaveBodiesDir, name)
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Sprintf("[cannot save body: %v]", err), body
+	}
+	return fmt.Sprintf("[body saved to %s]", path), savedBody{io.TeeReader(body, file), body, file}
+}
+
+// savedBody copies a body to a file as it is read
+type savedBody struct {
+	io.Reader
+	body io.Closer
+	file *os.File
+}
+
+func (b savedBody) Close() error {
+	b.file.Close()
+	return b.body.Close()
+}
+
+// bodyExtensions covers common content types that mime may not know
+var bodyExtensions = map[string]string{
+	"application/json":         ".json",
+	"application/xml":          ".xml",
+	"application/octet-stream": ".bin",
+	"text/plain":               ".txt",
+	"text/html":                ".html",
+	"text/csv":                 ".csv",
+}
+
+// bodyExtension picks a file extension for a content type, falling back to
+// .bin
+func bodyExtension(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ".bin"
+	}
+	if ext, ok := bodyExtensions[mediaType]; ok {
+		return ext
+	}
+	if strings.HasSuffix(mediaType, "+json") {
+		return ".json"
+	}
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sync"
+)
+
+// Breakpoint pauses requests whose path matches a pattern, once before the
+// request is forwarded and once before the response is returned, until the
+// user presses Enter
+type Breakpoint struct {
+	mu      sync.Mutex
+	pattern *regexp.Regexp
+	input   *bufio.Reader
+	output  io.Writer
+}
+
+// NewBreakpoint creates a Breakpoint for the path pattern, reading
+// keypresses from input, normally the controlling TTY
+func NewBreakpoint(pattern string, input io.Reader, output io.Writer) (*Breakpoint, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid break path %q: %w", pattern, err)
+	}
+	return &Breakpoint{
+		pattern: re,
+		input:   bufio.NewReader(input),
+		output:  output,
+	}, nil
+}
+
+// Match reports whether a request should be paused
+func (b *Breakpoint) Match(r *http.Request) bool {
+	return b.pattern.MatchString(r.URL.Path)
+}
+
+// Wait announces the pause and blocks until a line is read from the input.
+// Pauses are taken one at a time so each keypress resumes a single request.
+func (b *Breakpoint) Wait(r *http.Request, stage string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	fmt.Fprintf(b.output, "\n[BREAK] %s %s paused %s; press Enter to continue\n", r.Method, r.URL.Path, stage)
+	if _, err := b.input.ReadString('\n'); err != nil {
+		fmt.Fprintf(b.output, "[BREAK] no more input (%v); continuing\n", err)
+	}
+}
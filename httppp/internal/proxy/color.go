@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Values for Config.Color
+const (
+	ColorAuto   = "auto"
+	ColorAlways = "always"
+	ColorNever  = "never"
+)
+
+// ANSI escape codes used in colored output
+const (
+	ansiReset   = "\x1b[0m"
+	ansiBold    = "\x1b[1m"
+	ansiRed     = "\x1b[31m"
+	ansiGreen   = "\x1b[32m"
+	ansiYellow  = "\x1b[33m"
+	ansiBlue    = "\x1b[34m"
+	ansiMagenta = "\x1b[35m"
+	ansiCyan    = "\x1b[36m"
+)
+
+// ParseColor checks a Config.Color value
+func ParseColor(s string) (string, error) {
+	switch s {
+	case ColorAuto, ColorAlways, ColorNever:
+		return s, nil
+	case "":
+		return ColorAuto, nil
+	}
+	return "", fmt.Errorf("invalid color mode %q (expected auto, always or never)", s)
+}
+
+// isTerminal reports whether w is a character device such as a terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorEnabled reports whether output should be colored. In auto mode it
+// is when the output is a terminal and NO_COLOR is not set.
+func (pp *PrettyPrinter) colorEnabled() bool {
+	switch pp.config.Color {
+	case ColorAlways:
+		return true
+	case ColorNever:
+		return false
+	}
+	return pp.terminal && os.Getenv("NO_COLOR") == ""
+}
+
+// paint wraps s in an ANSI code when color is enabled
+func (pp *PrettyPrinter) paint(code, s string) string {
+	if !pp.colorEnabled() {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+// statusColor picks the color for a status code: green for success, yellow
+// for client errors and red for server errors
+func statusColor(status int) string {
+	switch {
+	case status >= 500:
+		return ansiRed
+	case status >= 400:
+		return ansiYellow
+	case status >= 200 && status < 300:
+		return ansiGreen
+	}
+	return ""
+}
+
+// highlightJSON colors the keys, strings, numbers and literals of valid
+// JSON, such as the output of json.Indent
+func highlightJSON(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '"':
+			end := i + 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			end = min(end+1, len(s))
+			color := ansiGreen
+			if strings.HasPrefix(strings.TrimLeft(s[end:], " \t\r\n"), ":") {
+				color = ansiBlue
+			}
+			b.WriteString(color + s[i:end] + ansiReset)
+			i = end
+		case c == '-' || (c >= '0' && c <= '9'):
+			end := i + 1
+			for end < len(s) && strings.IndexByte("0123456789.eE+-", s[end]) >= 0 {
+				end++
+			}
+			b.WriteString(ansiCyan + s[i:end] + ansiReset)
+			i = end
+		case c == 't' || c == 'f' || c == 'n':
+			end := i + 1
+			for end < len(s) && s[end] >= 'a' && s[end] <= 'z' {
+				end++
+			}
+			b.WriteString(ansiMagenta + s[i:end] + ansiReset)
+			i = end
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
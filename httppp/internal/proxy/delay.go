@@ -0,0 +1,31 @@
+package proxy
+
+import (
+	"context"
+	"time"
+)
+
+// delay returns how long to hold a request before forwarding it: Delay
+// plus a random part of DelayJitter, chosen afresh for each request
+func (h *Handler) delay() time.Duration {
+	d := h.config.Delay
+	if h.config.DelayJitter > 0 {
+		h.randomMu.Lock()
+		d += time.Duration(h.random.Int64N(int64(h.config.DelayJitter) + 1))
+		h.randomMu.Unlock()
+	}
+	return d
+}
+
+// sleep waits for d, returning false early if ctx is done first, as when
+// the client gives up on the request
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
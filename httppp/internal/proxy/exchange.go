@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Exchange is one request/response pair as recorded in the exchange log
+type Exchange struct {
+	ID              string      `json:"id"`
+	Time            time.Time   `json:"time"`
+	Method          string      `json:"method"`
+	Path            string      `json:"path"`
+	Status          int         `json:"status"`
+	DurationMs      float64     `json:"duration_ms"`
+	RequestHeaders  http.Header `json:"request_headers"`
+	ResponseHeaders http.Header `json:"response_headers"`
+	RequestBody     string      `json:"request_body,omitempty"`
+	ResponseBody    string      `json:"response_body,omitempty"`
+	// Truncated is set when either body was cut to MaxBodySize
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// ExchangeLog appends one JSON object per exchange to its output, so a
+// debugging session leaves a record that can be queried with tq or jq
+type ExchangeLog struct {
+	mu     sync.Mutex
+	output io.Writer
+	config *Config
+}
+
+// NewExchangeLog creates a new ExchangeLog
+func NewExchangeLog(output io.Writer, config *Config) *ExchangeLog {
+	return &ExchangeLog{
+		output: output,
+		config: config,
+	}
+}
+
+// Write appends an exchange as a single line
+func (l *ExchangeLog) Write(exchange *Exchange) error {
+	line, err := json.Marshal(exchange)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.output.Write(line)
+	return err
+}
+
+// exchangeRecorder captures the status and body written to the client
+type exchangeRecorder struct {
+	http.ResponseWriter
+	status int
+	body   cappedBuffer
+}
+
+func (r *exchangeRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *exchangeRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// hijack the connection for a WebSocket
+func (r *exchangeRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// newExchangeID returns a random identifier for correlating log records
+func newExchangeID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// serveLogged serves a request and records the exchange in the exchange
+// log and HAR log, whichever are set, once the response has been written
+func (h *Handler) serveLogged(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	// Keep the start of the request body as the proxy streams it, which is
+	// as much as the logs record
+	reqBody := &cappedBuffer{max: h.config.MaxBodySize}
+	if r.Body != nil {
+		r.Body = replayBody{io.TeeReader(r.Body, reqBody), r.Body}
+	}
+	reqHeaders := r.Header.Clone()
+
+	rec := &exchangeRecorder{ResponseWriter: w}
+	rec.body.max = h.config.MaxBodySize
+	h.serve(rec, r)
+	duration := time.Since(start)
+
+	if h.har != nil {
+		_, target := h.target(r.URL.Path)
+		entry := h.har.entry(r, target, reqHeaders, reqBody, rec, start, duration)
+		if err := h.har.Write(entry); err != nil {
+			fmt.Fprintf(h.printer.output, "Error writing HAR log: %v\n", err)
+		}
+	}
+	if h.exchanges == nil {
+		return
+	}
+
+	exchange := &Exchange{
+		ID:              newExchangeID(),
+		Time:            start.UTC(),
+		Method:          r.Method,
+		Path:            r.URL.RequestURI(),
+		Status:          rec.status,
+		DurationMs:      float64(duration.Microseconds()) / 1000,
+		RequestHeaders:  reqHeaders,
+		ResponseHeaders: rec.Header().Clone(),
+	}
+	exchange.RequestBody = reqBody.String()
+	exchange.ResponseBody = rec.body.String()
+	exchange.Truncated = reqBody.Truncated() || rec.body.Truncated()
+
+	if err := h.exchanges.Write(exchange); err != nil {
+		fmt.Fprintf(h.printer.output, "Error writing exchange log: %v\n", err)
+	}
+}
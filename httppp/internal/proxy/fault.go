@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+)
+
+// newRandom returns the source of the proxy's random choices, seeded from
+// FaultSeed when it is set so a run's faults and jitter can be reproduced
+func newRandom(config *Config) *rand.Rand {
+	if config.FaultSeed != nil {
+		return rand.New(rand.NewPCG(*config.FaultSeed, 0))
+	}
+	return rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+}
+
+// injectFault reports whether this request should fail with FaultStatus
+// instead of being forwarded, which happens to a FaultRate fraction of
+// requests
+func (h *Handler) injectFault() bool {
+	if h.config.FaultRate <= 0 {
+		return false
+	}
+	h.randomMu.Lock()
+	defer h.randomMu.Unlock()
+	return h.random.Float64() < h.config.FaultRate
+}
+
+// fault replies with the synthetic error status, 503 unless FaultStatus
+// says otherwise
+func (h *Handler) fault(w http.ResponseWriter) {
+	status := h.config.FaultStatus
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+	h.proxyError(w, status, ErrorFaultInjected, fmt.Errorf("injected fault (rate %g)", h.config.FaultRate))
+}
@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HAREntry is one request/response pair in a HAR 1.2 log
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+// HARRequest is the request half of a HAREntry, as forwarded to the target
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+// HARResponse is the response half of a HAREntry, as returned to the client
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+// HARNameValue is a header, cookie or query parameter
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HARPostData is a request body
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+// HARContent is a response body. Size is the full size even when Text was
+// cut to MaxBodySize.
+type HARContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+// HARTimings breaks down an entry's time. The proxy only measures the
+// whole exchange, so it is all counted as waiting.
+type HARTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// HARLog writes exchanges to its output as a HAR 1.2 document, which
+// browsers' developer tools and many HTTP clients can load and replay.
+// Entries are written as they complete; Close finishes the document.
+type HARLog struct {
+	mu      sync.Mutex
+	output  io.Writer
+	config  *Config
+	entries int
+	closed  bool
+}
+
+// NewHARLog creates a new HARLog
+func NewHARLog(output io.Writer, config *Config) *HARLog {
+	return &HARLog{
+		output: output,
+		config: config,
+	}
+}
+
+// harHeader opens the document up to its entries array
+const harHeader = `{"log":{"version":"1.2","creator":{"name":"httppp","version":"1.0"},"entries":[`
+
+// Write appends an entry to the document
+func (l *HARLog) Write(entry *HAREntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return errors.New("HAR log is closed")
+	}
+	prefix := ","
+	if l.entries == 0 {
+		prefix = harHeader
+	}
+	if _, err := io.WriteString(l.output, prefix+"\n"); err != nil {
+		return err
+	}
+	if _, err := l.output.Write(data); err != nil {
+		return err
+	}
+	l.entries++
+	return nil
+}
+
+// Close finishes the document so it is valid JSON. It does not close the
+// underlying output, and later calls do nothing.
+func (l *HARLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	trailer := "\n]}}\n"
+	if l.entries == 0 {
+		trailer = harHeader + trailer
+	}
+	_, err := io.WriteString(l.output, trailer)
+	return err
+}
+
+// entry builds a HAR entry for an exchange
+func (l *HARLog) entry(r *http.Request, target string, reqHeaders http.Header, reqBody *cappedBuffer, rec *exchangeRecorder, start time.Time, duration time.Duration) *HAREntry {
+	ms := float64(duration.Microseconds()) / 1000
+	targetURL := target + r.URL.RequestURI()
+	entry := &HAREntry{
+		StartedDateTime: start.UTC(),
+		Time:            ms,
+		Request: HARRequest{
+			Method:      r.Method,
+			URL:         targetURL,
+			HTTPVersion: r.Proto,
+			Cookies:     harCookies(r.Cookies()),
+			Headers:     harPairs(reqHeaders),
+			QueryString: harPairs(r.URL.Query()),
+			HeadersSize: -1,
+			BodySize:    reqBody.Size(),
+		},
+		Response: HARResponse{
+			Status:      rec.status,
+			StatusText:  http.StatusText(rec.status),
+			HTTPVersion: r.Proto,
+			Cookies:     harCookies((&http.Response{Header: rec.Header()}).Cookies()),
+			Headers:     harPairs(rec.Header()),
+			Content: HARContent{
+				Size:     rec.body.Size(),
+				MimeType: rec.Header().Get("Content-Type"),
+			},
+			RedirectURL: rec.Header().Get("Location"),
+			HeadersSize: -1,
+			BodySize:    rec.body.Size(),
+		},
+		Timings: HARTimings{Wait: ms},
+	}
+
+	if reqBody.Size() > 0 {
+		entry.Request.PostData = &HARPostData{
+			MimeType: reqHeaders.Get("Content-Type"),
+			Text:     reqBody.String(),
+		}
+	}
+	entry.Response.Content.Text = rec.body.String()
+	if reqBody.Truncated() || rec.body.Truncated() {
+		entry.Comment = fmt.Sprintf("bodies truncated to %d bytes", l.config.MaxBodySize)
+	}
+	return entry
+}
+
+// harPairs flattens headers or query parameters into name/value pairs,
+// sorted by name
+func harPairs(m map[string][]string) []HARNameValue {
+	pairs := []HARNameValue{}
+	for name, values := range m {
+		for _, value := range values {
+			pairs = append(pairs, HARNameValue{Name: name, Value: value})
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].Name < pairs[j].Name })
+	return pairs
+}
+
+// harCookies converts cookies into name/value pairs
+func harCookies(cookies []*http.Cookie) []HARNameValue {
+	pairs := []HARNameValue{}
+	for _, cookie := range cookies {
+		pairs = append(pairs, HARNameValue{Name: cookie.Name, Value: cookie.Value})
+	}
+	return pairs
+}
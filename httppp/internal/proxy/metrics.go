@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metrics tallies how the upstream behaves: the latency of every response,
+// from sending the request to receiving the response headers, its status
+// class, and the requests that got no response at all
+type metrics struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	classes   [6]int
+	failed    int
+}
+
+// record adds a response from the upstream
+func (m *metrics) record(latency time.Duration, status int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latencies = append(m.latencies, latency)
+	if class := status / 100; class > 0 && class < len(m.classes) {
+		m.classes[class]++
+	}
+}
+
+// recordFailure adds a request the upstream never answered
+func (m *metrics) recordFailure() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failed++
+}
+
+// Metrics is a summary of the upstream's behavior so far
+type Metrics struct {
+	// Requests counts every request sent upstream, Failed those that got
+	// no response
+	Requests int
+	Failed   int
+	// P50, P95 and P99 are latency percentiles over the responses
+	P50, P95, P99 time.Duration
+	// Classes counts responses by status class, so Classes[2] is the
+	// number of 2xx responses
+	Classes [6]int
+}
+
+// Metrics summarizes the requests the handler has sent upstream
+func (h *Handler) Metrics() Metrics {
+	h.metrics.mu.Lock()
+	latencies := slices.Clone(h.metrics.latencies)
+	summary := Metrics{
+		Requests: len(latencies) + h.metrics.failed,
+		Failed:   h.metrics.failed,
+		Classes:  h.metrics.classes,
+	}
+	h.metrics.mu.Unlock()
+
+	slices.Sort(latencies)
+	summary.P50 = percentile(latencies, 50)
+	summary.P95 = percentile(latencies, 95)
+	summary.P99 = percentile(latencies, 99)
+	return summary
+}
+
+// percentile returns the nearest-rank percentile of sorted samples
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// String formats the summary as printed at shutdown
+func (m Metrics) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s SUMMARY %s\n", strings.Repeat("=", 40), strings.Repeat("=", 40))
+	fmt.Fprintf(&b, "Requests: %d", m.Requests)
+	if m.Failed > 0 {
+		fmt.Fprintf(&b, " (%d without a response)", m.Failed)
+	}
+	b.WriteString("\n")
+	if m.Requests > m.Failed {
+		fmt.Fprintf(&b, "Latency: p50 %s, p95 %s, p99 %s\n", roundLatency(m.P50), roundLatency(m.P95), roundLatency(m.P99))
+	}
+	var classes []string
+	for class := 1; class < len(m.Classes); class++ {
+		// 1xx only comes up for upgraded connections
+		if class >= 2 || m.Classes[class] > 0 {
+			classes = append(classes, fmt.Sprintf("%dxx %d", class, m.Classes[class]))
+		}
+	}
+	fmt.Fprintf(&b, "Status: %s\n", strings.Join(classes, ", "))
+	b.WriteString(strings.Repeat("=", 88) + "\n")
+	return b.String()
+}
+
+// roundLatency rounds a latency for display
+func roundLatency(d time.Duration) time.Duration {
+	if d < time.Millisecond {
+		return d.Round(time.Microsecond)
+	}
+	return d.Round(100 * time.Microsecond)
+}
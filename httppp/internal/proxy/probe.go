@@ -0,0 +1,25 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// ProbeUpstream sends a single HEAD request to the target URL through the
+// same transport used for proxied requests and returns the upstream's status.
+// Any HTTP response, even an error status, counts as reachable; only a failure
+// to get a response at all is returned as an error.
+func (h *Handler) ProbeUpstream(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, h.config.TargetURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid target URL: %w", err)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Status, nil
+}
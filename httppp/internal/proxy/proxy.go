@@ -4,10 +4,29 @@ import (
 	"bytes"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrorHeader marks responses generated by the proxy itself rather than the upstream
+const ErrorHeader = "X-Httppp-Error"
+
+// Values for ErrorHeader describing why the proxy produced the response
+const (
+	ErrorUpstreamUnreachable = "upstream-unreachable"
+	ErrorUpstreamTimeout     = "upstream-timeout"
+	ErrorBadRequest          = "bad-request"
+	ErrorNoRoute             = "no-route"
+	ErrorFaultInjected       = "fault-injected"
+	ErrorInternal            = "internal"
 )
 
 // Config holds all configuration for the proxy
@@ -19,113 +38,247 @@ type Config struct {
 	OnlyBody      bool   `env:"ONLY_BODY" envDefault:"false"`
 	OnlyJSON      bool   `env:"ONLY_JSON" envDefault:"false"`
 	SkipTLSVerify bool   `env:"SKIP_TLS_VERIFY" envDefault:"false"`
+	PreserveHost  bool   `env:"PRESERVE_HOST" envDefault:"false"`
+	Color         string `env:"COLOR" envDefault:"auto"`
+	UpstreamProxy string `env:"UPSTREAM_PROXY"`
+	LogFile       string `env:"LOG_FILE"`
+	HARFile       string `env:"HAR_FILE"`
+	SaveBodiesDir string `env:"SAVE_BODIES_DIR"`
+	BreakPath     string `env:"BREAK_PATH"`
+
+	// Routes sends requests under a path prefix to their own target, e.g.
+	// ROUTES=/api=http://api:9000,/auth=http://auth:9001. The longest
+	// matching prefix wins, and TargetURL takes everything else.
+	Routes map[string]string `env:"ROUTES" envKeyValSeparator:"="`
+
+	// FilterHeaders are printed as [REDACTED], matched case-insensitively.
+	// They are still forwarded, and recorded as-is in the exchange and HAR
+	// logs.
+	FilterHeaders []string `env:"FILTER_HEADERS" envDefault:"Authorization,Cookie,Set-Cookie,Proxy-Authorization"`
+
+	// ProbeUpstream checks at startup whether TargetURL is reachable, and
+	// RequireUpstream additionally refuses to start when it is not
+	ProbeUpstream   bool          `env:"PROBE_UPSTREAM" envDefault:"false"`
+	RequireUpstream bool          `env:"REQUIRE_UPSTREAM" envDefault:"false"`
+	ProbeTimeout    time.Duration `env:"PROBE_TIMEOUT" envDefault:"5s"`
+
+	// Delay holds every request this long before forwarding it, plus a
+	// random extra of up to DelayJitter, to test client timeouts and
+	// retries against a slow upstream
+	Delay       time.Duration `env:"DELAY" envDefault:"0"`
+	DelayJitter time.Duration `env:"DELAY_JITTER" envDefault:"0"`
+
+	// FaultRate is the fraction of requests, from 0 to 1, answered with
+	// FaultStatus instead of being forwarded, to test client resilience.
+	// FaultSeed makes the choice of requests, and the delay jitter,
+	// repeatable from run to run.
+	FaultRate   float64 `env:"FAULT_RATE" envDefault:"0"`
+	FaultStatus int     `env:"FAULT_STATUS" envDefault:"503"`
+	FaultSeed   *uint64 `env:"FAULT_SEED"`
 }
 
 // PrettyPrinter handles pretty printing of HTTP requests and responses
 type PrettyPrinter struct {
-	output io.Writer
-	config *Config
+	output   io.Writer
+	config   *Config
+	terminal bool
+	// saved numbers the files written to SaveBodiesDir
+	saved atomic.Int64
 }
 
 // NewPrettyPrinter creates a new PrettyPrinter
 func NewPrettyPrinter(output io.Writer, config *Config) *PrettyPrinter {
 	return &PrettyPrinter{
-		output: output,
-		config: config,
+		output:   output,
+		config:   config,
+		terminal: isTerminal(output),
 	}
 }
 
-// PrintRequest pretty prints an HTTP request
+// PrintRequest pretty prints an HTTP request. Only the part of the body
+// that is printed is read; the rest is left to stream from req.Body.
 func (pp *PrettyPrinter) PrintRequest(req *http.Request) error {
+	return pp.printRequest(req, "", "")
+}
+
+// printRequest pretty prints an HTTP request, noting the route it matched
+// unless route is empty
+func (pp *PrettyPrinter) printRequest(req *http.Request, route, targetURL string) error {
 	if !pp.config.OnlyBody && !pp.config.OnlyJSON {
-		fmt.Fprintf(pp.output, "\n%s REQUEST %s\n", strings.Repeat("=", 40), strings.Repeat("=", 40))
-		fmt.Fprintf(pp.output, "%s %s %s\n", req.Method, req.URL.String(), req.Proto)
-		fmt.Fprintf(pp.output, "Host: %s\n", req.Host)
+		fmt.Fprintf(pp.output, "\n%s\n", pp.paint(ansiBold, strings.Repeat("=", 40)+" REQUEST "+strings.Repeat("=", 40)))
+		fmt.Fprintf(pp.output, "%s %s %s\n", pp.paint(ansiBold, req.Method), req.URL.String(), req.Proto)
+		fmt.Fprintf(pp.output, "%s: %s\n", pp.paint(ansiCyan, "Host"), req.Host)
+		if route != "" {
+			fmt.Fprintf(pp.output, "%s: %s -> %s\n", pp.paint(ansiCyan, "Route"), route, targetURL)
+		}
 
 		for key, values := range req.Header {
 			for _, value := range values {
-				fmt.Fprintf(pp.output, "%s: %s\n", key, value)
+				fmt.Fprintf(pp.output, "%s: %s\n", pp.paint(ansiCyan, key), pp.headerValue(key, value))
 			}
 		}
 	}
 
 	if req.Body != nil {
-		bodyBytes, err := io.ReadAll(req.Body)
+		bodyBytes, body, err := pp.peekBody(req.Body)
 		if err != nil {
 			return err
 		}
-		req.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+		req.Body = body
+		contentType := req.Header.Get("Content-Type")
 
-		if len(bodyBytes) > 0 && !pp.config.OnlyHeaders {
-			contentType := req.Header.Get("Content-Type")
+		var savedNote string
+		if pp.config.SaveBodiesDir != "" && len(bodyBytes) > 0 {
+			savedNote, req.Body = pp.saveBody("req", req.Body, contentType)
+		}
 
+		if len(bodyBytes) > 0 && !pp.config.OnlyHeaders {
 			// Skip if OnlyJSON is set and content is not JSON
 			if pp.config.OnlyJSON && !strings.Contains(contentType, "application/json") {
 				return nil
 			}
 
+			formatted := pp.formatBody(bodyBytes, contentType)
+			if savedNote != "" {
+				formatted = savedNote
+			}
 			if pp.config.OnlyBody || pp.config.OnlyJSON {
-				fmt.Fprintf(pp.output, "%s\n", pp.formatBody(bodyBytes, contentType))
+				fmt.Fprintf(pp.output, "%s\n", formatted)
 			} else {
-				fmt.Fprintf(pp.output, "\n%s\n", pp.formatBody(bodyBytes, contentType))
+				fmt.Fprintf(pp.output, "\n%s\n", formatted)
 			}
 		}
 	}
 
 	if !pp.config.OnlyBody && !pp.config.OnlyJSON {
-		fmt.Fprintf(pp.output, "%s\n", strings.Repeat("=", 88))
+		fmt.Fprintf(pp.output, "%s\n", pp.paint(ansiBold, strings.Repeat("=", 88)))
 	}
 	return nil
 }
 
-// PrintResponse pretty prints an HTTP response
+// PrintResponse pretty prints an HTTP response. Only the part of the body
+// that is printed is read; the rest is left to stream from resp.Body.
 func (pp *PrettyPrinter) PrintResponse(resp *http.Response) error {
-	if !pp.config.OnlyBody && !pp.config.OnlyJSON {
-		fmt.Fprintf(pp.output, "\n%s RESPONSE %s\n", strings.Repeat("=", 39), strings.Repeat("=", 39))
-		fmt.Fprintf(pp.output, "%s %s\n", resp.Proto, resp.Status)
-
-		for key, values := range resp.Header {
-			for _, value := range values {
-				fmt.Fprintf(pp.output, "%s: %s\n", key, value)
-			}
-		}
-	}
+	pp.printResponseHead(resp)
 
 	if resp.Body != nil {
-		bodyBytes, err := io.ReadAll(resp.Body)
+		// Compressed bodies are shown decompressed, but the client still
+		// gets the original bytes
+		encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+		var bodyBytes []byte
+		var body io.ReadCloser
+		var decodeErr error
+		var err error
+		if encoding == "" || encoding == "identity" {
+			bodyBytes, body, err = pp.peekBody(resp.Body)
+		} else {
+			bodyBytes, body, decodeErr = pp.peekEncodedBody(resp.Body, encoding)
+		}
 		if err != nil {
 			return err
 		}
-		resp.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+		resp.Body = body
+		contentType := resp.Header.Get("Content-Type")
 
-		if len(bodyBytes) > 0 && !pp.config.OnlyHeaders {
-			contentType := resp.Header.Get("Content-Type")
+		// The saved file holds the body as sent, compressed or not
+		var savedNote string
+		if pp.config.SaveBodiesDir != "" && (len(bodyBytes) > 0 || decodeErr != nil) {
+			savedNote, resp.Body = pp.saveBody("resp", resp.Body, contentType)
+		}
 
+		if (len(bodyBytes) > 0 || decodeErr != nil) && !pp.config.OnlyHeaders {
 			// Skip if OnlyJSON is set and content is not JSON
 			if pp.config.OnlyJSON && !strings.Contains(contentType, "application/json") {
 				return nil
 			}
 
+			formatted := pp.formatBody(bodyBytes, contentType)
+			switch {
+			case savedNote != "":
+				formatted = savedNote
+			case decodeErr != nil:
+				formatted = fmt.Sprintf("[cannot decompress %s body: %v]", encoding, decodeErr)
+			case encoding != "" && encoding != "identity":
+				formatted += fmt.Sprintf("\n... [decompressed from %s]", encoding)
+			}
 			if pp.config.OnlyBody || pp.config.OnlyJSON {
-				fmt.Fprintf(pp.output, "%s\n", pp.formatBody(bodyBytes, contentType))
+				fmt.Fprintf(pp.output, "%s\n", formatted)
 			} else {
-				fmt.Fprintf(pp.output, "\n%s\n", pp.formatBody(bodyBytes, contentType))
+				fmt.Fprintf(pp.output, "\n%s\n", formatted)
 			}
 		}
 	}
 
 	if !pp.config.OnlyBody && !pp.config.OnlyJSON {
-		fmt.Fprintf(pp.output, "%s\n\n", strings.Repeat("=", 88))
+		fmt.Fprintf(pp.output, "%s\n\n", pp.paint(ansiBold, strings.Repeat("=", 88)))
 	}
 	return nil
 }
 
+// headerValue returns a header's value for printing, redacted if the header
+// is one of FilterHeaders
+func (pp *PrettyPrinter) headerValue(key, value string) string {
+	for _, name := range pp.config.FilterHeaders {
+		if strings.EqualFold(strings.TrimSpace(name), key) {
+			return "[REDACTED]"
+		}
+	}
+	return value
+}
+
+// PrintUpgrade prints the response that switched the connection to another
+// protocol, such as WebSocket. What is relayed afterwards is not printed.
+func (pp *PrettyPrinter) PrintUpgrade(resp *http.Response) {
+	pp.printResponseHead(resp)
+	if !pp.config.OnlyBody && !pp.config.OnlyJSON {
+		fmt.Fprintf(pp.output, "%s\n", pp.paint(ansiBold, strings.Repeat("=", 88)))
+	}
+	fmt.Fprintf(pp.output, "\n%s connection established, relaying until closed\n\n", pp.paint(ansiBold, "[UPGRADED] "+resp.Header.Get("Upgrade")))
+}
+
+// PrintUpgradeClosed prints the end of a relayed connection
+func (pp *PrettyPrinter) PrintUpgradeClosed(protocol string, duration time.Duration, up, down int64) {
+	fmt.Fprintf(pp.output, "\n%s connection closed after %s (%d bytes to upstream, %d bytes to client)\n\n",
+		pp.paint(ansiBold, "[UPGRADED] "+protocol), duration.Round(time.Millisecond), up, down)
+}
+
+// printResponseHead prints a response's banner, status and headers
+func (pp *PrettyPrinter) printResponseHead(resp *http.Response) {
+	if pp.config.OnlyBody || pp.config.OnlyJSON {
+		return
+	}
+	fmt.Fprintf(pp.output, "\n%s\n", pp.paint(ansiBold, strings.Repeat("=", 39)+" RESPONSE "+strings.Repeat("=", 39)))
+	fmt.Fprintf(pp.output, "%s %s\n", resp.Proto, pp.paint(statusColor(resp.StatusCode), resp.Status))
+
+	for key, values := range resp.Header {
+		for _, value := range values {
+			fmt.Fprintf(pp.output, "%s: %s\n", pp.paint(ansiCyan, key), pp.headerValue(key, value))
+		}
+	}
+}
+
+// PrintDelay notes the latency added to a request before it is forwarded
+func (pp *PrettyPrinter) PrintDelay(d time.Duration) {
+	if pp.config.OnlyBody || pp.config.OnlyJSON {
+		return
+	}
+	fmt.Fprintf(pp.output, "%s holding request for %s before forwarding\n", pp.paint(ansiYellow, "[DELAY]"), d.Round(time.Millisecond))
+}
+
+// PrintProxyError prints an error response generated by the proxy itself,
+// labeled so it can't be mistaken for a response from the upstream
+func (pp *PrettyPrinter) PrintProxyError(status int, kind string, err error) {
+	label := fmt.Sprintf("[PROXY ERROR] %d %s", status, http.StatusText(status))
+	fmt.Fprintf(pp.output, "\n%s (%s): %v\n\n", pp.paint(ansiBold+ansiRed, label), kind, err)
+}
+
 // formatBody attempts to pretty print the body based on content type
 func (pp *PrettyPrinter) formatBody(body []byte, contentType string) string {
-	// Truncate if maxBodySize is set and body exceeds it
+	// Truncate if the body exceeds what gets printed
+	limit := pp.printLimit()
 	truncated := false
-	if pp.config.MaxBodySize > 0 && len(body) > pp.config.MaxBodySize {
-		body = body[:pp.config.MaxBodySize]
+	if len(body) > limit {
+		body = body[:limit]
 		truncated = true
 	}
 
@@ -134,6 +287,9 @@ func (pp *PrettyPrinter) formatBody(body []byte, contentType string) string {
 		var prettyJSON bytes.Buffer
 		if err := json.Indent(&prettyJSON, body, "", "  "); err == nil {
 			result = prettyJSON.String()
+			if pp.colorEnabled() {
+				result = highlightJSON(result)
+			}
 		} else {
 			result = string(body)
 		}
@@ -142,89 +298,180 @@ func (pp *PrettyPrinter) formatBody(body []byte, contentType string) string {
 	}
 
 	if truncated {
-		result += fmt.Sprintf("\n... [truncated, showing first %d bytes]", pp.config.MaxBodySize)
+		result += fmt.Sprintf("\n... [truncated, showing first %d bytes]", limit)
 	}
 	return result
 }
 
 // Handler creates an HTTP handler that proxies requests and pretty prints them
 type Handler struct {
-	printer *PrettyPrinter
-	client  *http.Client
-	config  *Config
+	printer    *PrettyPrinter
+	client     *http.Client
+	config     *Config
+	exchanges  *ExchangeLog
+	har        *HARLog
+	breakpoint *Breakpoint
+	metrics    metrics
+
+	// random makes the delay jitter and fault choices
+	randomMu sync.Mutex
+	random   *rand.Rand
 }
 
 // NewHandler creates a new proxy handler
 func NewHandler(printer *PrettyPrinter, config *Config) *Handler {
-	client := &http.Client{}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = upstreamProxy(config)
 	if config.SkipTLSVerify {
-		client.Transport = &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	}
+	client := &http.Client{Transport: transport}
 	return &Handler{
 		printer: printer,
 		client:  client,
 		config:  config,
+		random:  newRandom(config),
 	}
 }
 
+// LogExchanges records every completed exchange to log in addition to the
+// console output
+func (h *Handler) LogExchanges(log *ExchangeLog) {
+	h.exchanges = log
+}
+
+// RecordHAR records every completed exchange to har in addition to the
+// console output
+func (h *Handler) RecordHAR(har *HARLog) {
+	h.har = har
+}
+
+// Break pauses requests matching the breakpoint before they are forwarded
+// and before their response is returned
+func (h *Handler) Break(breakpoint *Breakpoint) {
+	h.breakpoint = breakpoint
+}
+
 // ServeHTTP handles the proxy request
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Print the incoming request
-	if err := h.printer.PrintRequest(r); err != nil {
-		http.Error(w, fmt.Sprintf("Error printing request: %v", err), http.StatusInternalServerError)
+	if h.exchanges != nil || h.har != nil {
+		h.serveLogged(w, r)
 		return
 	}
+	h.serve(w, r)
+}
 
-	// Read the body if present
-	var bodyBytes []byte
+// serve proxies a request to the target and pretty prints both sides
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request) {
+	// Print the incoming request along with where it is going
+	route, target := h.target(r.URL.Path)
+	if err := h.printer.printRequest(r, route, target); err != nil {
+		h.proxyError(w, http.StatusInternalServerError, ErrorInternal, fmt.Errorf("printing request: %w", err))
+		return
+	}
+	// Close the body even if it is never forwarded, which also finishes
+	// any file it is being saved to
 	if r.Body != nil {
-		var err error
-		bodyBytes, err = io.ReadAll(r.Body)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Error reading request body: %v", err), http.StatusInternalServerError)
-			return
-		}
+		defer r.Body.Close()
+	}
+
+	// Give the user a chance to inspect the upstream before it sees the request
+	paused := h.breakpoint != nil && h.breakpoint.Match(r)
+	if paused {
+		h.breakpoint.Wait(r, "before forwarding")
+	}
+
+	// Stream the body upstream rather than reading it into memory
+	var body *clientBody
+	if r.Body != nil && r.ContentLength != 0 {
+		body = &clientBody{ReadCloser: r.Body}
+	}
+
+	if target == "" {
+		h.proxyError(w, http.StatusBadGateway, ErrorNoRoute, fmt.Errorf("no route for %s", r.URL.Path))
+		return
 	}
 
 	// Build the full target URL with the incoming request path and query
-	targetURL := h.config.TargetURL + r.URL.Path
+	targetURL := target + r.URL.Path
 	if r.URL.RawQuery != "" {
 		targetURL += "?" + r.URL.RawQuery
 	}
 
 	// Create the proxied request
-	proxyReq, err := http.NewRequest(r.Method, targetURL, bytes.NewBuffer(bodyBytes))
+	proxyReq, err := http.NewRequest(r.Method, targetURL, nil)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error creating proxy request: %v", err), http.StatusBadGateway)
+		h.proxyError(w, http.StatusBadGateway, ErrorUpstreamUnreachable, fmt.Errorf("creating proxy request: %w", err))
 		return
 	}
+	if body != nil {
+		proxyReq.Body = body
+		proxyReq.ContentLength = r.ContentLength
+	}
 
-	// Copy headers (excluding Host and connection-related headers)
+	// Copy headers (excluding Host, which is sent as the target's unless
+	// PreserveHost is set)
 	for key, values := range r.Header {
-		if key == "Host" || strings.HasPrefix(key, "X-Forwarded") {
+		if key == "Host" {
 			continue
 		}
 		for _, value := range values {
 			proxyReq.Header.Add(key, value)
 		}
 	}
+	if h.config.PreserveHost {
+		proxyReq.Host = r.Host
+	}
+	setForwardedHeaders(proxyReq, r)
+
+	// Add any configured latency; a client that gives up meanwhile has no
+	// one left to answer
+	if d := h.delay(); d > 0 {
+		h.printer.PrintDelay(d)
+		if !sleep(r.Context(), d) {
+			return
+		}
+	}
+	if h.injectFault() {
+		h.fault(w)
+		return
+	}
 
 	// Execute the request
+	sent := time.Now()
 	resp, err := h.client.Do(proxyReq)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error executing proxy request: %v", err), http.StatusBadGateway)
+		h.metrics.recordFailure()
+		var netErr net.Error
+		if body != nil && body.err != nil {
+			h.proxyError(w, http.StatusBadRequest, ErrorBadRequest, fmt.Errorf("reading request body: %w", body.err))
+		} else if errors.As(err, &netErr) && netErr.Timeout() {
+			h.proxyError(w, http.StatusGatewayTimeout, ErrorUpstreamTimeout, fmt.Errorf("executing proxy request: %w", err))
+		} else {
+			h.proxyError(w, http.StatusBadGateway, ErrorUpstreamUnreachable, fmt.Errorf("executing proxy request: %w", err))
+		}
 		return
 	}
+	h.metrics.record(time.Since(sent), resp.StatusCode)
 	defer resp.Body.Close()
 
+	// A WebSocket handshake, or any other protocol switch, turns the
+	// exchange into a connection to relay
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		h.relayUpgrade(w, resp)
+		return
+	}
+
 	// Print the response
 	if err := h.printer.PrintResponse(resp); err != nil {
-		http.Error(w, fmt.Sprintf("Error printing response: %v", err), http.StatusInternalServerError)
+		h.proxyError(w, http.StatusInternalServerError, ErrorInternal, fmt.Errorf("printing response: %w", err))
 		return
 	}
 
+	if paused {
+		h.breakpoint.Wait(r, "before responding")
+	}
+
 	// Copy response headers
 	for key, values := range resp.Header {
 		for _, value := range values {
@@ -240,3 +487,30 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(h.printer.output, "Error copying response body: %v\n", err)
 	}
 }
+
+// setForwardedHeaders tells the upstream about the original request the way
+// reverse proxies usually do: the client's IP is appended to any
+// X-Forwarded-For chain it sent, and X-Forwarded-Host and X-Forwarded-Proto
+// are set to the Host and scheme the client used
+func setForwardedHeaders(out, in *http.Request) {
+	if clientIP, _, err := net.SplitHostPort(in.RemoteAddr); err == nil {
+		if prior := out.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+			clientIP = strings.Join(prior, ", ") + ", " + clientIP
+		}
+		out.Header.Set("X-Forwarded-For", clientIP)
+	}
+	out.Header.Set("X-Forwarded-Host", in.Host)
+	if in.TLS != nil {
+		out.Header.Set("X-Forwarded-Proto", "https")
+	} else {
+		out.Header.Set("X-Forwarded-Proto", "http")
+	}
+}
+
+// proxyError replies with an error generated by the proxy, tagging the
+// response with ErrorHeader and printing it distinctly from upstream responses
+func (h *Handler) proxyError(w http.ResponseWriter, status int, kind string, err error) {
+	h.printer.PrintProxyError(status, kind, err)
+	w.Header().Set(ErrorHeader, kind)
+	http.Error(w, fmt.Sprintf("httppp: %v", err), status)
+}
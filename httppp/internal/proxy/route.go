@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ParseRoute parses a route given as prefix=target, such as
+// /api=http://api:9000
+func ParseRoute(s string) (string, string, error) {
+	prefix, target, ok := strings.Cut(s, "=")
+	if !ok {
+		return "", "", fmt.Errorf("invalid route %q: expected prefix=target", s)
+	}
+	if err := validateRoute(prefix, target); err != nil {
+		return "", "", err
+	}
+	return prefix, target, nil
+}
+
+// ValidateRoutes checks that every route maps a path prefix to an absolute
+// http or https URL
+func ValidateRoutes(routes map[string]string) error {
+	for prefix, target := range routes {
+		if err := validateRoute(prefix, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateRoute(prefix, target string) error {
+	if !strings.HasPrefix(prefix, "/") {
+		return fmt.Errorf("invalid route %q: prefix must start with /", prefix)
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("invalid route target for %s: %w", prefix, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid route target for %s: %q is not an http or https URL", prefix, target)
+	}
+	return nil
+}
+
+// matchRoute returns the longest route prefix that path falls under. A
+// prefix matches whole path segments, so /api matches /api and /api/users
+// but not /apis.
+func matchRoute(routes map[string]string, path string) (string, bool) {
+	best, found := "", false
+	for prefix := range routes {
+		trimmed := strings.TrimSuffix(prefix, "/")
+		if path != trimmed && !strings.HasPrefix(path, trimmed+"/") {
+			continue
+		}
+		if !found || len(prefix) > len(best) {
+			best, found = prefix, true
+		}
+	}
+	return best, found
+}
+
+// target returns the upstream for a request path: the target of the
+// longest matching route, or TargetURL when no route matches. route is
+// empty for the fallback.
+func (h *Handler) target(path string) (route, targetURL string) {
+	if prefix, ok := matchRoute(h.config.Routes, path); ok {
+		return prefix, strings.TrimSuffix(h.config.Routes[prefix], "/")
+	}
+	return "", h.config.TargetURL
+}
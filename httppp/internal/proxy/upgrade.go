@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// relayUpgrade completes a protocol switch, such as a WebSocket handshake,
+// by hijacking the client's connection and copying bytes both ways between
+// it and the upstream until either side closes
+func (h *Handler) relayUpgrade(w http.ResponseWriter, resp *http.Response) {
+	// The transport hands back the upstream connection as the body
+	backend, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		h.proxyError(w, http.StatusBadGateway, ErrorInternal, fmt.Errorf("upstream switched protocols without a writable connection"))
+		return
+	}
+
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	if rec, ok := w.(*exchangeRecorder); ok {
+		rec.status = resp.StatusCode
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		h.proxyError(w, http.StatusInternalServerError, ErrorInternal, fmt.Errorf("hijacking connection: %w", err))
+		return
+	}
+	defer conn.Close()
+
+	h.printer.PrintUpgrade(resp)
+
+	// Finish the handshake ourselves now that the server no longer owns
+	// the connection
+	fmt.Fprintf(brw, "HTTP/1.1 %s\r\n", resp.Status)
+	w.Header().Write(brw)
+	brw.WriteString("\r\n")
+	if err := brw.Flush(); err != nil {
+		fmt.Fprintf(h.printer.output, "Error completing upgrade: %v\n", err)
+		return
+	}
+
+	start := time.Now()
+	var up, down int64
+	done := make(chan struct{}, 2)
+	go func() {
+		// Read through brw, which may already hold bytes from the client
+		up, _ = io.Copy(backend, brw)
+		done <- struct{}{}
+	}()
+	go func() {
+		down, _ = io.Copy(conn, backend)
+		done <- struct{}{}
+	}()
+
+	// Once either side is finished, closing both ends stops the other copy
+	<-done
+	conn.Close()
+	backend.Close()
+	<-done
+
+	h.printer.PrintUpgradeClosed(resp.Header.Get("Upgrade"), time.Since(start), up, down)
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ParseProxyURL parses an upstream proxy address. Supported schemes are http,
+// https, socks5 and socks5h; an address without a scheme is treated as http.
+func ParseProxyURL(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream proxy: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported upstream proxy scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.New("invalid upstream proxy: missing host")
+	}
+	return u, nil
+}
+
+// upstreamProxy returns the Proxy function for the outgoing transport. An
+// explicit UpstreamProxy wins; otherwise HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+// are honored as usual, with ALL_PROXY as a fallback for schemes that have
+// no proxy of their own.
+func upstreamProxy(config *Config) func(*http.Request) (*url.URL, error) {
+	if config.UpstreamProxy != "" {
+		u, err := ParseProxyURL(config.UpstreamProxy)
+		if err != nil {
+			return func(*http.Request) (*url.URL, error) { return nil, err }
+		}
+		return http.ProxyURL(u)
+	}
+
+	allProxy := getenvAny("ALL_PROXY", "all_proxy")
+	if allProxy == "" {
+		return http.ProxyFromEnvironment
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		if u, err := http.ProxyFromEnvironment(req); u != nil || err != nil {
+			return u, err
+		}
+		// A scheme-specific proxy that returned nil was bypassed on purpose
+		if schemeProxy(req.URL.Scheme) != "" || bypassProxy(req.URL.Hostname()) {
+			return nil, nil
+		}
+		return ParseProxyURL(allProxy)
+	}
+}
+
+// schemeProxy returns the proxy configured for a URL scheme in the environment
+func schemeProxy(scheme string) string {
+	if scheme == "https" {
+		return getenvAny("HTTPS_PROXY", "https_proxy")
+	}
+	return getenvAny("HTTP_PROXY", "http_proxy")
+}
+
+// bypassProxy reports whether a host is exempt from proxying, either because
+// it is a loopback address or because it matches an entry in NO_PROXY
+func bypassProxy(host string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	if host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return true
+	}
+
+	for _, entry := range strings.Split(getenvAny("NO_PROXY", "no_proxy"), ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		entry = strings.TrimPrefix(strings.TrimPrefix(entry, "*"), ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// getenvAny returns the first non-empty environment variable among names
+func getenvAny(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
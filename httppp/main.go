@@ -1,11 +1,19 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/caarlos0/env/v11"
 	"github.com/presbrey/cmd/httppp/internal/proxy"
@@ -15,11 +23,44 @@ func main() {
 	// Define CLI flags
 	port := flag.String("port", "", "Port to listen on (overrides PORT env var)")
 	targetURL := flag.String("url", "", "Target URL to proxy requests to (overrides TARGET_URL env var)")
-	maxBodySize := flag.Int("max-body", -1, "Maximum bytes to print from request/response bodies (overrides MAX_BODY_SIZE env var)")
+	maxBodySize := flag.Int("max-body", -1, "Maximum bytes to print from request/response bodies; 0 prints up to 1 MiB (overrides MAX_BODY_SIZE env var)")
 	onlyHeaders := flag.Bool("only-headers", false, "Print only headers, skip body content (overrides ONLY_HEADERS env var)")
 	onlyBody := flag.Bool("only-body", false, "Print only body, skip headers (overrides ONLY_BODY env var)")
 	onlyJSON := flag.Bool("only-json", false, "Print only JSON bodies, skip non-JSON content (overrides ONLY_JSON env var)")
 	skipTLSVerify := flag.Bool("skip-tls-verify", false, "Skip TLS certificate verification (overrides SKIP_TLS_VERIFY env var)")
+	filterHeaders := flag.String("filter-headers", "", "Comma-separated headers to print as [REDACTED], or none (overrides FILTER_HEADERS env var)")
+	color := flag.String("color", "", "Color output: auto, always or never (overrides COLOR env var)")
+	preserveHost := flag.Bool("preserve-host", false, "Send the client's Host header upstream instead of the target's (overrides PRESERVE_HOST env var)")
+	upstreamProxy := flag.String("upstream-proxy", "", "HTTP or SOCKS5 proxy for outgoing requests (overrides UPSTREAM_PROXY env var)")
+	probeUpstream := flag.Bool("probe-upstream", false, "Check that the target URL is reachable at startup (overrides PROBE_UPSTREAM env var)")
+	requireUpstream := flag.Bool("require-upstream", false, "Exit at startup if the target URL is unreachable (overrides REQUIRE_UPSTREAM env var)")
+	logFile := flag.String("log", "", "Append one JSON line per exchange to this file (overrides LOG_FILE env var)")
+	harFile := flag.String("har", "", "Record exchanges to this HAR file, finished on Ctrl-C (overrides HAR_FILE env var)")
+	saveBodies := flag.String("save-bodies", "", "Save each body to a file in this directory and print its path instead (overrides SAVE_BODIES_DIR env var)")
+	breakPath := flag.String("break-path", "", "Pause requests whose path matches this regex until Enter is pressed (overrides BREAK_PATH env var)")
+	probeTimeout := flag.Duration("probe-timeout", 0, "Timeout for the startup probe (overrides PROBE_TIMEOUT env var)")
+	delay := flag.Duration("delay", 0, "Hold each request this long before forwarding it, e.g. 500ms (overrides DELAY env var)")
+	delayJitter := flag.Duration("delay-jitter", 0, "Add a random extra delay of up to this much to each request (overrides DELAY_JITTER env var)")
+	faultRate := flag.Float64("fault-rate", -1, "Fraction of requests, 0 to 1, to answer with -fault-status instead of forwarding (overrides FAULT_RATE env var)")
+	faultStatus := flag.Int("fault-status", 0, "Status for injected faults (overrides FAULT_STATUS env var)")
+	var faultSeed *uint64
+	flag.Func("fault-seed", "Seed for choosing faulty requests and delay jitter, for repeatable runs (overrides FAULT_SEED env var)", func(s string) error {
+		seed, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		faultSeed = &seed
+		return nil
+	})
+	routes := map[string]string{}
+	flag.Func("route", "Send requests under a path prefix to another target, as prefix=url; repeatable (overrides ROUTES env var)", func(s string) error {
+		prefix, target, err := proxy.ParseRoute(s)
+		if err != nil {
+			return err
+		}
+		routes[prefix] = target
+		return nil
+	})
 	flag.Parse()
 
 	// Parse environment variables first
@@ -42,20 +83,219 @@ func main() {
 	cfg.OnlyBody = *onlyBody
 	cfg.OnlyJSON = *onlyJSON
 	cfg.SkipTLSVerify = *skipTLSVerify
+	if *filterHeaders != "" {
+		cfg.FilterHeaders = strings.Split(*filterHeaders, ",")
+	}
+	if len(cfg.FilterHeaders) == 1 && strings.EqualFold(cfg.FilterHeaders[0], "none") {
+		cfg.FilterHeaders = nil
+	}
+	if *color != "" {
+		cfg.Color = *color
+	}
+	if *preserveHost {
+		cfg.PreserveHost = true
+	}
+	if *upstreamProxy != "" {
+		cfg.UpstreamProxy = *upstreamProxy
+	}
+	if *probeUpstream {
+		cfg.ProbeUpstream = true
+	}
+	if *requireUpstream {
+		cfg.RequireUpstream = true
+	}
+	if *probeTimeout > 0 {
+		cfg.ProbeTimeout = *probeTimeout
+	}
+	if *delay > 0 {
+		cfg.Delay = *delay
+	}
+	if *delayJitter > 0 {
+		cfg.DelayJitter = *delayJitter
+	}
+	if *faultRate >= 0 {
+		cfg.FaultRate = *faultRate
+	}
+	if *faultStatus != 0 {
+		cfg.FaultStatus = *faultStatus
+	}
+	if faultSeed != nil {
+		cfg.FaultSeed = faultSeed
+	}
+	if *logFile != "" {
+		cfg.LogFile = *logFile
+	}
+	if *harFile != "" {
+		cfg.HARFile = *harFile
+	}
+	if *saveBodies != "" {
+		cfg.SaveBodiesDir = *saveBodies
+	}
+	if *breakPath != "" {
+		cfg.BreakPath = *breakPath
+	}
+	if len(routes) > 0 {
+		cfg.Routes = routes
+	}
 
 	// Validate required configuration
-	if cfg.TargetURL == "" {
-		log.Fatal("TARGET_URL is required (set via environment variable or -url flag)")
+	if cfg.TargetURL == "" && len(cfg.Routes) == 0 {
+		log.Fatal("TARGET_URL is required (set via environment variable or -url flag) unless routes are given")
+	}
+	if err := proxy.ValidateRoutes(cfg.Routes); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.Delay < 0 || cfg.DelayJitter < 0 {
+		log.Fatal("DELAY and DELAY_JITTER cannot be negative")
+	}
+	if cfg.FaultRate < 0 || cfg.FaultRate > 1 {
+		log.Fatalf("FAULT_RATE must be between 0 and 1, got %g", cfg.FaultRate)
+	}
+	if cfg.FaultStatus < 400 || cfg.FaultStatus > 599 {
+		log.Fatalf("FAULT_STATUS must be an error status from 400 to 599, got %d", cfg.FaultStatus)
+	}
+	if cfg.Color, err = proxy.ParseColor(cfg.Color); err != nil {
+		log.Fatal(err)
+	}
+	var upstreamProxyURL *url.URL
+	if cfg.UpstreamProxy != "" {
+		if upstreamProxyURL, err = proxy.ParseProxyURL(cfg.UpstreamProxy); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if cfg.SaveBodiesDir != "" {
+		if err := os.MkdirAll(cfg.SaveBodiesDir, 0755); err != nil {
+			log.Fatalf("Failed to create body directory: %v", err)
+		}
 	}
 
 	printer := proxy.NewPrettyPrinter(os.Stdout, &cfg)
 	handler := proxy.NewHandler(printer, &cfg)
+	if cfg.LogFile != "" {
+		file, err := os.OpenFile(cfg.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatalf("Failed to open exchange log: %v", err)
+		}
+		defer file.Close()
+		handler.LogExchanges(proxy.NewExchangeLog(file, &cfg))
+	}
+	var har *proxy.HARLog
+	if cfg.HARFile != "" {
+		file, err := os.Create(cfg.HARFile)
+		if err != nil {
+			log.Fatalf("Failed to create HAR file: %v", err)
+		}
+		defer file.Close()
+		har = proxy.NewHARLog(file, &cfg)
+		handler.RecordHAR(har)
+	}
+	if cfg.BreakPath != "" {
+		// Read keypresses from the terminal even when stdin is redirected
+		tty, err := os.Open("/dev/tty")
+		if err != nil {
+			log.Fatalf("Breakpoints need a controlling terminal: %v", err)
+		}
+		defer tty.Close()
+		breakpoint, err := proxy.NewBreakpoint(cfg.BreakPath, tty, os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		handler.Break(breakpoint)
+	}
 
 	addr := fmt.Sprintf(":%s", cfg.Port)
 	log.Printf("Starting pretty printing HTTP proxy on %s", addr)
-	log.Printf("Proxying requests to: %s", cfg.TargetURL)
+	prefixes := make([]string, 0, len(cfg.Routes))
+	for prefix := range cfg.Routes {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		log.Printf("Proxying %s to: %s", prefix, cfg.Routes[prefix])
+	}
+	if cfg.TargetURL != "" {
+		log.Printf("Proxying requests to: %s", cfg.TargetURL)
+	}
+	if upstreamProxyURL != nil {
+		log.Printf("Using upstream proxy: %s", upstreamProxyURL.Redacted())
+	}
+	if cfg.LogFile != "" {
+		log.Printf("Logging exchanges to: %s", cfg.LogFile)
+	}
+	if cfg.HARFile != "" {
+		log.Printf("Recording HAR to: %s", cfg.HARFile)
+	}
+	if cfg.SaveBodiesDir != "" {
+		log.Printf("Saving bodies to: %s", cfg.SaveBodiesDir)
+	}
+	if cfg.BreakPath != "" {
+		log.Printf("Pausing requests matching: %s", cfg.BreakPath)
+	}
+	if cfg.Delay > 0 || cfg.DelayJitter > 0 {
+		log.Printf("Delaying requests by: %s (+ up to %s jitter)", cfg.Delay, cfg.DelayJitter)
+	}
+	if cfg.FaultRate > 0 {
+		log.Printf("Failing %g of requests with: %d", cfg.FaultRate, cfg.FaultStatus)
+	}
 
-	if err := http.ListenAndServe(addr, handler); err != nil {
+	// Catch a mistyped target URL now rather than on the first proxied request
+	if (cfg.ProbeUpstream || cfg.RequireUpstream) && cfg.TargetURL != "" {
+		checkUpstream(handler, &cfg)
+	}
+
+	server := &http.Server{Addr: addr, Handler: handler}
+	// Stop cleanly on Ctrl-C so the HAR file can be finished and the
+	// metrics summary printed
+	stopped := make(chan struct{})
+	go func() {
+		shutdownOnSignal(server)
+		close(stopped)
+	}()
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Server failed: %v", err)
 	}
+	// Let requests in flight finish first
+	<-stopped
+	fmt.Print(handler.Metrics())
+	if har != nil {
+		if err := har.Close(); err != nil {
+			log.Fatalf("Failed to finish HAR file: %v", err)
+		}
+		log.Printf("Wrote HAR to: %s", cfg.HARFile)
+	}
+}
+
+// shutdownOnSignal stops the server on SIGINT or SIGTERM, giving requests
+// in flight a few seconds to finish
+func shutdownOnSignal(server *http.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	signal.Stop(signals)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Warning: requests still in flight at shutdown: %v", err)
+	}
+}
+
+// checkUpstream checks once whether the target is reachable and logs the
+// result, exiting if the upstream is required
+func checkUpstream(handler *proxy.Handler, cfg *proxy.Config) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ProbeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	status, err := handler.ProbeUpstream(ctx)
+	if err != nil {
+		if cfg.RequireUpstream {
+			log.Fatalf("Upstream %s is not reachable: %v", cfg.TargetURL, err)
+		}
+		log.Printf("Warning: upstream %s is not reachable: %v", cfg.TargetURL, err)
+		return
+	}
+	log.Printf("Upstream %s is reachable (%s in %s)", cfg.TargetURL, status, time.Since(start).Round(time.Millisecond))
 }
@@ -1,40 +1,38 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 func main() {
-	// Create a map to store filename -> content
-	files := make(map[string]string)
+	dir := flag.String("dir", ".", "Directory to list")
+	recursive := flag.Bool("r", false, "Include files in subdirectories, keyed by their path relative to -dir")
+	maxDepth := flag.Int("max-depth", 0, "With -r, descend at most this many directories below -dir (0 means no limit)")
+	stream := flag.Bool("stream", false, "Write a JSON array of {name, content} objects one file at a time, in directory order")
+	flag.Parse()
+
+	if *stream {
+		if err := streamFiles(os.Stdout, *dir, *recursive, *maxDepth); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	// Read current directory
-	entries, err := os.ReadDir(".")
+	files, err := listFiles(*dir, *recursive, *maxDepth)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error reading directory: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Process each file
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue // Skip directories
-		}
-
-		// Read file content
-		content, err := os.ReadFile(filepath.Clean(entry.Name()))
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error reading %s: %v\n", entry.Name(), err)
-			continue
-		}
-
-		// Store in map
-		files[entry.Name()] = string(content)
-	}
-
 	// Encode to JSON
 	encoder := json.NewEncoder(os.Stdout)
 	encoder.SetIndent("", "  ")
@@ -43,3 +41,94 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// listFiles maps the name of each file under root to its content
+func listFiles(root string, recursive bool, maxDepth int) (map[string]string, error) {
+	// Create a map to store filename -> content
+	files := make(map[string]string)
+	err := walkFiles(root, recursive, maxDepth, func(name string, content []byte) error {
+		files[name] = string(content)
+		return nil
+	})
+	return files, err
+}
+
+// file is an element of the array written by streamFiles
+type file struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+// streamFiles writes the files under root to w as a JSON array, encoding
+// each as it is read so only one is in memory at a time
+func streamFiles(w io.Writer, root string, recursive bool, maxDepth int) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	count := 0
+	err := walkFiles(root, recursive, maxDepth, func(name string, content []byte) error {
+		buf.Reset()
+		if err := encoder.Encode(file{Name: name, Content: string(content)}); err != nil {
+			return err
+		}
+		sep := ",\n  "
+		if count == 0 {
+			sep = "[\n  "
+		}
+		count++
+		if _, err := io.WriteString(w, sep); err != nil {
+			return err
+		}
+		// Drop the newline Encode ends each value with
+		_, err := w.Write(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		_, err = io.WriteString(w, "[]\n")
+	} else {
+		_, err = io.WriteString(w, "\n]\n")
+	}
+	return err
+}
+
+// walkFiles calls fn with the name and content of each file under root, in
+// lexical order. Names are relative to root and separated by slashes.
+// Directories are not entries of their own; with recursive their files are
+// included, down to maxDepth directories below root when it is positive.
+// Files that cannot be read are reported and skipped.
+func walkFiles(root string, recursive bool, maxDepth int, fn func(name string, content []byte) error) error {
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "error reading %s: %v\n", path, err)
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if rel == "." {
+				return nil
+			}
+			depth := strings.Count(rel, string(filepath.Separator)) + 1
+			if !recursive || (maxDepth > 0 && depth > maxDepth) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Read file content
+		content, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error reading %s: %v\n", rel, err)
+			return nil
+		}
+
+		return fn(filepath.ToSlash(rel), content)
+	})
+}
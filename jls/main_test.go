@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestListFiles(t *testing.T) {
+	root := t.TempDir()
+	tree := map[string]string{
+		"README.md":          "readme",
+		"src/main.go":        "package main",
+		"src/lib/util.go":    "package lib",
+		"src/lib/deep/x.txt": "deep",
+	}
+	for path, content := range tree {
+		full := filepath.Join(root, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(root, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		recursive bool
+		maxDepth  int
+		want      []string
+	}{
+		{"current directory only", false, 0, []string{"README.md"}},
+		{"recursive", true, 0, []string{"README.md", "src/main.go", "src/lib/util.go", "src/lib/deep/x.txt"}},
+		{"max depth 1", true, 1, []string{"README.md", "src/main.go"}},
+		{"max depth 2", true, 2, []string{"README.md", "src/main.go", "src/lib/util.go"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := listFiles(root, tt.recursive, tt.maxDepth)
+			if err != nil {
+				t.Fatalf("listFiles() error = %v", err)
+			}
+			want := make(map[string]string)
+			for _, path := range tt.want {
+				want[path] = tree[path]
+			}
+			if !maps.Equal(got, want) {
+				t.Errorf("listFiles() = %v, want %v", got, want)
+			}
+		})
+	}
+
+	if _, err := listFiles(filepath.Join(root, "missing"), true, 0); err == nil {
+		t.Error("listFiles() of a missing directory succeeded")
+	}
+}
+
+func TestStreamFiles(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"b.txt", "a.txt", "c.json"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte("content of "+name+"\n\"quoted\""), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "sub", "d.txt"), []byte("nested"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		recursive bool
+		want      []string
+	}{
+		{false, []string{"a.txt", "b.txt", "c.json"}},
+		{true, []string{"a.txt", "b.txt", "c.json", "sub/d.txt"}},
+	} {
+		var out bytes.Buffer
+		if err := streamFiles(&out, root, tt.recursive, 0); err != nil {
+			t.Fatalf("streamFiles() error = %v", err)
+		}
+		var got []file
+		if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+			t.Fatalf("streamed output does not parse: %v\n%s", err, out.String())
+		}
+		var names []string
+		for _, f := range got {
+			names = append(names, f.Name)
+			content, _ := os.ReadFile(filepath.Join(root, filepath.FromSlash(f.Name)))
+			if f.Content != string(content) {
+				t.Errorf("%s content = %q, want %q", f.Name, f.Content, content)
+			}
+		}
+		if !slices.Equal(names, tt.want) {
+			t.Errorf("recursive=%v: streamed %v, want %v", tt.recursive, names, tt.want)
+		}
+	}
+
+	// An empty directory is an empty array
+	var out bytes.Buffer
+	if err := streamFiles(&out, t.TempDir(), false, 0); err != nil || out.String() != "[]\n" {
+		t.Errorf("streamFiles() of an empty directory = %q, %v", out.String(), err)
+	}
+}
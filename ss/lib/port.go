@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"fmt"
+	"iter"
+	"strconv"
+	"strings"
+)
+
+// PortFilter matches ports against a comparison such as ">=1024"
+type PortFilter struct {
+	Op   string // One of ==, !=, <, <=, >, >=
+	Port int
+}
+
+// portOps lists the comparison operators, two-character ones first so they
+// are recognized before their one-character prefixes
+var portOps = []string{"==", "!=", "<=", ">=", "<", ">", "="}
+
+// ParsePortFilter parses a port comparison. A bare number, or one prefixed
+// with = or ==, matches that port exactly.
+func ParsePortFilter(s string) (*PortFilter, error) {
+	expr := strings.TrimSpace(s)
+	op := "=="
+	for _, candidate := range portOps {
+		if strings.HasPrefix(expr, candidate) {
+			op = candidate
+			expr = strings.TrimSpace(expr[len(candidate):])
+			break
+		}
+	}
+	if op == "=" {
+		op = "=="
+	}
+
+	port, err := strconv.Atoi(expr)
+	if err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port filter %q: expected a port number, optionally prefixed by ==, !=, <, <=, > or >=", s)
+	}
+	return &PortFilter{Op: op, Port: port}, nil
+}
+
+// Match reports whether port satisfies the comparison. A nil filter
+// matches every port.
+func (f *PortFilter) Match(port int) bool {
+	if f == nil {
+		return true
+	}
+	switch f.Op {
+	case "!=":
+		return port != f.Port
+	case "<":
+		return port < f.Port
+	case "<=":
+		return port <= f.Port
+	case ">":
+		return port > f.Port
+	case ">=":
+		return port >= f.Port
+	}
+	return port == f.Port
+}
+
+// FilterPorts returns an iterator over the sockets whose local port matches
+// sport and remote port matches dport. Errors are passed through.
+func FilterPorts(sockets iter.Seq2[Socket, error], sport, dport *PortFilter) iter.Seq2[Socket, error] {
+	return func(yield func(Socket, error) bool) {
+		for s, err := range sockets {
+			if err == nil && (!sport.Match(s.LocalPort) || !dport.Match(s.RemotePort)) {
+				continue
+			}
+			if !yield(s, err) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package lib
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParsePortFilter(t *testing.T) {
+	tests := []struct {
+		input string
+		want  PortFilter
+	}{
+		{"443", PortFilter{Op: "==", Port: 443}},
+		{"=443", PortFilter{Op: "==", Port: 443}},
+		{"==443", PortFilter{Op: "==", Port: 443}},
+		{"!=22", PortFilter{Op: "!=", Port: 22}},
+		{">=1024", PortFilter{Op: ">=", Port: 1024}},
+		{"> 1024", PortFilter{Op: ">", Port: 1024}},
+		{"<=80", PortFilter{Op: "<=", Port: 80}},
+		{"<80", PortFilter{Op: "<", Port: 80}},
+	}
+	for _, tt := range tests {
+		got, err := ParsePortFilter(tt.input)
+		if err != nil {
+			t.Errorf("ParsePortFilter(%q) failed: %v", tt.input, err)
+			continue
+		}
+		if *got != tt.want {
+			t.Errorf("ParsePortFilter(%q) = %+v, want %+v", tt.input, *got, tt.want)
+		}
+	}
+
+	for _, input := range []string{"", "abc", ">=", "=>1024", "65536", "-1", "80-90"} {
+		if _, err := ParsePortFilter(input); err == nil {
+			t.Errorf("ParsePortFilter(%q): expected error", input)
+		}
+	}
+}
+
+func TestPortFilterMatch(t *testing.T) {
+	tests := []struct {
+		filter string
+		port   int
+		want   bool
+	}{
+		{"443", 443, true},
+		{"443", 80, false},
+		{"!=22", 22, false},
+		{"!=22", 2222, true},
+		{">=1024", 1024, true},
+		{">=1024", 1023, false},
+		{">1024", 1024, false},
+		{"<=80", 80, true},
+		{"<80", 80, false},
+		{"<80", 0, true},
+	}
+	for _, tt := range tests {
+		f, err := ParsePortFilter(tt.filter)
+		if err != nil {
+			t.Fatalf("ParsePortFilter(%q) failed: %v", tt.filter, err)
+		}
+		if got := f.Match(tt.port); got != tt.want {
+			t.Errorf("%q.Match(%d) = %v, want %v", tt.filter, tt.port, got, tt.want)
+		}
+	}
+
+	var none *PortFilter
+	if !none.Match(12345) {
+		t.Error("nil filter should match every port")
+	}
+}
+
+func TestFilterPorts(t *testing.T) {
+	sockets, err := ReadSnapshot(strings.NewReader(snapshot))
+	if err != nil {
+		t.Fatalf("ReadSnapshot failed: %v", err)
+	}
+
+	collect := func(sport, dport string) []int {
+		var sf, df *PortFilter
+		if sport != "" {
+			sf, _ = ParsePortFilter(sport)
+		}
+		if dport != "" {
+			df, _ = ParsePortFilter(dport)
+		}
+		var pids []int
+		for s, err := range FilterPorts(SnapshotSockets(sockets, true, true, FamilyAny, false, true), sf, df) {
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			pids = append(pids, s.PID)
+		}
+		return pids
+	}
+
+	tests := []struct {
+		sport, dport string
+		want         []int
+	}{
+		{"", "", []int{1, 42, 7}},
+		{"22", "", []int{1, 42}},
+		{"", ">=1024", []int{42}},
+		{"22", "0", []int{1}},
+		{"!=22", "", []int{7}},
+	}
+	for _, tt := range tests {
+		if got := collect(tt.sport, tt.dport); !slices.Equal(got, tt.want) {
+			t.Errorf("sport %q dport %q: got PIDs %v, want %v", tt.sport, tt.dport, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package lib
+
+import (
+	"iter"
+	"regexp"
+)
+
+// ProcessFilter matches sockets by the name of the process that owns them
+type ProcessFilter struct {
+	re *regexp.Regexp
+}
+
+// ParseProcessFilter parses a -p pattern. The pattern is a regular
+// expression matched anywhere in the process name, so a plain name such as
+// "nginx" matches as a substring and "^(nginx|httpd)$" matches exactly.
+func ParseProcessFilter(pattern string) (*ProcessFilter, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &ProcessFilter{re: re}, nil
+}
+
+// Match reports whether name matches the pattern. A nil filter matches
+// every name.
+func (f *ProcessFilter) Match(name string) bool {
+	return f == nil || f.re.MatchString(name)
+}
+
+// FilterProcesses returns an iterator over the sockets whose process name
+// matches process. Errors are passed through.
+func FilterProcesses(sockets iter.Seq2[Socket, error], process *ProcessFilter) iter.Seq2[Socket, error] {
+	return func(yield func(Socket, error) bool) {
+		for s, err := range sockets {
+			if err == nil && !process.Match(s.ProcessName) {
+				continue
+			}
+			if !yield(s, err) {
+				return
+			}
+		}
+	}
+}
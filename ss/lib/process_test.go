@@ -0,0 +1,78 @@
+package lib
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestProcessFilterMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"nginx", "nginx", true},
+		{"nginx", "nginx-worker", true},
+		{"gin", "nginx", true},
+		{"nginx", "httpd", false},
+		{"nginx", "", false},
+		{"^(nginx|httpd)$", "httpd", true},
+		{"^(nginx|httpd)$", "nginx-worker", false},
+		{`^python3\.\d+$`, "python3.12", true},
+		{`^python3\.\d+$`, "python3x12", false},
+		{"(?i)NGINX", "nginx", true},
+		{"NGINX", "nginx", false},
+	}
+	for _, tt := range tests {
+		filter, err := ParseProcessFilter(tt.pattern)
+		if err != nil {
+			t.Fatalf("ParseProcessFilter(%q) failed: %v", tt.pattern, err)
+		}
+		if got := filter.Match(tt.name); got != tt.want {
+			t.Errorf("pattern %q matching %q = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+
+	var none *ProcessFilter
+	if !none.Match("anything") {
+		t.Error("nil filter should match every name")
+	}
+
+	if _, err := ParseProcessFilter("nginx("); err == nil {
+		t.Error("ParseProcessFilter should reject an invalid regular expression")
+	}
+}
+
+func TestFilterProcesses(t *testing.T) {
+	sockets, err := ReadSnapshot(strings.NewReader(snapshot))
+	if err != nil {
+		t.Fatalf("ReadSnapshot failed: %v", err)
+	}
+
+	tests := []struct {
+		pattern string
+		want    []int
+	}{
+		{"sshd", []int{1, 42}},
+		{"dns", []int{7}},
+		{"^(sshd|dnsmasq)$", []int{1, 42, 7}},
+		{"nginx", nil},
+	}
+	for _, tt := range tests {
+		filter, err := ParseProcessFilter(tt.pattern)
+		if err != nil {
+			t.Fatalf("ParseProcessFilter(%q) failed: %v", tt.pattern, err)
+		}
+		var pids []int
+		for s, err := range FilterProcesses(SnapshotSockets(sockets, true, true, FamilyAny, false, true), filter) {
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			pids = append(pids, s.PID)
+		}
+		if !slices.Equal(pids, tt.want) {
+			t.Errorf("-p %s: got PIDs %v, want %v", tt.pattern, pids, tt.want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// tcpStates maps the hex state column of /proc/net/tcp to state names
+var tcpStates = map[string]string{
+	"01": "ESTABLISHED",
+	"02": "SYN_SENT",
+	"03": "SYN_RECV",
+	"04": "FIN_WAIT1",
+	"05": "FIN_WAIT2",
+	"06": "TIME_WAIT",
+	"07": "CLOSE",
+	"08": "CLOSE_WAIT",
+	"09": "LAST_ACK",
+	"0A": "LISTEN",
+	"0B": "CLOSING",
+}
+
+// procSocket is a socket read from /proc/net along with its inode, which
+// links it to the process holding it open
+type procSocket struct {
+	Socket
+	Inode uint64
+}
+
+// parseProcNet parses the contents of /proc/net/{tcp,tcp6,udp,udp6}. The
+// netid (tcp or udp) is recorded on each socket, along with its family:
+// IPv4 and IPv6 tables are told apart by the width of their addresses.
+func parseProcNet(r io.Reader, netid string) ([]procSocket, error) {
+	var sockets []procSocket
+	scanner := bufio.NewScanner(r)
+
+	// Skip header
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+
+		localAddr, localPort, err := decodeProcAddr(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		family := FamilyIPv4
+		if addrHex, _, _ := strings.Cut(fields[1], ":"); len(addrHex) == 2*net.IPv6len {
+			family = FamilyIPv6
+		}
+		remoteAddr, remotePort, err := decodeProcAddr(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid inode %q: %w", fields[9], err)
+		}
+
+		// Unconnected sockets have no peer
+		if remoteAddr == "*" && remotePort == 0 {
+			remoteAddr = ""
+		}
+
+		sockets = append(sockets, procSocket{
+			Socket: Socket{
+				Netid:      netid,
+				State:      procState(netid, strings.ToUpper(fields[3])),
+				LocalAddr:  localAddr,
+				LocalPort:  localPort,
+				RemoteAddr: remoteAddr,
+				RemotePort: remotePort,
+				Family:     family,
+			},
+			Inode: inode,
+		})
+	}
+	return sockets, scanner.Err()
+}
+
+// procState names the hex state column. UDP reuses the TCP values, but only
+// connected sockets are worth distinguishing there.
+func procState(netid, st string) string {
+	if netid == "udp" {
+		if st == "01" {
+			return "ESTABLISHED"
+		}
+		return "UNCONN"
+	}
+	if state, ok := tcpStates[st]; ok {
+		return state
+	}
+	return "UNKNOWN"
+}
+
+// decodeProcAddr decodes an address:port pair such as 0100007F:0016. The
+// kernel prints addresses as 32-bit words in host byte order and the port in
+// hex. Unspecified addresses are returned as "*" to match the lsof output.
+func decodeProcAddr(s string) (string, int, error) {
+	addrHex, portHex, ok := strings.Cut(s, ":")
+	if !ok {
+		return "", 0, fmt.Errorf("invalid address %q", s)
+	}
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in %q: %w", s, err)
+	}
+
+	raw, err := hex.DecodeString(addrHex)
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return "", 0, fmt.Errorf("invalid address %q", s)
+	}
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		binary.NativeEndian.PutUint32(ip[i:], binary.BigEndian.Uint32(raw[i:]))
+	}
+
+	if ip.IsUnspecified() {
+		return "*", int(port), nil
+	}
+	return ip.String(), int(port), nil
+}
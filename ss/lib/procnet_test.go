@@ -0,0 +1,127 @@
+package lib
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const procNetTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 662 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:1F90 0100007F:C738 01 00000000:00000000 02:00000BAD 00000000  1000        0 33025 2 0000000000000000 20 4 0 32 -1
+   2: 0500000A:0016 0900000A:C738 06 00000000:00000000 03:00001770 00000000     0        0 0 3 0000000000000000
+`
+
+const procNetTCP6 = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000000000000:0050 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 700 1 0000000000000000 100 0 0 10 0
+   1: 00000000000000000000000001000000:0035 00000000000000000000000001000000:D431 01 00000000:00000000 00:00000000 00000000     0        0 701 1 0000000000000000 20 4 0 10 -1
+   2: 0000000000000000FFFF00000100007F:0050 0000000000000000FFFF00000100007F:D432 01 00000000:00000000 00:00000000 00000000     0        0 702 1 0000000000000000 20 4 0 10 -1
+`
+
+const procNetUDP = `   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  100: 3500007F:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000   101        0 800 2 0000000000000000 0
+`
+
+func TestParseProcNet(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		netid string
+		want  []procSocket
+	}{
+		{
+			name:  "tcp",
+			input: procNetTCP,
+			netid: "tcp",
+			want: []procSocket{
+				{Socket{Netid: "tcp", State: "LISTEN", LocalAddr: "*", LocalPort: 22, Family: FamilyIPv4}, 662},
+				{Socket{Netid: "tcp", State: "ESTABLISHED", LocalAddr: "127.0.0.1", LocalPort: 8080, RemoteAddr: "127.0.0.1", RemotePort: 51000, Family: FamilyIPv4}, 33025},
+				{Socket{Netid: "tcp", State: "TIME_WAIT", LocalAddr: "10.0.0.5", LocalPort: 22, RemoteAddr: "10.0.0.9", RemotePort: 51000, Family: FamilyIPv4}, 0},
+			},
+		},
+		{
+			name:  "tcp6",
+			input: procNetTCP6,
+			netid: "tcp",
+			want: []procSocket{
+				{Socket{Netid: "tcp", State: "LISTEN", LocalAddr: "*", LocalPort: 80, Family: FamilyIPv6}, 700},
+				{Socket{Netid: "tcp", State: "ESTABLISHED", LocalAddr: "::1", LocalPort: 53, RemoteAddr: "::1", RemotePort: 54321, Family: FamilyIPv6}, 701},
+				{Socket{Netid: "tcp", State: "ESTABLISHED", LocalAddr: "127.0.0.1", LocalPort: 80, RemoteAddr: "127.0.0.1", RemotePort: 54322, Family: FamilyIPv6}, 702},
+			},
+		},
+		{
+			name:  "udp",
+			input: procNetUDP,
+			netid: "udp",
+			want: []procSocket{
+				{Socket{Netid: "udp", State: "UNCONN", LocalAddr: "127.0.0.53", LocalPort: 53, Family: FamilyIPv4}, 800},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseProcNet(strings.NewReader(tt.input), tt.netid)
+			if err != nil {
+				t.Fatalf("parseProcNet failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got:\n%+v\nwant:\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseProcNetInvalid(t *testing.T) {
+	for _, input := range []string{
+		"header\n 0: 0100007F 00000000:0000 0A 0 0 0 0 0 1 1\n",
+		"header\n 0: 0100007F:ZZZZ 00000000:0000 0A 0 0 0 0 0 1 1\n",
+		"header\n 0: 01007F:0016 00000000:0000 0A 0 0 0 0 0 1 1\n",
+		"header\n 0: 0100007F:0016 00000000:0000 0A 0 0 0 0 0 x 1\n",
+	} {
+		if _, err := parseProcNet(strings.NewReader(input), "tcp"); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func TestWildcardFamily(t *testing.T) {
+	var sockets []Socket
+	for _, table := range []string{procNetTCP, procNetTCP6} {
+		parsed, err := parseProcNet(strings.NewReader(table), "tcp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range parsed {
+			sockets = append(sockets, e.Socket)
+		}
+	}
+
+	// The family survives a snapshot, for --from to read back
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, sockets); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"family": "ipv6"`) {
+		t.Errorf("snapshot is missing the family:\n%s", buf.String())
+	}
+	loaded, err := ReadSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both wildcard listeners print as "*", but each belongs to its own table
+	for family, wantPort := range map[Family]int{FamilyIPv4: 22, FamilyIPv6: 80} {
+		var ports []int
+		for s := range SnapshotSockets(loaded, true, false, family, true, false) {
+			if s.LocalAddr != "*" {
+				t.Errorf("%s listener address = %q, want *", family, s.LocalAddr)
+			}
+			ports = append(ports, s.LocalPort)
+		}
+		if !reflect.DeepEqual(ports, []int{wantPort}) {
+			t.Errorf("%s listeners on ports %v, want [%d]", family, ports, wantPort)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package lib
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resolver looks up host names for an address. *net.Resolver implements it.
+type Resolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// HostCache resolves each address at most once, remembering failures and
+// timeouts as well so a slow resolver only costs one wait per address
+type HostCache struct {
+	mu       sync.Mutex
+	resolver Resolver
+	timeout  time.Duration
+	names    map[string][]string
+}
+
+// NewHostCache creates a HostCache that gives each lookup up to timeout, or
+// as long as it takes when timeout is zero
+func NewHostCache(resolver Resolver, timeout time.Duration) *HostCache {
+	return &HostCache{
+		resolver: resolver,
+		timeout:  timeout,
+		names:    make(map[string][]string),
+	}
+}
+
+// Lookup returns the first host name for an IP address, or "" when it has
+// none, isn't an IP address or couldn't be resolved in time
+func (c *HostCache) Lookup(addr string) string {
+	if addr == "" || addr == "*" || net.ParseIP(addr) == nil {
+		return ""
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	names, ok := c.names[addr]
+	if !ok {
+		ctx := context.Background()
+		if c.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+		}
+		names, _ = c.resolver.LookupAddr(ctx, addr)
+		c.names[addr] = names
+	}
+
+	if len(names) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(names[0], ".")
+}
+
+// Resolve fills in the host names of a socket's addresses
+func (c *HostCache) Resolve(s Socket) Socket {
+	s.LocalHost = c.Lookup(s.LocalAddr)
+	s.RemoteHost = c.Lookup(s.RemoteAddr)
+	return s
+}
@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeResolver answers from a table and counts lookups per address
+type fakeResolver struct {
+	mu    sync.Mutex
+	names map[string][]string
+	calls map[string]int
+	delay time.Duration
+}
+
+func (r *fakeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	r.mu.Lock()
+	r.calls[addr]++
+	r.mu.Unlock()
+
+	if r.delay > 0 {
+		select {
+		case <-time.After(r.delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if names, ok := r.names[addr]; ok {
+		return names, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func TestHostCache(t *testing.T) {
+	resolver := &fakeResolver{
+		names: map[string][]string{"10.0.0.9": {"peer.example.com."}, "::1": {"localhost."}},
+		calls: make(map[string]int),
+	}
+	hosts := NewHostCache(resolver, time.Second)
+
+	for i := 0; i < 3; i++ {
+		s := hosts.Resolve(Socket{LocalAddr: "::1", RemoteAddr: "10.0.0.9"})
+		if s.LocalHost != "localhost" || s.RemoteHost != "peer.example.com" {
+			t.Errorf("Resolve = %+v, want localhost and peer.example.com", s)
+		}
+		if got := hosts.Lookup("10.0.0.1"); got != "" {
+			t.Errorf("Lookup(unresolvable) = %q, want empty", got)
+		}
+	}
+	for _, addr := range []string{"", "*", "not-an-ip"} {
+		if got := hosts.Lookup(addr); got != "" {
+			t.Errorf("Lookup(%q) = %q, want empty", addr, got)
+		}
+	}
+
+	want := map[string]int{"::1": 1, "10.0.0.9": 1, "10.0.0.1": 1}
+	for addr, n := range want {
+		if resolver.calls[addr] != n {
+			t.Errorf("%s resolved %d times, want %d", addr, resolver.calls[addr], n)
+		}
+	}
+	if len(resolver.calls) != len(want) {
+		t.Errorf("unexpected lookups: %v", resolver.calls)
+	}
+}
+
+func TestHostCacheTimeout(t *testing.T) {
+	resolver := &fakeResolver{
+		names: map[string][]string{"10.0.0.9": {"slow.example.com."}},
+		calls: make(map[string]int),
+		delay: time.Second,
+	}
+	hosts := NewHostCache(resolver, 20*time.Millisecond)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if got := hosts.Lookup("10.0.0.9"); got != "" {
+			t.Errorf("Lookup = %q, want numeric fallback", got)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("lookups took %s, want one timeout's worth", elapsed)
+	}
+	if resolver.calls["10.0.0.9"] != 1 {
+		t.Errorf("resolved %d times, want 1", resolver.calls["10.0.0.9"])
+	}
+}
@@ -0,0 +1,70 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+)
+
+// ReadSnapshot decodes a previously captured JSON array of sockets, such as
+// one saved from another machine for debugging
+func ReadSnapshot(r io.Reader) ([]Socket, error) {
+	var sockets []Socket
+	if err := json.NewDecoder(r).Decode(&sockets); err != nil {
+		return nil, fmt.Errorf("decoding socket snapshot: %w", err)
+	}
+	return sockets, nil
+}
+
+// WriteSnapshot encodes sockets as a JSON array that ReadSnapshot can load
+func WriteSnapshot(w io.Writer, sockets []Socket) error {
+	if sockets == nil {
+		sockets = []Socket{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sockets)
+}
+
+// SnapshotSockets returns an iterator over captured sockets that applies the
+// same filters as Sockets does for the live system. It never yields an error.
+func SnapshotSockets(sockets []Socket, tcp, udp bool, family Family, listeningOnly, all bool) iter.Seq2[Socket, error] {
+	return func(yield func(Socket, error) bool) {
+		for _, s := range sockets {
+			if !Match(s, tcp, udp, family, listeningOnly, all) {
+				continue
+			}
+			if !yield(s, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Match reports whether a socket passes the protocol, family and listening
+// filters
+func Match(s Socket, tcp, udp bool, family Family, listeningOnly, all bool) bool {
+	switch s.Netid {
+	case "tcp":
+		if !tcp {
+			return false
+		}
+	case "udp":
+		if !udp {
+			return false
+		}
+	default:
+		return false
+	}
+
+	if !s.MatchFamily(family) {
+		return false
+	}
+
+	// Skip non-listening sockets if listening only is requested
+	if listeningOnly && s.State != "LISTEN" && !all {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,135 @@
+package lib
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const snapshot = `[
+  {"Netid": "tcp", "State": "LISTEN", "LocalAddr": "*", "LocalPort": 22, "ProcessName": "sshd", "PID": 1},
+  {"Netid": "tcp", "State": "ESTABLISHED", "LocalAddr": "10.0.0.5", "LocalPort": 22, "RemoteAddr": "10.0.0.9", "RemotePort": 51000, "ProcessName": "sshd", "PID": 42},
+  {"Netid": "udp", "State": "UNCONN", "LocalAddr": "::1", "LocalPort": 53, "ProcessName": "dnsmasq", "PID": 7}
+]`
+
+func TestReadSnapshot(t *testing.T) {
+	sockets, err := ReadSnapshot(strings.NewReader(snapshot))
+	if err != nil {
+		t.Fatalf("ReadSnapshot failed: %v", err)
+	}
+	if len(sockets) != 3 {
+		t.Fatalf("got %d sockets, want 3", len(sockets))
+	}
+	want := Socket{Netid: "tcp", State: "ESTABLISHED", LocalAddr: "10.0.0.5", LocalPort: 22, RemoteAddr: "10.0.0.9", RemotePort: 51000, ProcessName: "sshd", PID: 42}
+	if sockets[1] != want {
+		t.Errorf("sockets[1] = %+v, want %+v", sockets[1], want)
+	}
+
+	if _, err := ReadSnapshot(strings.NewReader("not json")); err == nil {
+		t.Error("expected error for invalid snapshot")
+	}
+}
+
+func TestWriteSnapshotRoundTrip(t *testing.T) {
+	sockets := []Socket{
+		{Netid: "tcp", State: "LISTEN", LocalAddr: "*", LocalPort: 22, ProcessName: "sshd", PID: 1},
+		{Netid: "tcp", State: "ESTABLISHED", LocalAddr: "127.0.0.1", LocalPort: 8080, LocalHost: "localhost",
+			RemoteAddr: "::1", RemotePort: 51000, RemoteHost: "ip6-localhost", ProcessName: "api", PID: 42},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, sockets); err != nil {
+		t.Fatalf("WriteSnapshot failed: %v", err)
+	}
+	for _, key := range []string{`"netid": "tcp"`, `"localAddr": "127.0.0.1"`, `"localHost": "localhost"`, `"pid": 42`} {
+		if !strings.Contains(buf.String(), key) {
+			t.Errorf("output missing %s:\n%s", key, buf.String())
+		}
+	}
+
+	got, err := ReadSnapshot(&buf)
+	if err != nil {
+		t.Fatalf("ReadSnapshot failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, sockets) {
+		t.Errorf("round trip got %+v, want %+v", got, sockets)
+	}
+
+	buf.Reset()
+	if err := WriteSnapshot(&buf, nil); err != nil || strings.TrimSpace(buf.String()) != "[]" {
+		t.Errorf("empty snapshot = %q, %v; want []", buf.String(), err)
+	}
+}
+
+func TestSnapshotSockets(t *testing.T) {
+	sockets, err := ReadSnapshot(strings.NewReader(snapshot))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name                     string
+		tcp, udp, listening, all bool
+		family                   Family
+		wantPIDs                 []int
+	}{
+		{name: "tcp", tcp: true, wantPIDs: []int{1, 42}},
+		{name: "udp", udp: true, wantPIDs: []int{7}},
+		{name: "both", tcp: true, udp: true, wantPIDs: []int{1, 42, 7}},
+		{name: "listening", tcp: true, listening: true, wantPIDs: []int{1}},
+		{name: "listening all", tcp: true, listening: true, all: true, wantPIDs: []int{1, 42}},
+		{name: "ipv4", tcp: true, udp: true, family: FamilyIPv4, wantPIDs: []int{1, 42}},
+		{name: "ipv6", tcp: true, udp: true, family: FamilyIPv6, wantPIDs: []int{1, 7}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pids []int
+			for s, err := range SnapshotSockets(sockets, tt.tcp, tt.udp, tt.family, tt.listening, tt.all) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				pids = append(pids, s.PID)
+			}
+			if !reflect.DeepEqual(pids, tt.wantPIDs) {
+				t.Errorf("got PIDs %v, want %v", pids, tt.wantPIDs)
+			}
+		})
+	}
+}
+
+func TestAddrFamily(t *testing.T) {
+	tests := []struct {
+		addr string
+		want Family
+	}{
+		{addr: "*", want: FamilyAny},
+		{addr: "", want: FamilyAny},
+		{addr: "127.0.0.1", want: FamilyIPv4},
+		{addr: "10.0.0.5", want: FamilyIPv4},
+		{addr: "::1", want: FamilyIPv6},
+		{addr: "fe80::1", want: FamilyIPv6},
+		{addr: "::ffff:10.0.0.5", want: FamilyIPv6},
+		{addr: "localhost", want: FamilyAny},
+	}
+
+	for _, tt := range tests {
+		s := Socket{LocalAddr: tt.addr}
+		if got := s.AddrFamily(); got != tt.want {
+			t.Errorf("AddrFamily(%q) = %d, want %d", tt.addr, got, tt.want)
+		}
+		for _, family := range []Family{FamilyIPv4, FamilyIPv6} {
+			want := tt.want == FamilyAny || tt.want == family
+			if got := s.MatchFamily(family); got != want {
+				t.Errorf("MatchFamily(%q, %d) = %v, want %v", tt.addr, family, got, want)
+			}
+		}
+	}
+
+	// A recorded family wins over the address
+	s := Socket{LocalAddr: "*", Family: FamilyIPv6}
+	if s.AddrFamily() != FamilyIPv6 || s.MatchFamily(FamilyIPv4) {
+		t.Errorf("wildcard socket with family %s classified as %s", s.Family, s.AddrFamily())
+	}
+}
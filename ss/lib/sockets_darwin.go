@@ -1,28 +1,36 @@
 package lib
 
 import (
+	"fmt"
+	"iter"
 	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
 )
 
+// lsofPath is the lsof command used to list sockets
+var lsofPath = "lsof"
+
 // GetSockets retrieves socket information on macOS using lsof
-func GetSockets(tcp, udp, listeningOnly, all bool) ([]Socket, error) {
+func GetSockets(tcp, udp bool, family Family, listeningOnly, all bool) ([]Socket, error) {
 	var sockets []Socket
 
 	// Use the range function to collect all sockets
-	for s := range Sockets(tcp, udp, listeningOnly, all) {
+	for s, err := range Sockets(tcp, udp, family, listeningOnly, all) {
+		if err != nil {
+			return nil, err
+		}
 		sockets = append(sockets, s)
 	}
 
 	return sockets, nil
 }
 
-// Sockets returns an iterator that yields socket information one by one
-// This implements the Go 1.22 range function pattern
-func Sockets(tcp, udp, listeningOnly, all bool) func(yield func(Socket) bool) {
-	return func(yield func(Socket) bool) {
+// Sockets returns an iterator that yields socket information one by one.
+// If lsof fails, it yields the error and stops.
+func Sockets(tcp, udp bool, family Family, listeningOnly, all bool) iter.Seq2[Socket, error] {
+	return func(yield func(Socket, error) bool) {
 		// Build lsof command arguments
 		args := []string{"-nP", "-i"} // -n for numeric, -P for numeric ports
 		if tcp && !udp {
@@ -32,10 +40,17 @@ func Sockets(tcp, udp, listeningOnly, all bool) func(yield func(Socket) bool) {
 		}
 
 		// Execute lsof command
-		cmd := exec.Command("lsof", args...)
+		cmd := exec.Command(lsofPath, args...)
 		output, err := cmd.CombinedOutput()
 		if err != nil && !strings.Contains(string(output), "COMMAND") {
-			// Cannot return error in iter.Seq, so we just return without yielding anything
+			// lsof exits non-zero without output when no sockets match
+			if _, ok := err.(*exec.ExitError); ok && len(strings.TrimSpace(string(output))) == 0 {
+				return
+			}
+			if msg := strings.TrimSpace(string(output)); msg != "" {
+				err = fmt.Errorf("%w: %s", err, msg)
+			}
+			yield(Socket{}, fmt.Errorf("running lsof: %w", err))
 			return
 		}
 
@@ -111,6 +126,15 @@ func Sockets(tcp, udp, listeningOnly, all bool) func(yield func(Socket) bool) {
 				localAddr, localPort = ParseAddrPort(addrField, ipv4PortRegex, ipv6PortRegex)
 			}
 
+			// The TYPE column tells IPv4 and IPv6 wildcard listeners apart
+			var sockFamily Family
+			switch fields[4] {
+			case "IPv4":
+				sockFamily = FamilyIPv4
+			case "IPv6":
+				sockFamily = FamilyIPv6
+			}
+
 			// Create socket object
 			socket := Socket{
 				Netid:       proto,
@@ -121,10 +145,16 @@ func Sockets(tcp, udp, listeningOnly, all bool) func(yield func(Socket) bool) {
 				RemotePort:  remotePort,
 				ProcessName: procName,
 				PID:         pid,
+				Family:      sockFamily,
+			}
+
+			// Skip sockets of the other address family
+			if !socket.MatchFamily(family) {
+				continue
 			}
 
 			// Yield the socket to the callback
-			if !yield(socket) {
+			if !yield(socket, nil) {
 				break // Stop iteration if callback returns false
 			}
 		}
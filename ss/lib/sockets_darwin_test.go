@@ -0,0 +1,34 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSocketsError(t *testing.T) {
+	lsof := filepath.Join(t.TempDir(), "lsof")
+	if err := os.WriteFile(lsof, []byte("#!/bin/sh\necho 'lsof: permission denied' >&2\nexit 2\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	saved := lsofPath
+	lsofPath = lsof
+	defer func() { lsofPath = saved }()
+
+	var errs []error
+	for s, err := range Sockets(true, false, FamilyAny, false, false) {
+		if err == nil {
+			t.Errorf("unexpected socket %+v", s)
+			continue
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "permission denied") {
+		t.Errorf("got errors %v, want one permission denied error", errs)
+	}
+
+	if _, err := GetSockets(true, false, FamilyAny, false, false); err == nil {
+		t.Error("GetSockets: expected error")
+	}
+}
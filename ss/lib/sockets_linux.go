@@ -0,0 +1,135 @@
+package lib
+
+import (
+	"fmt"
+	"iter"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// procNetDir is where the kernel's socket tables are read from
+var procNetDir = "/proc/net"
+
+// GetSockets retrieves socket information on Linux from /proc/net
+func GetSockets(tcp, udp bool, family Family, listeningOnly, all bool) ([]Socket, error) {
+	var sockets []Socket
+
+	// Use the range function to collect all sockets
+	for s, err := range Sockets(tcp, udp, family, listeningOnly, all) {
+		if err != nil {
+			return nil, err
+		}
+		sockets = append(sockets, s)
+	}
+
+	return sockets, nil
+}
+
+// Sockets returns an iterator that yields socket information one by one.
+// If the socket tables can't be read, it yields the error and stops.
+func Sockets(tcp, udp bool, family Family, listeningOnly, all bool) iter.Seq2[Socket, error] {
+	return func(yield func(Socket, error) bool) {
+		sockets, err := readProcSockets(tcp, udp)
+		if err != nil {
+			yield(Socket{}, err)
+			return
+		}
+		for s, err := range SnapshotSockets(sockets, tcp, udp, family, listeningOnly, all) {
+			if !yield(s, err) {
+				return
+			}
+		}
+	}
+}
+
+// readProcSockets reads the requested /proc/net tables and attributes each
+// socket to the process holding it
+func readProcSockets(tcp, udp bool) ([]Socket, error) {
+	var files []string
+	if tcp {
+		files = append(files, "tcp", "tcp6")
+	}
+	if udp {
+		files = append(files, "udp", "udp6")
+	}
+
+	var entries []procSocket
+	for _, name := range files {
+		f, err := os.Open(filepath.Join(procNetDir, name))
+		if os.IsNotExist(err) {
+			// IPv6 may be disabled
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := parseProcNet(f, strings.TrimSuffix(name, "6"))
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", f.Name(), err)
+		}
+		entries = append(entries, parsed...)
+	}
+
+	owners := socketOwners()
+	sockets := make([]Socket, 0, len(entries))
+	for _, e := range entries {
+		if owner, ok := owners[e.Inode]; ok {
+			e.ProcessName = owner.ProcessName
+			e.PID = owner.PID
+		}
+		sockets = append(sockets, e.Socket)
+	}
+	return sockets, nil
+}
+
+// socketOwner identifies the process holding a socket
+type socketOwner struct {
+	ProcessName string
+	PID         int
+}
+
+// socketOwners maps socket inodes to processes by walking /proc/*/fd.
+// Processes that can't be inspected, typically those of other users when
+// not running as root, are skipped.
+func socketOwners() map[uint64]socketOwner {
+	owners := make(map[uint64]socketOwner)
+
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return owners
+	}
+	for _, proc := range procs {
+		pid, err := strconv.Atoi(proc.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join("/proc", proc.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+
+		var name string
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			inode, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]"), 10, 64)
+			if err != nil {
+				continue
+			}
+			if name == "" {
+				comm, _ := os.ReadFile(filepath.Join("/proc", proc.Name(), "comm"))
+				name = strings.TrimSpace(string(comm))
+			}
+			if _, ok := owners[inode]; !ok {
+				owners[inode] = socketOwner{ProcessName: name, PID: pid}
+			}
+		}
+	}
+	return owners
+}
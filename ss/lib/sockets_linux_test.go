@@ -0,0 +1,34 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSocketsError(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "tcp"), []byte("header\n 0: not-an-address 00000000:0000 0A 0 0 0 0 0 1 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	saved := procNetDir
+	procNetDir = dir
+	defer func() { procNetDir = saved }()
+
+	var errs []error
+	for s, err := range Sockets(true, false, FamilyAny, false, false) {
+		if err == nil {
+			t.Errorf("unexpected socket %+v", s)
+			continue
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "invalid address") {
+		t.Errorf("got errors %v, want one invalid address error", errs)
+	}
+
+	if _, err := GetSockets(true, false, FamilyAny, false, false); err == nil {
+		t.Error("GetSockets: expected error")
+	}
+}
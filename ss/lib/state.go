@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+)
+
+// stateNames maps the friendly names accepted by --state to the states
+// reported by /proc/net and lsof, which spell some of them differently
+var stateNames = map[string][]string{
+	"established": {"ESTABLISHED"},
+	"syn-sent":    {"SYN_SENT"},
+	"syn-recv":    {"SYN_RECV", "SYN_RECEIVED"},
+	"fin-wait-1":  {"FIN_WAIT1", "FIN_WAIT_1"},
+	"fin-wait-2":  {"FIN_WAIT2", "FIN_WAIT_2"},
+	"time-wait":   {"TIME_WAIT"},
+	"close":       {"CLOSE", "CLOSED"},
+	"close-wait":  {"CLOSE_WAIT"},
+	"last-ack":    {"LAST_ACK"},
+	"listen":      {"LISTEN"},
+	"closing":     {"CLOSING"},
+	"unconn":      {"UNCONN"},
+}
+
+// StateFilter is a set of socket states, as reported in Socket.State
+type StateFilter map[string]bool
+
+// ParseStateFilter parses a comma-separated list of state names such as
+// "established,time-wait". Names are case-insensitive and may use
+// underscores in place of hyphens.
+func ParseStateFilter(s string) (StateFilter, error) {
+	filter := make(StateFilter)
+	for _, name := range strings.Split(s, ",") {
+		key := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), "_", "-")
+		states, ok := stateNames[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown state %q (valid states: %s)", strings.TrimSpace(name), strings.Join(StateNames(), ", "))
+		}
+		for _, state := range states {
+			filter[state] = true
+		}
+	}
+	return filter, nil
+}
+
+// StateNames returns the names accepted by ParseStateFilter, sorted
+func StateNames() []string {
+	names := make([]string, 0, len(stateNames))
+	for name := range stateNames {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Match reports whether state is in the set. A nil filter matches every
+// state.
+func (f StateFilter) Match(state string) bool {
+	return f == nil || f[strings.ToUpper(state)]
+}
+
+// FilterStates returns an iterator over the sockets whose state is in
+// states. Errors are passed through.
+func FilterStates(sockets iter.Seq2[Socket, error], states StateFilter) iter.Seq2[Socket, error] {
+	return func(yield func(Socket, error) bool) {
+		for s, err := range sockets {
+			if err == nil && !states.Match(s.State) {
+				continue
+			}
+			if !yield(s, err) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package lib
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseStateFilter(t *testing.T) {
+	filter, err := ParseStateFilter("Established, TIME_WAIT,fin-wait-1")
+	if err != nil {
+		t.Fatalf("ParseStateFilter failed: %v", err)
+	}
+	for _, state := range []string{"ESTABLISHED", "TIME_WAIT", "FIN_WAIT1", "FIN_WAIT_1"} {
+		if !filter.Match(state) {
+			t.Errorf("filter should match %s", state)
+		}
+	}
+	for _, state := range []string{"LISTEN", "CLOSE_WAIT", "UNCONN"} {
+		if filter.Match(state) {
+			t.Errorf("filter should not match %s", state)
+		}
+	}
+
+	var none StateFilter
+	if !none.Match("LISTEN") {
+		t.Error("nil filter should match every state")
+	}
+}
+
+func TestParseStateFilterUnknown(t *testing.T) {
+	for _, input := range []string{"bogus", "established,bogus", "", "listen,"} {
+		_, err := ParseStateFilter(input)
+		if err == nil {
+			t.Errorf("ParseStateFilter(%q): expected error", input)
+			continue
+		}
+		if !strings.Contains(err.Error(), "valid states: close, close-wait,") {
+			t.Errorf("ParseStateFilter(%q): error should list valid states, got %v", input, err)
+		}
+	}
+}
+
+func TestFilterStates(t *testing.T) {
+	sockets, err := ReadSnapshot(strings.NewReader(snapshot))
+	if err != nil {
+		t.Fatalf("ReadSnapshot failed: %v", err)
+	}
+
+	tests := []struct {
+		states string
+		want   []int
+	}{
+		{"listen", []int{1}},
+		{"established", []int{42}},
+		{"established,unconn", []int{42, 7}},
+		{"time-wait", nil},
+	}
+	for _, tt := range tests {
+		filter, err := ParseStateFilter(tt.states)
+		if err != nil {
+			t.Fatalf("ParseStateFilter(%q) failed: %v", tt.states, err)
+		}
+		var pids []int
+		for s, err := range FilterStates(SnapshotSockets(sockets, true, true, FamilyAny, false, true), filter) {
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			pids = append(pids, s.PID)
+		}
+		if !slices.Equal(pids, tt.want) {
+			t.Errorf("--state %s: got PIDs %v, want %v", tt.states, pids, tt.want)
+		}
+	}
+}
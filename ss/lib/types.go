@@ -1,13 +1,95 @@
 package lib
 
-// Socket represents a network socket
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Socket represents a network socket. The JSON keys match the field names
+// case-insensitively, so snapshots written before the tags existed still load.
 type Socket struct {
-	Netid       string // Protocol (tcp, udp)
-	State       string // Socket state (LISTEN, ESTABLISHED, etc.)
-	LocalAddr   string // Local address
-	LocalPort   int    // Local port
-	RemoteAddr  string // Remote address
-	RemotePort  int    // Remote port
-	ProcessName string // Process name
-	PID         int    // Process ID
+	Netid       string `json:"netid"`                // Protocol (tcp, udp)
+	State       string `json:"state"`                // Socket state (LISTEN, ESTABLISHED, etc.)
+	LocalAddr   string `json:"localAddr"`            // Local address
+	LocalPort   int    `json:"localPort"`            // Local port
+	LocalHost   string `json:"localHost,omitempty"`  // Resolved local host name, if any
+	RemoteAddr  string `json:"remoteAddr"`           // Remote address
+	RemotePort  int    `json:"remotePort"`           // Remote port
+	RemoteHost  string `json:"remoteHost,omitempty"` // Resolved remote host name, if any
+	ProcessName string `json:"processName"`          // Process name
+	PID         int    `json:"pid"`                  // Process ID
+
+	// Family is the address family of the socket itself, when the source
+	// reports it. It tells wildcard listeners on IPv4 and IPv6 apart, since
+	// both print their address as "*".
+	Family Family `json:"family,omitempty"`
+}
+
+// Family is an address family to filter sockets by
+type Family int
+
+const (
+	FamilyAny  Family = iota // IPv4 and IPv6
+	FamilyIPv4               // IPv4 only
+	FamilyIPv6               // IPv6 only
+)
+
+// String names the family as in snapshots: "ipv4", "ipv6" or "any"
+func (f Family) String() string {
+	switch f {
+	case FamilyIPv4:
+		return "ipv4"
+	case FamilyIPv6:
+		return "ipv6"
+	}
+	return "any"
+}
+
+// MarshalText writes the family by name, so snapshots stay readable
+func (f Family) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+// UnmarshalText reads a family written by MarshalText
+func (f *Family) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "ipv4":
+		*f = FamilyIPv4
+	case "ipv6":
+		*f = FamilyIPv6
+	case "any", "":
+		*f = FamilyAny
+	default:
+		return fmt.Errorf("unknown address family %q", text)
+	}
+	return nil
+}
+
+// AddrFamily classifies a socket by its recorded family, or else by its
+// local address. A wildcard "*" address without a recorded family, as in
+// older snapshots, doesn't reveal its family, so it is FamilyAny.
+func (s Socket) AddrFamily() Family {
+	if s.Family != FamilyAny {
+		return s.Family
+	}
+	ip := net.ParseIP(s.LocalAddr)
+	switch {
+	case ip == nil:
+		return FamilyAny
+	case strings.Contains(s.LocalAddr, ":"):
+		return FamilyIPv6
+	default:
+		return FamilyIPv4
+	}
+}
+
+// MatchFamily reports whether a socket belongs to the family. Sockets of
+// unknown family match either one.
+func (s Socket) MatchFamily(family Family) bool {
+	if family == FamilyAny {
+		return true
+	}
+	f := s.AddrFamily()
+	return f == FamilyAny || f == family
 }
@@ -0,0 +1,64 @@
+package lib
+
+import (
+	"context"
+	"iter"
+	"time"
+)
+
+// socketKey identifies a socket from one refresh to the next. Its state and
+// resolved host names may change while it stays the same socket.
+type socketKey struct {
+	netid      string
+	localAddr  string
+	localPort  int
+	remoteAddr string
+	remotePort int
+	pid        int
+	family     Family
+}
+
+func keyOf(s Socket) socketKey {
+	return socketKey{s.Netid, s.LocalAddr, s.LocalPort, s.RemoteAddr, s.RemotePort, s.PID, s.Family}
+}
+
+// Watch collects sockets now and then once every interval until ctx is done,
+// passing each refresh to render along with a function reporting whether a
+// socket is new since the refresh before. Nothing is new on the first
+// refresh. Sockets is ranged over afresh each time, so an iterator from
+// Sockets lists the live system again. An error from sockets or render
+// stops the watch; ctx being done ends it without error.
+func Watch(ctx context.Context, interval time.Duration, sockets iter.Seq2[Socket, error], render func(current []Socket, isNew func(Socket) bool) error) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var previous map[socketKey]bool
+	for {
+		var current []Socket
+		for s, err := range sockets {
+			if err != nil {
+				return err
+			}
+			current = append(current, s)
+		}
+
+		seen := previous
+		isNew := func(s Socket) bool {
+			return seen != nil && !seen[keyOf(s)]
+		}
+		if err := render(current, isNew); err != nil {
+			return err
+		}
+
+		previous = make(map[socketKey]bool, len(current))
+		for _, s := range current {
+			previous[keyOf(s)] = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"slices"
+	"testing"
+	"time"
+)
+
+// fakeSockets yields the next listing in turn each time it is ranged over
+func fakeSockets(listings ...[]Socket) iter.Seq2[Socket, error] {
+	calls := 0
+	return func(yield func(Socket, error) bool) {
+		listing := listings[min(calls, len(listings)-1)]
+		calls++
+		for _, s := range listing {
+			if !yield(s, nil) {
+				return
+			}
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	sshd := Socket{Netid: "tcp", State: "LISTEN", LocalAddr: "*", LocalPort: 22, ProcessName: "sshd", PID: 1}
+	conn := Socket{Netid: "tcp", State: "SYN_SENT", LocalAddr: "10.0.0.5", LocalPort: 51000, RemoteAddr: "10.0.0.9", RemotePort: 443, ProcessName: "curl", PID: 42}
+	established := conn
+	established.State = "ESTABLISHED"
+	api := Socket{Netid: "tcp", State: "LISTEN", LocalAddr: "127.0.0.1", LocalPort: 8080, ProcessName: "api", PID: 7}
+
+	sockets := fakeSockets(
+		[]Socket{sshd, conn},
+		[]Socket{sshd, established, api},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var refreshes [][]bool
+	err := Watch(ctx, time.Millisecond, sockets, func(current []Socket, isNew func(Socket) bool) error {
+		var flags []bool
+		for _, s := range current {
+			flags = append(flags, isNew(s))
+		}
+		refreshes = append(refreshes, flags)
+		if len(refreshes) == 2 {
+			cancel()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+
+	if len(refreshes) != 2 {
+		t.Fatalf("got %d refreshes, want 2", len(refreshes))
+	}
+	// Nothing is new on the first refresh; on the second only api is, since
+	// conn only changed state
+	if want := []bool{false, false}; !slices.Equal(refreshes[0], want) {
+		t.Errorf("first refresh new = %v, want %v", refreshes[0], want)
+	}
+	if want := []bool{false, false, true}; !slices.Equal(refreshes[1], want) {
+		t.Errorf("second refresh new = %v, want %v", refreshes[1], want)
+	}
+}
+
+func TestWatchError(t *testing.T) {
+	failing := func(yield func(Socket, error) bool) {
+		yield(Socket{}, errors.New("lsof failed"))
+	}
+	err := Watch(context.Background(), time.Millisecond, failing, func([]Socket, func(Socket) bool) error {
+		t.Error("render called despite the error")
+		return nil
+	})
+	if err == nil || err.Error() != "lsof failed" {
+		t.Errorf("Watch error = %v, want the socket error", err)
+	}
+
+	renderErr := errors.New("write failed")
+	err = Watch(context.Background(), time.Millisecond, fakeSockets(nil), func([]Socket, func(Socket) bool) error {
+		return renderErr
+	})
+	if !errors.Is(err, renderErr) {
+		t.Errorf("Watch error = %v, want the render error", err)
+	}
+}
@@ -1,38 +1,119 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"iter"
 	"net"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/presbrey/cmd/ss/lib"
 )
 
 func main() {
 	// Define flags but don't use the flag package for parsing
-	var numeric, listening, process, tcp, udp, all, help bool
+	var numeric, listening, process, tcp, udp, ipv4, ipv6, all, jsonOutput, help bool
+	var from string
+	var sport, dport *lib.PortFilter
+	var states lib.StateFilter
+	var processFilter *lib.ProcessFilter
+	var interval time.Duration
+	resolveTimeout := 2 * time.Second
 
 	// Custom usage
 	usage := func() {
 		fmt.Printf("Usage: %s [options]\n\n", os.Args[0])
 		fmt.Println("Options:")
+		fmt.Println("  -4\tDisplay only IPv4 sockets")
+		fmt.Println("  -6\tDisplay only IPv6 sockets")
 		fmt.Println("  -a\tDisplay all sockets (listening and non-listening)")
 		fmt.Println("  -h\tDisplay help")
+		fmt.Println("  -i INTERVAL\tRefresh the listing every INTERVAL, e.g. 2s or 2, until interrupted")
 		fmt.Println("  -l\tDisplay only listening sockets")
 		fmt.Println("  -n\tShow numeric addresses instead of resolving host names")
-		fmt.Println("  -p\tShow process using socket")
+		fmt.Println("  -o, --json\tOutput sockets as a JSON array")
+		fmt.Println("  -p [PATTERN]\tShow process using socket, only sockets whose process name matches the PATTERN regular expression if given")
 		fmt.Println("  -t\tDisplay TCP sockets")
 		fmt.Println("  -u\tDisplay UDP sockets")
+		fmt.Println("  --from FILE\tRead sockets from a saved JSON snapshot instead of the live system")
+		fmt.Println("  --sport FILTER\tShow only sockets whose local port matches, e.g. 8080 or \">=1024\"")
+		fmt.Println("  --dport FILTER\tShow only sockets whose remote port matches, e.g. 443 or \"!=22\"")
+		fmt.Println("  --state LIST\tShow only sockets in the given comma-separated states, e.g. established,time-wait (overrides -l and -a)")
+		fmt.Println("  --resolve-timeout DURATION\tGive up resolving a host name after this long (default 2s, 0 to wait indefinitely)")
 		fmt.Println("\nExamples:")
 		fmt.Println("  ss -t       # Show TCP sockets")
 		fmt.Println("  ss -ua      # Show all UDP sockets")
 		fmt.Println("  ss -nlpt    # Show listening TCP socket processes in numeric format")
+		fmt.Println("  ss -t6      # Show IPv6 TCP sockets")
+		fmt.Println("  ss -t -p nginx  # Show TCP sockets owned by nginx processes")
+		fmt.Println("  ss -t -i 2s # Watch TCP sockets, refreshing every 2 seconds")
+		fmt.Println("  ss -t --dport 443  # Show TCP connections to HTTPS servers")
+		fmt.Println("  ss -t --state time-wait,close-wait  # Show TCP connections being torn down")
+		fmt.Println("  ss -tnlp --json > capture.json  # Save a snapshot of listening TCP sockets")
+		fmt.Println("  ss -tn --from capture.json  # Show TCP sockets from a snapshot")
 	}
 
 	// Parse command line arguments manually to support combined flags
+args:
 	for i := 1; i < len(os.Args); i++ {
 		arg := os.Args[i]
+
+		// Long options take a value, either inline or as the next argument
+		if name, value, hasValue := strings.Cut(arg, "="); name == "--from" || name == "--resolve-timeout" || name == "--sport" || name == "--dport" || name == "--state" {
+			if !hasValue {
+				if i+1 >= len(os.Args) {
+					fmt.Fprintf(os.Stderr, "Option %s requires a value\n", name)
+					usage()
+					os.Exit(1)
+				}
+				i++
+				value = os.Args[i]
+			}
+			switch name {
+			case "--from":
+				from = value
+			case "--resolve-timeout":
+				d, err := time.ParseDuration(value)
+				if err != nil || d < 0 {
+					fmt.Fprintf(os.Stderr, "Invalid %s: %s\n", name, value)
+					usage()
+					os.Exit(1)
+				}
+				resolveTimeout = d
+			case "--sport", "--dport":
+				filter, err := lib.ParsePortFilter(value)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Invalid %s: %v\n", name, err)
+					usage()
+					os.Exit(1)
+				}
+				if name == "--sport" {
+					sport = filter
+				} else {
+					dport = filter
+				}
+			case "--state":
+				filter, err := lib.ParseStateFilter(value)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Invalid %s: %v\n", name, err)
+					usage()
+					os.Exit(1)
+				}
+				states = filter
+			}
+			continue
+		}
+
+		if arg == "--json" {
+			jsonOutput = true
+			continue
+		}
+
 		if !strings.HasPrefix(arg, "-") || strings.HasPrefix(arg, "--") {
 			fmt.Fprintf(os.Stderr, "Unknown argument: %s\n", arg)
 			usage()
@@ -40,20 +121,61 @@ func main() {
 		}
 
 		// Process each character in the flag
-		for _, c := range arg[1:] {
+		for j, c := range arg[1:] {
 			switch c {
+			case 'i':
+				// The interval is the rest of the argument, as in -i2s,
+				// or the next one
+				value := arg[j+2:]
+				if value == "" {
+					if i+1 >= len(os.Args) {
+						fmt.Fprintln(os.Stderr, "Option -i requires an interval")
+						usage()
+						os.Exit(1)
+					}
+					i++
+					value = os.Args[i]
+				}
+				d, err := parseInterval(value)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Invalid -i: %v\n", err)
+					usage()
+					os.Exit(1)
+				}
+				interval = d
+				continue args
 			case 'n':
 				numeric = true
 			case 'l':
 				listening = true
 			case 'p':
 				process = true
+				// A pattern may follow -p, or a cluster ending in p such
+				// as -tp, as the next argument. ss takes no other
+				// positional arguments, so anything not starting with -
+				// is one.
+				if j == len(arg)-2 && i+1 < len(os.Args) && !strings.HasPrefix(os.Args[i+1], "-") {
+					i++
+					filter, err := lib.ParseProcessFilter(os.Args[i])
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Invalid -p: %v\n", err)
+						usage()
+						os.Exit(1)
+					}
+					processFilter = filter
+				}
 			case 't':
 				tcp = true
 			case 'u':
 				udp = true
 			case 'a':
 				all = true
+			case 'o':
+				jsonOutput = true
+			case '4':
+				ipv4 = true
+			case '6':
+				ipv6 = true
 			case 'h':
 				usage()
 				os.Exit(0)
@@ -76,15 +198,151 @@ func main() {
 		tcp = true
 	}
 
+	// Restrict to one address family only when exactly one is given
+	family := lib.FamilyAny
+	if ipv4 && !ipv6 {
+		family = lib.FamilyIPv4
+	} else if ipv6 && !ipv4 {
+		family = lib.FamilyIPv6
+	}
+
+	// An explicit state list replaces the listening/all selection
+	if states != nil {
+		listening, all = false, true
+	}
+
+	// Read sockets from a snapshot file or the live system
+	sockets := lib.Sockets(tcp, udp, family, listening, all)
+	if from != "" {
+		snapshot, err := readSnapshotFile(from)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		sockets = lib.SnapshotSockets(snapshot, tcp, udp, family, listening, all)
+	}
+	if sport != nil || dport != nil {
+		sockets = lib.FilterPorts(sockets, sport, dport)
+	}
+	if states != nil {
+		sockets = lib.FilterStates(sockets, states)
+	}
+	if processFilter != nil {
+		sockets = lib.FilterProcesses(sockets, processFilter)
+	}
+
+	// Each address is resolved at most once per run
+	var hosts *lib.HostCache
+	if !numeric {
+		hosts = lib.NewHostCache(net.DefaultResolver, resolveTimeout)
+	}
+
+	if interval > 0 {
+		if jsonOutput {
+			fmt.Fprintln(os.Stderr, "Error: -i cannot be combined with --json")
+			os.Exit(1)
+		}
+		// Interrupting the watch is the normal way to end it
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := watchSockets(ctx, interval, sockets, hosts, process); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if jsonOutput {
+		if err := writeSocketsJSON(sockets, hosts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Display socket information using range function
-	displaySocketsWithRange(tcp, udp, listening, all, numeric, process)
+	if err := displaySocketsWithRange(sockets, hosts, process); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// readSnapshotFile loads a socket snapshot previously saved as JSON
+func readSnapshotFile(path string) ([]lib.Socket, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return lib.ReadSnapshot(file)
 }
 
 // getSockets retrieves socket information based on the specified filters
 // Platform-specific implementation is in sockets_*.go files
 
-// displaySocketsWithRange uses the range function to display sockets
-func displaySocketsWithRange(tcp, udp, listening, all, numeric, showProcess bool) {
+// writeSocketsJSON collects every socket and writes them to stdout as a JSON
+// array, with host names when hosts is set
+func writeSocketsJSON(sockets iter.Seq2[lib.Socket, error], hosts *lib.HostCache) error {
+	var all []lib.Socket
+	for s, err := range sockets {
+		if err != nil {
+			return err
+		}
+		if hosts != nil {
+			s = hosts.Resolve(s)
+		}
+		all = append(all, s)
+	}
+	return lib.WriteSnapshot(os.Stdout, all)
+}
+
+// parseInterval parses a -i interval, either a duration such as 500ms or a
+// number of seconds as watch takes it
+func parseInterval(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		seconds, ferr := strconv.ParseFloat(s, 64)
+		if ferr != nil {
+			return 0, fmt.Errorf("%q is not a duration or a number of seconds", s)
+		}
+		d = time.Duration(seconds * float64(time.Second))
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("interval must be positive, got %s", s)
+	}
+	return d, nil
+}
+
+// watchSockets redraws the socket table every interval until ctx is done,
+// like watch, showing sockets that appeared since the last refresh in
+// reverse video
+func watchSockets(ctx context.Context, interval time.Duration, sockets iter.Seq2[lib.Socket, error], hosts *lib.HostCache, showProcess bool) error {
+	command := "ss " + strings.Join(os.Args[1:], " ")
+	return lib.Watch(ctx, interval, sockets, func(current []lib.Socket, isNew func(lib.Socket) bool) error {
+		// Move home and clear the screen
+		fmt.Print("\033[H\033[2J")
+		fmt.Printf("Every %s: %s    %s\n\n", interval, command, time.Now().Format(time.DateTime))
+		listing := func(yield func(lib.Socket, error) bool) {
+			for _, s := range current {
+				if !yield(s, nil) {
+					return
+				}
+			}
+		}
+		return displaySockets(listing, hosts, showProcess, isNew)
+	})
+}
+
+// displaySocketsWithRange uses the range function to display sockets,
+// stopping at the first error. Addresses are shown as host names when hosts
+// is set and they resolve.
+func displaySocketsWithRange(sockets iter.Seq2[lib.Socket, error], hosts *lib.HostCache, showProcess bool) error {
+	return displaySockets(sockets, hosts, showProcess, nil)
+}
+
+// displaySockets is displaySocketsWithRange, showing the sockets highlight
+// reports true for in reverse video
+func displaySockets(sockets iter.Seq2[lib.Socket, error], hosts *lib.HostCache, showProcess bool, highlight func(lib.Socket) bool) error {
 	// Print header in the style of the actual ss command
 	fmt.Printf("%-5s %-11s %-23s %-23s", "Netid", "State", "Local Address:Port", "Peer Address:Port")
 	if showProcess {
@@ -93,24 +351,22 @@ func displaySocketsWithRange(tcp, udp, listening, all, numeric, showProcess bool
 	fmt.Println()
 
 	// Use range function to process each socket
-	for s := range lib.Sockets(tcp, udp, listening, all) {
+	for s, err := range sockets {
+		if err != nil {
+			return err
+		}
+		highlighted := highlight != nil && highlight(s)
 		localAddr := s.LocalAddr
 		remoteAddr := s.RemoteAddr
 
 		// Resolve addresses if not numeric
-		if !numeric {
-			if localAddr != "*" && net.ParseIP(localAddr) != nil {
-				names, err := net.LookupAddr(localAddr)
-				if err == nil && len(names) > 0 {
-					localAddr = strings.TrimSuffix(names[0], ".")
-				}
+		if hosts != nil {
+			s = hosts.Resolve(s)
+			if s.LocalHost != "" {
+				localAddr = s.LocalHost
 			}
-
-			if remoteAddr != "" && remoteAddr != "*" && net.ParseIP(remoteAddr) != nil {
-				names, err := net.LookupAddr(remoteAddr)
-				if err == nil && len(names) > 0 {
-					remoteAddr = strings.TrimSuffix(names[0], ".")
-				}
+			if s.RemoteHost != "" {
+				remoteAddr = s.RemoteHost
 			}
 		}
 
@@ -122,6 +378,9 @@ func displaySocketsWithRange(tcp, udp, listening, all, numeric, showProcess bool
 		}
 
 		// Print socket information
+		if highlighted {
+			fmt.Print("\033[7m")
+		}
 		fmt.Printf("%-5s %-11s %-23s %-23s", s.Netid, s.State, localAddrPort, remoteAddrPort)
 
 		// Print process information if requested
@@ -129,8 +388,12 @@ func displaySocketsWithRange(tcp, udp, listening, all, numeric, showProcess bool
 			fmt.Printf(" %-20s", fmt.Sprintf("%s(%d)", s.ProcessName, s.PID))
 		}
 
+		if highlighted {
+			fmt.Print("\033[0m")
+		}
 		fmt.Println()
 	}
+	return nil
 }
 
 func formatAddrPort(addr string, port int) string {
@@ -0,0 +1,256 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// bareBuiltins are the builtins that take no arguments and, like jq's,
+// are written without parentheses
+var bareBuiltins = map[string]bool{
+	"keys":   true,
+	"length": true,
+	"values": true,
+}
+
+// parseCall recognizes a builtin function call such as test("^v\\d") or
+// length and returns the function name and its raw, semicolon-separated
+// arguments
+func parseCall(expr string) (string, []string, bool) {
+	expr = strings.TrimSpace(expr)
+	if bareBuiltins[expr] {
+		return expr, nil, true
+	}
+	open := strings.IndexByte(expr, '(')
+	if open <= 0 || !strings.HasSuffix(expr, ")") {
+		return "", nil, false
+	}
+
+	name := expr[:open]
+	for _, r := range name {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return "", nil, false
+		}
+	}
+
+	var args []string
+	for _, arg := range splitTopLevel(expr[open+1:len(expr)-1], ';') {
+		args = append(args, strings.TrimSpace(arg))
+	}
+	return name, args, true
+}
+
+// splitTopLevel splits s on sep wherever it appears outside brackets,
+// parentheses and string literals
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	depth := 0
+	inString := false
+	last := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if inString {
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '[', '(':
+			depth++
+		case ']', ')':
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, s[last:i])
+				last = i + 1
+			}
+		}
+	}
+	return append(parts, s[last:])
+}
+
+// callBuiltin applies the named builtin function to a value and returns the
+// results it produces
+func callBuiltin(value interface{}, name string, args []string) ([]interface{}, error) {
+	switch name {
+	case "keys", "length", "values":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("%s takes no arguments", name)
+		}
+		switch name {
+		case "keys":
+			return builtinKeys(value)
+		case "length":
+			return builtinLength(value)
+		default:
+			// Like jq's values, pass through everything but null
+			if value == nil {
+				return nil, nil
+			}
+			return []interface{}{value}, nil
+		}
+	case "test", "match", "capture", "splits":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s expects 1 argument, got %d", name, len(args))
+		}
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s cannot be applied to non-string %v", name, value)
+		}
+		re, err := parseRegexArg(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		switch name {
+		case "test":
+			return []interface{}{re.MatchString(s)}, nil
+		case "match":
+			return regexMatch(re, s), nil
+		case "capture":
+			return regexCapture(re, s), nil
+		default:
+			return regexSplits(re, s), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown function: %s", name)
+}
+
+// builtinKeys returns an object's keys in sorted order, or an array's
+// indexes, as jq's keys does
+func builtinKeys(value interface{}) ([]interface{}, error) {
+	if arr, ok := value.([]interface{}); ok {
+		indexes := make([]interface{}, len(arr))
+		for i := range arr {
+			indexes[i] = i
+		}
+		return []interface{}{indexes}, nil
+	}
+	names, ok := objectKeys(value)
+	if !ok {
+		return nil, fmt.Errorf("keys cannot be applied to %s", typeName(value))
+	}
+	sort.Strings(names)
+	keys := make([]interface{}, len(names))
+	for i, name := range names {
+		keys[i] = name
+	}
+	return []interface{}{keys}, nil
+}
+
+// builtinLength measures a value as jq's length does: elements of an array,
+// keys of an object, characters of a string, 0 for null and the absolute
+// value of a number
+func builtinLength(value interface{}) ([]interface{}, error) {
+	switch v := value.(type) {
+	case nil:
+		return []interface{}{0}, nil
+	case string:
+		return []interface{}{utf8.RuneCountInString(v)}, nil
+	case []interface{}:
+		return []interface{}{len(v)}, nil
+	}
+	if keys, ok := objectKeys(value); ok {
+		return []interface{}{len(keys)}, nil
+	}
+	if i, f, integral, ok := toNumber(value); ok {
+		if integral {
+			if i < 0 {
+				i = -i
+			}
+			return []interface{}{i}, nil
+		}
+		return []interface{}{math.Abs(f)}, nil
+	}
+	return nil, fmt.Errorf("%s has no length", typeName(value))
+}
+
+// parseRegexArg decodes a JSON string literal argument and compiles it
+func parseRegexArg(arg string) (*regexp.Regexp, error) {
+	var pattern string
+	if err := json.Unmarshal([]byte(arg), &pattern); err != nil {
+		return nil, fmt.Errorf("expected a string literal, got %s", arg)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex: %w", err)
+	}
+	return re, nil
+}
+
+// regexMatch describes the first match like jq's match, with offsets and
+// lengths counted in characters. It produces no result when nothing matches.
+func regexMatch(re *regexp.Regexp, s string) []interface{} {
+	loc := re.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return nil
+	}
+
+	names := re.SubexpNames()
+	captures := []interface{}{}
+	for i := 1; i < len(names); i++ {
+		capture := map[string]interface{}{
+			"offset": -1,
+			"length": 0,
+			"string": nil,
+			"name":   nil,
+		}
+		if names[i] != "" {
+			capture["name"] = names[i]
+		}
+		if loc[2*i] >= 0 {
+			capture["offset"] = utf8.RuneCountInString(s[:loc[2*i]])
+			capture["length"] = utf8.RuneCountInString(s[loc[2*i]:loc[2*i+1]])
+			capture["string"] = s[loc[2*i]:loc[2*i+1]]
+		}
+		captures = append(captures, capture)
+	}
+
+	return []interface{}{map[string]interface{}{
+		"offset":   utf8.RuneCountInString(s[:loc[0]]),
+		"length":   utf8.RuneCountInString(s[loc[0]:loc[1]]),
+		"string":   s[loc[0]:loc[1]],
+		"captures": captures,
+	}}
+}
+
+// regexCapture returns the named groups of the first match as an object,
+// producing no result when nothing matches
+func regexCapture(re *regexp.Regexp, s string) []interface{} {
+	match := re.FindStringSubmatchIndex(s)
+	if match == nil {
+		return nil
+	}
+
+	result := map[string]interface{}{}
+	for i, name := range re.SubexpNames() {
+		if i == 0 || name == "" {
+			continue
+		}
+		if match[2*i] < 0 {
+			result[name] = nil
+		} else {
+			result[name] = s[match[2*i]:match[2*i+1]]
+		}
+	}
+	return []interface{}{result}
+}
+
+// regexSplits splits a string around every match of the regex, producing
+// each piece as a separate result
+func regexSplits(re *regexp.Regexp, s string) []interface{} {
+	parts := []interface{}{}
+	for _, part := range re.Split(s, -1) {
+		parts = append(parts, part)
+	}
+	return parts
+}
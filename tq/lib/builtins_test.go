@@ -0,0 +1,174 @@
+package lib
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRegexBuiltins(t *testing.T) {
+	data := map[string]interface{}{
+		"version": "v1.24.3",
+		"name":    "héllo world",
+		"csv":     "a, b,c",
+		"port":    int64(8080),
+	}
+
+	tests := []struct {
+		filter string
+		want   []interface{}
+	}{
+		{filter: `.version | test("^v1\\.")`, want: []interface{}{true}},
+		{filter: `.version | test("^v2")`, want: []interface{}{false}},
+		{filter: `.name | test("(?i)HELLO")`, want: []interface{}{false}},
+		{filter: `.name | test("(?i)HÉLLO")`, want: []interface{}{true}},
+		{
+			filter: `.version | capture("(?<major>\\d+)\\.(?<minor>\\d+)")`,
+			want:   []interface{}{map[string]interface{}{"major": "1", "minor": "24"}},
+		},
+		{
+			filter: `.version | capture("(?P<major>\\d+)(?:-(?P<pre>\\w+))?")`,
+			want:   []interface{}{map[string]interface{}{"major": "1", "pre": nil}},
+		},
+		{filter: `.version | capture("^x(?<y>y)")`, want: nil},
+		{
+			filter: `.name | match("w(or)")`,
+			want: []interface{}{map[string]interface{}{
+				"offset": 6,
+				"length": 3,
+				"string": "wor",
+				"captures": []interface{}{
+					map[string]interface{}{"offset": 7, "length": 2, "string": "or", "name": nil},
+				},
+			}},
+		},
+		{filter: `.name | match("xyz")`, want: nil},
+		{filter: `.csv | splits(", *")`, want: []interface{}{"a", "b", "c"}},
+		{filter: `.csv|splits("\\|")`, want: []interface{}{"a, b,c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			got, err := applyFilter(data, tt.filter)
+			if err != nil {
+				t.Fatalf("applyFilter(%q) failed: %v", tt.filter, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("applyFilter(%q) = %#v, want %#v", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegexBuiltinErrors(t *testing.T) {
+	data := map[string]interface{}{
+		"version": "v1.24.3",
+		"port":    int64(8080),
+	}
+
+	tests := []struct {
+		filter string
+		errMsg string
+	}{
+		{filter: `.port | test("80")`, errMsg: "non-string"},
+		{filter: `.version | test("(")`, errMsg: "invalid regex"},
+		{filter: `.version | test(abc)`, errMsg: "string literal"},
+		{filter: `.version | test()`, errMsg: "expected a string literal"},
+		{filter: `.version | test("a"; "b")`, errMsg: "expects 1 argument"},
+		{filter: `.version | nope("a")`, errMsg: "unknown function"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			_, err := applyFilter(data, tt.filter)
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("applyFilter(%q) error = %v, want %q", tt.filter, err, tt.errMsg)
+			}
+		})
+	}
+}
+
+func TestCaptureToJson(t *testing.T) {
+	input := strings.NewReader(`version = "2.7.1"`)
+	output := &bytes.Buffer{}
+
+	err := TomlToJsonWithFilter(input, output, `.version | capture("(?<major>\\d+)\\.(?<minor>\\d+)")`, true, false)
+	if err != nil {
+		t.Fatalf("TomlToJsonWithFilter failed: %v", err)
+	}
+	if got := strings.TrimSpace(output.String()); got != `{"major":"2","minor":"7"}` {
+		t.Errorf("Unexpected output: %s", got)
+	}
+}
+
+func TestBareBuiltins(t *testing.T) {
+	data := map[string]interface{}{
+		"users": []interface{}{"ann", "bob", "cy"},
+		"owner": map[string]interface{}{"name": "Tom", "dob": "1979", "bio": nil},
+		"name":  "héllo",
+		"delta": int64(-3),
+		"ratio": -1.5,
+		"empty": nil,
+	}
+	ordered := &OrderedMap{values: map[string]interface{}{}}
+	ordered.Set("zeta", int64(1))
+	ordered.Set("alpha", int64(2))
+
+	tests := []struct {
+		filter string
+		data   interface{}
+		want   []interface{}
+	}{
+		{filter: `.owner | keys`, want: []interface{}{[]interface{}{"bio", "dob", "name"}}},
+		{filter: `.users | keys`, want: []interface{}{[]interface{}{0, 1, 2}}},
+		{filter: `keys`, data: ordered, want: []interface{}{[]interface{}{"alpha", "zeta"}}},
+		{filter: `.owner | length`, want: []interface{}{3}},
+		{filter: `.users | length`, want: []interface{}{3}},
+		{filter: `.name | length`, want: []interface{}{5}},
+		{filter: `.empty | length`, want: []interface{}{0}},
+		{filter: `.delta | length`, want: []interface{}{int64(3)}},
+		{filter: `.ratio | length`, want: []interface{}{1.5}},
+		{filter: `.users[] | length`, want: []interface{}{3, 3, 2}},
+		{filter: `.users | length == 3`, want: []interface{}{true}},
+		{filter: `.owner | values`, want: []interface{}{data["owner"]}},
+		{filter: `.users | values`, want: []interface{}{data["users"]}},
+		{filter: `.name | values`, want: []interface{}{"héllo"}},
+		{filter: `.owner[] | values`, want: []interface{}{"1979", "Tom"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			input := tt.data
+			if input == nil {
+				input = data
+			}
+			got, err := applyFilter(input, tt.filter)
+			if err != nil {
+				t.Fatalf("applyFilter(%q) failed: %v", tt.filter, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("applyFilter(%q) = %#v, want %#v", tt.filter, got, tt.want)
+			}
+		})
+	}
+
+	errTests := []struct {
+		filter string
+		errMsg string
+	}{
+		{filter: `.name | keys`, errMsg: "keys cannot be applied to string"},
+		{filter: `.delta | keys`, errMsg: "keys cannot be applied to number"},
+		{filter: `.empty | keys`, errMsg: "keys cannot be applied to null"},
+		{filter: `.users | length(1)`, errMsg: "length takes no arguments"},
+	}
+	for _, tt := range errTests {
+		_, err := applyFilter(data, tt.filter)
+		if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+			t.Errorf("applyFilter(%q) error = %v, want %q", tt.filter, err, tt.errMsg)
+		}
+	}
+	if _, err := applyFilter(true, `length`); err == nil || !strings.Contains(err.Error(), "boolean has no length") {
+		t.Errorf("length of a boolean: error = %v", err)
+	}
+}
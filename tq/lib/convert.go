@@ -6,9 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
-
-	"github.com/pelletier/go-toml/v2"
 )
 
 // TomlToJson converts TOML data to JSON
@@ -21,135 +20,532 @@ func JsonToToml(input io.Reader, output io.Writer) error {
 	return JsonToTomlWithFilter(input, output, ".", false)
 }
 
+// YamlToJson converts YAML data to JSON
+func YamlToJson(input io.Reader, output io.Writer) error {
+	return YamlToJsonWithFilter(input, output, ".", false, false)
+}
+
+// JsonToYaml converts JSON data to YAML
+func JsonToYaml(input io.Reader, output io.Writer) error {
+	return JsonToYamlWithFilter(input, output, ".")
+}
+
 // TomlToJsonWithFilter converts TOML data to JSON with a filter expression
 func TomlToJsonWithFilter(input io.Reader, output io.Writer, filter string, compact bool, raw bool) error {
-	var data interface{}
-	
-	// Decode TOML
-	decoder := toml.NewDecoder(input)
-	if err := decoder.Decode(&data); err != nil {
-		return err
+	return ConvertWithFilter(input, output, FormatTOML, FormatJSON, filter, compact, raw)
+}
+
+// JsonToTomlWithFilter converts JSON data to TOML with a filter expression.
+// An optional TomlOptions sets the layout of the TOML.
+func JsonToTomlWithFilter(input io.Reader, output io.Writer, filter string, compact bool, tomlOpts ...TomlOptions) error {
+	opts := Options{Compact: compact}
+	if len(tomlOpts) > 0 {
+		opts.TOML = tomlOpts[0]
 	}
-	
-	// Apply filter
-	filtered, err := applyFilter(data, filter)
+	_, err := Convert(input, output, FormatJSON, FormatTOML, filter, opts)
+	return err
+}
+
+// YamlToJsonWithFilter converts YAML data to JSON with a filter expression
+func YamlToJsonWithFilter(input io.Reader, output io.Writer, filter string, compact bool, raw bool) error {
+	return ConvertWithFilter(input, output, FormatYAML, FormatJSON, filter, compact, raw)
+}
+
+// JsonToYamlWithFilter converts JSON data to YAML with a filter expression
+func JsonToYamlWithFilter(input io.Reader, output io.Writer, filter string) error {
+	return ConvertWithFilter(input, output, FormatJSON, FormatYAML, filter, false, false)
+}
+
+// Options controls how Convert decodes and encodes documents
+type Options struct {
+	// Compact disables pretty-printing of JSON output and drops the blank
+	// lines between tables in TOML output
+	Compact bool
+	// Raw writes JSON string results without quotes
+	Raw bool
+	// PreserveOrder keeps object keys in the order they appear in the
+	// input instead of sorting them. TOML output is always sorted.
+	PreserveOrder bool
+	// NullOnEmpty treats input that is empty or only whitespace as a null
+	// document instead of failing with an "empty input" error
+	NullOnEmpty bool
+	// RawInput passes each line of input to the filter as a string instead
+	// of decoding it as a document
+	RawInput bool
+	// NulInput splits raw input at NUL bytes instead of newlines, and
+	// implies RawInput
+	NulInput bool
+	// NulOutput writes each result raw followed by a NUL byte, whatever
+	// the output format
+	NulOutput bool
+	// NaNAs chooses how JSON output represents NaN and infinite numbers.
+	// The zero value behaves as NaNError.
+	NaNAs NaNMode
+	// Set assigns values into the document, in order, before the filter
+	// is applied. It cannot be combined with raw input.
+	Set []Assignment
+	// Vars binds the $name variables in the filter, as given by --arg and
+	// --argjson. Values must be numbers, strings, booleans or nil.
+	Vars map[string]interface{}
+	// TOML sets the layout of TOML output
+	TOML TomlOptions
+}
+
+// TomlOptions sets the layout of TOML output
+type TomlOptions struct {
+	// IndentTables indents each nested table, and its keys, one level
+	// deeper than its parent
+	IndentTables bool
+	// IndentSymbol is one level of indentation, two spaces when empty
+	IndentSymbol string
+}
+
+// ConvertWithFilter decodes input in one format, applies a filter expression
+// and encodes each result in another format. See Options for how compact and
+// raw apply to each output format.
+func ConvertWithFilter(input io.Reader, output io.Writer, from, to Format, filter string, compact bool, raw bool) error {
+	_, err := Convert(input, output, from, to, filter, Options{Compact: compact, Raw: raw})
+	return err
+}
+
+// Convert decodes input in one format, applies a filter expression and
+// encodes each result in another format according to opts. It returns the
+// results that were written.
+func Convert(input io.Reader, output io.Writer, from, to Format, filter string, opts Options) ([]interface{}, error) {
+	var filtered []interface{}
+	filter, err := substituteVars(filter, opts.Vars)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	
-	// Encode as JSON
-	encoder := json.NewEncoder(output)
-	if !compact {
-		encoder.SetIndent("", "  ")
+	if opts.RawInput || opts.NulInput {
+		if len(opts.Set) > 0 {
+			return nil, errSetRawInput
+		}
+		// Each raw record is filtered on its own
+		if filtered, err = filterRecords(input, filter, opts); err != nil {
+			return nil, err
+		}
+	} else {
+		data, err := decode(input, from, opts)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = applyAssignments(data, opts.Set); err != nil {
+			return nil, err
+		}
+
+		// Apply filter
+		if filtered, err = applyFilter(data, filter); err != nil {
+			return nil, err
+		}
 	}
-	
-	// Handle raw output (unwrap top-level values)
-	if raw {
-		return outputRaw(filtered, output, compact)
+
+	if opts.NulOutput {
+		return filtered, encodeNul(output, filtered, opts)
 	}
-	
-	return encoder.Encode(filtered)
+
+	documents := filtered
+	if to == FormatTOML {
+		if from != FormatTOML {
+			// Datetimes read from TOML are written to JSON and YAML as
+			// strings; turn them back into TOML datetimes
+			documents = make([]interface{}, len(filtered))
+			for i, value := range filtered {
+				documents[i] = restoreDatetimes(value)
+			}
+		}
+		if documents, err = tomlDocuments(documents, filter); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := encode(output, documents, to, opts); err != nil {
+		return nil, err
+	}
+	return filtered, nil
 }
 
-// JsonToTomlWithFilter converts JSON data to TOML with a filter expression
-func JsonToTomlWithFilter(input io.Reader, output io.Writer, filter string, compact bool) error {
-	var data interface{}
-	
-	// Decode JSON
-	decoder := json.NewDecoder(input)
-	if err := decoder.Decode(&data); err != nil {
-		return err
+// tomlDocuments prepares filter results for TOML output. A TOML document
+// must be a table, so any other result, such as an array of tables or a
+// single value, is wrapped in a table under the last field name of the
+// filter: .servers becomes [[servers]] and .title becomes title = "...".
+func tomlDocuments(values []interface{}, filter string) ([]interface{}, error) {
+	documents := make([]interface{}, len(values))
+	for i, value := range values {
+		switch value.(type) {
+		case map[string]interface{}, *OrderedMap:
+			documents[i] = value
+			continue
+		}
+		key := lastFieldName(filter)
+		if key == "" {
+			return nil, fmt.Errorf("cannot encode %s as a TOML document: filter %s has no field name to use as its key", typeName(value), filter)
+		}
+		documents[i] = map[string]interface{}{key: value}
 	}
-	
-	// Apply filter
-	filtered, err := applyFilter(data, filter)
-	if err != nil {
-		return err
+	return documents, nil
+}
+
+// lastFieldName returns the last field a filter selects, looking back through
+// index expressions and pipe stages, or "" when there is none. Comparisons,
+// arithmetic and function calls compute new values, so they have no field name.
+func lastFieldName(filter string) string {
+	stages := splitTopLevel(filter, '|')
+	for i := len(stages) - 1; i >= 0; i-- {
+		stage := strings.TrimSpace(stages[i])
+		if _, _, _, ok := splitComparison(stage); ok {
+			return ""
+		}
+		if _, _, _, ok := splitArithmetic(stage); ok {
+			return ""
+		}
+		if _, _, ok := parseCall(stage); ok {
+			return ""
+		}
+		parts := parseFilterParts(strings.TrimPrefix(stage, "."))
+		for j := len(parts) - 1; j >= 0; j-- {
+			name := parts[j]
+			if idx := strings.Index(name, "["); idx >= 0 {
+				// A string key such as ["name"] names the field too
+				indexes, _ := splitIndexes(name[idx:])
+				for k := len(indexes) - 1; k >= 0; k-- {
+					if key, ok := stringLiteral(indexes[k]); ok {
+						return key
+					}
+				}
+				name = name[:idx]
+			}
+			if name != "" {
+				return name
+			}
+		}
 	}
-	
-	// Encode as TOML
-	encoder := toml.NewEncoder(output)
-	// Note: go-toml/v2 doesn't support indentation control like JSON
-	return encoder.Encode(filtered)
+	return ""
 }
 
-// applyFilter applies a jq-like filter to the data
-// Currently supports basic field access (.field) and array indexing (.field[0])
-func applyFilter(data interface{}, filter string) (interface{}, error) {
+// Exit statuses reported by ExitStatus, matching jq's --exit-status
+const (
+	ExitTruthy   = 0
+	ExitFalsy    = 1
+	ExitNoOutput = 4
+)
+
+// ExitStatus chooses a process exit status from the results of a filter the
+// way jq -e does: ExitTruthy when the last result is neither null nor false,
+// ExitFalsy when it is, and ExitNoOutput when there were no results at all
+func ExitStatus(results []interface{}) int {
+	if len(results) == 0 {
+		return ExitNoOutput
+	}
+	switch last := results[len(results)-1]; last {
+	case nil, false:
+		return ExitFalsy
+	}
+	return ExitTruthy
+}
+
+// applyFilter applies a jq-like filter to the data and returns every result
+// it produces. Most filters produce exactly one result, but iteration (.[])
+// may produce any number.
+// Currently supports basic field access (.field), array indexing (.field[0]),
+// array slicing (.field[1:3]), iteration (.field[]), arithmetic between two
+// operands (.price * .quantity), equality (.a == .b), builtin function calls (test("^1")) and
+// pipes that chain any of these (.servers | .[0] | .name)
+func applyFilter(data interface{}, filter string) ([]interface{}, error) {
+	filter = strings.TrimSpace(filter)
+
+	// Pipes feed every result of one stage into the next, left to right
+	if stages := splitTopLevel(filter, '|'); len(stages) > 1 {
+		values := []interface{}{data}
+		for i, stage := range stages {
+			stage = strings.TrimSpace(stage)
+			if stage == "" {
+				return nil, fmt.Errorf("pipe stage %d is empty", i+1)
+			}
+			var next []interface{}
+			for _, value := range values {
+				results, err := applyFilter(value, stage)
+				if err != nil {
+					return nil, fmt.Errorf("pipe stage %d (%s) failed on %s: %w", i+1, stage, typeName(value), err)
+				}
+				next = append(next, results...)
+			}
+			values = next
+		}
+		return values, nil
+	}
+
+	// Comparisons and arithmetic evaluate each operand separately
+	if left, op, right, ok := splitComparison(filter); ok {
+		return evalComparison(data, left, op, right)
+	}
+	if left, op, right, ok := splitArithmetic(filter); ok {
+		return evalArithmetic(data, left, op, right)
+	}
+
+	// Builtin function calls apply to the whole input
+	if name, args, ok := parseCall(filter); ok {
+		return callBuiltin(data, name, args)
+	}
+
 	// Identity filter returns the entire document
 	if filter == "." {
-		return data, nil
+		return []interface{}{data}, nil
 	}
-	
+
 	// Remove leading dot if present
 	if strings.HasPrefix(filter, ".") {
 		filter = filter[1:]
 	}
-	
-	// Split the filter into parts (handling both field access and array indexing)
-	parts := parseFilterParts(filter)
-	
-	// Apply each part of the filter in sequence
-	current := data
-	for _, part := range parts {
-		// Check if we're accessing an array element
-		if strings.HasSuffix(part, "]") && strings.Contains(part, "[") {
-			// Split into field name and array index
-			idxStart := strings.Index(part, "[")
-			fieldName := part[:idxStart]
-			idxStr := part[idxStart+1 : len(part)-1]
-			
-			// Get the array first
-			var arr interface{}
-			if fieldName == "" {
-				// Direct array access
-				arr = current
-			} else {
-				// Field containing an array
-				switch m := current.(type) {
-				case map[string]interface{}:
-					var ok bool
-					arr, ok = m[fieldName]
-					if !ok {
-						return nil, fmt.Errorf("field '%s' not found", fieldName)
-					}
-				default:
-					return nil, errors.New("cannot access field of non-object")
-				}
+
+	// Apply each part of the filter in sequence to every current result
+	current := []interface{}{data}
+	for _, part := range parseFilterParts(filter) {
+		var next []interface{}
+		for _, value := range current {
+			results, err := applyPart(value, part)
+			if err != nil {
+				return nil, err
 			}
-			
-			// Parse the index
-			var idx int
-			if _, err := fmt.Sscanf(idxStr, "%d", &idx); err != nil {
-				return nil, fmt.Errorf("invalid array index: %s", idxStr)
+			next = append(next, results...)
+		}
+		current = next
+	}
+
+	return current, nil
+}
+
+// applyPart applies one component of a path, such as "field", "field[0]",
+// "[1:3]" or "items[]", to a single value
+func applyPart(value interface{}, part string) ([]interface{}, error) {
+	// Split into field name and any bracketed index expressions
+	fieldName := part
+	var indexes []string
+	if idxStart := strings.Index(part, "["); idxStart >= 0 && strings.HasSuffix(part, "]") {
+		fieldName = part[:idxStart]
+		var err error
+		if indexes, err = splitIndexes(part[idxStart:]); err != nil {
+			return nil, err
+		}
+	}
+
+	current := []interface{}{value}
+	if fieldName != "" {
+		// Regular field access
+		field, ok, isObject := objectField(value, fieldName)
+		if !isObject {
+			return nil, errors.New("cannot access field of non-object")
+		}
+		if !ok {
+			return nil, fmt.Errorf("field '%s' not found", fieldName)
+		}
+		current = []interface{}{field}
+	}
+
+	for _, idxStr := range indexes {
+		var next []interface{}
+		for _, v := range current {
+			results, err := applyIndex(v, idxStr)
+			if err != nil {
+				return nil, err
 			}
-			
-			// Access the array element
-			switch a := arr.(type) {
-			case []interface{}:
-				if idx < 0 || idx >= len(a) {
-					return nil, fmt.Errorf("array index out of bounds: %d", idx)
-				}
-				current = a[idx]
-			default:
-				return nil, errors.New("cannot index non-array")
+			next = append(next, results...)
+		}
+		current = next
+	}
+	return current, nil
+}
+
+// splitIndexes splits a run of bracket expressions like "[0][1:]" or
+// ["key"] into their contents
+func splitIndexes(s string) ([]string, error) {
+	var indexes []string
+	for s != "" {
+		if s[0] != '[' {
+			return nil, fmt.Errorf("invalid index expression: %s", s)
+		}
+		end := closingBracket(s)
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated index expression: %s", s)
+		}
+		indexes = append(indexes, s[1:end])
+		s = s[end+1:]
+	}
+	return indexes, nil
+}
+
+// closingBracket returns the index of the ] closing the bracket expression
+// that s starts with, skipping any in a string literal, or -1
+func closingBracket(s string) int {
+	inString := false
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case inString && c == '\\':
+			i++
+		case c == '"':
+			inString = !inString
+		case !inString && c == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+// applyIndex applies the contents of one bracket expression to a value:
+// empty brackets iterate, a colon slices, a string literal looks up an
+// object key and anything else is an index. Strings can be sliced and
+// indexed too, by character rather than byte.
+func applyIndex(value interface{}, idxStr string) ([]interface{}, error) {
+	// Empty brackets iterate over array elements or object values
+	if strings.TrimSpace(idxStr) == "" {
+		if a, ok := value.([]interface{}); ok {
+			return a, nil
+		}
+		if values, ok := objectValues(value); ok {
+			return values, nil
+		}
+		return nil, fmt.Errorf("cannot iterate over %s", typeName(value))
+	}
+
+	// String keys like ["name"] select an object field, even one whose
+	// name isn't a valid .field
+	if key, ok := stringLiteral(idxStr); ok {
+		field, found, isObject := objectField(value, key)
+		if !isObject {
+			return nil, fmt.Errorf("cannot index %s with string %q", typeName(value), key)
+		}
+		if !found {
+			return nil, fmt.Errorf("field '%s' not found", key)
+		}
+		return []interface{}{field}, nil
+	}
+
+	// Slice expressions like [1:3] select a sub-array or substring
+	if strings.Contains(idxStr, ":") {
+		switch v := value.(type) {
+		case []interface{}:
+			start, end, err := parseSliceBounds(idxStr, len(v))
+			if err != nil {
+				return nil, err
 			}
-		} else {
-			// Regular field access
-			switch m := current.(type) {
-			case map[string]interface{}:
-				var ok bool
-				current, ok = m[part]
-				if !ok {
-					return nil, fmt.Errorf("field '%s' not found", part)
-				}
-			default:
-				return nil, errors.New("cannot access field of non-object")
+			return []interface{}{v[start:end]}, nil
+		case string:
+			runes := []rune(v)
+			start, end, err := parseSliceBounds(idxStr, len(runes))
+			if err != nil {
+				return nil, err
 			}
+			return []interface{}{string(runes[start:end])}, nil
 		}
+		return nil, fmt.Errorf("cannot slice %s", typeName(value))
 	}
-	
-	return current, nil
+
+	// Parse the index
+	var idx int
+	if _, err := fmt.Sscanf(idxStr, "%d", &idx); err != nil {
+		return nil, fmt.Errorf("invalid array index: %s", idxStr)
+	}
+
+	// Access the array element or character. Negative indices count back
+	// from the end.
+	switch v := value.(type) {
+	case []interface{}:
+		if idx < 0 {
+			idx += len(v)
+		}
+		if idx < 0 || idx >= len(v) {
+			return nil, fmt.Errorf("array index out of bounds: %s", idxStr)
+		}
+		return []interface{}{v[idx]}, nil
+	case string:
+		runes := []rune(v)
+		if idx < 0 {
+			idx += len(runes)
+		}
+		if idx < 0 || idx >= len(runes) {
+			return nil, fmt.Errorf("string index out of bounds: %s", idxStr)
+		}
+		return []interface{}{string(runes[idx])}, nil
+	}
+	return nil, fmt.Errorf("cannot index %s", typeName(value))
+}
+
+// stringLiteral decodes s when it is a JSON string literal
+func stringLiteral(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, `"`) {
+		return "", false
+	}
+	var str string
+	if err := json.Unmarshal([]byte(s), &str); err != nil {
+		return "", false
+	}
+	return str, true
+}
+
+// typeName describes the type of a decoded value in jq's terms
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}, *OrderedMap:
+		return "object"
+	}
+	if _, _, _, ok := toNumber(value); ok {
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// parseSliceBounds parses a slice expression such as "1:3", ":2" or "-2:"
+// into concrete bounds for a sequence of the given length. Negative bounds
+// count from the end, out-of-range bounds are clamped, and a start past the
+// end yields an empty slice.
+func parseSliceBounds(spec string, length int) (int, int, error) {
+	bounds := strings.Split(spec, ":")
+	if len(bounds) != 2 {
+		return 0, 0, fmt.Errorf("invalid slice: %s", spec)
+	}
+
+	resolve := func(s string, def int) (int, error) {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return def, nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid slice bound: %s", s)
+		}
+		if n < 0 {
+			n += length
+		}
+		if n < 0 {
+			n = 0
+		}
+		if n > length {
+			n = length
+		}
+		return n, nil
+	}
+
+	start, err := resolve(bounds[0], 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := resolve(bounds[1], length)
+	if err != nil {
+		return 0, 0, err
+	}
+	if start > end {
+		start = end
+	}
+	return start, end, nil
 }
 
 // parseFilterParts splits a filter string into its component parts
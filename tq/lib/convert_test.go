@@ -2,6 +2,8 @@ package lib
 
 import (
 	"bytes"
+	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -113,3 +115,405 @@ enabled = true
 			strings.TrimSpace(originalToml), finalToml)
 	}
 }
+
+func TestApplyFilterNegativeIndex(t *testing.T) {
+	data := map[string]interface{}{
+		"users": []interface{}{"alice", "bob", "carol"},
+	}
+
+	tests := []struct {
+		filter  string
+		want    interface{}
+		wantErr bool
+	}{
+		{filter: ".users[0]", want: "alice"},
+		{filter: ".users[-1]", want: "carol"},
+		{filter: ".users[-2]", want: "bob"},
+		{filter: ".users[-3]", want: "alice"},
+		{filter: ".users[-4]", wantErr: true},
+		{filter: ".users[-5]", wantErr: true},
+		{filter: ".users[3]", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			got, err := filterOne(data, tt.filter)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("applyFilter(%q) = %v, want error", tt.filter, got)
+				}
+				if !strings.Contains(err.Error(), "array index out of bounds") {
+					t.Errorf("applyFilter(%q) error = %v, want out of bounds", tt.filter, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyFilter(%q) failed: %v", tt.filter, err)
+			}
+			if got != tt.want {
+				t.Errorf("applyFilter(%q) = %v, want %v", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyFilterSlice(t *testing.T) {
+	users := []interface{}{"alice", "bob", "carol", "dave"}
+	data := map[string]interface{}{
+		"users": users,
+		"empty": []interface{}{},
+		"name":  "tq",
+	}
+
+	tests := []struct {
+		data   interface{}
+		filter string
+		want   []interface{}
+	}{
+		{data: data, filter: ".users[1:3]", want: []interface{}{"bob", "carol"}},
+		{data: data, filter: ".users[:2]", want: []interface{}{"alice", "bob"}},
+		{data: data, filter: ".users[2:]", want: []interface{}{"carol", "dave"}},
+		{data: data, filter: ".users[:]", want: users},
+		{data: data, filter: ".users[-2:]", want: []interface{}{"carol", "dave"}},
+		{data: data, filter: ".users[:-3]", want: []interface{}{"alice"}},
+		{data: data, filter: ".users[3:1]", want: []interface{}{}},
+		{data: data, filter: ".users[1:100]", want: []interface{}{"bob", "carol", "dave"}},
+		{data: data, filter: ".empty[0:2]", want: []interface{}{}},
+		{data: users, filter: ".[1:3]", want: []interface{}{"bob", "carol"}},
+		{data: users, filter: "[:1]", want: []interface{}{"alice"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			got, err := filterOne(tt.data, tt.filter)
+			if err != nil {
+				t.Fatalf("applyFilter(%q) failed: %v", tt.filter, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("applyFilter(%q) = %v, want %v", tt.filter, got, tt.want)
+			}
+		})
+	}
+
+	for _, filter := range []string{".users[1:2:3]", ".users[a:b]"} {
+		if _, err := applyFilter(data, filter); err == nil {
+			t.Errorf("applyFilter(%q) should fail", filter)
+		}
+	}
+}
+
+func TestApplyFilterString(t *testing.T) {
+	data := map[string]interface{}{
+		"name":  "postgres-primary",
+		"greek": "αβγδ",
+		"count": int64(3),
+	}
+
+	tests := []struct {
+		filter string
+		want   interface{}
+	}{
+		{filter: ".name[0:3]", want: "pos"},
+		{filter: ".name[:8]", want: "postgres"},
+		{filter: ".name[-7:]", want: "primary"},
+		{filter: ".name[9:100]", want: "primary"},
+		{filter: ".name[5:2]", want: ""},
+		{filter: ".name[0]", want: "p"},
+		{filter: ".name[-1]", want: "y"},
+		{filter: ".greek[1:3]", want: "βγ"},
+		{filter: ".greek[-1]", want: "δ"},
+		{filter: ".name | .[0:3]", want: "pos"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			got, err := filterOne(data, tt.filter)
+			if err != nil {
+				t.Fatalf("applyFilter(%q) failed: %v", tt.filter, err)
+			}
+			if got != tt.want {
+				t.Errorf("applyFilter(%q) = %q, want %q", tt.filter, got, tt.want)
+			}
+		})
+	}
+
+	errCases := map[string]string{
+		".greek[4]":   "string index out of bounds: 4",
+		".count[0:1]": "cannot slice number",
+		".count[0]":   "cannot index number",
+		".name[a:b]":  "invalid slice bound: a",
+	}
+	for filter, want := range errCases {
+		if _, err := applyFilter(data, filter); err == nil || err.Error() != want {
+			t.Errorf("applyFilter(%q) error = %v, want %q", filter, err, want)
+		}
+	}
+}
+
+// filterOne applies a filter that is expected to produce exactly one result
+func filterOne(data interface{}, filter string) (interface{}, error) {
+	results, err := applyFilter(data, filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) != 1 {
+		return nil, fmt.Errorf("got %d results, want 1", len(results))
+	}
+	return results[0], nil
+}
+
+func TestApplyFilterIterate(t *testing.T) {
+	data := map[string]interface{}{
+		"users": []interface{}{"alice", "bob"},
+		"data": map[string]interface{}{
+			"items": []interface{}{
+				map[string]interface{}{"name": "one", "size": int64(1)},
+				map[string]interface{}{"name": "two", "size": int64(2)},
+			},
+		},
+		"ports": map[string]interface{}{"https": int64(443), "http": int64(80)},
+		"empty": []interface{}{},
+		"name":  "tq",
+	}
+
+	tests := []struct {
+		data   interface{}
+		filter string
+		want   []interface{}
+	}{
+		{data: data, filter: ".users[]", want: []interface{}{"alice", "bob"}},
+		{data: data, filter: ".data.items[].name", want: []interface{}{"one", "two"}},
+		{data: data, filter: ".data.items[].size * 10", want: []interface{}{int64(10), int64(20)}},
+		{data: data, filter: ".ports[]", want: []interface{}{int64(80), int64(443)}},
+		{data: data, filter: ".empty[]", want: nil},
+		{data: data, filter: ".users[] | test(\"^a\")", want: []interface{}{true, false}},
+		{data: []interface{}{"x", "y"}, filter: ".[]", want: []interface{}{"x", "y"}},
+		{data: data["ports"], filter: ".[]", want: []interface{}{int64(80), int64(443)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			got, err := applyFilter(tt.data, tt.filter)
+			if err != nil {
+				t.Fatalf("applyFilter(%q) failed: %v", tt.filter, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("applyFilter(%q) = %v, want %v", tt.filter, got, tt.want)
+			}
+		})
+	}
+
+	for _, filter := range []string{".name[]", ".users[0][]"} {
+		_, err := applyFilter(data, filter)
+		if err == nil || !strings.Contains(err.Error(), "cannot iterate over string") {
+			t.Errorf("applyFilter(%q) error = %v, want cannot iterate over string", filter, err)
+		}
+	}
+}
+
+func TestIterateToJson(t *testing.T) {
+	input := strings.NewReader(`
+[[servers]]
+host = "a.example.com"
+
+[[servers]]
+host = "b.example.com"
+`)
+
+	output := &bytes.Buffer{}
+	if err := TomlToJsonWithFilter(input, output, ".servers[].host", false, false); err != nil {
+		t.Fatalf("TomlToJsonWithFilter failed: %v", err)
+	}
+	if got, want := output.String(), "\"a.example.com\"\n\"b.example.com\"\n"; got != want {
+		t.Errorf("Unexpected output: %q, want %q", got, want)
+	}
+
+	input = strings.NewReader(`hosts = ["a", "b"]`)
+	output.Reset()
+	if err := TomlToJsonWithFilter(input, output, ".hosts[]", false, true); err != nil {
+		t.Fatalf("TomlToJsonWithFilter failed: %v", err)
+	}
+	if got, want := output.String(), "a\nb"; got != want {
+		t.Errorf("Unexpected raw output: %q, want %q", got, want)
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	input := `
+name = "tq"
+enabled = false
+count = 0
+tags = []
+flags = [true, false]
+`
+
+	tests := []struct {
+		filter string
+		want   int
+	}{
+		{filter: ".name", want: ExitTruthy},
+		{filter: ".count", want: ExitTruthy},
+		{filter: ".enabled", want: ExitFalsy},
+		{filter: `.name | match("xyz")`, want: ExitNoOutput},
+		{filter: ".tags[]", want: ExitNoOutput},
+		{filter: ".flags[]", want: ExitFalsy},
+		{filter: ".flags[0]", want: ExitTruthy},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			results, err := Convert(strings.NewReader(input), &bytes.Buffer{}, FormatTOML, FormatJSON, tt.filter, Options{})
+			if err != nil {
+				t.Fatalf("Convert(%q) failed: %v", tt.filter, err)
+			}
+			if got := ExitStatus(results); got != tt.want {
+				t.Errorf("ExitStatus(%v) = %d, want %d", results, got, tt.want)
+			}
+		})
+	}
+
+	// JSON null is falsy too
+	results, err := Convert(strings.NewReader(`{"a": null}`), &bytes.Buffer{}, FormatJSON, FormatJSON, ".a", Options{})
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if got := ExitStatus(results); got != ExitFalsy {
+		t.Errorf("ExitStatus(null) = %d, want %d", got, ExitFalsy)
+	}
+}
+
+func TestApplyFilterPipe(t *testing.T) {
+	data := map[string]interface{}{
+		"servers": []interface{}{
+			map[string]interface{}{"name": "alpha", "ports": []interface{}{int64(80), int64(443)}},
+			map[string]interface{}{"name": "beta", "ports": []interface{}{int64(8080)}},
+		},
+		"owner": map[string]interface{}{"name": "Tom"},
+	}
+
+	tests := []struct {
+		filter string
+		want   []interface{}
+	}{
+		{filter: ".servers | .[0] | .name", want: []interface{}{"alpha"}},
+		{filter: ".servers|.[-1]|.name", want: []interface{}{"beta"}},
+		{filter: "  .owner  |  .name  ", want: []interface{}{"Tom"}},
+		{filter: ". | .owner | .", want: []interface{}{map[string]interface{}{"name": "Tom"}}},
+		{filter: ".servers | .[] | .name", want: []interface{}{"alpha", "beta"}},
+		{filter: ".servers[0].ports | .[] | . * 2", want: []interface{}{int64(160), int64(886)}},
+		{filter: ".servers | .[1:] | .[0].name", want: []interface{}{"beta"}},
+		{filter: `.servers[] | .name | test("^a")`, want: []interface{}{true, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			got, err := applyFilter(data, tt.filter)
+			if err != nil {
+				t.Fatalf("applyFilter(%q) failed: %v", tt.filter, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("applyFilter(%q) = %v, want %v", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyFilterPipeErrors(t *testing.T) {
+	data := map[string]interface{}{
+		"servers": []interface{}{map[string]interface{}{"name": "alpha"}},
+	}
+
+	tests := []struct {
+		filter string
+		errMsg string
+	}{
+		{filter: ".servers | .name", errMsg: "pipe stage 2 (.name) failed on array: cannot access field of non-object"},
+		{filter: ".servers | .[0] | .[0]", errMsg: "pipe stage 3 (.[0]) failed on object: cannot index object"},
+		{filter: ".servers | .[0] | .missing", errMsg: "pipe stage 3 (.missing) failed on object: field 'missing' not found"},
+		{filter: ".servers | | .[0]", errMsg: "pipe stage 2 is empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			_, err := applyFilter(data, tt.filter)
+			if err == nil || err.Error() != tt.errMsg {
+				t.Errorf("applyFilter(%q) error = %v, want %q", tt.filter, err, tt.errMsg)
+			}
+		})
+	}
+}
+
+func TestTomlSectionExtraction(t *testing.T) {
+	input := `
+title = "Config"
+
+[database]
+host = "db.local"
+
+[database.pool]
+max = 10
+idle = 2
+
+[[servers]]
+name = "alpha"
+port = 80
+
+[[servers]]
+name = "beta"
+port = 81
+`
+
+	tests := []struct {
+		filter string
+		want   map[string]interface{}
+	}{
+		{
+			filter: ".database.pool",
+			want:   map[string]interface{}{"max": int64(10), "idle": int64(2)},
+		},
+		{
+			filter: ".servers",
+			want: map[string]interface{}{"servers": []interface{}{
+				map[string]interface{}{"name": "alpha", "port": int64(80)},
+				map[string]interface{}{"name": "beta", "port": int64(81)},
+			}},
+		},
+		{
+			filter: ".servers | .[1:]",
+			want: map[string]interface{}{"servers": []interface{}{
+				map[string]interface{}{"name": "beta", "port": int64(81)},
+			}},
+		},
+		{
+			filter: ".title",
+			want:   map[string]interface{}{"title": "Config"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			output := &bytes.Buffer{}
+			err := ConvertWithFilter(strings.NewReader(input), output, FormatTOML, FormatTOML, tt.filter, false, false)
+			if err != nil {
+				t.Fatalf("ConvertWithFilter failed: %v", err)
+			}
+
+			// The output must be a valid standalone TOML document
+			got, err := decode(bytes.NewReader(output.Bytes()), FormatTOML, Options{})
+			if err != nil {
+				t.Fatalf("Output is not valid TOML: %v\n%s", err, output.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v\noutput:\n%s", got, tt.want, output.String())
+			}
+		})
+	}
+}
+
+func TestTomlSectionExtractionNoKey(t *testing.T) {
+	output := &bytes.Buffer{}
+	err := ConvertWithFilter(strings.NewReader("a = 1\n"), output, FormatTOML, FormatTOML, ".a * 2", false, false)
+	if err == nil || !strings.Contains(err.Error(), "cannot encode number as a TOML document") {
+		t.Errorf("Expected an error for a keyless scalar result, got %v\n%s", err, output.String())
+	}
+}
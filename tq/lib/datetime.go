@@ -0,0 +1,68 @@
+package lib
+
+import (
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// tomlDatetime parses a string written the way JSON and YAML output spell a
+// TOML datetime back into the value the TOML decoder produces: a time.Time
+// for an offset datetime such as 1979-05-27T07:32:00-08:00, or a local
+// datetime, date or time.
+func tomlDatetime(s string) (interface{}, bool) {
+	// Every form starts with the digits of a year or an hour
+	if len(s) < 8 || s[0] < '0' || s[0] > '9' {
+		return nil, false
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, true
+	}
+	var dateTime toml.LocalDateTime
+	if err := dateTime.UnmarshalText([]byte(s)); err == nil {
+		return dateTime, true
+	}
+	var date toml.LocalDate
+	if err := date.UnmarshalText([]byte(s)); err == nil {
+		return date, true
+	}
+	var clock toml.LocalTime
+	if err := clock.UnmarshalText([]byte(s)); err == nil {
+		return clock, true
+	}
+	return nil, false
+}
+
+// restoreDatetimes returns value with every string that spells a TOML
+// datetime replaced by the datetime, so a document converted from TOML to
+// JSON or YAML converts back with its datetimes intact. Objects and arrays
+// are copied rather than changed in place, since filter results may share
+// them.
+func restoreDatetimes(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if t, ok := tomlDatetime(v); ok {
+			return t
+		}
+		return v
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			out[key] = restoreDatetimes(elem)
+		}
+		return out
+	case *OrderedMap:
+		out := &OrderedMap{keys: v.keys, values: make(map[string]interface{}, len(v.values))}
+		for key, elem := range v.values {
+			out.values[key] = restoreDatetimes(elem)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, elem := range v {
+			out[i] = restoreDatetimes(elem)
+		}
+		return out
+	}
+	return value
+}
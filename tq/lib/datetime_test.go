@@ -0,0 +1,92 @@
+package lib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+func TestDatetimeRoundTrip(t *testing.T) {
+	original := `
+[owner]
+dob = 1979-05-27T07:32:00-08:00
+day = 1979-05-27
+alarm = 07:32:00.5
+meeting = 1979-05-27T07:32:00
+version = "1.2.3"
+`
+	var jsonBuf, tomlBuf bytes.Buffer
+	if err := TomlToJson(strings.NewReader(original), &jsonBuf); err != nil {
+		t.Fatalf("TomlToJson failed: %v", err)
+	}
+	if err := JsonToToml(&jsonBuf, &tomlBuf); err != nil {
+		t.Fatalf("JsonToToml failed: %v", err)
+	}
+	if !strings.Contains(tomlBuf.String(), "dob = 1979-05-27T07:32:00-08:00\n") {
+		t.Errorf("dob was not written as a TOML datetime:\n%s", tomlBuf.String())
+	}
+
+	var got struct {
+		Owner map[string]interface{}
+	}
+	if err := toml.Unmarshal(tomlBuf.Bytes(), &got); err != nil {
+		t.Fatalf("round-tripped TOML does not parse: %v\n%s", err, tomlBuf.String())
+	}
+	if dob, ok := got.Owner["dob"].(time.Time); !ok || !dob.Equal(time.Date(1979, 5, 27, 15, 32, 0, 0, time.UTC)) {
+		t.Errorf("dob = %#v, want an offset datetime", got.Owner["dob"])
+	}
+	if _, ok := got.Owner["day"].(toml.LocalDate); !ok {
+		t.Errorf("day = %#v, want a local date", got.Owner["day"])
+	}
+	if _, ok := got.Owner["alarm"].(toml.LocalTime); !ok {
+		t.Errorf("alarm = %#v, want a local time", got.Owner["alarm"])
+	}
+	if _, ok := got.Owner["meeting"].(toml.LocalDateTime); !ok {
+		t.Errorf("meeting = %#v, want a local datetime", got.Owner["meeting"])
+	}
+	if got.Owner["version"] != "1.2.3" {
+		t.Errorf("version = %#v, want the string 1.2.3", got.Owner["version"])
+	}
+}
+
+func TestTomlDatetime(t *testing.T) {
+	for _, s := range []string{
+		"1979-05-27T07:32:00Z",
+		"1979-05-27T07:32:00.999999-07:00",
+		"1979-05-27T07:32:00",
+		"1979-05-27",
+		"07:32:00",
+	} {
+		if _, ok := tomlDatetime(s); !ok {
+			t.Errorf("tomlDatetime(%q) is not a datetime", s)
+		}
+	}
+	for _, s := range []string{
+		"",
+		"yesterday",
+		"1979-13-27",
+		"1979-05-27T25:00:00Z",
+		"1979-05-27 and more",
+		"20240101",
+		"v1979-05-27",
+	} {
+		if v, ok := tomlDatetime(s); ok {
+			t.Errorf("tomlDatetime(%q) = %#v, want a plain string", s, v)
+		}
+	}
+}
+
+func TestTomlKeepsDatetimeStrings(t *testing.T) {
+	// A quoted string in TOML input stays a string in TOML output
+	input := strings.NewReader(`released = "2024-01-02"`)
+	var output bytes.Buffer
+	if _, err := Convert(input, &output, FormatTOML, FormatTOML, ".", Options{}); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if got := strings.TrimSpace(output.String()); got != "released = '2024-01-02'" {
+		t.Errorf("Convert() = %s, want the string kept", got)
+	}
+}
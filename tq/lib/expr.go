@@ -0,0 +1,328 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// arithmeticOps lists the binary operators grouped by precedence, lowest first
+var arithmeticOps = []string{"+-", "*/%"}
+
+// splitArithmetic looks for a top-level arithmetic operator in the filter.
+// Operators of lower precedence are split first and the rightmost occurrence
+// wins, which makes chains like `.a - .b - .c` left-associative.
+func splitArithmetic(filter string) (left string, op byte, right string, ok bool) {
+	for _, ops := range arithmeticOps {
+		idx := findTopLevelOperator(filter, ops)
+		if idx >= 0 {
+			return strings.TrimSpace(filter[:idx]), filter[idx], strings.TrimSpace(filter[idx+1:]), true
+		}
+	}
+	return "", 0, "", false
+}
+
+// findTopLevelOperator returns the index of the rightmost operator from ops
+// that appears outside brackets, parentheses and string literals, or -1.
+func findTopLevelOperator(filter string, ops string) int {
+	found := -1
+	depth := 0
+	inString := false
+	for i := 0; i < len(filter); i++ {
+		c := filter[i]
+		if inString {
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '[', '(':
+			depth++
+		case ']', ')':
+			depth--
+		default:
+			if depth == 0 && strings.IndexByte(ops, c) >= 0 && isBinaryOperator(filter, i) {
+				found = i
+			}
+		}
+	}
+	return found
+}
+
+// isBinaryOperator reports whether the operator at position i has an operand
+// on both sides. A '-' must also be preceded by whitespace so that hyphenated
+// keys like `.max-connections` and negative literals keep working.
+func isBinaryOperator(filter string, i int) bool {
+	before := strings.TrimRight(filter[:i], " \t")
+	after := strings.TrimSpace(filter[i+1:])
+	if before == "" || after == "" {
+		return false
+	}
+	if strings.IndexByte("+-*/%", before[len(before)-1]) >= 0 {
+		return false
+	}
+	if filter[i] == '-' && len(before) == len(filter[:i]) {
+		return false
+	}
+	return true
+}
+
+// splitComparison looks for a top-level == or != in the filter. Comparisons
+// bind more loosely than arithmetic, so `.a + 1 == .b` compares the sum.
+func splitComparison(filter string) (left string, op string, right string, ok bool) {
+	depth := 0
+	inString := false
+	for i := 0; i+1 < len(filter); i++ {
+		c := filter[i]
+		if inString {
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '[', '(':
+			depth++
+		case ']', ')':
+			depth--
+		case '=', '!':
+			if depth == 0 && filter[i+1] == '=' {
+				return strings.TrimSpace(filter[:i]), filter[i : i+2], strings.TrimSpace(filter[i+2:]), true
+			}
+		}
+	}
+	return "", "", "", false
+}
+
+// evalComparison evaluates both operands against the data and compares every
+// pair of results
+func evalComparison(data interface{}, left, op, right string) ([]interface{}, error) {
+	if left == "" || right == "" {
+		return nil, fmt.Errorf("'%s' needs an operand on both sides", op)
+	}
+	lhs, err := evalOperand(data, left)
+	if err != nil {
+		return nil, err
+	}
+	rhs, err := evalOperand(data, right)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []interface{}
+	for _, r := range rhs {
+		for _, l := range lhs {
+			results = append(results, valuesEqual(l, r) == (op == "=="))
+		}
+	}
+	return results, nil
+}
+
+// valuesEqual compares two decoded values structurally. Objects are equal
+// when they have the same keys and values regardless of key order, and
+// numbers compare by value, so 1 equals 1.0.
+func valuesEqual(a, b interface{}) bool {
+	if equal, ok := numbersEqual(a, b); ok {
+		return equal
+	}
+
+	if aKeys, ok := objectKeys(a); ok {
+		bKeys, ok := objectKeys(b)
+		if !ok || len(aKeys) != len(bKeys) {
+			return false
+		}
+		for _, key := range aKeys {
+			av, _, _ := objectField(a, key)
+			bv, found, _ := objectField(b, key)
+			if !found || !valuesEqual(av, bv) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if aArr, ok := a.([]interface{}); ok {
+		bArr, ok := b.([]interface{})
+		if !ok || len(aArr) != len(bArr) {
+			return false
+		}
+		for i := range aArr {
+			if !valuesEqual(aArr[i], bArr[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// numbersEqual compares two values when both are numbers. Integers are
+// compared exactly, so large values aren't conflated by float rounding.
+func numbersEqual(a, b interface{}) (bool, bool) {
+	ai, af, aIsInt, ok := toNumber(a)
+	if !ok {
+		return false, false
+	}
+	bi, bf, bIsInt, ok := toNumber(b)
+	if !ok {
+		return false, false
+	}
+	if aIsInt && bIsInt {
+		return ai == bi, true
+	}
+	return af == bf, true
+}
+
+// objectKeys returns the keys of either kind of object
+func objectKeys(value interface{}) ([]string, bool) {
+	switch m := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		return keys, true
+	case *OrderedMap:
+		return m.Keys(), true
+	}
+	return nil, false
+}
+
+// evalArithmetic evaluates both operands against the data and combines every
+// pair of results
+func evalArithmetic(data interface{}, left string, op byte, right string) ([]interface{}, error) {
+	lhs, err := evalOperand(data, left)
+	if err != nil {
+		return nil, err
+	}
+	rhs, err := evalOperand(data, right)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []interface{}
+	for _, r := range rhs {
+		for _, l := range lhs {
+			result, err := applyArithmetic(l, op, r)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// evalOperand resolves an operand, which is either a literal (a number, a
+// JSON string, true, false or null) or a filter
+func evalOperand(data interface{}, operand string) ([]interface{}, error) {
+	if n, err := strconv.ParseInt(operand, 10, 64); err == nil {
+		return []interface{}{n}, nil
+	}
+	if f, err := strconv.ParseFloat(operand, 64); err == nil {
+		return []interface{}{f}, nil
+	}
+	switch operand {
+	case "true":
+		return []interface{}{true}, nil
+	case "false":
+		return []interface{}{false}, nil
+	case "null":
+		return []interface{}{nil}, nil
+	}
+	if strings.HasPrefix(operand, `"`) {
+		var str string
+		if err := json.Unmarshal([]byte(operand), &str); err != nil {
+			return nil, fmt.Errorf("invalid string literal %s", operand)
+		}
+		return []interface{}{str}, nil
+	}
+	return applyFilter(data, operand)
+}
+
+// applyArithmetic combines two numbers. Integer operands stay integers unless
+// a division leaves a remainder; anything involving a float yields a float.
+func applyArithmetic(lhs interface{}, op byte, rhs interface{}) (interface{}, error) {
+	li, lf, lIsInt, ok := toNumber(lhs)
+	if !ok {
+		return nil, fmt.Errorf("cannot apply '%c' to non-number %v", op, lhs)
+	}
+	ri, rf, rIsInt, ok := toNumber(rhs)
+	if !ok {
+		return nil, fmt.Errorf("cannot apply '%c' to non-number %v", op, rhs)
+	}
+
+	if lIsInt && rIsInt {
+		switch op {
+		case '+':
+			return li + ri, nil
+		case '-':
+			return li - ri, nil
+		case '*':
+			return li * ri, nil
+		case '/':
+			if ri == 0 {
+				return nil, errors.New("division by zero")
+			}
+			if li%ri == 0 {
+				return li / ri, nil
+			}
+			return float64(li) / float64(ri), nil
+		case '%':
+			if ri == 0 {
+				return nil, errors.New("modulo by zero")
+			}
+			return li % ri, nil
+		}
+	}
+
+	switch op {
+	case '+':
+		return lf + rf, nil
+	case '-':
+		return lf - rf, nil
+	case '*':
+		return lf * rf, nil
+	case '/':
+		if rf == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return lf / rf, nil
+	case '%':
+		if rf == 0 {
+			return nil, errors.New("modulo by zero")
+		}
+		return math.Mod(lf, rf), nil
+	}
+	return nil, fmt.Errorf("unknown operator '%c'", op)
+}
+
+// toNumber converts any decoded numeric value into both integer and float
+// form, reporting whether the value is integral in the source data
+func toNumber(v interface{}) (int64, float64, bool, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), float64(n), true, true
+	case int64:
+		return n, float64(n), true, true
+	case uint64:
+		return int64(n), float64(n), true, true
+	case float64:
+		return int64(n), n, false, true
+	}
+	return 0, 0, false, false
+}
@@ -0,0 +1,169 @@
+package lib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestApplyFilterArithmetic(t *testing.T) {
+	data := map[string]interface{}{
+		"price":           2.5,
+		"quantity":        int64(4),
+		"timeout":         int64(60),
+		"max-connections": int64(10),
+		"ports":           []interface{}{int64(8000), int64(8001)},
+	}
+
+	tests := []struct {
+		filter string
+		want   interface{}
+	}{
+		{filter: ".price * .quantity", want: 10.0},
+		{filter: ".timeout + 30", want: int64(90)},
+		{filter: ".timeout - 15", want: int64(45)},
+		{filter: ".timeout / 4", want: int64(15)},
+		{filter: ".timeout / 7", want: 60.0 / 7},
+		{filter: ".timeout % 7", want: int64(4)},
+		{filter: ".timeout - -5", want: int64(65)},
+		{filter: ".timeout+.quantity*2", want: int64(68)},
+		{filter: ".timeout - 10 - 5", want: int64(45)},
+		{filter: ".ports[-1] - .ports[0]", want: int64(1)},
+		{filter: ".max-connections * 2", want: int64(20)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			got, err := filterOne(data, tt.filter)
+			if err != nil {
+				t.Fatalf("applyFilter(%q) failed: %v", tt.filter, err)
+			}
+			if got != tt.want {
+				t.Errorf("applyFilter(%q) = %v (%T), want %v (%T)", tt.filter, got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyFilterArithmeticErrors(t *testing.T) {
+	data := map[string]interface{}{
+		"name":    "tq",
+		"timeout": int64(60),
+	}
+
+	tests := []struct {
+		filter string
+		errMsg string
+	}{
+		{filter: ".timeout / 0", errMsg: "division by zero"},
+		{filter: ".timeout % 0", errMsg: "modulo by zero"},
+		{filter: ".name + 1", errMsg: "non-number"},
+		{filter: ".missing * 2", errMsg: "not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			_, err := applyFilter(data, tt.filter)
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("applyFilter(%q) error = %v, want %q", tt.filter, err, tt.errMsg)
+			}
+		})
+	}
+}
+
+func TestTomlArithmeticToJson(t *testing.T) {
+	input := strings.NewReader("price = 2.5\nquantity = 4\n")
+	output := &bytes.Buffer{}
+
+	if err := TomlToJsonWithFilter(input, output, ".price * .quantity", false, false); err != nil {
+		t.Fatalf("TomlToJsonWithFilter failed: %v", err)
+	}
+	if got := strings.TrimSpace(output.String()); got != "10" {
+		t.Errorf("Expected 10, got %s", got)
+	}
+}
+
+func TestApplyFilterEquality(t *testing.T) {
+	input := `
+name = "tq"
+count = 3
+ratio = 3.0
+tags = ["a", "b"]
+
+[expected]
+host = "db"
+ports = [5432, 5433]
+options = { ssl = true, pool = 10 }
+
+[derived]
+options = { pool = 10, ssl = true }
+host = "db"
+ports = [5432, 5433]
+
+[other]
+host = "db"
+ports = [5433, 5432]
+`
+
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{filter: ".expected == .derived", want: true},
+		{filter: ".expected != .derived", want: false},
+		{filter: ".expected == .other", want: false},
+		{filter: ".expected.ports == .other.ports", want: false},
+		{filter: ".tags == .tags", want: true},
+		{filter: ".tags == .expected", want: false},
+		{filter: ".count == .ratio", want: true},
+		{filter: ".count + 1 == 4", want: true},
+		{filter: ".count != 3", want: false},
+		{filter: `.name == "tq"`, want: true},
+		{filter: `.name == "t=q"`, want: false},
+		{filter: ".expected.options.ssl == true", want: true},
+		{filter: ".name == null", want: false},
+		{filter: `.tags | .[0] == "a"`, want: true},
+	}
+
+	for _, tt := range tests {
+		for _, preserveOrder := range []bool{false, true} {
+			t.Run(tt.filter, func(t *testing.T) {
+				output := &bytes.Buffer{}
+				opts := Options{Compact: true, PreserveOrder: preserveOrder}
+				if _, err := Convert(strings.NewReader(input), output, FormatTOML, FormatJSON, tt.filter, opts); err != nil {
+					t.Fatalf("Convert(%q) failed: %v", tt.filter, err)
+				}
+				want := "false"
+				if tt.want {
+					want = "true"
+				}
+				if got := strings.TrimSpace(output.String()); got != want {
+					t.Errorf("%q (preserve order %v) = %s, want %s", tt.filter, preserveOrder, got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestApplyFilterEqualityErrors(t *testing.T) {
+	data := map[string]interface{}{"name": "tq"}
+
+	tests := []struct {
+		filter string
+		errMsg string
+	}{
+		{filter: ".name ==", errMsg: "operand on both sides"},
+		{filter: "!= .name", errMsg: "operand on both sides"},
+		{filter: `.name == "unterminated`, errMsg: "invalid string literal"},
+		{filter: ".missing == .name", errMsg: "not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			_, err := applyFilter(data, tt.filter)
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("applyFilter(%q) error = %v, want %q", tt.filter, err, tt.errMsg)
+			}
+		})
+	}
+}
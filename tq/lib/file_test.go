@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(path, []byte("name = \"old\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reformat the file in place the way the -i flag does
+	input, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output bytes.Buffer
+	if err := ConvertWithFilter(bytes.NewReader(input), &output, FormatTOML, FormatJSON, ".", true, false); err != nil {
+		t.Fatalf("ConvertWithFilter failed: %v", err)
+	}
+	if err := WriteFileAtomic(path, output.Bytes()); err != nil {
+		t.Fatalf("WriteFileAtomic failed: %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(got)) != `{"name":"old"}` {
+		t.Errorf("Unexpected contents: %s", got)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Mode = %v, want %v", info.Mode().Perm(), os.FileMode(0o600))
+	}
+
+	// No temporary files should be left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected only the original file, found %d entries", len(entries))
+	}
+}
+
+func TestInPlaceWithoutExtension(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conf")
+	if err := os.WriteFile(path, []byte("name = \"x\"\n[owner]\nid = 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Run -i the way main does, choosing formats for a file edit
+	input, output := ChooseFormats(path, "", true)
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	_, err = Convert(file, &buf, input, output, ".", Options{})
+	file.Close()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if err := WriteFileAtomic(path, buf.Bytes()); err != nil {
+		t.Fatalf("WriteFileAtomic failed: %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "name = 'x'\n\n[owner]\nid = 1\n"; string(got) != want {
+		t.Errorf("Expected the file to stay TOML, got:\n%s", got)
+	}
+}
+
+func TestWriteFileAtomicSymlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "real.json")
+	link := filepath.Join(dir, "link.json")
+	if err := os.WriteFile(target, []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	if err := WriteFileAtomic(link, []byte(`{"a":1}`)); err != nil {
+		t.Fatalf("WriteFileAtomic failed: %v", err)
+	}
+
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Symlink was replaced: %v", err)
+	}
+	if got, _ := os.ReadFile(target); string(got) != `{"a":1}` {
+		t.Errorf("Unexpected target contents: %s", got)
+	}
+}
+
+func TestWriteFileAtomicMissing(t *testing.T) {
+	if err := WriteFileAtomic(filepath.Join(t.TempDir(), "missing.toml"), nil); err == nil {
+		t.Error("Expected error for missing file")
+	}
+}
@@ -0,0 +1,36 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ReadFilterFile reads a filter from a file, as given to -f, so long
+// filters can be written over several lines without shell quoting. Lines
+// whose first non-blank character is # are comments and are dropped, as is
+// whitespace around the filter.
+func ReadFilterFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	filter := stripFilterComments(string(data))
+	if filter == "" {
+		return "", fmt.Errorf("filter file %s has no filter", path)
+	}
+	return filter, nil
+}
+
+// stripFilterComments strips the comment lines and surrounding whitespace from
+// the text of a filter file
+func stripFilterComments(text string) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		lines = append(lines, strings.TrimRight(line, " \t\r"))
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,56 @@
+package lib
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadFilterFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ports.jq")
+	text := `# First port of the database
+
+.database
+  # the ports table
+  | .ports
+  | .[0]
+
+`
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	filter, err := ReadFilterFile(path)
+	if err != nil {
+		t.Fatalf("ReadFilterFile failed: %v", err)
+	}
+	if want := ".database\n  | .ports\n  | .[0]"; filter != want {
+		t.Errorf("ReadFilterFile = %q, want %q", filter, want)
+	}
+
+	input := "[database]\nports = [8000, 8001]\n"
+	var out bytes.Buffer
+	if _, err := Convert(strings.NewReader(input), &out, FormatTOML, FormatJSON, filter, Options{}); err != nil {
+		t.Fatalf("Convert with the file's filter failed: %v", err)
+	}
+	if got := strings.TrimSpace(out.String()); got != "8000" {
+		t.Errorf("filter from file produced %q, want 8000", got)
+	}
+}
+
+func TestReadFilterFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := ReadFilterFile(filepath.Join(dir, "missing.jq")); err == nil {
+		t.Error("ReadFilterFile of a missing file should fail")
+	}
+
+	empty := filepath.Join(dir, "empty.jq")
+	if err := os.WriteFile(empty, []byte("# nothing but a comment\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFilterFile(empty); err == nil || !strings.Contains(err.Error(), "has no filter") {
+		t.Errorf("ReadFilterFile of a comment-only file = %v, want a no filter error", err)
+	}
+}
@@ -0,0 +1,246 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Format identifies a structured data format that tq can read and write
+type Format string
+
+const (
+	FormatTOML Format = "toml"
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+)
+
+// FormatFromExt returns the format implied by a file name's extension,
+// or an empty Format when the extension is not recognized
+func FormatFromExt(filename string) Format {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".toml":
+		return FormatTOML
+	case ".json":
+		return FormatJSON
+	case ".yaml", ".yml":
+		return FormatYAML
+	}
+	return ""
+}
+
+// ChooseFormats picks the input and output formats for filename. The input
+// format comes from the extension; without a recognizable one it is JSON
+// when TOML output is forced and TOML otherwise. forced, when not empty, is
+// the output format asked for explicitly. Otherwise edits (keepInput) keep
+// the input's format, JSON input converts to TOML and anything else to JSON.
+func ChooseFormats(filename string, forced Format, keepInput bool) (input, output Format) {
+	input = FormatFromExt(filename)
+	if input == "" {
+		if forced == FormatTOML {
+			input = FormatJSON
+		} else {
+			input = FormatTOML
+		}
+	}
+
+	switch {
+	case forced != "":
+		output = forced
+	case keepInput:
+		output = input
+	case input == FormatJSON:
+		output = FormatTOML
+	default:
+		output = FormatJSON
+	}
+	return input, output
+}
+
+// utf8BOM is the byte order mark some editors, mostly on Windows, write at
+// the start of UTF-8 files
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// readInput reads the whole input, stripping a leading UTF-8 byte order mark
+// and rejecting encodings other than UTF-8 with a clear error
+func readInput(input io.Reader) ([]byte, error) {
+	raw, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(raw, []byte{0xFF, 0xFE}) || bytes.HasPrefix(raw, []byte{0xFE, 0xFF}) {
+		return nil, errors.New("input is UTF-16 encoded; convert it to UTF-8 first")
+	}
+	raw = bytes.TrimPrefix(raw, utf8BOM)
+
+	if !utf8.Valid(raw) {
+		offset := 0
+		for offset < len(raw) {
+			r, size := utf8.DecodeRune(raw[offset:])
+			if r == utf8.RuneError && size == 1 {
+				break
+			}
+			offset += size
+		}
+		line := bytes.Count(raw[:offset], []byte("\n")) + 1
+		return nil, fmt.Errorf("input is not valid UTF-8 (invalid byte 0x%02X at line %d)", raw[offset], line)
+	}
+	return raw, nil
+}
+
+// decode reads a single document in the given format into generic values.
+// With opts.PreserveOrder, objects are decoded as *OrderedMap.
+func decode(input io.Reader, format Format, opts Options) (interface{}, error) {
+	raw, err := readInput(input)
+	if err != nil {
+		return nil, err
+	}
+	input = bytes.NewReader(raw)
+
+	// Input that is empty or only whitespace holds no JSON or YAML document.
+	// An empty TOML document is a valid empty table.
+	if len(bytes.TrimSpace(raw)) == 0 {
+		if opts.NullOnEmpty {
+			return nil, nil
+		}
+		if format != FormatTOML {
+			return nil, errors.New("empty input")
+		}
+	}
+
+	var data interface{}
+
+	switch format {
+	case FormatTOML:
+		if err := toml.NewDecoder(input).Decode(&data); err != nil {
+			return nil, err
+		}
+	case FormatJSON:
+		if err := json.NewDecoder(input).Decode(&data); err != nil {
+			return nil, err
+		}
+	case FormatYAML:
+		if err := yaml.NewDecoder(input).Decode(&data); err != nil {
+			return nil, err
+		}
+		// YAML allows non-string keys, which the filters don't understand
+		data = normalizeYAML(data)
+	default:
+		return nil, fmt.Errorf("unsupported input format: %q", format)
+	}
+
+	if opts.PreserveOrder {
+		return applyKeyOrder(data, raw, format)
+	}
+	return data, nil
+}
+
+// encode writes each value in the given format, one after another. Compact
+// affects JSON and TOML output; raw and NaNAs only affect JSON.
+func encode(output io.Writer, values []interface{}, format Format, opts Options) error {
+	compact, raw := opts.Compact, opts.Raw
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(output)
+		if !compact {
+			encoder.SetIndent("", "  ")
+		}
+		for i, value := range values {
+			value, err := replaceNonFinite(value, opts.NaNAs)
+			if err != nil {
+				return err
+			}
+			// Handle raw output (unwrap top-level values), one per line
+			if raw {
+				if i > 0 {
+					fmt.Fprintln(output)
+				}
+				if err := outputRaw(value, output, compact); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := encoder.Encode(value); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatTOML:
+		// Compact output drops the blank lines between tables; indentation
+		// is set separately by opts.TOML
+		var buf bytes.Buffer
+		encoder := toml.NewEncoder(&buf)
+		encoder.SetIndentTables(opts.TOML.IndentTables)
+		if opts.TOML.IndentSymbol != "" {
+			encoder.SetIndentSymbol(opts.TOML.IndentSymbol)
+		}
+		for _, value := range values {
+			if err := encoder.Encode(plainValue(value)); err != nil {
+				return err
+			}
+		}
+		encoded := buf.Bytes()
+		if compact {
+			encoded = stripBlankLines(encoded)
+		}
+		_, err := output.Write(encoded)
+		return err
+	case FormatYAML:
+		// Multiple values are written as separate YAML documents
+		encoder := yaml.NewEncoder(output)
+		encoder.SetIndent(2)
+		for _, value := range values {
+			if err := encoder.Encode(value); err != nil {
+				return err
+			}
+		}
+		return encoder.Close()
+	}
+	return fmt.Errorf("unsupported output format: %q", format)
+}
+
+// stripBlankLines removes empty lines from encoded TOML. This is safe because
+// the encoder escapes newlines inside strings rather than writing multi-line
+// strings.
+func stripBlankLines(encoded []byte) []byte {
+	var out bytes.Buffer
+	for _, line := range bytes.SplitAfter(encoded, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) > 0 {
+			out.Write(line)
+		}
+	}
+	return out.Bytes()
+}
+
+// normalizeYAML converts maps with non-string keys, which YAML permits,
+// into the map[string]interface{} shape produced by the other decoders
+func normalizeYAML(data interface{}) interface{} {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = normalizeYAML(value)
+		}
+		return v
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = normalizeYAML(value)
+		}
+		return m
+	case []interface{}:
+		for i, value := range v {
+			v[i] = normalizeYAML(value)
+		}
+		return v
+	}
+	return data
+}
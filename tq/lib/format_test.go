@@ -0,0 +1,315 @@
+package lib
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestFormatFromExt(t *testing.T) {
+	tests := map[string]Format{
+		"config.toml":  FormatTOML,
+		"data.JSON":    FormatJSON,
+		"compose.yaml": FormatYAML,
+		"ci.yml":       FormatYAML,
+		"notes.txt":    "",
+		"":             "",
+	}
+
+	for filename, want := range tests {
+		if got := FormatFromExt(filename); got != want {
+			t.Errorf("FormatFromExt(%q) = %q, want %q", filename, got, want)
+		}
+	}
+}
+
+func TestChooseFormats(t *testing.T) {
+	tests := []struct {
+		filename      string
+		forced        Format
+		keepInput     bool
+		input, output Format
+	}{
+		{"config.toml", "", false, FormatTOML, FormatJSON},
+		{"data.json", "", false, FormatJSON, FormatTOML},
+		{"ci.yml", "", false, FormatYAML, FormatJSON},
+		{"ci.yml", "", true, FormatYAML, FormatYAML},
+		{"data.json", FormatYAML, true, FormatJSON, FormatYAML},
+		// Without an extension the input is TOML, or JSON when TOML is asked for
+		{"", "", false, FormatTOML, FormatJSON},
+		{"", FormatTOML, false, FormatJSON, FormatTOML},
+		{"conf", "", true, FormatTOML, FormatTOML},
+		{"conf", FormatTOML, true, FormatJSON, FormatTOML},
+	}
+	for _, tt := range tests {
+		input, output := ChooseFormats(tt.filename, tt.forced, tt.keepInput)
+		if input != tt.input || output != tt.output {
+			t.Errorf("ChooseFormats(%q, %q, %v) = %q, %q; want %q, %q",
+				tt.filename, tt.forced, tt.keepInput, input, output, tt.input, tt.output)
+		}
+	}
+}
+
+func TestYamlToJson(t *testing.T) {
+	yamlData := `
+title: YAML Example
+owner:
+  name: Tom Preston-Werner
+ports:
+  - 8000
+  - 8001
+`
+	expectedJson := `{
+  "owner": {
+    "name": "Tom Preston-Werner"
+  },
+  "ports": [
+    8000,
+    8001
+  ],
+  "title": "YAML Example"
+}
+`
+
+	output := &bytes.Buffer{}
+	if err := YamlToJson(strings.NewReader(yamlData), output); err != nil {
+		t.Fatalf("YamlToJson failed: %v", err)
+	}
+
+	actual := strings.TrimSpace(output.String())
+	expected := strings.TrimSpace(expectedJson)
+	if actual != expected {
+		t.Errorf("Expected JSON:\n%s\n\nGot:\n%s", expected, actual)
+	}
+}
+
+func TestYamlFilter(t *testing.T) {
+	yamlData := `
+users:
+  - name: alice
+  - name: bob
+`
+
+	output := &bytes.Buffer{}
+	if err := YamlToJsonWithFilter(strings.NewReader(yamlData), output, ".users[-1].name", false, true); err != nil {
+		t.Fatalf("YamlToJsonWithFilter failed: %v", err)
+	}
+	if got := output.String(); got != "bob" {
+		t.Errorf("Expected bob, got %q", got)
+	}
+}
+
+func TestYamlNonStringKeys(t *testing.T) {
+	output := &bytes.Buffer{}
+	if err := YamlToJsonWithFilter(strings.NewReader("codes:\n  200: ok\n  404: missing\n"), output, ".codes", true, false); err != nil {
+		t.Fatalf("YamlToJsonWithFilter failed: %v", err)
+	}
+	if got := strings.TrimSpace(output.String()); got != `{"200":"ok","404":"missing"}` {
+		t.Errorf("Unexpected output: %s", got)
+	}
+}
+
+func TestYamlRoundTrip(t *testing.T) {
+	// Test YAML -> JSON -> YAML
+	originalYaml := `
+title: Round Trip Test
+nested:
+  value: 42
+  enabled: true
+  deeper:
+    tags:
+      - a
+      - b
+servers:
+  - name: alpha
+    ports: [80, 443]
+  - name: beta
+    ports: []
+`
+
+	jsonOutput := &bytes.Buffer{}
+	if err := YamlToJson(strings.NewReader(originalYaml), jsonOutput); err != nil {
+		t.Fatalf("YamlToJson failed: %v", err)
+	}
+
+	yamlOutput := &bytes.Buffer{}
+	if err := JsonToYaml(strings.NewReader(jsonOutput.String()), yamlOutput); err != nil {
+		t.Fatalf("JsonToYaml failed: %v", err)
+	}
+
+	var original, final interface{}
+	if err := yaml.Unmarshal([]byte(originalYaml), &original); err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal(yamlOutput.Bytes(), &final); err != nil {
+		t.Fatalf("Final YAML does not parse: %v\n%s", err, yamlOutput.String())
+	}
+
+	if !reflect.DeepEqual(original, final) {
+		t.Errorf("Round trip conversion failed.\nOriginal YAML:\n%s\n\nFinal YAML:\n%s",
+			strings.TrimSpace(originalYaml), yamlOutput.String())
+	}
+}
+
+func TestTomlToYaml(t *testing.T) {
+	output := &bytes.Buffer{}
+	err := ConvertWithFilter(strings.NewReader("[owner]\nname = \"Tom\"\n"), output, FormatTOML, FormatYAML, ".", false, false)
+	if err != nil {
+		t.Fatalf("ConvertWithFilter failed: %v", err)
+	}
+	if got := output.String(); got != "owner:\n  name: Tom\n" {
+		t.Errorf("Unexpected YAML output:\n%s", got)
+	}
+}
+
+func TestDecodeBOM(t *testing.T) {
+	bom := "\xEF\xBB\xBF"
+	tests := []struct {
+		name   string
+		format Format
+		input  string
+	}{
+		{name: "json", format: FormatJSON, input: bom + `{"name": "tq"}`},
+		{name: "toml", format: FormatTOML, input: bom + `name = "tq"`},
+		{name: "yaml", format: FormatYAML, input: bom + "name: tq\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := &bytes.Buffer{}
+			if err := ConvertWithFilter(strings.NewReader(tt.input), output, tt.format, FormatJSON, ".name", false, true); err != nil {
+				t.Fatalf("ConvertWithFilter failed: %v", err)
+			}
+			if got := output.String(); got != "tq" {
+				t.Errorf("Unexpected output: %q", got)
+			}
+		})
+	}
+}
+
+func TestDecodeInvalidEncoding(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		errMsg string
+	}{
+		{name: "utf16le", input: "\xFF\xFEn\x00=\x001\x00", errMsg: "UTF-16"},
+		{name: "utf16be", input: "\xFE\xFF\x00n\x00=\x001", errMsg: "UTF-16"},
+		{name: "latin1", input: "a = 1\nname = \"caf\xE9\"\n", errMsg: "invalid byte 0xE9 at line 2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := TomlToJson(strings.NewReader(tt.input), &bytes.Buffer{})
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("error = %v, want %q", err, tt.errMsg)
+			}
+		})
+	}
+}
+
+func TestTomlIndentTables(t *testing.T) {
+	input := `{"name": "tq", "server": {"port": 80, "tls": {"enabled": true}}}`
+
+	plain := &bytes.Buffer{}
+	if err := JsonToTomlWithFilter(strings.NewReader(input), plain, ".", true); err != nil {
+		t.Fatalf("JsonToTomlWithFilter failed: %v", err)
+	}
+
+	indented := &bytes.Buffer{}
+	if err := JsonToTomlWithFilter(strings.NewReader(input), indented, ".", true, TomlOptions{IndentTables: true}); err != nil {
+		t.Fatalf("JsonToTomlWithFilter failed: %v", err)
+	}
+	if indented.String() == plain.String() {
+		t.Fatalf("Expected indented tables to differ from the default:\n%s", plain.String())
+	}
+	want := "name = 'tq'\n[server]\n  port = 80.0\n  [server.tls]\n    enabled = true\n"
+	if got := indented.String(); got != want {
+		t.Errorf("Unexpected indented TOML:\n%s\nwant:\n%s", got, want)
+	}
+
+	tabs := &bytes.Buffer{}
+	if err := JsonToTomlWithFilter(strings.NewReader(input), tabs, ".", true, TomlOptions{IndentTables: true, IndentSymbol: "\t"}); err != nil {
+		t.Fatalf("JsonToTomlWithFilter failed: %v", err)
+	}
+	if got, want := tabs.String(), strings.ReplaceAll(want, "  ", "\t"); got != want {
+		t.Errorf("Unexpected tab-indented TOML:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The indented output is still valid TOML with the same data
+	roundTrip := &bytes.Buffer{}
+	if err := TomlToJsonWithFilter(strings.NewReader(indented.String()), roundTrip, ".server.tls.enabled", true, false); err != nil {
+		t.Fatalf("Indented TOML does not parse: %v", err)
+	}
+	if got := strings.TrimSpace(roundTrip.String()); got != "true" {
+		t.Errorf("Indented TOML round trip = %s, want true", got)
+	}
+}
+
+func TestTomlCompact(t *testing.T) {
+	input := `{"name": "tq", "note": "line one\n\nline three", "server": {"port": 80, "tls": {"enabled": true}}}`
+
+	pretty := &bytes.Buffer{}
+	if err := JsonToTomlWithFilter(strings.NewReader(input), pretty, ".", false); err != nil {
+		t.Fatalf("JsonToTomlWithFilter failed: %v", err)
+	}
+	if !strings.Contains(pretty.String(), "\n\n[server]") {
+		t.Fatalf("Expected blank lines between tables by default:\n%s", pretty.String())
+	}
+
+	compact := &bytes.Buffer{}
+	if err := JsonToTomlWithFilter(strings.NewReader(input), compact, ".", true); err != nil {
+		t.Fatalf("JsonToTomlWithFilter failed: %v", err)
+	}
+	want := "name = 'tq'\nnote = \"line one\\n\\nline three\"\n[server]\nport = 80.0\n[server.tls]\nenabled = true\n"
+	if got := compact.String(); got != want {
+		t.Errorf("Unexpected compact TOML:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The compact output is still valid TOML with the same data
+	roundTrip := &bytes.Buffer{}
+	if err := TomlToJsonWithFilter(strings.NewReader(compact.String()), roundTrip, ".server.tls.enabled", true, false); err != nil {
+		t.Fatalf("Compact TOML does not parse: %v", err)
+	}
+	if strings.TrimSpace(roundTrip.String()) != "true" {
+		t.Errorf("Unexpected round trip value: %s", roundTrip.String())
+	}
+}
+
+func TestDecodeEmptyInput(t *testing.T) {
+	for _, input := range []string{"", "  \n\t\n"} {
+		for _, format := range []Format{FormatJSON, FormatYAML} {
+			if _, err := decode(strings.NewReader(input), format, Options{}); err == nil || err.Error() != "empty input" {
+				t.Errorf("decode(%q, %s) error = %v, want empty input", input, format, err)
+			}
+		}
+
+		// An empty TOML document is an empty table
+		data, err := decode(strings.NewReader(input), FormatTOML, Options{})
+		if err != nil {
+			t.Fatalf("decode(%q, toml) failed: %v", input, err)
+		}
+		if m, ok := data.(map[string]interface{}); !ok || len(m) != 0 {
+			t.Errorf("decode(%q, toml) = %#v, want an empty table", input, data)
+		}
+	}
+}
+
+func TestNullOnEmpty(t *testing.T) {
+	for _, format := range []Format{FormatJSON, FormatYAML, FormatTOML} {
+		output := &bytes.Buffer{}
+		results, err := Convert(strings.NewReader(" \n"), output, format, FormatJSON, ".", Options{NullOnEmpty: true})
+		if err != nil {
+			t.Fatalf("Convert(%s) failed: %v", format, err)
+		}
+		if got := strings.TrimSpace(output.String()); got != "null" {
+			t.Errorf("Convert(%s) = %s, want null", format, got)
+		}
+		if ExitStatus(results) != ExitFalsy {
+			t.Errorf("Expected a null result to be falsy, got %v", results)
+		}
+	}
+}
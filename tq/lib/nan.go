@@ -0,0 +1,97 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+)
+
+// NaNMode chooses how JSON output represents NaN and infinite numbers, which
+// JSON has no syntax for. YAML and TOML sources can contain them, and so can
+// arithmetic that overflows.
+type NaNMode string
+
+const (
+	// NaNError fails the conversion, as encoding/json does. It is the
+	// default.
+	NaNError NaNMode = "error"
+	// NaNNull writes null in place of the number
+	NaNNull NaNMode = "null"
+	// NaNString writes "NaN", "Infinity" or "-Infinity", the spellings
+	// JavaScript uses
+	NaNString NaNMode = "string"
+)
+
+// ParseNaNMode validates a mode given on the command line
+func ParseNaNMode(s string) (NaNMode, error) {
+	switch mode := NaNMode(s); mode {
+	case NaNError, NaNNull, NaNString:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid NaN mode %q: expected null, string or error", s)
+}
+
+// replaceNonFinite returns value with every NaN and infinity replaced as
+// mode says. Objects and arrays are copied rather than changed in place,
+// since filter results may share them.
+func replaceNonFinite(value interface{}, mode NaNMode) (interface{}, error) {
+	switch v := value.(type) {
+	case float64:
+		return nonFinite(v, mode)
+	case float32:
+		return nonFinite(float64(v), mode)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			replaced, err := replaceNonFinite(elem, mode)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = replaced
+		}
+		return out, nil
+	case *OrderedMap:
+		out := &OrderedMap{keys: v.keys, values: make(map[string]interface{}, len(v.values))}
+		for key, elem := range v.values {
+			replaced, err := replaceNonFinite(elem, mode)
+			if err != nil {
+				return nil, err
+			}
+			out.values[key] = replaced
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, elem := range v {
+			replaced, err := replaceNonFinite(elem, mode)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = replaced
+		}
+		return out, nil
+	}
+	return value, nil
+}
+
+// nonFinite replaces f when it is NaN or infinite
+func nonFinite(f float64, mode NaNMode) (interface{}, error) {
+	var name string
+	switch {
+	case math.IsNaN(f):
+		name = "NaN"
+	case math.IsInf(f, 1):
+		name = "Infinity"
+	case math.IsInf(f, -1):
+		name = "-Infinity"
+	default:
+		return f, nil
+	}
+
+	switch mode {
+	case NaNNull:
+		return nil, nil
+	case NaNString:
+		return name, nil
+	}
+	return nil, fmt.Errorf("cannot encode %s as JSON, which has no representation for it", name)
+}
@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestNaNAs(t *testing.T) {
+	input := "a: .nan\nb: [1, .inf, -.inf]\nc: 1.5\n"
+
+	tests := []struct {
+		mode NaNMode
+		opts Options
+		want string
+	}{
+		{mode: NaNNull, want: `{"a":null,"b":[1,null,null],"c":1.5}`},
+		{mode: NaNString, want: `{"a":"NaN","b":[1,"Infinity","-Infinity"],"c":1.5}`},
+		{mode: NaNString, opts: Options{PreserveOrder: true}, want: `{"a":"NaN","b":[1,"Infinity","-Infinity"],"c":1.5}`},
+		{mode: NaNString, opts: Options{NulOutput: true}, want: "{\"a\":\"NaN\",\"b\":[1,\"Infinity\",\"-Infinity\"],\"c\":1.5}\x00"},
+	}
+	for _, tt := range tests {
+		output := &bytes.Buffer{}
+		opts := tt.opts
+		opts.Compact = true
+		opts.NaNAs = tt.mode
+		if _, err := Convert(strings.NewReader(input), output, FormatYAML, FormatJSON, ".", opts); err != nil {
+			t.Fatalf("%s: Convert failed: %v", tt.mode, err)
+		}
+		if got := strings.TrimSpace(output.String()); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.mode, got, tt.want)
+		}
+	}
+
+	// A raw string result is written without quotes
+	output := &bytes.Buffer{}
+	if _, err := Convert(strings.NewReader("x = nan\n"), output, FormatTOML, FormatJSON, ".x", Options{Raw: true, NaNAs: NaNString}); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if got := output.String(); got != "NaN" {
+		t.Errorf("raw output = %q, want NaN", got)
+	}
+}
+
+func TestNaNAsError(t *testing.T) {
+	for _, mode := range []NaNMode{"", NaNError} {
+		_, err := Convert(strings.NewReader("b: [1, -.inf]\n"), &bytes.Buffer{}, FormatYAML, FormatJSON, ".", Options{NaNAs: mode})
+		if err == nil || !strings.Contains(err.Error(), "-Infinity") {
+			t.Errorf("mode %q: expected an error naming -Infinity, got %v", mode, err)
+		}
+	}
+
+	// Other output formats can represent these numbers
+	output := &bytes.Buffer{}
+	if _, err := Convert(strings.NewReader("a: .nan\n"), output, FormatYAML, FormatYAML, ".", Options{}); err != nil {
+		t.Fatalf("YAML output failed: %v", err)
+	}
+	if got := strings.TrimSpace(output.String()); got != "a: .nan" {
+		t.Errorf("YAML output = %q", got)
+	}
+}
+
+func TestParseNaNMode(t *testing.T) {
+	for _, s := range []string{"null", "string", "error"} {
+		if mode, err := ParseNaNMode(s); err != nil || string(mode) != s {
+			t.Errorf("ParseNaNMode(%q) = %q, %v", s, mode, err)
+		}
+	}
+	if _, err := ParseNaNMode("zero"); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
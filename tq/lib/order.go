@@ -0,0 +1,355 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/pelletier/go-toml/v2/unstable"
+	"gopkg.in/yaml.v3"
+)
+
+// OrderedMap is an object that remembers the order in which its keys
+// appeared in the source document. It is produced instead of
+// map[string]interface{} when key order is preserved.
+type OrderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// Keys returns the object's keys in document order
+func (m *OrderedMap) Keys() []string {
+	return m.keys
+}
+
+// Get returns the value stored under key
+func (m *OrderedMap) Get(key string) (interface{}, bool) {
+	value, ok := m.values[key]
+	return value, ok
+}
+
+// Set stores value under key, adding the key at the end if it is new
+func (m *OrderedMap) Set(key string, value interface{}) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// MarshalJSON writes the object with its keys in document order
+func (m *OrderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// MarshalYAML writes the object as a mapping with its keys in document order
+func (m *OrderedMap) MarshalYAML() (interface{}, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, key := range m.keys {
+		var k, v yaml.Node
+		if err := k.Encode(key); err != nil {
+			return nil, err
+		}
+		if err := v.Encode(m.values[key]); err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, &k, &v)
+	}
+	return node, nil
+}
+
+// objectField looks up a key in either kind of object. The last result
+// reports whether value was an object at all.
+func objectField(value interface{}, key string) (interface{}, bool, bool) {
+	switch m := value.(type) {
+	case map[string]interface{}:
+		field, ok := m[key]
+		return field, ok, true
+	case *OrderedMap:
+		field, ok := m.Get(key)
+		return field, ok, true
+	}
+	return nil, false, false
+}
+
+// objectValues returns the values of either kind of object, in document
+// order when known and sorted by key otherwise
+func objectValues(value interface{}) ([]interface{}, bool) {
+	switch m := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		values := make([]interface{}, 0, len(m))
+		for _, key := range keys {
+			values = append(values, m[key])
+		}
+		return values, true
+	case *OrderedMap:
+		values := make([]interface{}, 0, len(m.keys))
+		for _, key := range m.keys {
+			values = append(values, m.values[key])
+		}
+		return values, true
+	}
+	return nil, false
+}
+
+// plainValue converts any OrderedMap values back into plain maps, for
+// encoders that cannot make use of the order
+func plainValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *OrderedMap:
+		m := make(map[string]interface{}, len(v.keys))
+		for _, key := range v.keys {
+			m[key] = plainValue(v.values[key])
+		}
+		return m
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, elem := range v {
+			out[i] = plainValue(elem)
+		}
+		return out
+	}
+	return value
+}
+
+// keyOrder records, for every object in a document, the order in which its
+// keys first appeared. Objects are identified by their path from the root.
+type keyOrder struct {
+	keys map[string][]string
+	seen map[string]bool
+	// arrayTables counts the elements of each TOML array of tables
+	arrayTables map[string]int
+}
+
+func newKeyOrder() *keyOrder {
+	return &keyOrder{
+		keys:        make(map[string][]string),
+		seen:        make(map[string]bool),
+		arrayTables: make(map[string]int),
+	}
+}
+
+// childPath returns the path of a key below path
+func childPath(path, key string) string {
+	return path + "\x00" + key
+}
+
+// elemPath returns the path of an array element below path
+func elemPath(path string, i int) string {
+	return path + "\x00[" + strconv.Itoa(i) + "]"
+}
+
+// add records a key of the object at path unless it was already seen
+func (o *keyOrder) add(path, key string) {
+	id := childPath(path, key)
+	if o.seen[id] {
+		return
+	}
+	o.seen[id] = true
+	o.keys[path] = append(o.keys[path], key)
+}
+
+// apply rebuilds every object in data as an OrderedMap. Keys missing from
+// the recorded order are appended in sorted order.
+func (o *keyOrder) apply(data interface{}, path string) interface{} {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		m := &OrderedMap{values: make(map[string]interface{}, len(v))}
+		for _, key := range o.keys[path] {
+			if _, ok := v[key]; ok {
+				m.keys = append(m.keys, key)
+			}
+		}
+		var rest []string
+		for key := range v {
+			if !o.seen[childPath(path, key)] {
+				rest = append(rest, key)
+			}
+		}
+		sort.Strings(rest)
+		m.keys = append(m.keys, rest...)
+		for _, key := range m.keys {
+			m.values[key] = o.apply(v[key], childPath(path, key))
+		}
+		return m
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = o.apply(elem, elemPath(path, i))
+		}
+		return v
+	}
+	return data
+}
+
+// applyKeyOrder scans the raw document for key order and applies it to the
+// already decoded data
+func applyKeyOrder(data interface{}, raw []byte, format Format) (interface{}, error) {
+	order := newKeyOrder()
+	var err error
+	switch format {
+	case FormatTOML:
+		err = order.scanTOML(raw)
+	case FormatJSON:
+		err = order.scanJSON(json.NewDecoder(bytes.NewReader(raw)), "")
+	case FormatYAML:
+		var doc yaml.Node
+		if err = yaml.Unmarshal(raw, &doc); err == nil {
+			order.scanYAML(&doc, "")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return order.apply(data, ""), nil
+}
+
+// scanTOML records key order from the TOML expressions in the document
+func (o *keyOrder) scanTOML(raw []byte) error {
+	var p unstable.Parser
+	p.Reset(raw)
+
+	table := ""
+	for p.NextExpression() {
+		expr := p.Expression()
+		switch expr.Kind {
+		case unstable.Table:
+			table = o.addTOMLKey("", expr.Key(), false)
+		case unstable.ArrayTable:
+			table = o.addTOMLKey("", expr.Key(), true)
+		case unstable.KeyValue:
+			o.addTOMLValue(o.addTOMLKey(table, expr.Key(), false), expr.Value())
+		}
+	}
+	return p.Error()
+}
+
+// addTOMLKey records each part of a possibly dotted key and returns the path
+// it refers to. Keys that name an array of tables refer to its last element.
+func (o *keyOrder) addTOMLKey(path string, key unstable.Iterator, arrayTable bool) string {
+	for key.Next() {
+		last := key.IsLast()
+		name := string(key.Node().Data)
+		o.add(path, name)
+		path = childPath(path, name)
+		if arrayTable && last {
+			o.arrayTables[path]++
+		}
+		if n, ok := o.arrayTables[path]; ok {
+			path = elemPath(path, n-1)
+		}
+	}
+	return path
+}
+
+// addTOMLValue records key order inside inline tables and arrays
+func (o *keyOrder) addTOMLValue(path string, value *unstable.Node) {
+	switch value.Kind {
+	case unstable.InlineTable:
+		it := value.Children()
+		for it.Next() {
+			kv := it.Node()
+			o.addTOMLValue(o.addTOMLKey(path, kv.Key(), false), kv.Value())
+		}
+	case unstable.Array:
+		it := value.Children()
+		for i := 0; it.Next(); i++ {
+			o.addTOMLValue(elemPath(path, i), it.Node())
+		}
+	}
+}
+
+// scanJSON records key order from the next JSON value in the token stream
+func (o *keyOrder) scanJSON(dec *json.Decoder, path string) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok {
+	case json.Delim('{'):
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key, ok := tok.(string)
+			if !ok {
+				return errors.New("invalid object key")
+			}
+			o.add(path, key)
+			if err := o.scanJSON(dec, childPath(path, key)); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+	case json.Delim('['):
+		for i := 0; dec.More(); i++ {
+			if err := o.scanJSON(dec, elemPath(path, i)); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+	}
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// scanYAML records key order from a YAML node tree, following aliases and
+// merge keys
+func (o *keyOrder) scanYAML(node *yaml.Node, path string) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			o.scanYAML(child, path)
+		}
+	case yaml.AliasNode:
+		o.scanYAML(node.Alias, path)
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Tag == "!!merge" {
+				merged := []*yaml.Node{value}
+				if value.Kind == yaml.SequenceNode {
+					merged = value.Content
+				}
+				for _, m := range merged {
+					o.scanYAML(m, path)
+				}
+				continue
+			}
+			o.add(path, key.Value)
+			o.scanYAML(value, childPath(path, key.Value))
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			o.scanYAML(child, elemPath(path, i))
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package lib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func convertOrdered(t *testing.T, input string, from, to Format, filter string) string {
+	t.Helper()
+	output := &bytes.Buffer{}
+	opts := Options{Compact: true, PreserveOrder: true}
+	if _, err := Convert(strings.NewReader(input), output, from, to, filter, opts); err != nil {
+		t.Fatalf("Convert(%q) failed: %v", filter, err)
+	}
+	return strings.TrimSpace(output.String())
+}
+
+func TestPreserveOrderToml(t *testing.T) {
+	input := `
+title = "Round Trip Test"
+version = 2
+alpha.zeta = 1
+alpha.beta = 2
+
+[nested]
+value = 42
+enabled = true
+inline = { z = 1, a = { y = 2, b = 3 } }
+list = [{ z = 1, a = 2 }]
+
+[[servers]]
+name = "b"
+ip = "10.0.0.2"
+
+[servers.limits]
+soft = 1
+hard = 2
+
+[[servers]]
+name = "a"
+port = 80
+ip = "10.0.0.1"
+
+[database]
+"quoted key" = true
+enabled = false
+`
+
+	tests := []struct {
+		filter string
+		want   string
+	}{
+		{
+			filter: ".",
+			want: `{"title":"Round Trip Test","version":2,"alpha":{"zeta":1,"beta":2},` +
+				`"nested":{"value":42,"enabled":true,"inline":{"z":1,"a":{"y":2,"b":3}},"list":[{"z":1,"a":2}]},` +
+				`"servers":[{"name":"b","ip":"10.0.0.2","limits":{"soft":1,"hard":2}},{"name":"a","port":80,"ip":"10.0.0.1"}],` +
+				`"database":{"quoted key":true,"enabled":false}}`,
+		},
+		{filter: ".nested", want: `{"value":42,"enabled":true,"inline":{"z":1,"a":{"y":2,"b":3}},"list":[{"z":1,"a":2}]}`},
+		{filter: ".servers[0].limits", want: `{"soft":1,"hard":2}`},
+		{filter: ".nested.inline[]", want: "1\n{\"y\":2,\"b\":3}"},
+		{filter: ".servers[].name", want: "\"b\"\n\"a\""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			if got := convertOrdered(t, input, FormatTOML, FormatJSON, tt.filter); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreserveOrderJson(t *testing.T) {
+	input := `{"zebra": 1, "apple": {"y": true, "b": [{"k2": 1, "k1": 2}]}, "mango": null}`
+
+	tests := []struct {
+		filter string
+		want   string
+	}{
+		{filter: ".", want: `{"zebra":1,"apple":{"y":true,"b":[{"k2":1,"k1":2}]},"mango":null}`},
+		{filter: ".apple", want: `{"y":true,"b":[{"k2":1,"k1":2}]}`},
+		{filter: ".apple.b[]", want: `{"k2":1,"k1":2}`},
+		{filter: ".apple | .b | .[0]", want: `{"k2":1,"k1":2}`},
+		{filter: ".[]", want: "1\n{\"y\":true,\"b\":[{\"k2\":1,\"k1\":2}]}\nnull"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			if got := convertOrdered(t, input, FormatJSON, FormatJSON, tt.filter); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreserveOrderJsonPretty(t *testing.T) {
+	output := &bytes.Buffer{}
+	opts := Options{PreserveOrder: true}
+	if _, err := Convert(strings.NewReader(`{"b": {"z": 1, "a": [2]}, "a": "x"}`), output, FormatJSON, FormatJSON, ".", opts); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	want := "{\n  \"b\": {\n    \"z\": 1,\n    \"a\": [\n      2\n    ]\n  },\n  \"a\": \"x\"\n}"
+	if got := strings.TrimSpace(output.String()); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPreserveOrderYaml(t *testing.T) {
+	input := `
+defaults: &defaults
+  timeout: 30
+  retries: 3
+service:
+  name: api
+  <<: *defaults
+  port: 8080
+`
+	want := "defaults:\n  timeout: 30\n  retries: 3\nservice:\n  name: api\n  timeout: 30\n  retries: 3\n  port: 8080"
+	if got := convertOrdered(t, input, FormatYAML, FormatYAML, "."); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPreserveOrderTomlOutput(t *testing.T) {
+	// TOML output cannot keep the order but must still encode ordered objects
+	got := convertOrdered(t, `{"b": 1, "a": {"d": 2, "c": 3}}`, FormatJSON, FormatTOML, ".")
+	for _, want := range []string{"b = 1", "[a]", "d = 2", "c = 3"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TOML output missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestWithoutPreserveOrder(t *testing.T) {
+	output := &bytes.Buffer{}
+	if err := TomlToJsonWithFilter(strings.NewReader("b = 1\na = 2\n"), output, ".", true, false); err != nil {
+		t.Fatalf("TomlToJsonWithFilter failed: %v", err)
+	}
+	if got := strings.TrimSpace(output.String()); got != `{"a":2,"b":1}` {
+		t.Errorf("Unexpected output: %s", got)
+	}
+}
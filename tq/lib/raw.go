@@ -0,0 +1,66 @@
+package lib
+
+import (
+	"bytes"
+	"io"
+)
+
+// readRecords splits raw input into strings at each sep. A separator at the
+// very end, such as the final newline of a file or the NUL after the last
+// name from find -print0, does not start another record.
+func readRecords(input io.Reader, sep byte) ([]interface{}, error) {
+	raw, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	raw = bytes.TrimSuffix(raw, []byte{sep})
+
+	var records []interface{}
+	for _, record := range bytes.Split(raw, []byte{sep}) {
+		records = append(records, string(record))
+	}
+	return records, nil
+}
+
+// filterRecords applies the filter to each raw input record in turn
+func filterRecords(input io.Reader, filter string, opts Options) ([]interface{}, error) {
+	sep := byte('\n')
+	if opts.NulInput {
+		sep = 0
+	}
+	records, err := readRecords(input, sep)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []interface{}
+	for _, record := range records {
+		filtered, err := applyFilter(record, filter)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, filtered...)
+	}
+	return results, nil
+}
+
+// encodeNul writes each value raw, as -r would, followed by a NUL byte so
+// that strings containing newlines stay unambiguous
+func encodeNul(output io.Writer, values []interface{}, opts Options) error {
+	for _, value := range values {
+		value, err := replaceNonFinite(value, opts.NaNAs)
+		if err != nil {
+			return err
+		}
+		if err := outputRaw(value, output, opts.Compact); err != nil {
+			return err
+		}
+		if _, err := output.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package lib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRawInput(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		filter string
+		opts   Options
+		want   string
+	}{
+		{name: "lines", input: "alpha\nbeta\n", filter: ".", opts: Options{RawInput: true, Compact: true}, want: "\"alpha\"\n\"beta\"\n"},
+		{name: "no trailing newline", input: "alpha\nbeta", filter: ".[0:1]", opts: Options{RawInput: true, Raw: true}, want: "a\nb"},
+		{name: "blank line kept", input: "a\n\nb\n", filter: ".", opts: Options{RawInput: true, Compact: true}, want: "\"a\"\n\"\"\n\"b\"\n"},
+		{name: "empty", input: "", filter: ".", opts: Options{RawInput: true}, want: ""},
+		{name: "nul", input: "./a b\x00./new\nline\x00", filter: ".[2:]", opts: Options{NulInput: true, Compact: true}, want: "\"a b\"\n\"new\\nline\"\n"},
+		{name: "nul in and out", input: "./a b\x00./new\nline\x00", filter: ".[2:]", opts: Options{NulInput: true, NulOutput: true}, want: "a b\x00new\nline\x00"},
+		{name: "nul output from document", input: `{"names": ["x", "y\nz"]}`, filter: ".names[]", opts: Options{NulOutput: true}, want: "x\x00y\nz\x00"},
+		{name: "builtin", input: "v1.2\nmain\n", filter: `test("^v\\d")`, opts: Options{RawInput: true, Compact: true}, want: "true\nfalse\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := &bytes.Buffer{}
+			if _, err := Convert(strings.NewReader(tt.input), output, FormatJSON, FormatJSON, tt.filter, tt.opts); err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			if got := output.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
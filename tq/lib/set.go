@@ -0,0 +1,182 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Assignment sets the value at a path in a document, as given to --set
+type Assignment struct {
+	Path  string
+	Value interface{}
+}
+
+// ParseAssignment parses path=value, such as .owner.name="Alice". The value
+// is JSON, so strings must be quoted while numbers, booleans, null, arrays
+// and objects are written as they are.
+func ParseAssignment(s string) (Assignment, error) {
+	path, raw, ok := strings.Cut(s, "=")
+	if !ok {
+		return Assignment{}, fmt.Errorf("invalid assignment %q: expected path=value", s)
+	}
+	path = strings.TrimSpace(path)
+	if _, err := parseSetPath(path); err != nil {
+		return Assignment{}, err
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return Assignment{}, fmt.Errorf("invalid value for %s: %v (values are JSON, so strings need quotes: %s=\"...\")", path, err, path)
+	}
+	if decoder.More() {
+		return Assignment{}, fmt.Errorf("invalid value for %s: more than one JSON value", path)
+	}
+	return Assignment{Path: path, Value: jsonNumbers(value)}, nil
+}
+
+// jsonNumbers converts json.Number values to int64 when they are integers
+// and float64 otherwise, so integers stay integers in TOML and YAML output
+func jsonNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for key, elem := range v {
+			v[key] = jsonNumbers(elem)
+		}
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = jsonNumbers(elem)
+		}
+	}
+	return value
+}
+
+// pathStep is one step of a path: an object key or an array index
+type pathStep struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// parseSetPath parses a path that can be assigned to, made of field names
+// and array indexes, such as .servers[0].host. Iterators and slices select
+// no single location, so they are refused.
+func parseSetPath(path string) ([]pathStep, error) {
+	if !strings.HasPrefix(path, ".") {
+		return nil, fmt.Errorf("invalid path %q: must start with .", path)
+	}
+	var steps []pathStep
+	for _, part := range parseFilterParts(path[1:]) {
+		fieldName := part
+		var indexes []string
+		if idxStart := strings.Index(part, "["); idxStart >= 0 && strings.HasSuffix(part, "]") {
+			fieldName = part[:idxStart]
+			var err error
+			if indexes, err = splitIndexes(part[idxStart:]); err != nil {
+				return nil, err
+			}
+		}
+		if fieldName != "" {
+			steps = append(steps, pathStep{key: fieldName})
+		}
+		for _, idxStr := range indexes {
+			idx, err := strconv.Atoi(strings.TrimSpace(idxStr))
+			if err != nil {
+				return nil, fmt.Errorf("invalid path %q: cannot assign to [%s]", path, idxStr)
+			}
+			steps = append(steps, pathStep{index: idx, isIndex: true})
+		}
+	}
+	return steps, nil
+}
+
+// setAtPath stores value at path in data, creating missing objects along
+// the way and padding arrays with nulls up to a new index, and returns the
+// updated document. Objects and arrays in data are updated in place.
+func setAtPath(data interface{}, path string, value interface{}) (interface{}, error) {
+	steps, err := parseSetPath(strings.TrimSpace(path))
+	if err != nil {
+		return nil, err
+	}
+	return setSteps(data, steps, value)
+}
+
+func setSteps(node interface{}, steps []pathStep, value interface{}) (interface{}, error) {
+	if len(steps) == 0 {
+		return value, nil
+	}
+	step, rest := steps[0], steps[1:]
+
+	if step.isIndex {
+		arr, ok := node.([]interface{})
+		if !ok && node != nil {
+			return nil, fmt.Errorf("cannot index %s", typeName(node))
+		}
+		idx := step.index
+		if idx < 0 {
+			idx += len(arr)
+			if idx < 0 {
+				return nil, fmt.Errorf("array index out of bounds: %d", step.index)
+			}
+		}
+		for len(arr) <= idx {
+			arr = append(arr, nil)
+		}
+		child, err := setSteps(arr[idx], rest, value)
+		if err != nil {
+			return nil, err
+		}
+		arr[idx] = child
+		return arr, nil
+	}
+
+	switch m := node.(type) {
+	case nil:
+		child, err := setSteps(nil, rest, value)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{step.key: child}, nil
+	case map[string]interface{}:
+		child, err := setSteps(m[step.key], rest, value)
+		if err != nil {
+			return nil, err
+		}
+		m[step.key] = child
+		return m, nil
+	case *OrderedMap:
+		existing, _ := m.Get(step.key)
+		child, err := setSteps(existing, rest, value)
+		if err != nil {
+			return nil, err
+		}
+		m.Set(step.key, child)
+		return m, nil
+	}
+	return nil, fmt.Errorf("cannot set field '%s' of %s", step.key, typeName(node))
+}
+
+// applyAssignments applies each assignment to data in turn
+func applyAssignments(data interface{}, assignments []Assignment) (interface{}, error) {
+	for _, a := range assignments {
+		var err error
+		if data, err = setAtPath(data, a.Path, a.Value); err != nil {
+			return nil, fmt.Errorf("setting %s: %w", a.Path, err)
+		}
+	}
+	return data, nil
+}
+
+// errSetRawInput is returned when assignments are combined with raw input,
+// which has no document to assign into
+var errSetRawInput = errors.New("assignments cannot be combined with raw input")
@@ -0,0 +1,163 @@
+package lib
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSetAtPath(t *testing.T) {
+	document := func() map[string]interface{} {
+		return map[string]interface{}{
+			"owner": map[string]interface{}{"name": "Bob", "age": int64(40)},
+			"ports": []interface{}{int64(80), int64(443)},
+		}
+	}
+
+	tests := []struct {
+		name  string
+		path  string
+		value interface{}
+		want  interface{}
+	}{
+		{
+			name:  "nested scalar",
+			path:  ".owner.name",
+			value: "Alice",
+			want: map[string]interface{}{
+				"owner": map[string]interface{}{"name": "Alice", "age": int64(40)},
+				"ports": []interface{}{int64(80), int64(443)},
+			},
+		},
+		{
+			name:  "missing path",
+			path:  ".database.primary.host",
+			value: "db1",
+			want: map[string]interface{}{
+				"owner":    map[string]interface{}{"name": "Bob", "age": int64(40)},
+				"ports":    []interface{}{int64(80), int64(443)},
+				"database": map[string]interface{}{"primary": map[string]interface{}{"host": "db1"}},
+			},
+		},
+		{
+			name:  "array element",
+			path:  ".ports[1]",
+			value: int64(8443),
+			want: map[string]interface{}{
+				"owner": map[string]interface{}{"name": "Bob", "age": int64(40)},
+				"ports": []interface{}{int64(80), int64(8443)},
+			},
+		},
+		{
+			name:  "negative index",
+			path:  ".ports[-2]",
+			value: int64(8080),
+			want: map[string]interface{}{
+				"owner": map[string]interface{}{"name": "Bob", "age": int64(40)},
+				"ports": []interface{}{int64(8080), int64(443)},
+			},
+		},
+		{
+			name:  "past the end",
+			path:  ".ports[3]",
+			value: int64(9000),
+			want: map[string]interface{}{
+				"owner": map[string]interface{}{"name": "Bob", "age": int64(40)},
+				"ports": []interface{}{int64(80), int64(443), nil, int64(9000)},
+			},
+		},
+		{
+			name:  "whole document",
+			path:  ".",
+			value: map[string]interface{}{"replaced": true},
+			want:  map[string]interface{}{"replaced": true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := setAtPath(document(), tt.path, tt.value)
+			if err != nil {
+				t.Fatalf("setAtPath(%q) failed: %v", tt.path, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("setAtPath(%q) = %#v, want %#v", tt.path, got, tt.want)
+			}
+		})
+	}
+
+	for _, path := range []string{".owner.name.first", ".owner[0]", ".ports[]", ".ports[0:1]", ".ports[-3]", "owner"} {
+		if _, err := setAtPath(document(), path, "x"); err == nil {
+			t.Errorf("setAtPath(%q) succeeded", path)
+		}
+	}
+}
+
+func TestParseAssignment(t *testing.T) {
+	tests := []struct {
+		input string
+		path  string
+		value interface{}
+	}{
+		{`.owner.name="Alice"`, ".owner.name", "Alice"},
+		{`.port=8080`, ".port", int64(8080)},
+		{`.ratio=0.5`, ".ratio", 0.5},
+		{`.enabled = true`, ".enabled", true},
+		{`.tags=["a", 1]`, ".tags", []interface{}{"a", int64(1)}},
+		{`.owner={"name": "Alice", "age": 30}`, ".owner", map[string]interface{}{"name": "Alice", "age": int64(30)}},
+		{`.expr="a=b"`, ".expr", "a=b"},
+	}
+	for _, tt := range tests {
+		got, err := ParseAssignment(tt.input)
+		if err != nil {
+			t.Errorf("ParseAssignment(%q) failed: %v", tt.input, err)
+			continue
+		}
+		if got.Path != tt.path || !reflect.DeepEqual(got.Value, tt.value) {
+			t.Errorf("ParseAssignment(%q) = %#v, want %s = %#v", tt.input, got, tt.path, tt.value)
+		}
+	}
+
+	for _, input := range []string{`.name`, `.name=Alice`, `.name="a" "b"`, `name="a"`, `.items[]=1`} {
+		if _, err := ParseAssignment(input); err == nil {
+			t.Errorf("ParseAssignment(%q) succeeded", input)
+		}
+	}
+}
+
+func TestConvertSet(t *testing.T) {
+	input := "title = 'x'\n\n[owner]\nname = 'Bob'\nports = [1, 2]\n"
+	var assignments []Assignment
+	for _, s := range []string{`.owner.name="Alice"`, `.owner.ports[0]=8080`, `.db.host="h"`} {
+		a, err := ParseAssignment(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assignments = append(assignments, a)
+	}
+
+	output := &bytes.Buffer{}
+	if _, err := Convert(strings.NewReader(input), output, FormatTOML, FormatTOML, ".", Options{Set: assignments}); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	want := "title = 'x'\n\n[db]\nhost = 'h'\n\n[owner]\nname = 'Alice'\nports = [8080, 2]\n"
+	if got := output.String(); got != want {
+		t.Errorf("Unexpected TOML:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Key order is kept, with new keys at the end
+	output.Reset()
+	opts := Options{Set: assignments, PreserveOrder: true, Compact: true}
+	if _, err := Convert(strings.NewReader(input), output, FormatTOML, FormatJSON, ".", opts); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if got, want := output.String(), `{"title":"x","owner":{"name":"Alice","ports":[8080,2]},"db":{"host":"h"}}`+"\n"; got != want {
+		t.Errorf("Unexpected JSON: %s, want %s", got, want)
+	}
+
+	opts = Options{Set: assignments, RawInput: true}
+	if _, err := Convert(strings.NewReader("line\n"), output, FormatJSON, FormatJSON, ".", opts); err == nil {
+		t.Error("Convert with Set and RawInput succeeded")
+	}
+}
@@ -0,0 +1,129 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ExtractVarArgs removes every --arg name value and --argjson name value
+// from command-line arguments, which the flag package can't parse since they
+// take two values, and returns the remaining arguments along with the
+// variables. Arguments after -- are left alone.
+func ExtractVarArgs(args []string) ([]string, map[string]interface{}, error) {
+	var rest []string
+	vars := make(map[string]interface{})
+	for i := 0; i < len(args); i++ {
+		option := args[i]
+		if option == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		if option != "--arg" && option != "-arg" && option != "--argjson" && option != "-argjson" {
+			rest = append(rest, option)
+			continue
+		}
+		if i+2 >= len(args) {
+			return nil, nil, fmt.Errorf("%s needs a name and a value", option)
+		}
+		name, raw := args[i+1], args[i+2]
+		i += 2
+		for j := 0; j < len(name); j++ {
+			if !isVarChar(name[j], j == 0) {
+				return nil, nil, fmt.Errorf("%s: invalid variable name %q", option, name)
+			}
+		}
+		if name == "" {
+			return nil, nil, fmt.Errorf("%s: variable name is empty", option)
+		}
+		if strings.HasSuffix(option, "json") {
+			value, err := ParseArgJSON(name, raw)
+			if err != nil {
+				return nil, nil, err
+			}
+			vars[name] = value
+		} else {
+			vars[name] = raw
+		}
+	}
+	return rest, vars, nil
+}
+
+// ParseArgJSON parses the value of an --argjson variable. The filter
+// language has no array or object literals, so only numbers, strings,
+// booleans and null can be bound.
+func ParseArgJSON(name, raw string) (interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("invalid JSON for $%s: %v", name, err)
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("invalid JSON for $%s: more than one JSON value", name)
+	}
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		return nil, fmt.Errorf("$%s must be a number, string, boolean or null, not %s", name, typeName(value))
+	}
+	return jsonNumbers(value), nil
+}
+
+// substituteVars replaces each $name in the filter with its value from vars,
+// written as a literal: a JSON string for --arg, or the JSON of an --argjson
+// value. Variables can then be used wherever a literal can, such as
+// .users[0][$key], .port == $port or test($pattern). A $ inside a string
+// literal is left alone, and a variable missing from vars is an error.
+func substituteVars(filter string, vars map[string]interface{}) (string, error) {
+	if !strings.Contains(filter, "$") {
+		return filter, nil
+	}
+	var out strings.Builder
+	inString := false
+	for i := 0; i < len(filter); i++ {
+		c := filter[i]
+		if inString {
+			out.WriteByte(c)
+			if c == '\\' && i+1 < len(filter) {
+				i++
+				out.WriteByte(filter[i])
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		if c == '"' {
+			inString = true
+		}
+		if c != '$' {
+			out.WriteByte(c)
+			continue
+		}
+
+		end := i + 1
+		for end < len(filter) && isVarChar(filter[end], end == i+1) {
+			end++
+		}
+		name := filter[i+1 : end]
+		if name == "" {
+			return "", fmt.Errorf("expected a variable name after $ in %s", filter)
+		}
+		value, ok := vars[name]
+		if !ok {
+			return "", fmt.Errorf("$%s is not defined", name)
+		}
+		literal, err := json.Marshal(value)
+		if err != nil {
+			return "", fmt.Errorf("$%s: %v", name, err)
+		}
+		out.Write(literal)
+		i = end - 1
+	}
+	return out.String(), nil
+}
+
+// isVarChar reports whether c can appear in a variable name, which starts
+// with a letter or underscore like jq's
+func isVarChar(c byte, first bool) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || !first && c >= '0' && c <= '9'
+}
@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const varsInput = `
+title = "TOML Example"
+ports = [8000, 8001, 8002]
+enabled = true
+
+[[users]]
+name = "Alice"
+"e-mail" = "alice@example.com"
+
+[[users]]
+name = "Bob"
+`
+
+func TestVars(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		vars   map[string]interface{}
+		want   string
+	}{
+		{"string key", `.users[0][$key]`, map[string]interface{}{"key": "name"}, `"Alice"`},
+		{"key that is not a field name", `.users[0][$key]`, map[string]interface{}{"key": "e-mail"}, `"alice@example.com"`},
+		{"JSON index", `.ports[$i]`, map[string]interface{}{"i": int64(1)}, `8001`},
+		{"JSON negative index", `.users[$i].name`, map[string]interface{}{"i": int64(-1)}, `"Bob"`},
+		{"string comparison", `.title == $title`, map[string]interface{}{"title": "TOML Example"}, `true`},
+		{"JSON comparison", `.enabled == $on`, map[string]interface{}{"on": false}, `false`},
+		{"JSON arithmetic", `.ports[0] + $offset`, map[string]interface{}{"offset": int64(80)}, `8080`},
+		{"regex argument", `.title | test($re)`, map[string]interface{}{"re": "^toml"}, `false`},
+		{"string with quotes", `.title == $s`, map[string]interface{}{"s": `say "hi"`}, `false`},
+		{"several uses", `.users[$i][$k] == .users[$i][$k]`, map[string]interface{}{"i": int64(1), "k": "name"}, `true`},
+		{"dollar in a string literal", `.title | test("e$")`, nil, `true`},
+		{"literal string key", `.users[1]["name"]`, nil, `"Bob"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			_, err := Convert(strings.NewReader(varsInput), &out, FormatTOML, FormatJSON, tt.filter, Options{Vars: tt.vars})
+			if err != nil {
+				t.Fatalf("Convert(%s) failed: %v", tt.filter, err)
+			}
+			if got := strings.TrimSpace(out.String()); got != tt.want {
+				t.Errorf("Convert(%s) = %s, want %s", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVarsErrors(t *testing.T) {
+	tests := []struct {
+		filter string
+		vars   map[string]interface{}
+		want   string
+	}{
+		{`.users[0][$key]`, nil, "$key is not defined"},
+		{`.users[0][$key]`, map[string]interface{}{"other": "name"}, "$key is not defined"},
+		{`.title == $`, nil, "expected a variable name after $"},
+		{`.users[0][$key]`, map[string]interface{}{"key": "missing"}, "field 'missing' not found"},
+		{`.title[$key]`, map[string]interface{}{"key": "name"}, `cannot index string with string "name"`},
+	}
+	for _, tt := range tests {
+		_, err := Convert(strings.NewReader(varsInput), &bytes.Buffer{}, FormatTOML, FormatJSON, tt.filter, Options{Vars: tt.vars})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Convert(%s) error = %v, want %q", tt.filter, err, tt.want)
+		}
+	}
+}
+
+func TestExtractVarArgs(t *testing.T) {
+	args := []string{"-r", "--arg", "key", "name", "--argjson", "n", "2", "-argjson", "ok", "true", "--arg", "neg", "-5", ".users[$n][$key]", "in.toml", "--", "--arg"}
+	rest, vars, err := ExtractVarArgs(args)
+	if err != nil {
+		t.Fatalf("ExtractVarArgs failed: %v", err)
+	}
+	if want := []string{"-r", ".users[$n][$key]", "in.toml", "--", "--arg"}; !reflect.DeepEqual(rest, want) {
+		t.Errorf("rest = %q, want %q", rest, want)
+	}
+	want := map[string]interface{}{"key": "name", "n": int64(2), "ok": true, "neg": "-5"}
+	if !reflect.DeepEqual(vars, want) {
+		t.Errorf("vars = %#v, want %#v", vars, want)
+	}
+
+	for _, bad := range [][]string{
+		{"--arg", "key"},
+		{"--arg", "1st", "x"},
+		{"--arg", "", "x"},
+		{"--argjson", "x", "{bad"},
+		{"--argjson", "x", `{"a": 1}`},
+		{"--argjson", "x", "[1, 2]"},
+		{"--argjson", "x", "1 2"},
+	} {
+		if _, _, err := ExtractVarArgs(bad); err == nil {
+			t.Errorf("ExtractVarArgs(%q) should fail", bad)
+		}
+	}
+}
+
+func TestVarsTomlKey(t *testing.T) {
+	var out bytes.Buffer
+	vars := map[string]interface{}{"key": "e-mail"}
+	if _, err := Convert(strings.NewReader(varsInput), &out, FormatTOML, FormatTOML, `.users[0][$key]`, Options{Vars: vars}); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if got, want := strings.TrimSpace(out.String()), `e-mail = 'alice@example.com'`; got != want {
+		t.Errorf("TOML output = %q, want %q", got, want)
+	}
+}
@@ -1,39 +1,85 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
-	"strings"
 
 	"github.com/presbrey/cmd/tq/lib"
 )
 
 func printUsage() {
-	fmt.Fprintf(os.Stderr, "Usage: tq [options] [filter] [file...]\n\n")
-	fmt.Fprintf(os.Stderr, "tq is a lightweight and flexible command-line TOML/JSON processor.\n")
+	fmt.Fprintf(os.Stderr, "Usage: tq [options] [filter] [file...]\n")
+	fmt.Fprintf(os.Stderr, "       tq [options] -f filter_file [file...]\n\n")
+	fmt.Fprintf(os.Stderr, "tq is a lightweight and flexible command-line TOML/JSON/YAML processor.\n")
 	fmt.Fprintf(os.Stderr, "Similar to jq, it lets you slice, filter, and transform structured data.\n\n")
 	fmt.Fprintf(os.Stderr, "Options:\n")
 	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "  -arg name value\n    \tBind $name in the filter to the string value (repeatable)\n")
+	fmt.Fprintf(os.Stderr, "  -argjson name value\n    \tBind $name in the filter to the JSON number, string, boolean or null value (repeatable)\n")
 	fmt.Fprintf(os.Stderr, "\nExamples:\n")
 	fmt.Fprintf(os.Stderr, "  tq '.' example.toml            # Output the entire TOML file as JSON\n")
 	fmt.Fprintf(os.Stderr, "  tq --toml '.' example.json     # Output the entire JSON file as TOML\n")
+	fmt.Fprintf(os.Stderr, "  tq --yaml '.' example.toml     # Output the entire TOML file as YAML\n")
 	fmt.Fprintf(os.Stderr, "  tq '.users' example.toml       # Extract just the 'users' field\n")
 	fmt.Fprintf(os.Stderr, "  tq '.users[0]' example.toml    # Extract the first user\n")
+	fmt.Fprintf(os.Stderr, "  tq '.users[-1]' example.toml   # Extract the last user\n")
+	fmt.Fprintf(os.Stderr, "  tq -r '.users[].name' example.toml # Output each user's name\n")
 	fmt.Fprintf(os.Stderr, "  cat example.toml | tq '.users' # Read from stdin\n")
+	fmt.Fprintf(os.Stderr, "  tq '.price * .quantity' order.toml # Compute a derived value\n")
+	fmt.Fprintf(os.Stderr, "  tq -i '.' config.toml           # Reformat a file in place\n")
+	fmt.Fprintf(os.Stderr, "  tq -i --set '.owner.name=\"Alice\"' config.toml # Change a value in place\n")
+	fmt.Fprintf(os.Stderr, "  tq --arg key name '.users[0][$key]' example.toml # Use a variable in the filter\n")
+	fmt.Fprintf(os.Stderr, "  tq -f report.jq example.toml    # Read the filter from a file\n")
+	fmt.Fprintf(os.Stderr, "  find . -print0 | tq -0 --raw-output0 '.[2:]' | xargs -0 ls # Handle any file name\n")
 }
 
 func main() {
 	// Define command-line flags more similar to jq
 	toJson := flag.Bool("json", false, "Force JSON output (default for TOML input)")
 	toToml := flag.Bool("toml", false, "Force TOML output (default for JSON input)")
+	toYaml := flag.Bool("yaml", false, "Force YAML output")
 	compact := flag.Bool("c", false, "Compact output instead of pretty-printed")
+	flag.BoolVar(compact, "compact", false, "Compact output instead of pretty-printed")
 	rawOutput := flag.Bool("r", false, "Raw output (unwrap top-level values)")
+	rawOutput0 := flag.Bool("raw-output0", false, "Raw output with a NUL byte after each value instead of a newline between them")
+	rawInput := flag.Bool("R", false, "Raw input: pass each line to the filter as a string")
+	flag.BoolVar(rawInput, "raw-input", false, "Raw input: pass each line to the filter as a string")
+	rawInput0 := flag.Bool("0", false, "Raw input split at NUL bytes instead of newlines, e.g. from find -print0 (implies -R)")
+	flag.BoolVar(rawInput0, "raw-input0", false, "Raw input split at NUL bytes instead of newlines, e.g. from find -print0 (implies -R)")
 	outputFile := flag.String("o", "", "Output file (default: stdout)")
+	preserveOrder := flag.Bool("preserve-order", false, "Keep object keys in document order instead of sorting them")
+	exitStatus := flag.Bool("e", false, "Set the exit status from the last output (1 if null or false, 4 if none)")
+	flag.BoolVar(exitStatus, "exit-status", false, "Set the exit status from the last output (1 if null or false, 4 if none)")
+	failEmpty := flag.Bool("fail-empty", false, "Exit with status 4 and a note on stderr when the filter produces no output")
+	nullOnEmpty := flag.Bool("null-on-empty", false, "Treat empty input as null instead of failing")
+	nanAs := flag.String("nan-as", "error", "How JSON output writes NaN and infinite numbers: null, string or error")
+	tomlIndentTables := flag.Bool("toml-indent-tables", false, "Indent nested TOML tables under their parents")
+	tomlIndentSymbol := flag.String("toml-indent-symbol", "", "One level of TOML indentation, such as a tab (default two spaces)")
+	inPlace := flag.Bool("i", false, "Edit the input file in place")
+	flag.BoolVar(inPlace, "in-place", false, "Edit the input file in place")
+	filterFile := flag.String("f", "", "Read the filter from this file instead of the first argument; # starts a comment line")
+	flag.StringVar(filterFile, "from-file", "", "Read the filter from this file instead of the first argument; # starts a comment line")
 	helpFlag := flag.Bool("help", false, "Show help information")
-	flag.Parse()
+	var assignments []lib.Assignment
+	flag.Func("set", "Set the value at a path before filtering, as path=JSON, e.g. '.owner.name=\"Alice\"' (repeatable)", func(s string) error {
+		assignment, err := lib.ParseAssignment(s)
+		if err != nil {
+			return err
+		}
+		assignments = append(assignments, assignment)
+		return nil
+	})
+	// --arg and --argjson take two values, which the flag package can't
+	// parse, so they are picked out first
+	flagArgs, vars, err := lib.ExtractVarArgs(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	flag.CommandLine.Parse(flagArgs)
 
 	if *helpFlag {
 		printUsage()
@@ -42,21 +88,50 @@ func main() {
 
 	// Get filter and input files
 	args := flag.Args()
-	if len(args) == 0 {
-		printUsage()
-		os.Exit(1)
+	var filter string
+	if *filterFile != "" {
+		// A filter file leaves every argument for input files
+		if filter, err = lib.ReadFilterFile(*filterFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading filter file: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		if len(assignments) > 0 && len(args) < 2 {
+			// With --set the filter defaults to the identity, so a lone
+			// argument is the input file
+			args = append([]string{"."}, args...)
+		}
+		if len(args) == 0 {
+			printUsage()
+			os.Exit(1)
+		}
+
+		// First argument is the filter (like jq)
+		filter, args = args[0], args[1:]
 	}
 
-	// First argument is the filter (like jq)
-	filter := args[0]
+	if *inPlace && len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: -i requires an input file, it cannot be used with stdin")
+		os.Exit(1)
+	}
+	if *inPlace && *outputFile != "" {
+		fmt.Fprintln(os.Stderr, "Error: -i cannot be combined with -o")
+		os.Exit(1)
+	}
 	
+	nanMode, err := lib.ParseNaNMode(*nanAs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Determine input source
 	var input io.Reader
 	var filename string
 	
-	if len(args) > 1 {
+	if len(args) > 0 {
 		// Input from file argument
-		filename = args[1]
+		filename = args[0]
 		file, err := os.Open(filename)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error opening input file: %v\n", err)
@@ -69,9 +144,12 @@ func main() {
 		input = os.Stdin
 	}
 
-	// Set up output
+	// Set up output; in-place edits are buffered until the input is fully read
 	var output io.Writer
-	if *outputFile != "" {
+	var inPlaceBuf bytes.Buffer
+	if *inPlace {
+		output = &inPlaceBuf
+	} else if *outputFile != "" {
 		file, err := os.Create(*outputFile)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error creating output file: %v\n", err)
@@ -83,31 +161,55 @@ func main() {
 		output = os.Stdout
 	}
 
-	// Determine conversion direction based on file extension if not explicitly specified
-	if !*toJson && !*toToml && filename != "" {
-		ext := strings.ToLower(filepath.Ext(filename))
-		if ext == ".json" {
-			*toToml = true
-		} else if ext == ".toml" {
-			*toJson = true
-		}
-	}
-
-	// Default to TOML -> JSON if no direction is specified
-	if !*toToml {
-		*toJson = true
+	// Determine the formats, preferring explicit flags for the output.
+	// Editing a file, in place or not, keeps its own format.
+	var forced lib.Format
+	switch {
+	case *toYaml:
+		forced = lib.FormatYAML
+	case *toToml:
+		forced = lib.FormatTOML
+	case *toJson:
+		forced = lib.FormatJSON
 	}
+	inputFormat, outputFormat := lib.ChooseFormats(filename, forced, *inPlace || len(assignments) > 0)
 
 	// Process the data with the filter
-	var err error
-	if *toJson {
-		err = lib.TomlToJsonWithFilter(input, output, filter, *compact, *rawOutput)
-	} else {
-		err = lib.JsonToTomlWithFilter(input, output, filter, *compact)
+	opts := lib.Options{
+		Compact:       *compact,
+		Raw:           *rawOutput,
+		PreserveOrder: *preserveOrder,
+		NullOnEmpty:   *nullOnEmpty,
+		RawInput:      *rawInput,
+		NulInput:      *rawInput0,
+		NulOutput:     *rawOutput0,
+		NaNAs:         nanMode,
+		Set:           assignments,
+		Vars:          vars,
+		TOML: lib.TomlOptions{
+			IndentTables: *tomlIndentTables,
+			IndentSymbol: *tomlIndentSymbol,
+		},
 	}
-
+	results, err := lib.Convert(input, output, inputFormat, outputFormat, filter, opts)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error during processing: %v\n", err)
 		os.Exit(1)
 	}
+
+	if *inPlace {
+		if err := lib.WriteFileAtomic(filename, inPlaceBuf.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", filename, err)
+			os.Exit(1)
+		}
+	}
+
+	if *failEmpty && len(results) == 0 {
+		fmt.Fprintf(os.Stderr, "tq: filter %s produced no output\n", filter)
+		os.Exit(lib.ExitNoOutput)
+	}
+
+	if *exitStatus {
+		os.Exit(lib.ExitStatus(results))
+	}
 }